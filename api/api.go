@@ -120,12 +120,15 @@ func NewRouter(repo *repository.Repository, token string) *mux.Router {
 
 	handle("/repository/configuration", repositoryConfiguration).Methods("GET")
 	handle("/repository/snapshots", repositorySnapshots).Methods("GET")
+	handle("/repository/stats", repositoryStats).Methods("GET")
 	handle("/repository/states", repositoryStates).Methods("GET")
 	handle("/repository/state/{state}", repositoryState).Methods("GET")
 	handle("/repository/packfiles", repositoryPackfiles).Methods("GET")
 	handle("/repository/packfile/{packfile}", repositoryPackfile).Methods("GET")
 
 	handle("/snapshot/{snapshot}", snapshotHeader).Methods("GET")
+	handle("/snapshot/annotations/{snapshot}", snapshotAnnotations).Methods("GET")
+	handle("/snapshot/annotations/{snapshot}", snapshotAnnotate).Methods("POST")
 	readerHandle("/snapshot/reader/{snapshot}:{path:.+}", snapshotReader).Methods("GET")
 	handle("/snapshot/reader-sign-url/{snapshot}:{path:.+}", urlSigner.Sign).Methods("POST")
 	handle("/snapshot/search/{snapshot}:{path:.+}", snapshotSearch).Methods("GET")
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/PlakarKorp/plakar/snapshot"
 	"github.com/PlakarKorp/plakar/snapshot/header"
 	"github.com/gorilla/mux"
 )
@@ -81,3 +82,47 @@ func QueryParamToSortKeys(r *http.Request, param, def string) ([]string, error)
 
 	return sortKeys, nil
 }
+
+func QueryParamsToFilter(r *http.Request) (*header.Filter, error) {
+	query := r.URL.Query()
+	filter := &header.Filter{
+		Name:        query.Get("name"),
+		Category:    query.Get("category"),
+		Environment: query.Get("environment"),
+		Perimeter:   query.Get("perimeter"),
+		Tag:         query.Get("tag"),
+	}
+	for _, meta := range query["meta"] {
+		kv, err := header.ParseKeyValue(meta)
+		if err != nil {
+			return nil, parameterError("meta", InvalidArgument, err)
+		}
+		filter.Meta = append(filter.Meta, kv)
+	}
+	return filter, nil
+}
+
+func QueryParamsToPathFilter(r *http.Request) (*snapshot.PathFilter, error) {
+	query := r.URL.Query()
+
+	mappings := make([]snapshot.PathMapping, 0, len(query["map"]))
+	for _, value := range query["map"] {
+		mapping, err := snapshot.ParsePathMapping(value)
+		if err != nil {
+			return nil, parameterError("map", InvalidArgument, err)
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	if _, err := snapshot.NewPathFilter(query["include"], nil, "", nil); err != nil {
+		return nil, parameterError("include", InvalidArgument, err)
+	}
+	if _, err := snapshot.NewPathFilter(nil, query["exclude"], "", nil); err != nil {
+		return nil, parameterError("exclude", InvalidArgument, err)
+	}
+	filter, err := snapshot.NewPathFilter(query["include"], query["exclude"], query.Get("query"), mappings)
+	if err != nil {
+		return nil, parameterError("query", InvalidArgument, err)
+	}
+	return filter, nil
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/PlakarKorp/plakar/packfile"
 	"github.com/PlakarKorp/plakar/snapshot"
 	"github.com/PlakarKorp/plakar/snapshot/header"
 	"github.com/gorilla/mux"
@@ -31,6 +32,11 @@ func repositorySnapshots(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	filter, err := QueryParamsToFilter(r)
+	if err != nil {
+		return err
+	}
+
 	lrepository.RebuildState()
 
 	snapshotIDs, err := lrepository.GetSnapshots()
@@ -38,14 +44,21 @@ func repositorySnapshots(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	annotations := snapshot.LoadAnnotations(lrepository)
+
 	headers := make([]header.Header, 0, len(snapshotIDs))
 	for _, snapshotID := range snapshotIDs {
 		snap, err := snapshot.Load(lrepository, snapshotID)
 		if err != nil {
 			return err
 		}
+		annotations.Apply(snap.Header)
+		if !filter.Match(snap.Header) {
+			continue
+		}
 		headers = append(headers, *snap.Header)
 	}
+	total := len(headers)
 
 	if limit == 0 {
 		limit = uint32(len(headers))
@@ -61,7 +74,7 @@ func repositorySnapshots(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	items := Items{
-		Total: len(snapshotIDs),
+		Total: total,
 		Items: make([]interface{}, len(headers)),
 	}
 	for i, header := range headers {
@@ -71,6 +84,32 @@ func repositorySnapshots(w http.ResponseWriter, r *http.Request) error {
 	return json.NewEncoder(w).Encode(items)
 }
 
+func repositoryStats(w http.ResponseWriter, r *http.Request) error {
+	top, _, err := QueryParamToUint32(r, "top")
+	if err != nil {
+		return err
+	}
+
+	lrepository.RebuildState()
+
+	snapshotIDs, err := lrepository.GetSnapshots()
+	if err != nil {
+		return err
+	}
+
+	refCounts, err := snapshot.NewRefCounts(lrepository, snapshotIDs)
+	if err != nil {
+		return err
+	}
+
+	stats := refCounts.Stats()
+	if top != 0 && uint32(len(stats.SnapshotsStats)) > top {
+		stats.SnapshotsStats = stats.SnapshotsStats[:top]
+	}
+
+	return json.NewEncoder(w).Encode(Item{Item: stats})
+}
+
 func repositoryStates(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	_ = vars
@@ -141,6 +180,13 @@ func repositoryPackfile(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	// blobs from v2 packfiles need their flags to be decoded, without
+	// them the blob is assumed to come from a v1 packfile
+	flags, _, err := QueryParamToUint32(r, "flags")
+	if err != nil {
+		return err
+	}
+
 	if (offsetExists && !lengthExists) || (!offsetExists && lengthExists) {
 		param := "offset"
 		if !offsetExists {
@@ -151,7 +197,7 @@ func repositoryPackfile(w http.ResponseWriter, r *http.Request) error {
 
 	var rd io.Reader
 	if offsetExists && lengthExists {
-		rd, err = lrepository.GetPackfileBlob(packfileBytes32, offset, length)
+		rd, err = lrepository.GetPackfileBlob(packfileBytes32, offset, length, packfile.Flags(flags))
 		if err != nil {
 			return err
 		}
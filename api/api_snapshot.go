@@ -32,10 +32,72 @@ func snapshotHeader(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	snapshot.LoadAnnotations(lrepository).Apply(snap.Header)
 
 	return json.NewEncoder(w).Encode(Item{Item: snap.Header})
 }
 
+func snapshotAnnotations(w http.ResponseWriter, r *http.Request) error {
+	snapshotID32, err := PathParamToID(r, "snapshot")
+	if err != nil {
+		return err
+	}
+
+	lrepository.RebuildState()
+
+	annotations := snapshot.LoadAnnotations(lrepository)[snapshotID32]
+	items := Items{
+		Total: len(annotations),
+		Items: make([]interface{}, len(annotations)),
+	}
+	for i, annotation := range annotations {
+		items.Items[i] = annotation
+	}
+
+	return json.NewEncoder(w).Encode(items)
+}
+
+type AnnotationRequest struct {
+	AddTags    []string `json:"add_tags"`
+	RemoveTags []string `json:"remove_tags"`
+	Note       *string  `json:"note"`
+	Pinned     *bool    `json:"pinned"`
+}
+
+func snapshotAnnotate(w http.ResponseWriter, r *http.Request) error {
+	snapshotID32, err := PathParamToID(r, "snapshot")
+	if err != nil {
+		return err
+	}
+
+	var req AnnotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return parameterError("body", InvalidArgument, err)
+	}
+	if len(req.AddTags) == 0 && len(req.RemoveTags) == 0 && req.Note == nil && req.Pinned == nil {
+		return parameterError("body", MissingArgument, ErrMissingField)
+	}
+
+	if _, err := snapshot.Load(lrepository, snapshotID32); err != nil {
+		return err
+	}
+
+	annotation, err := snapshot.NewAnnotation(lrepository, snapshotID32)
+	if err != nil {
+		return err
+	}
+	annotation.AddTags = req.AddTags
+	annotation.RemoveTags = req.RemoveTags
+	annotation.Note = req.Note
+	annotation.Pinned = req.Pinned
+
+	if err := snapshot.PutAnnotation(lrepository, annotation); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(Item{Item: annotation})
+}
+
 func snapshotReader(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	path := vars["path"]
@@ -58,6 +120,11 @@ func snapshotReader(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	filter, err := QueryParamsToPathFilter(r)
+	if err != nil {
+		return err
+	}
+
 	snap, err := snapshot.Load(lrepository, snapshotID32)
 	if err != nil {
 		return err
@@ -78,6 +145,16 @@ func snapshotReader(w http.ResponseWriter, r *http.Request) error {
 	}
 	fileEntry := st.(*vfs.FileEntry)
 
+	if match, err := filter.Match(snap, path, fileEntry); err != nil {
+		return err
+	} else if !match {
+		return &ApiError{
+			HttpCode: http.StatusNotFound,
+			ErrCode:  "not-found",
+			Message:  path + ": not selected by the filter",
+		}
+	}
+
 	rd, err := snap.NewReader(path)
 	if err != nil {
 		return err
@@ -85,7 +162,8 @@ func snapshotReader(w http.ResponseWriter, r *http.Request) error {
 	defer rd.Close()
 
 	if do_download {
-		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(filepath.Base(path)))
+		filename, _ := filter.Map(path)
+		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(filepath.Base(filename)))
 	}
 
 	if do_highlight {
@@ -131,7 +209,7 @@ func snapshotReader(w http.ResponseWriter, r *http.Request) error {
 			}
 		}
 	} else {
-		http.ServeContent(w, r, filepath.Base(path), fileEntry.Stat().ModTime(), rd.(io.ReadSeeker))
+		http.ServeContent(w, r, filepath.Base(path), fileEntry.Stat().ModTime(), rd)
 	}
 
 	return nil
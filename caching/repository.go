@@ -1,18 +1,30 @@
 package caching
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
+// MaxPackfilesSize bounds the size of the packfiles kept in the cache, the
+// least recently used ones are evicted past it.
+const MaxPackfilesSize = 256 << 20
+
 type _RepositoryCache struct {
 	manager *Manager
 	db      *leveldb.DB
+
+	// packfilesSize is the size of the cached packfiles, -1 until known
+	muPackfiles   sync.Mutex
+	packfilesSize int64
 }
 
 func newRepositoryCache(cacheManager *Manager, repositoryID uuid.UUID) (*_RepositoryCache, error) {
@@ -24,8 +36,9 @@ func newRepositoryCache(cacheManager *Manager, repositoryID uuid.UUID) (*_Reposi
 	}
 
 	return &_RepositoryCache{
-		manager: cacheManager,
-		db:      db,
+		manager:       cacheManager,
+		db:            db,
+		packfilesSize: -1,
 	}, nil
 }
 
@@ -72,6 +85,187 @@ func (c *_RepositoryCache) DelState(stateID [32]byte) error {
 	return c.delete("__state__", fmt.Sprintf("%x", stateID))
 }
 
+// packfileUsage is recorded along with each cached packfile to evict the
+// least recently used ones without reading them.
+type packfileUsage struct {
+	packfileID [32]byte
+	atime      int64
+	size       int64
+}
+
+func (c *_RepositoryCache) putUsage(usage packfileUsage) error {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint64(data[0:], uint64(usage.atime))
+	binary.LittleEndian.PutUint64(data[8:], uint64(usage.size))
+	return c.put("__packfile_usage__", fmt.Sprintf("%x", usage.packfileID), data)
+}
+
+func (c *_RepositoryCache) getUsage(packfileID [32]byte) (*packfileUsage, error) {
+	data, err := c.get("__packfile_usage__", fmt.Sprintf("%x", packfileID))
+	if err != nil || len(data) != 16 {
+		return nil, err
+	}
+	return &packfileUsage{
+		packfileID: packfileID,
+		atime:      int64(binary.LittleEndian.Uint64(data[0:])),
+		size:       int64(binary.LittleEndian.Uint64(data[8:])),
+	}, nil
+}
+
+func (c *_RepositoryCache) listUsages() ([]packfileUsage, error) {
+	iter := c.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	usages := make([]packfileUsage, 0)
+	keyPrefix := "__packfile_usage__:"
+	for iter.Seek([]byte(keyPrefix)); iter.Valid(); iter.Next() {
+		if !strings.HasPrefix(string(iter.Key()), keyPrefix) {
+			break
+		}
+		var packfileID [32]byte
+		if _, err := hex.Decode(packfileID[:], iter.Key()[len(keyPrefix):]); err != nil || len(iter.Value()) != 16 {
+			continue
+		}
+		usages = append(usages, packfileUsage{
+			packfileID: packfileID,
+			atime:      int64(binary.LittleEndian.Uint64(iter.Value()[0:])),
+			size:       int64(binary.LittleEndian.Uint64(iter.Value()[8:])),
+		})
+	}
+	return usages, iter.Error()
+}
+
+// evictPackfiles removes the least recently used packfiles until the cache
+// fits in MaxPackfilesSize, it expects muPackfiles to be held.
+func (c *_RepositoryCache) evictPackfiles() error {
+	if c.packfilesSize >= 0 && c.packfilesSize <= MaxPackfilesSize {
+		return nil
+	}
+
+	usages, err := c.listUsages()
+	if err != nil {
+		return err
+	}
+	c.packfilesSize = 0
+	for _, usage := range usages {
+		c.packfilesSize += usage.size
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].atime < usages[j].atime
+	})
+	for _, usage := range usages {
+		if c.packfilesSize <= MaxPackfilesSize {
+			break
+		}
+		if err := c.delPackfile(usage.packfileID, usage.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *_RepositoryCache) PutPackfile(packfileID [32]byte, data []byte) error {
+	c.muPackfiles.Lock()
+	defer c.muPackfiles.Unlock()
+
+	previous, err := c.getUsage(packfileID)
+	if err != nil {
+		return err
+	}
+	if err := c.put("__packfile__", fmt.Sprintf("%x", packfileID), data); err != nil {
+		return err
+	}
+	if err := c.putUsage(packfileUsage{packfileID, time.Now().UnixNano(), int64(len(data))}); err != nil {
+		return err
+	}
+	if c.packfilesSize >= 0 {
+		c.packfilesSize += int64(len(data))
+		if previous != nil {
+			c.packfilesSize -= previous.size
+		}
+	}
+	return c.evictPackfiles()
+}
+
+func (c *_RepositoryCache) HasPackfile(packfileID [32]byte) (bool, error) {
+	return c.has("__packfile__", fmt.Sprintf("%x", packfileID))
+}
+
+func (c *_RepositoryCache) GetPackfile(packfileID [32]byte) ([]byte, error) {
+	data, err := c.get("__packfile__", fmt.Sprintf("%x", packfileID))
+	if err != nil || data == nil {
+		return data, err
+	}
+
+	c.muPackfiles.Lock()
+	defer c.muPackfiles.Unlock()
+
+	usage, err := c.getUsage(packfileID)
+	if err != nil {
+		return nil, err
+	}
+	if usage == nil && c.packfilesSize >= 0 {
+		c.packfilesSize += int64(len(data))
+	}
+	if err := c.putUsage(packfileUsage{packfileID, time.Now().UnixNano(), int64(len(data))}); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (c *_RepositoryCache) DelPackfile(packfileID [32]byte) error {
+	c.muPackfiles.Lock()
+	defer c.muPackfiles.Unlock()
+
+	usage, err := c.getUsage(packfileID)
+	if err != nil {
+		return err
+	}
+	size := int64(0)
+	if usage != nil {
+		size = usage.size
+	}
+	return c.delPackfile(packfileID, size)
+}
+
+func (c *_RepositoryCache) delPackfile(packfileID [32]byte, size int64) error {
+	if err := c.delete("__packfile__", fmt.Sprintf("%x", packfileID)); err != nil {
+		return err
+	}
+	if err := c.delete("__packfile_usage__", fmt.Sprintf("%x", packfileID)); err != nil {
+		return err
+	}
+	if c.packfilesSize >= 0 {
+		c.packfilesSize -= size
+	}
+	return nil
+}
+
+func (c *_RepositoryCache) PutSnapshotRefs(snapshotID [32]byte, data []byte) error {
+	return c.put("__refs__", fmt.Sprintf("%x", snapshotID), data)
+}
+
+func (c *_RepositoryCache) GetSnapshotRefs(snapshotID [32]byte) ([]byte, error) {
+	return c.get("__refs__", fmt.Sprintf("%x", snapshotID))
+}
+
+func (c *_RepositoryCache) DelSnapshotRefs(snapshotID [32]byte) error {
+	return c.delete("__refs__", fmt.Sprintf("%x", snapshotID))
+}
+
+func (c *_RepositoryCache) PutCheckpoint(source string, data []byte) error {
+	return c.put("__checkpoint__", source, data)
+}
+
+func (c *_RepositoryCache) GetCheckpoint(source string) ([]byte, error) {
+	return c.get("__checkpoint__", source)
+}
+
+func (c *_RepositoryCache) DelCheckpoint(source string) error {
+	return c.delete("__checkpoint__", source)
+}
+
 func (c *_RepositoryCache) ListStates() (chan [32]byte, error) {
 	ch := make(chan [32]byte)
 	go func() {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"os/user"
 	"path"
 	"path/filepath"
@@ -12,11 +13,13 @@ import (
 	"runtime/pprof"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/PlakarKorp/plakar/caching"
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
+	"github.com/PlakarKorp/plakar/config"
 	"github.com/PlakarKorp/plakar/context"
 	"github.com/PlakarKorp/plakar/encryption"
 	"github.com/PlakarKorp/plakar/logging"
@@ -35,9 +38,13 @@ import (
 	_ "github.com/PlakarKorp/plakar/snapshot/importer/fs"
 	_ "github.com/PlakarKorp/plakar/snapshot/importer/ftp"
 	_ "github.com/PlakarKorp/plakar/snapshot/importer/s3"
+	_ "github.com/PlakarKorp/plakar/snapshot/importer/stdio"
 
+	_ "github.com/PlakarKorp/plakar/snapshot/exporter/archive"
 	_ "github.com/PlakarKorp/plakar/snapshot/exporter/fs"
+	_ "github.com/PlakarKorp/plakar/snapshot/exporter/ftp"
 	_ "github.com/PlakarKorp/plakar/snapshot/exporter/s3"
+	_ "github.com/PlakarKorp/plakar/snapshot/exporter/sftp"
 
 	_ "github.com/PlakarKorp/plakar/classifier/backend/noop"
 )
@@ -117,6 +124,13 @@ func entryPoint() int {
 
 	ctx.SetCWD(cwd)
 
+	cfg, err := config.Load(opt_configfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: could not load configuration: %s\n", flag.CommandLine.Name(), err)
+		return 1
+	}
+	ctx.SetConfig(cfg)
+
 	keyringDir := filepath.Join(opt_userDefault.HomeDir, ".plakar-keyring")
 	ctx.SetKeyringDir(keyringDir)
 
@@ -213,6 +227,18 @@ func entryPoint() int {
 
 	ctx.SetLogger(logger)
 
+	// the first interrupt cancels the command so it can stop cleanly, the
+	// second one exits right away
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		logger.Warn("interrupted, stopping (interrupt again to force)")
+		ctx.Cancel()
+		<-signals
+		os.Exit(130)
+	}()
+
 	command, args := flag.Args()[0], flag.Args()[1:]
 
 	var repositoryPath string
@@ -248,7 +274,7 @@ func entryPoint() int {
 		skipPassphrase = true
 	}
 
-	store, err := storage.Open(repositoryPath)
+	store, err := storage.Open(ctx, repositoryPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
 		return 1
@@ -18,10 +18,13 @@ import (
 	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/ls"
 	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/mount"
 	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/restore"
+	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/rewrite"
 	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/rm"
 	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/server"
+	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/stats"
 	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/stdio"
 	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/sync"
+	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/tag"
 	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/tags"
 	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/ui"
 	_ "github.com/PlakarKorp/plakar/cmd/plakar/subcommands/version"
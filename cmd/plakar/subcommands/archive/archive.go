@@ -17,22 +17,23 @@
 package archive
 
 import (
-	"archive/tar"
-	"archive/zip"
-	"compress/gzip"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
 	"github.com/PlakarKorp/plakar/context"
+	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/exporter/archive"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
 	"github.com/PlakarKorp/plakar/snapshot/vfs"
 )
 
@@ -48,20 +49,23 @@ func cmd_archive(ctx *context.Context, repo *repository.Repository, args []strin
 	flags := flag.NewFlagSet("archive", flag.ExitOnError)
 	flags.StringVar(&opt_output, "output", "", "archive pathname")
 	flags.BoolVar(&opt_rebase, "rebase", false, "strip pathname when pulling")
-	flags.StringVar(&opt_format, "format", "tarball", "archive format")
+	flags.StringVar(&opt_format, "format", "tarball", "archive format: tar, tarball, tar.zst or zip")
+	pathFilter := utils.PathFilterFlags(flags)
 	flags.Parse(args)
 
+	filter, err := pathFilter()
+	if err != nil {
+		ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+		return 1
+	}
+
 	if flags.NArg() == 0 {
 		log.Fatalf("%s: need at least one snapshot ID to pull", flag.CommandLine.Name())
 	}
 
-	supportedFormats := map[string]string{
-		"tar":     ".tar",
-		"tarball": ".tar.gz",
-		"zip":     ".zip",
-	}
-	if _, ok := supportedFormats[opt_format]; !ok {
-		log.Fatalf("%s: unsupported format %s", flag.CommandLine.Name(), opt_format)
+	format, err := archive.ParseFormat(opt_format)
+	if err != nil {
+		log.Fatalf("%s: %s", flag.CommandLine.Name(), err)
 	}
 
 	snapshotPrefix, pathname := utils.ParseSnapshotID(flags.Arg(0))
@@ -70,176 +74,179 @@ func cmd_archive(ctx *context.Context, repo *repository.Repository, args []strin
 		log.Fatalf("%s: could not open snapshot: %s", flag.CommandLine.Name(), snapshotPrefix)
 	}
 
-	fs, err := snap.Filesystem()
-	if err != nil {
-		log.Fatalf("%s: %s: %s", flag.CommandLine.Name(), pathname, err)
-	}
-
 	if opt_output == "" {
-		opt_output = fmt.Sprintf("plakar-%s.%s", time.Now().UTC().Format(time.RFC3339), supportedFormats[opt_format])
+		opt_output = fmt.Sprintf("plakar-%s%s", time.Now().UTC().Format(time.RFC3339), format.Extension())
 	}
 
-	var out io.WriteCloser
-	if opt_output == "-" {
-		out = os.Stdout
-	} else {
-		tmp, err := os.CreateTemp("", "plakar-archive-")
-		if err != nil {
-			log.Fatalf("%s: %s: %s", flag.CommandLine.Name(), pathname, err)
-		}
-		defer os.Remove(tmp.Name())
-		out = tmp
+	write := func(aw *archive.Writer) error {
+		return writeArchive(ctx, snap, aw, format, pathname, opt_rebase, filter)
 	}
-
-	switch opt_format {
-	case "tar":
-		if err := archiveTarball(snap, out, fs, pathname, opt_rebase); err != nil {
-			log.Fatal(err)
-		}
-	case "tarball":
-		gzipWriter := gzip.NewWriter(out)
-		if err := archiveTarball(snap, gzipWriter, fs, pathname, opt_rebase); err != nil {
-			log.Fatal(err)
+	if opt_output == "-" {
+		aw, err := archive.NewWriter(os.Stdout, format)
+		if err == nil {
+			err = write(aw)
+			if closeErr := aw.Close(); err == nil {
+				err = closeErr
+			}
 		}
-		gzipWriter.Close()
-	case "zip":
-		if err := archiveZip(snap, out, fs, pathname, opt_rebase); err != nil {
-			log.Fatal(err)
+		if err != nil {
+			ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+			return 1
 		}
-	default:
-		log.Fatalf("%s: unsupported format %s", flag.CommandLine.Name(), opt_format)
+		return 0
 	}
-	if err := out.Close(); err != nil {
+	if err := archive.Create(opt_output, format, write); err != nil {
+		ctx.GetLogger().Error("%s: %s", flags.Name(), err)
 		return 1
 	}
-	if out, isFile := out.(*os.File); isFile {
-		if err := os.Rename(out.Name(), opt_output); err != nil {
-			return 1
-		}
-	}
-
 	return 0
 }
 
-func archiveTarball(snap *snapshot.Snapshot, out io.Writer, fs *vfs.Filesystem, path string, rebase bool) error {
-	tarWriter := tar.NewWriter(out)
-	defer tarWriter.Close()
+// writeArchive writes the entries below prefix selected by the filter to
+// aw, in order.  The content of files is read through a single planned
+// stream and written as it arrives, reads are coalesced across files.
+func writeArchive(ctx *context.Context, snap *snapshot.Snapshot, aw *archive.Writer, format archive.Format, prefix string, rebase bool, filter *snapshot.PathFilter) error {
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return err
+	}
+	records, objs, err := collectEntries(snap, fs, prefix, rebase, filter)
+	if err != nil {
+		return err
+	}
 
-	for file := range fs.Pathnames() {
-		if path != "" && !utils.PathIsWithin(file, path) {
-			continue
-		}
-
-		info, err := fs.Stat(file)
-		if err != nil {
-			snap.Logger().Error("could not stat file %s: %s", file, err)
-			continue
+	// hard links to an entry already written are not fetched
+	linked := archive.Links(format, records)
+	planned := make([]*objects.Object, 0)
+	for i, record := range records {
+		if record.FileInfo.Mode().IsRegular() && !linked[i] {
+			planned = append(planned, objs[i])
 		}
+	}
+	rd := snap.NewObjectsReader(planned)
+	defer rd.Close()
 
-		filepath := file
-		if rebase {
-			filepath = strings.TrimPrefix(filepath, path)
+	for i, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-
-		var header *tar.Header
-		switch entry := info.(type) {
-		case *vfs.FileEntry:
-			header = &tar.Header{
-				Typeflag: tar.TypeReg,
-				Name:     filepath,
-				Size:     entry.Stat().Size(),
-				Mode:     int64(entry.Stat().Mode()),
-				ModTime:  entry.Stat().ModTime(),
-			}
-		case *vfs.DirEntry:
-			header = &tar.Header{
-				Typeflag: tar.TypeDir,
-				Name:     filepath,
-				Mode:     int64(entry.Stat().Mode()),
-				ModTime:  entry.Stat().ModTime(),
+		var content io.Reader
+		if record.FileInfo.Mode().IsRegular() && !linked[i] {
+			content, err = rd.NextObject()
+			if err != nil {
+				return fmt.Errorf("%s: %w", record.Pathname, err)
 			}
-		default:
-			snap.Logger().Error("could not stat file %T: %s %s", file, file, err)
-			continue
 		}
+		if err := aw.WriteEntry(record, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		err = tarWriter.WriteHeader(header)
-		if err != nil {
-			snap.Logger().Error("could not write header for file %s: %s", file, err)
+// collectEntries returns the entries below prefix selected by the filter,
+// in order, named as in the archive, along with the objects of the regular
+// files.  With a selective filter, directories are only kept if they match
+// or hold a selected entry.
+func collectEntries(snap *snapshot.Snapshot, fs *vfs.Filesystem, prefix string, rebase bool, filter *snapshot.PathFilter) ([]*importer.ScanRecord, []*objects.Object, error) {
+	prefix = path.Clean("/" + prefix)
+
+	records := make([]*importer.ScanRecord, 0)
+	objs := make([]*objects.Object, 0)
+	pathnames := make([]string, 0)
+	selected := make([]bool, 0)
+	for pathname := range fs.Pathnames() {
+		if !utils.PathIsWithin(pathname, prefix) && pathname != prefix {
 			continue
 		}
-
-		if _, isDir := info.(*vfs.DirEntry); isDir {
+		if filter.Excluded(pathname) {
 			continue
 		}
 
-		rd, err := snap.NewReader(file)
+		info, err := fs.Stat(pathname)
 		if err != nil {
-			snap.Logger().Error("could not find file %s", file)
-			continue
+			return nil, nil, fmt.Errorf("%s: %w", pathname, err)
 		}
-
-		_, err = io.Copy(tarWriter, rd)
+		match, err := filter.Match(snap, pathname, info)
 		if err != nil {
-			snap.Logger().Error("could not write file %s: %s", file, err)
-			rd.Close()
-			return err
+			return nil, nil, fmt.Errorf("%s: %w", pathname, err)
 		}
-		rd.Close()
-	}
 
-	return nil
-}
-
-func archiveZip(snap *snapshot.Snapshot, out io.Writer, fs *vfs.Filesystem, path string, rebase bool) error {
-	zipWriter := zip.NewWriter(out)
-	defer zipWriter.Close()
-
-	for file := range fs.Pathnames() {
-
-		if path != "" {
-			if !utils.PathIsWithin(file, path) {
+		record := &importer.ScanRecord{
+			Pathname:           archivePath(pathname, info.Stat().IsDir(), prefix, rebase, filter),
+			FileInfo:           *info.Stat(),
+			ExtendedAttributes: make(map[string][]byte),
+		}
+		var object *objects.Object
+		var xattrs []vfs.ExtendedAttribute
+		switch entry := info.(type) {
+		case *vfs.DirEntry:
+			record.Type = entry.Type
+			xattrs = entry.ExtendedAttributes
+		case *vfs.FileEntry:
+			if !match {
 				continue
 			}
+			record.Type = entry.Type
+			record.Target = entry.SymlinkTarget
+			object = entry.Object
+			xattrs = entry.ExtendedAttributes
+		default:
+			return nil, nil, fmt.Errorf("%s: unexpected vfs entry type", pathname)
 		}
-		info, _ := fs.Stat(file)
-		filepath := file
-		if rebase {
-			filepath = strings.TrimPrefix(filepath, path)
+		for _, xattr := range xattrs {
+			record.ExtendedAttributes[xattr.Name] = xattr.Value
 		}
 
-		if _, isDir := info.(*vfs.DirEntry); isDir {
-			continue
-		}
+		records = append(records, record)
+		objs = append(objs, object)
+		pathnames = append(pathnames, pathname)
+		selected = append(selected, match)
+	}
 
-		header, err := zip.FileInfoHeader(info.(*vfs.FileEntry).Stat())
-		if err != nil {
-			log.Printf("could not create header for file %s: %s", file, err)
-			continue
-		}
-		header.Name = strings.TrimLeft(filepath, "/")
-		header.Method = zip.Deflate
+	if !filter.Selective() {
+		return records, objs, nil
+	}
 
-		rd, err := snap.NewReader(file)
-		if err != nil {
-			log.Printf("could not find file %s", file)
+	parents := make(map[string]struct{})
+	for i, pathname := range pathnames {
+		if !selected[i] {
 			continue
 		}
+		for dir := path.Dir(pathname); ; dir = path.Dir(dir) {
+			if _, seen := parents[dir]; seen {
+				break
+			}
+			parents[dir] = struct{}{}
+			if dir == "/" || dir == "." {
+				break
+			}
+		}
+	}
 
-		writer, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			log.Printf("could not create zip entry for file %s: %s", file, err)
-			rd.Close()
-			continue
+	keptRecords := make([]*importer.ScanRecord, 0, len(records))
+	keptObjects := make([]*objects.Object, 0, len(objs))
+	for i, pathname := range pathnames {
+		if _, isParent := parents[pathname]; selected[i] || isParent {
+			keptRecords = append(keptRecords, records[i])
+			keptObjects = append(keptObjects, objs[i])
 		}
+	}
+	return keptRecords, keptObjects, nil
+}
 
-		_, err = io.Copy(writer, rd)
-		if err != nil {
-			log.Printf("could not write file %s: %s", file, err)
-			rd.Close()
-			continue
+// archivePath returns the name of pathname in the archive, a path mapping
+// takes precedence over the rebase.  When rebased, a directory becomes the
+// root of the archive and a file archived on its own keeps its name.
+func archivePath(pathname string, isDir bool, prefix string, rebase bool, filter *snapshot.PathFilter) string {
+	if mapped, ok := filter.Map(pathname); ok {
+		return path.Join("/", mapped)
+	}
+	if rebase {
+		if pathname == prefix && !isDir {
+			return path.Join("/", path.Base(pathname))
 		}
-		rd.Close()
+		return path.Join("/", strings.TrimPrefix(pathname, prefix))
 	}
-	return nil
+	return pathname
 }
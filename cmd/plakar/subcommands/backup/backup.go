@@ -21,17 +21,19 @@ import (
 	"encoding/base64"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
 	"github.com/PlakarKorp/plakar/context"
 	"github.com/PlakarKorp/plakar/identity"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/snapshot"
 	"github.com/PlakarKorp/plakar/snapshot/importer"
+	"github.com/PlakarKorp/plakar/snapshot/importer/stdio"
 	"github.com/dustin/go-humanize"
 	"github.com/gobwas/glob"
 	"github.com/google/uuid"
@@ -59,21 +61,71 @@ func cmd_backup(ctx *context.Context, repo *repository.Repository, args []string
 	var opt_concurrency uint64
 	var opt_quiet bool
 	var opt_identity string
+	var opt_checkpointInterval time.Duration
+	var opt_noResume bool
+	var opt_dryRun bool
+	var opt_minSize string
+	var opt_maxSize string
+	var opt_newerThan string
+	var opt_olderThan string
+	var opt_excludeType excludeFlags
+	var opt_excludeCaches bool
+	var opt_excludeMarker excludeFlags
+	var opt_oneFileSystem bool
+	var opt_stdin string
+	var opt_command string
+	var opt_preScan excludeFlags
+	var opt_postScan excludeFlags
+	var opt_preCommit excludeFlags
+	var opt_postCommit excludeFlags
+	var opt_hookPolicy excludeFlags
+	var opt_name string
+	var opt_category string
+	var opt_environment string
+	var opt_perimeter string
+	var opt_meta utils.MetaFlags
 
-	excludes := []glob.Glob{}
+	excludes := []string{}
 	flags := flag.NewFlagSet("backup", flag.ExitOnError)
 	flags.Uint64Var(&opt_concurrency, "concurrency", uint64(ctx.GetMaxConcurrency()), "maximum number of parallel tasks")
 	flags.StringVar(&opt_identity, "identity", "", "use identity from keyring")
 	flags.StringVar(&opt_tags, "tag", "", "tag to assign to this snapshot")
+	flags.StringVar(&opt_name, "name", "", "name of this snapshot")
+	flags.StringVar(&opt_category, "category", "", "category of this snapshot")
+	flags.StringVar(&opt_environment, "environment", "", "environment of this snapshot, such as prod or staging")
+	flags.StringVar(&opt_perimeter, "perimeter", "", "perimeter of this snapshot")
+	flags.Var(&opt_meta, "meta", "metadata to assign to this snapshot, as key=value")
 	flags.StringVar(&opt_excludes, "excludes", "", "file containing a list of exclusions")
 	flags.Var(&opt_exclude, "exclude", "file containing a list of exclusions")
 	flags.BoolVar(&opt_quiet, "quiet", false, "suppress output")
+	flags.DurationVar(&opt_checkpointInterval, "checkpoint-interval", 5*time.Minute, "interval between checkpoints of the backup, 0 disables them")
+	flags.BoolVar(&opt_noResume, "no-resume", false, "discard the checkpoint of an interrupted backup instead of resuming it")
+	flags.BoolVar(&opt_dryRun, "dry-run", false, "estimate the data the backup would upload without writing to the repository")
+	flags.StringVar(&opt_minSize, "min-size", "", "exclude files smaller than size")
+	flags.StringVar(&opt_maxSize, "max-size", "", "exclude files larger than size")
+	flags.StringVar(&opt_newerThan, "newer-than", "", "exclude files not modified after a date or within a duration")
+	flags.StringVar(&opt_olderThan, "older-than", "", "exclude files not modified before a date or within a duration")
+	flags.Var(&opt_excludeType, "exclude-type", "exclude records of type socket, device, pipe or symlink")
+	flags.BoolVar(&opt_excludeCaches, "exclude-caches", false, "exclude the content of directories holding a CACHEDIR.TAG file")
+	flags.Var(&opt_excludeMarker, "exclude-marker", "exclude the content of directories holding a file of this name")
+	flags.BoolVar(&opt_oneFileSystem, "one-file-system", false, "do not descend into directories on other filesystems")
+	flags.StringVar(&opt_stdin, "stdin", "", "back up the standard input as a file of this name")
+	flags.StringVar(&opt_command, "command", "", "back up the output of the command given as arguments as a file of this name")
+	flags.Var(&opt_preScan, "pre-scan", "command to run before scanning")
+	flags.Var(&opt_postScan, "post-scan", "command to run after scanning, even if the scan failed")
+	flags.Var(&opt_preCommit, "pre-commit", "command to run before committing the snapshot")
+	flags.Var(&opt_postCommit, "post-commit", "command to run after committing the snapshot, its failure is only reported")
+	flags.Var(&opt_hookPolicy, "hook-policy", "abort or warn when the hooks of a stage fail, as stage=policy")
 	flags.Parse(args)
 
 	go eventsProcessorStdio(ctx, opt_quiet)
 
 	for _, item := range opt_exclude {
-		excludes = append(excludes, glob.MustCompile(item))
+		if _, err := glob.Compile(item); err != nil {
+			ctx.GetLogger().Error("%s", err)
+			return 1
+		}
+		excludes = append(excludes, item)
 	}
 
 	if opt_excludes != "" {
@@ -86,12 +138,11 @@ func cmd_backup(ctx *context.Context, repo *repository.Repository, args []string
 
 		scanner := bufio.NewScanner(fp)
 		for scanner.Scan() {
-			pattern, err := glob.Compile(scanner.Text())
-			if err != nil {
+			if _, err := glob.Compile(scanner.Text()); err != nil {
 				ctx.GetLogger().Error("%s", err)
 				return 1
 			}
-			excludes = append(excludes, pattern)
+			excludes = append(excludes, scanner.Text())
 		}
 		if err := scanner.Err(); err != nil {
 			ctx.GetLogger().Error("%s", err)
@@ -106,25 +157,11 @@ func cmd_backup(ctx *context.Context, repo *repository.Repository, args []string
 		return 1
 	}
 
-	identityID := os.Getenv("PLAKAR_IDENTITY")
-	if opt_identity != "" {
-		identityID = opt_identity
+	if err := identity.UseIdentity(ctx, opt_identity); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
+		return 1
 	}
-	if identityID != "" {
-		parsedID, err := uuid.Parse(identityID)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s: invalid identity: %s\n", flag.CommandLine.Name(), err)
-			return 1
-		}
-
-		id, err := identity.UnsealIdentity(ctx.GetKeyringDir(), parsedID)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s: could not unseal identity: %s\n", flag.CommandLine.Name(), err)
-			return 1
-		}
-		ctx.SetIdentity(id.Identifier)
-		ctx.SetKeypair(&id.KeyPair)
-	} else {
+	if ctx.GetIdentity() == uuid.Nil {
 		ctx.GetLogger().Warn("no identity set, snapshot will not be signed")
 		ctx.GetLogger().Warn("consider using 'plakar id' to create an identity")
 	}
@@ -137,37 +174,95 @@ func cmd_backup(ctx *context.Context, repo *repository.Repository, args []string
 	}
 
 	opts := &snapshot.BackupOptions{
-		MaxConcurrency: opt_concurrency,
-		Name:           "default",
-		Tags:           tags,
-		Excludes:       excludes,
+		MaxConcurrency:     opt_concurrency,
+		Tags:               tags,
+		Excludes:           excludes,
+		CheckpointInterval: opt_checkpointInterval,
+		DiscardCheckpoint:  opt_noResume,
+		OneFileSystem:      opt_oneFileSystem,
+		ExcludeMarkers:     opt_excludeMarker,
+	}
+	parseMetadata(ctx.GetConfig(), opts, opt_name, opt_category, opt_environment, opt_perimeter, opt_meta)
+	if opt_excludeCaches {
+		opts.ExcludeMarkers = append(opts.ExcludeMarkers, importer.CacheDirTag)
+	}
+	if err := parseFilters(opts, opt_minSize, opt_maxSize, opt_newerThan, opt_olderThan, opt_excludeType); err != nil {
+		ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+		return 1
+	}
+	opts.Hooks, err = parseHooks(ctx.GetConfig(), map[string][]string{
+		snapshot.HookPreScan:    opt_preScan,
+		snapshot.HookPostScan:   opt_postScan,
+		snapshot.HookPreCommit:  opt_preCommit,
+		snapshot.HookPostCommit: opt_postCommit,
+	}, opt_hookPolicy)
+	if err != nil {
+		ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+		return 1
 	}
 
-	if flags.NArg() == 0 {
-		err = snap.Backup(ctx.GetCWD(), opts)
-	} else if flags.NArg() == 1 {
-		var cleanPath string
+	if opt_command != "" {
+		if opt_stdin != "" || opt_dryRun {
+			ctx.GetLogger().Error("%s: -command cannot be used with -stdin or -dry-run", flags.Name())
+			return 1
+		}
+		imp, err := stdio.NewCommandImporter(opt_command, flags.Args())
+		if err != nil {
+			ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+			return 1
+		}
+		err = snap.BackupImporter(imp, opts)
+		imp.Close()
+		if err != nil {
+			ctx.GetLogger().Error("failed to create snapshot: %s", err)
+			return 1
+		}
+		return backupDone(ctx, snap)
+	}
 
-		if !strings.HasPrefix(flags.Arg(0), "/") {
-			_, err := importer.NewImporter(flags.Arg(0))
+	var scanDirs []string
+	if opt_stdin != "" {
+		if flags.NArg() != 0 {
+			ctx.GetLogger().Error("%s: -stdin does not take a directory", flags.Name())
+			return 1
+		}
+		scanDirs = append(scanDirs, "stdin://"+opt_stdin)
+	} else if flags.NArg() == 0 {
+		scanDirs = append(scanDirs, ctx.GetCWD())
+	}
+	for _, arg := range flags.Args() {
+		if !strings.HasPrefix(arg, "/") {
+			_, err := importer.NewImporter(arg)
 			if err != nil {
-				cleanPath = path.Clean(ctx.GetCWD() + "/" + flags.Arg(0))
+				scanDirs = append(scanDirs, path.Clean(ctx.GetCWD()+"/"+arg))
 			} else {
-				cleanPath = flags.Arg(0)
+				scanDirs = append(scanDirs, arg)
 			}
 		} else {
-			cleanPath = path.Clean(flags.Arg(0))
+			scanDirs = append(scanDirs, path.Clean(arg))
 		}
-		err = snap.Backup(cleanPath, opts)
-	} else {
-		log.Fatal("only one directory pushable")
 	}
 
+	if opt_dryRun {
+		report, err := snap.DryRun(scanDirs, opts, 10)
+		if err != nil {
+			ctx.GetLogger().Error("failed to estimate backup: %s", err)
+			return 1
+		}
+		printDryRunReport(report)
+		return 0
+	}
+
+	err = snap.BackupSources(scanDirs, opts)
+
 	if err != nil {
 		ctx.GetLogger().Error("failed to create snapshot: %s", err)
 		return 1
 	}
+	return backupDone(ctx, snap)
+}
 
+func backupDone(ctx *context.Context, snap *snapshot.Snapshot) int {
 	signedStr := "unsigned"
 	if ctx.GetIdentity() != uuid.Nil {
 		signedStr = "signed"
@@ -180,3 +275,28 @@ func cmd_backup(ctx *context.Context, repo *repository.Repository, args []string
 		snap.Header.Duration)
 	return 0
 }
+
+func printDryRunReport(report *snapshot.DryRunReport) {
+	fmt.Printf("Files: %d\n", report.Files)
+	fmt.Printf("Directories: %d\n", report.Directories)
+	if report.Errors != 0 {
+		fmt.Printf("Errors: %d\n", report.Errors)
+	}
+	fmt.Printf("Total size: %s (%d bytes)\n", humanize.Bytes(report.Size), report.Size)
+	fmt.Printf("Chunks: %d, %d new\n", report.Chunks, report.NewChunks)
+	fmt.Printf("New unique size: %s (%d bytes)\n", humanize.Bytes(report.NewSize), report.NewSize)
+	fmt.Printf("Estimated stored size: %s (%d bytes)\n", humanize.Bytes(report.EncodedSize), report.EncodedSize)
+
+	if len(report.LargestFiles) != 0 {
+		fmt.Println("Largest new files:")
+		for _, entry := range report.LargestFiles {
+			fmt.Printf("%10s %s\n", humanize.Bytes(entry.NewSize), entry.Pathname)
+		}
+	}
+	if len(report.LargestDirectories) != 0 {
+		fmt.Println("Largest new directories:")
+		for _, entry := range report.LargestDirectories {
+			fmt.Printf("%10s %s\n", humanize.Bytes(entry.NewSize), entry.Pathname)
+		}
+	}
+}
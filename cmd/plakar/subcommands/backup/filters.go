@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2024 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+	"github.com/dustin/go-humanize"
+)
+
+var recordTypes = map[string]importer.RecordType{
+	"socket":  importer.RecordTypeSocket,
+	"device":  importer.RecordTypeDevice,
+	"pipe":    importer.RecordTypePipe,
+	"symlink": importer.RecordTypeSymlink,
+}
+
+func parseSize(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := humanize.ParseBytes(value)
+	if err != nil {
+		return 0, err
+	}
+	return int64(size), nil
+}
+
+// parseTime accepts a date, a timestamp or a duration before now, durations
+// also accept a number of days such as "30d".
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if days, found := strings.CutSuffix(value, "d"); found {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date or duration: %s", value)
+	}
+	return now.Add(-duration), nil
+}
+
+func parseFilters(opts *snapshot.BackupOptions, minSize, maxSize, newerThan, olderThan string, types []string) error {
+	var err error
+	if opts.MinSize, err = parseSize(minSize); err != nil {
+		return err
+	}
+	if opts.MaxSize, err = parseSize(maxSize); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if opts.NewerThan, err = parseTime(newerThan, now); err != nil {
+		return err
+	}
+	if opts.OlderThan, err = parseTime(olderThan, now); err != nil {
+		return err
+	}
+
+	for _, value := range types {
+		for _, name := range strings.Split(value, ",") {
+			recordType, exists := recordTypes[name]
+			if !exists {
+				return fmt.Errorf("invalid record type: %s", name)
+			}
+			opts.ExcludeTypes = append(opts.ExcludeTypes, recordType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2024 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/config"
+	"github.com/PlakarKorp/plakar/snapshot"
+)
+
+// parseHooks builds the hooks of each stage, commands given on the command
+// line replace those of the [backup] section of the configuration file and
+// so do policies, given as stage=policy.
+func parseHooks(cfg *config.Config, commands map[string][]string, policies []string) ([]snapshot.Hook, error) {
+	stagePolicies := make(map[string]snapshot.HookPolicy)
+	for _, stage := range snapshot.HookStages {
+		if value := cfg.Get("backup", stage+"-policy"); value != "" {
+			policy, err := snapshot.ParseHookPolicy(value)
+			if err != nil {
+				return nil, err
+			}
+			stagePolicies[stage] = policy
+		}
+	}
+	for _, item := range policies {
+		stage, value, found := strings.Cut(item, "=")
+		if !found || !isHookStage(stage) {
+			return nil, fmt.Errorf("invalid hook policy %q, must be stage=policy", item)
+		}
+		policy, err := snapshot.ParseHookPolicy(value)
+		if err != nil {
+			return nil, err
+		}
+		stagePolicies[stage] = policy
+	}
+
+	hooks := make([]snapshot.Hook, 0)
+	for _, stage := range snapshot.HookStages {
+		stageCommands := commands[stage]
+		if len(stageCommands) == 0 {
+			if value := cfg.Get("backup", stage); value != "" {
+				stageCommands = []string{value}
+			}
+		}
+		for _, command := range stageCommands {
+			hooks = append(hooks, snapshot.Hook{
+				Stage:   stage,
+				Command: command,
+				Policy:  stagePolicies[stage],
+			})
+		}
+	}
+	return hooks, nil
+}
+
+func isHookStage(stage string) bool {
+	for _, s := range snapshot.HookStages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
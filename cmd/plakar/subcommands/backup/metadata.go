@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2024 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
+	"github.com/PlakarKorp/plakar/config"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/header"
+)
+
+// parseMetadata sets the name, classification and metadata of the snapshot,
+// flags override the defaults of the [backup] section of the configuration
+// file and -meta pairs those of its [backup.meta] section with the same key.
+func parseMetadata(cfg *config.Config, opts *snapshot.BackupOptions, name, category, environment, perimeter string, meta utils.MetaFlags) {
+	withDefault := func(value string, key string) string {
+		if value == "" {
+			return cfg.Get("backup", key)
+		}
+		return value
+	}
+	opts.Name = withDefault(name, "name")
+	opts.Category = withDefault(category, "category")
+	opts.Environment = withDefault(environment, "environment")
+	opts.Perimeter = withDefault(perimeter, "perimeter")
+
+	if opts.Name == "" {
+		opts.Name = "default"
+	}
+
+	overridden := make(map[string]bool)
+	for _, kv := range meta {
+		overridden[kv.Key] = true
+	}
+	for _, key := range cfg.Keys("backup.meta") {
+		if !overridden[key] {
+			opts.Meta = append(opts.Meta, header.KeyValue{Key: key, Value: cfg.Get("backup.meta", key)})
+		}
+	}
+	opts.Meta = append(opts.Meta, meta...)
+}
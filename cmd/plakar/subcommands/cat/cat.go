@@ -40,12 +40,23 @@ func init() {
 func cmd_cat(ctx *context.Context, repo *repository.Repository, args []string) int {
 	var opt_nodecompress bool
 	var opt_highlight bool
+	var opt_offset int64
+	var opt_length int64
 
 	flags := flag.NewFlagSet("cat", flag.ExitOnError)
 	flags.BoolVar(&opt_nodecompress, "no-decompress", false, "do not try to decompress output")
 	flags.BoolVar(&opt_highlight, "highlight", false, "highlight output")
+	flags.Int64Var(&opt_offset, "offset", 0, "start output at this byte offset of the file")
+	flags.Int64Var(&opt_length, "length", -1, "output at most this many bytes")
 	flags.Parse(args)
 
+	if opt_offset < 0 {
+		ctx.GetLogger().Error("%s: invalid offset %d", flags.Name(), opt_offset)
+		return 1
+	}
+	// a byte range addresses the file as stored, it is never decompressed
+	byteRange := opt_offset != 0 || opt_length >= 0
+
 	if flags.NArg() == 0 {
 		ctx.GetLogger().Error("%s: at least one parameter is required", flags.Name())
 		return 1
@@ -95,13 +106,20 @@ func cmd_cat(ctx *context.Context, repo *repository.Repository, args []string) i
 			continue
 		}
 
-		var outRd io.ReadCloser = rd
+		var outRd io.Reader = rd
 
-		if !opt_nodecompress {
-			if fileEntry.Object.ContentType == "application/gzip" && !opt_nodecompress {
+		if byteRange {
+			length := opt_length
+			if length < 0 {
+				length = max(fileEntry.Stat().Size()-opt_offset, 0)
+			}
+			outRd = io.NewSectionReader(rd, opt_offset, length)
+		} else if !opt_nodecompress {
+			if fileEntry.Object.ContentType == "application/gzip" {
 				gzRd, err := gzip.NewReader(outRd)
 				if err != nil {
 					ctx.GetLogger().Error("%s: %s: %s", flags.Name(), pathname, err)
+					rd.Close()
 					errors++
 					continue
 				}
@@ -120,7 +138,7 @@ func cmd_cat(ctx *context.Context, repo *repository.Repository, args []string) i
 			formatter := formatters.Get("terminal")
 			style := styles.Get("dracula")
 
-			reader := bufio.NewReader(rd)
+			reader := bufio.NewReader(outRd)
 			buffer := make([]byte, 4096) // Fixed-size buffer for chunked reading
 			for {
 				n, err := reader.Read(buffer) // Read up to the size of the buffer
@@ -156,6 +174,7 @@ func cmd_cat(ctx *context.Context, repo *repository.Repository, args []string) i
 		} else {
 			_, err = io.Copy(os.Stdout, outRd)
 		}
+		rd.Close()
 		if err != nil {
 			ctx.GetLogger().Error("%s: %s: %s", flags.Name(), pathname, err)
 			errors++
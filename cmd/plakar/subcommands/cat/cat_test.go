@@ -0,0 +1,92 @@
+package cat
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/context"
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot"
+
+	_ "github.com/PlakarKorp/plakar/snapshot/importer/fs"
+)
+
+// newTestSnapshot backs up a file holding content to a new repository, it
+// returns the repository and the argument naming the file for cat.
+func newTestSnapshot(t *testing.T, content string) (*context.Context, *repository.Repository, string) {
+	t.Helper()
+
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	pathname := filepath.Join(src, "file.txt")
+	if err := os.WriteFile(pathname, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	snap, err := snapshot.New(repo)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if err := snap.Backup(src, &snapshot.BackupOptions{MaxConcurrency: 4}); err != nil {
+		t.Fatalf("Failed to back up %s: %v", src, err)
+	}
+	return repo.Context(), repo, fmt.Sprintf("%x:%s", snap.Header.Identifier, pathname)
+}
+
+// cat runs the cat command with args and returns its status and output.
+func cat(t *testing.T, ctx *context.Context, repo *repository.Repository, args ...string) (int, string) {
+	t.Helper()
+
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = wr
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(rd)
+		output <- string(data)
+	}()
+	status := cmd_cat(ctx, repo, args)
+	wr.Close()
+	return status, <-output
+}
+
+func TestCatRange(t *testing.T) {
+	content := "0123456789abcdefghij"
+	ctx, repo, file := newTestSnapshot(t, content)
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{nil, content},
+		{[]string{"-offset", "5"}, content[5:]},
+		{[]string{"-length", "4"}, content[:4]},
+		{[]string{"-offset", "5", "-length", "4"}, content[5:9]},
+		{[]string{"-offset", "18", "-length", "10"}, content[18:]},
+		{[]string{"-offset", "20"}, ""},
+		{[]string{"-offset", "100", "-length", "4"}, ""},
+		{[]string{"-length", "0"}, ""},
+	}
+	for _, test := range tests {
+		status, output := cat(t, ctx, repo, append(test.args, file)...)
+		if status != 0 {
+			t.Errorf("Expected cat %v to succeed, got status %d", test.args, status)
+		}
+		if output != test.expected {
+			t.Errorf("Expected cat %v to output %q, got %q", test.args, test.expected, output)
+		}
+	}
+
+	if status, output := cat(t, ctx, repo, "-offset", "-1", file); status == 0 || output != "" {
+		t.Errorf("Expected a negative offset to fail, got status %d and %q", status, output)
+	}
+}
@@ -87,6 +87,10 @@ func cmd_check(ctx *context.Context, repo *repository.Repository, args []string)
 			failures = true
 		}
 
+		if err := ctx.Err(); err != nil {
+			ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+			return 1
+		}
 	}
 
 	if failures {
@@ -48,7 +48,7 @@ func cmd_clone(ctx *context.Context, repo *repository.Repository, args []string)
 	configuration := sourceStore.Configuration()
 	configuration.RepositoryID = uuid.Must(uuid.NewRandom())
 
-	cloneStore, err := storage.Create(flags.Arg(1), configuration)
+	cloneStore, err := storage.Create(ctx, flags.Arg(1), configuration)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s: could not create repository: %s\n", flags.Arg(1), err)
 		return 1
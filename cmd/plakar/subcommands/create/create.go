@@ -42,15 +42,18 @@ func cmd_create(ctx *context.Context, _ *repository.Repository, args []string) i
 	var opt_nocompression bool
 	var opt_hashing string
 	var opt_compression string
+	var opt_padding bool
 
 	flags := flag.NewFlagSet("create", flag.ExitOnError)
 	flags.BoolVar(&opt_noencryption, "no-encryption", false, "disable transparent encryption")
 	flags.BoolVar(&opt_nocompression, "no-compression", false, "disable transparent compression")
 	flags.StringVar(&opt_hashing, "hashing", "SHA256", "swap the hashing function")
 	flags.StringVar(&opt_compression, "compression", "LZ4", "swap the compression function")
+	flags.BoolVar(&opt_padding, "padding", false, "pad packfiles to hide their exact size")
 	flags.Parse(args)
 
 	storageConfiguration := storage.NewConfiguration()
+	storageConfiguration.Packfile.Padding = opt_padding
 	if opt_nocompression {
 		storageConfiguration.Compression = nil
 	} else {
@@ -105,14 +108,14 @@ func cmd_create(ctx *context.Context, _ *repository.Repository, args []string) i
 
 	switch flags.NArg() {
 	case 0:
-		repo, err := storage.Create(filepath.Join(ctx.GetHomeDir(), ".plakar"), *storageConfiguration)
+		repo, err := storage.Create(ctx, filepath.Join(ctx.GetHomeDir(), ".plakar"), *storageConfiguration)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
 			return 1
 		}
 		repo.Close()
 	case 1:
-		repo, err := storage.Create(flags.Arg(0), *storageConfiguration)
+		repo, err := storage.Create(ctx, flags.Arg(0), *storageConfiguration)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
 			return 1
@@ -23,6 +23,8 @@ import (
 	"log"
 	"os"
 	"path"
+	"sort"
+	"strings"
 
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
@@ -92,23 +94,30 @@ func cmd_diff(ctx *context.Context, repo *repository.Repository, args []string)
 	return 0
 }
 
+// diff_filesystems compares the source directories of both snapshots, a
+// snapshot may have several of them.
 func diff_filesystems(snap1 *snapshot.Snapshot, snap2 *snapshot.Snapshot) (string, error) {
-	vfs1, err := snap1.Filesystem()
-	if err != nil {
-		return "", err
-	}
-
-	vfs2, err := snap2.Filesystem()
-	if err != nil {
-		return "", err
+	directories := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, snap := range []*snapshot.Snapshot{snap1, snap2} {
+		for _, source := range snap.Header.GetSources() {
+			if _, exists := seen[source.Directory]; !exists {
+				seen[source.Directory] = struct{}{}
+				directories = append(directories, source.Directory)
+			}
+		}
 	}
+	sort.Strings(directories)
 
-	stat1, err1 := vfs1.Stat("/")
-	stat2, err2 := vfs2.Stat("/")
-	if err1 != nil && err2 != nil {
-		return "", fmt.Errorf("root not found in both snapshots")
+	var diff strings.Builder
+	for _, directory := range directories {
+		text, err := diff_pathnames(snap1, directory, snap2, directory)
+		if err != nil {
+			return "", err
+		}
+		diff.WriteString(text)
 	}
-	return diff_directories(stat1.(*vfs.DirEntry), stat2.(*vfs.DirEntry))
+	return diff.String(), nil
 }
 
 func diff_pathnames(snap1 *snapshot.Snapshot, pathname1 string, snap2 *snapshot.Snapshot, pathname2 string) (string, error) {
@@ -124,14 +133,19 @@ func diff_pathnames(snap1 *snapshot.Snapshot, pathname1 string, snap2 *snapshot.
 
 	stat1, err1 := vfs1.Stat(pathname1)
 	stat2, err2 := vfs2.Stat(pathname2)
-	if err1 != nil && err2 != nil {
+	switch {
+	case err1 != nil && err2 != nil:
 		return "", fmt.Errorf("file not found in both snapshots")
+	case err1 != nil:
+		return fmt.Sprintf("Only in %x: %s\n", snap2.Header.GetIndexShortID(), pathname2), nil
+	case err2 != nil:
+		return fmt.Sprintf("Only in %x: %s\n", snap1.Header.GetIndexShortID(), pathname1), nil
 	}
 
 	dirEntry1, isDir1 := stat1.(*vfs.DirEntry)
 	dirEntry2, isDir2 := stat2.(*vfs.DirEntry)
 	if isDir1 && isDir2 {
-		return diff_directories(dirEntry1, dirEntry2)
+		return diff_directories(snap1, vfs1, dirEntry1, pathname1, snap2, vfs2, dirEntry2, pathname2)
 	}
 
 	fileEntry1, isFile1 := stat1.(*vfs.FileEntry)
@@ -140,20 +154,93 @@ func diff_pathnames(snap1 *snapshot.Snapshot, pathname1 string, snap2 *snapshot.
 		return diff_files(snap1, fileEntry1, snap2, fileEntry2)
 	}
 
-	return "", fmt.Errorf("not implemented yet")
+	return fmt.Sprintf("%x:%s and %x:%s are of different types\n",
+		snap1.Header.GetIndexShortID(), pathname1, snap2.Header.GetIndexShortID(), pathname2), nil
 }
 
-func diff_directories(dirEntry1 *vfs.DirEntry, dirEntry2 *vfs.DirEntry) (string, error) {
-	_ = dirEntry1
-	_ = dirEntry2
-	return "", fmt.Errorf("not implemented yet")
+// diff_directories compares the entries of both directories recursively,
+// entries present on one side only are reported as in diff -r and files
+// with identical content are left out.
+func diff_directories(snap1 *snapshot.Snapshot, vfs1 *vfs.Filesystem, dirEntry1 *vfs.DirEntry, pathname1 string, snap2 *snapshot.Snapshot, vfs2 *vfs.Filesystem, dirEntry2 *vfs.DirEntry, pathname2 string) (string, error) {
+	children := make(map[string][2]vfs.FSEntry)
+	for i, dir := range []struct {
+		fs    *vfs.Filesystem
+		entry *vfs.DirEntry
+	}{{vfs1, dirEntry1}, {vfs2, dirEntry2}} {
+		iter, err := dir.fs.ChildrenIter(dir.entry)
+		if err != nil {
+			return "", err
+		}
+		for child := range iter {
+			name := child.Stat().Name()
+			entries := children[name]
+			if entries[i], err = dir.fs.Stat(path.Join([]string{pathname1, pathname2}[i], name)); err != nil {
+				for range iter {
+				}
+				return "", err
+			}
+			children[name] = entries
+		}
+	}
+
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var diff strings.Builder
+	for _, name := range names {
+		entry1, entry2 := children[name][0], children[name][1]
+		child1, child2 := path.Join(pathname1, name), path.Join(pathname2, name)
+
+		switch {
+		case entry1 == nil:
+			fmt.Fprintf(&diff, "Only in %x:%s: %s\n", snap2.Header.GetIndexShortID(), pathname2, name)
+			continue
+		case entry2 == nil:
+			fmt.Fprintf(&diff, "Only in %x:%s: %s\n", snap1.Header.GetIndexShortID(), pathname1, name)
+			continue
+		}
+
+		dirEntry1, isDir1 := entry1.(*vfs.DirEntry)
+		dirEntry2, isDir2 := entry2.(*vfs.DirEntry)
+		fileEntry1, isFile1 := entry1.(*vfs.FileEntry)
+		fileEntry2, isFile2 := entry2.(*vfs.FileEntry)
+
+		var text string
+		var err error
+		switch {
+		case isDir1 && isDir2:
+			text, err = diff_directories(snap1, vfs1, dirEntry1, child1, snap2, vfs2, dirEntry2, child2)
+		case isFile1 && isFile2:
+			if identical(fileEntry1, fileEntry2) {
+				continue
+			}
+			text, err = diff_files(snap1, fileEntry1, snap2, fileEntry2)
+		default:
+			text = fmt.Sprintf("%x:%s and %x:%s are of different types\n",
+				snap1.Header.GetIndexShortID(), child1, snap2.Header.GetIndexShortID(), child2)
+		}
+		if err != nil {
+			return "", err
+		}
+		diff.WriteString(text)
+	}
+	return diff.String(), nil
 }
 
-func diff_files(snap1 *snapshot.Snapshot, fileEntry1 *vfs.FileEntry, snap2 *snapshot.Snapshot, fileEntry2 *vfs.FileEntry) (string, error) {
-	_ = fileEntry1
-	_ = fileEntry2
+// identical reports whether both files have the same content, empty files
+// have no object.
+func identical(fileEntry1 *vfs.FileEntry, fileEntry2 *vfs.FileEntry) bool {
+	if fileEntry1.Object == nil || fileEntry2.Object == nil {
+		return fileEntry1.Object == fileEntry2.Object && fileEntry1.SymlinkTarget == fileEntry2.SymlinkTarget
+	}
+	return fileEntry1.Object.Checksum == fileEntry2.Object.Checksum
+}
 
-	if fileEntry1.Object.Checksum == fileEntry2.Object.Checksum {
+func diff_files(snap1 *snapshot.Snapshot, fileEntry1 *vfs.FileEntry, snap2 *snapshot.Snapshot, fileEntry2 *vfs.FileEntry) (string, error) {
+	if identical(fileEntry1, fileEntry2) {
 		fmt.Printf("%s:%s and %s:%s are identical\n",
 			fmt.Sprintf("%x", snap1.Header.GetIndexShortID()), path.Join(fileEntry1.ParentPath, fileEntry1.Stat().Name()),
 			fmt.Sprintf("%x", snap2.Header.GetIndexShortID()), path.Join(fileEntry2.ParentPath, fileEntry2.Stat().Name()))
@@ -27,6 +27,7 @@ import (
 	"github.com/PlakarKorp/plakar/context"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/search"
+	"github.com/PlakarKorp/plakar/snapshot"
 	"github.com/dustin/go-humanize"
 )
 
@@ -36,34 +37,58 @@ func init() {
 
 func cmd_find(ctx *context.Context, repo *repository.Repository, args []string) int {
 	flags := flag.NewFlagSet("find", flag.ExitOnError)
+	filter := utils.FilterFlags(flags)
 	flags.Parse(args)
 
-	if flags.NArg() < 2 {
-		log.Fatalf("%s: need at least a chunk prefix to search", flag.CommandLine.Name())
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		log.Fatalf("%s: need a search expression and an optional snapshot", flag.CommandLine.Name())
 	}
 
-	snapshotID, prefix := utils.ParseSnapshotID(flags.Arg(0))
+	// without a snapshot, search all those selected by the filter
+	var snapshots []*snapshot.Snapshot
+	query := flags.Arg(0)
+	prefix := "/"
+	if flags.NArg() == 2 {
+		var snapshotID string
+		snapshotID, prefix = utils.ParseSnapshotID(flags.Arg(0))
+		query = flags.Arg(1)
 
-	snap, err := utils.OpenSnapshotByPrefix(repo, snapshotID)
-	if err != nil {
-		log.Fatalf("failed to open snapshot: %v", err)
+		snap, err := utils.OpenSnapshotByPrefix(repo, snapshotID)
+		if err != nil {
+			log.Fatalf("failed to open snapshot: %v", err)
+		}
+		snapshots = append(snapshots, snap)
+	} else {
+		var err error
+		snapshots, err = utils.GetSnapshots(repo, nil)
+		if err != nil {
+			log.Fatalf("failed to load snapshots: %v", err)
+		}
 	}
 
-	results, err := snap.Search(prefix, flags.Arg(1))
-	if err != nil {
-		log.Fatalf("failed to search: %v", err)
-	}
+	annotations := snapshot.LoadAnnotations(repo)
+	for _, snap := range snapshots {
+		annotations.Apply(snap.Header)
+		if !filter.Match(snap.Header) {
+			continue
+		}
 
-	for result := range results {
-		if entry, isFilename := result.(search.FileEntry); isFilename {
-			fmt.Printf("%s %s %s %x:%s\n",
-				entry.FileEntry.Stat().ModTime().UTC().Format(time.RFC3339),
-				entry.FileEntry.Stat().Mode(),
-				humanize.Bytes(uint64(entry.FileEntry.Stat().Size())),
-				entry.Snapshot[0:4],
-				entry.FileEntry.Path())
-		} else {
-			fmt.Printf("%+v\n", result)
+		results, err := snap.Search(prefix, query)
+		if err != nil {
+			log.Fatalf("failed to search: %v", err)
+		}
+
+		for result := range results {
+			if entry, isFilename := result.(search.FileEntry); isFilename {
+				fmt.Printf("%s %s %s %x:%s\n",
+					entry.FileEntry.Stat().ModTime().UTC().Format(time.RFC3339),
+					entry.FileEntry.Stat().Mode(),
+					humanize.Bytes(uint64(entry.FileEntry.Stat().Size())),
+					entry.Snapshot[0:4],
+					entry.FileEntry.Path())
+			} else {
+				fmt.Printf("%+v\n", result)
+			}
 		}
 	}
 
@@ -17,10 +17,7 @@
 package info
 
 import (
-	"bytes"
-	"crypto/sha256"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/hex"
 	"flag"
 	"fmt"
@@ -32,11 +29,14 @@ import (
 
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
+	"github.com/PlakarKorp/plakar/compression"
 	"github.com/PlakarKorp/plakar/context"
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/packfile"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/repository/state"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/header"
 	"github.com/PlakarKorp/plakar/snapshot/vfs"
 	"github.com/dustin/go-humanize"
 	"github.com/google/uuid"
@@ -131,6 +131,10 @@ func info_repository(repo *repository.Repository) int {
 	fmt.Printf(" - MaxSize: %s (%d bytes)\n",
 		humanize.Bytes(uint64(repo.Configuration().Packfile.MaxSize)),
 		repo.Configuration().Packfile.MaxSize)
+	fmt.Printf(" - MaxMetadataSize: %s (%d bytes)\n",
+		humanize.Bytes(uint64(repo.Configuration().Packfile.MaxSizeFor(packfile.TYPE_SNAPSHOT))),
+		repo.Configuration().Packfile.MaxSizeFor(packfile.TYPE_SNAPSHOT))
+	fmt.Println(" - Padding:", repo.Configuration().Packfile.Padding)
 
 	fmt.Println("Chunking:")
 	fmt.Println(" - Algorithm:", repo.Configuration().Chunking.Algorithm)
@@ -175,6 +179,7 @@ func info_snapshot(repo *repository.Repository, snapshotID string) error {
 	}
 
 	header := snap.Header
+	snapshot.LoadAnnotations(repo).Apply(header)
 
 	indexID := header.GetIndexID()
 	fmt.Printf("Version: %s\n", repo.Configuration().Version)
@@ -189,6 +194,12 @@ func info_snapshot(repo *repository.Repository, snapshotID string) error {
 	if len(header.Tags) > 0 {
 		fmt.Printf("Tags: %s\n", strings.Join(header.Tags, ", "))
 	}
+	if header.Note != "" {
+		fmt.Printf("Note: %s\n", header.Note)
+	}
+	if header.Pinned {
+		fmt.Println("Pinned: true")
+	}
 
 	if header.Identity.Identifier != uuid.Nil {
 		fmt.Println("Identity:")
@@ -202,9 +213,14 @@ func info_snapshot(repo *repository.Repository, snapshotID string) error {
 	fmt.Printf("Statistics: %x\n", header.Statistics)
 
 	fmt.Println("Importer:")
-	fmt.Printf(" - Type: %s\n", header.Importer.Type)
-	fmt.Printf(" - Origin: %s\n", header.Importer.Origin)
-	fmt.Printf(" - Directory: %s\n", header.Importer.Directory)
+	printImporter("", header.Importer)
+	if len(header.Sources) != 0 {
+		fmt.Println("Sources:")
+		for _, source := range header.Sources {
+			fmt.Printf(" - %s:\n", source.Directory)
+			printImporter("  ", source)
+		}
+	}
 
 	fmt.Println("Context:")
 	fmt.Printf(" - MachineID: %s\n", header.GetContext("MachineID"))
@@ -217,6 +233,18 @@ func info_snapshot(repo *repository.Repository, snapshotID string) error {
 	fmt.Printf(" - ProcessID: %s\n", header.GetContext("ProcessID"))
 	fmt.Printf(" - Client: %s\n", header.GetContext("Client"))
 	fmt.Printf(" - CommandLine: %s\n", header.GetContext("CommandLine"))
+	for _, kv := range header.Context {
+		if strings.HasPrefix(kv.Key, "Hook.") {
+			fmt.Printf(" - %s: %s\n", kv.Key, strings.TrimRight(kv.Value, "\n"))
+		}
+	}
+
+	if metas := header.GetMetas(); len(metas) != 0 {
+		fmt.Println("Meta:")
+		for _, kv := range metas {
+			fmt.Printf(" - %s: %s\n", kv.Key, kv.Value)
+		}
+	}
 
 	fmt.Println("Summary:")
 	fmt.Printf(" - Directories: %d\n", header.Summary.Directory.Directories+header.Summary.Below.Directories)
@@ -384,46 +412,7 @@ func info_packfile(repo *repository.Repository, args []string) error {
 				log.Fatal(err)
 			}
 
-			versionBytes := rawPackfile[len(rawPackfile)-5 : len(rawPackfile)-5+4]
-			version := binary.LittleEndian.Uint32(versionBytes)
-
-			//			version := rawPackfile[len(rawPackfile)-2]
-			footerOffset := rawPackfile[len(rawPackfile)-1]
-			rawPackfile = rawPackfile[:len(rawPackfile)-5]
-
-			_ = version
-
-			footerbuf := rawPackfile[len(rawPackfile)-int(footerOffset):]
-			rawPackfile = rawPackfile[:len(rawPackfile)-int(footerOffset)]
-
-			footerbuf, err = repo.DecodeBuffer(footerbuf)
-			if err != nil {
-				log.Fatal(err)
-			}
-			footer, err := packfile.NewFooterFromBytes(footerbuf)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			indexbuf := rawPackfile[int(footer.IndexOffset):]
-			rawPackfile = rawPackfile[:int(footer.IndexOffset)]
-
-			indexbuf, err = repo.DecodeBuffer(indexbuf)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			hasher := sha256.New()
-			hasher.Write(indexbuf)
-
-			if !bytes.Equal(hasher.Sum(nil), footer.IndexChecksum[:]) {
-				log.Fatal("index checksum mismatch")
-			}
-
-			rawPackfile = append(rawPackfile, indexbuf...)
-			rawPackfile = append(rawPackfile, footerbuf...)
-
-			p, err := packfile.NewFromBytes(rawPackfile)
+			p, err := repo.DecodePackfile(rawPackfile)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -434,13 +423,38 @@ func info_packfile(repo *repository.Repository, args []string) error {
 			fmt.Println()
 
 			for i, entry := range p.Index {
-				fmt.Printf("blob[%d]: %x %d %d %s\n", i, entry.Checksum, entry.Offset, entry.Length, entry.TypeName())
+				fmt.Printf("blob[%d]: %x %d %d %s %s\n", i, entry.Checksum, entry.Offset, entry.Length, entry.TypeName(), blobFlags(entry.Flags))
 			}
 		}
 	}
 	return nil
 }
 
+func blobFlags(flags packfile.Flags) string {
+	if flags&packfile.FLAG_V2 == 0 {
+		return "legacy"
+	}
+
+	ret := make([]string, 0)
+	if flags&packfile.FLAG_COMPRESSED != 0 {
+		algorithm, err := compression.CodecName(flags.Codec())
+		if err != nil {
+			algorithm = fmt.Sprintf("codec(%d)", flags.Codec())
+		}
+		ret = append(ret, strings.ToLower(algorithm))
+	}
+	if flags&packfile.FLAG_ENCRYPTED != 0 {
+		ret = append(ret, "encrypted")
+	}
+	if flags&packfile.FLAG_METADATA != 0 {
+		ret = append(ret, "metadata")
+	}
+	if len(ret) == 0 {
+		return "raw"
+	}
+	return strings.Join(ret, ",")
+}
+
 func info_object(repo *repository.Repository, objectID string) error {
 	if len(objectID) != 64 {
 		log.Fatalf("invalid object hash: %s", objectID)
@@ -478,6 +492,10 @@ func info_object(repo *repository.Repository, objectID string) error {
 
 	fmt.Println("  chunks:")
 	for _, chunk := range object.Chunks {
+		if chunk.IsHole() {
+			fmt.Printf("    hole: %d bytes\n", chunk.Length)
+			continue
+		}
 		fmt.Printf("    checksum: %x\n", chunk.Checksum)
 	}
 	return nil
@@ -648,7 +666,11 @@ func info_vfs(repo *repository.Repository, snapshotPath string) error {
 		if fileEntry.Object != nil {
 			fmt.Printf("Checksum: %x\n", fileEntry.Object.Checksum)
 			for offset, chunk := range fileEntry.Object.Chunks {
-				fmt.Printf("Chunk[%d].Checksum: %x\n", offset, chunk.Checksum)
+				if chunk.IsHole() {
+					fmt.Printf("Chunk[%d].Hole: true\n", offset)
+				} else {
+					fmt.Printf("Chunk[%d].Checksum: %x\n", offset, chunk.Checksum)
+				}
 				fmt.Printf("Chunk[%d].Length: %d\n", offset, chunk.Length)
 			}
 		}
@@ -678,3 +700,19 @@ func info_errors(repo *repository.Repository, snapshotID string) error {
 
 	return nil
 }
+
+func printImporter(indent string, importer header.Importer) {
+	fmt.Printf("%s - Type: %s\n", indent, importer.Type)
+	fmt.Printf("%s - Origin: %s\n", indent, importer.Origin)
+	fmt.Printf("%s - Directory: %s\n", indent, importer.Directory)
+	if importer.Command != "" {
+		fmt.Printf("%s - Command: %s\n", indent, importer.Command)
+		fmt.Printf("%s - ExitStatus: %d\n", indent, importer.ExitStatus)
+	}
+	if len(importer.Excludes) != 0 {
+		fmt.Printf("%s - Excludes:\n", indent)
+		for _, exclude := range importer.Excludes {
+			fmt.Printf("%s   - %s\n", indent, exclude)
+		}
+	}
+}
@@ -23,12 +23,16 @@ import (
 	"log"
 	"os"
 	"os/user"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
 	"github.com/PlakarKorp/plakar/context"
 	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/header"
 	"github.com/PlakarKorp/plakar/snapshot/vfs"
 	"github.com/dustin/go-humanize"
 )
@@ -39,17 +43,25 @@ func init() {
 
 func cmd_ls(ctx *context.Context, repo *repository.Repository, args []string) int {
 	var opt_recursive bool
-	var opt_tag string
 	var opt_uuid bool
+	var opt_groupBy string
 
 	flags := flag.NewFlagSet("ls", flag.ExitOnError)
 	flags.BoolVar(&opt_uuid, "uuid", false, "display uuid instead of short ID")
-	flags.StringVar(&opt_tag, "tag", "", "filter by tag")
 	flags.BoolVar(&opt_recursive, "recursive", false, "recursive listing")
+	flags.StringVar(&opt_groupBy, "group-by", "", "group snapshots by name, category, environment, perimeter or meta.<key>")
+	filter := utils.FilterFlags(flags)
 	flags.Parse(args)
 
+	if opt_groupBy != "" {
+		if _, err := header.ParseGroupBy(opt_groupBy); err != nil {
+			ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+			return 1
+		}
+	}
+
 	if flags.NArg() == 0 {
-		list_snapshots(repo, opt_uuid, opt_tag)
+		list_snapshots(repo, opt_uuid, filter, opt_groupBy)
 		return 0
 	}
 
@@ -57,41 +69,69 @@ func cmd_ls(ctx *context.Context, repo *repository.Repository, args []string) in
 	return 0
 }
 
-func list_snapshots(repo *repository.Repository, useUuid bool, tag string) {
+func list_snapshots(repo *repository.Repository, useUuid bool, filter *header.Filter, groupBy string) {
 	metadatas, err := utils.GetHeaders(repo, nil)
 	if err != nil {
 		log.Fatalf("%s: could not fetch snapshots list", flag.CommandLine.Name())
 	}
 
+	annotations := snapshot.LoadAnnotations(repo)
+
+	groups := make([]string, 0)
+	grouped := make(map[string][]*header.Header)
 	for _, metadata := range metadatas {
-		if tag != "" {
-			found := false
-			for _, t := range metadata.Tags {
-				if tag == t {
-					found = true
-					break
-				}
-			}
-			if !found {
-				continue
-			}
+		annotations.Apply(metadata)
+		if !filter.Match(metadata) {
+			continue
+		}
+		group := ""
+		if groupBy != "" {
+			group = metadata.GetField(groupBy)
+		}
+		if _, exists := grouped[group]; !exists {
+			groups = append(groups, group)
 		}
-		if !useUuid {
-			fmt.Fprintf(os.Stdout, "%s %10s%10s%10s %s\n",
-				metadata.Timestamp.UTC().Format(time.RFC3339),
-				hex.EncodeToString(metadata.GetIndexShortID()),
-				humanize.Bytes(metadata.Summary.Directory.Size+metadata.Summary.Below.Size),
-				metadata.Duration.Round(time.Second),
-				metadata.Importer.Directory)
-		} else {
-			indexID := metadata.GetIndexID()
-			fmt.Fprintf(os.Stdout, "%s %3s%10s%10s %s\n",
-				metadata.Timestamp.UTC().Format(time.RFC3339),
-				hex.EncodeToString(indexID[:]),
-				humanize.Bytes(metadata.Summary.Directory.Size+metadata.Summary.Below.Size),
-				metadata.Duration.Round(time.Second),
-				metadata.Importer.Directory)
+		grouped[group] = append(grouped[group], metadata)
+	}
+	sort.Strings(groups)
+
+	indent := ""
+	if groupBy != "" {
+		indent = "  "
+	}
+	for _, group := range groups {
+		if groupBy != "" {
+			fmt.Fprintf(os.Stdout, "%s=%s:\n", groupBy, group)
 		}
+		for _, metadata := range grouped[group] {
+			list_snapshot_header(metadata, useUuid, indent)
+		}
+	}
+}
+
+func list_snapshot_header(metadata *header.Header, useUuid bool, indent string) {
+	directories := make([]string, 0)
+	for _, source := range metadata.GetSources() {
+		directories = append(directories, source.Directory)
+	}
+
+	if !useUuid {
+		fmt.Fprintf(os.Stdout, "%s%s %10s%10s%10s %s\n",
+			indent,
+			metadata.Timestamp.UTC().Format(time.RFC3339),
+			hex.EncodeToString(metadata.GetIndexShortID()),
+			humanize.Bytes(metadata.Summary.Directory.Size+metadata.Summary.Below.Size),
+			metadata.Duration.Round(time.Second),
+			strings.Join(directories, ", "))
+	} else {
+		indexID := metadata.GetIndexID()
+		fmt.Fprintf(os.Stdout, "%s%s %3s%10s%10s %s\n",
+			indent,
+			metadata.Timestamp.UTC().Format(time.RFC3339),
+			hex.EncodeToString(indexID[:]),
+			humanize.Bytes(metadata.Summary.Directory.Size+metadata.Summary.Below.Size),
+			metadata.Duration.Round(time.Second),
+			strings.Join(directories, ", "))
 	}
 }
 
@@ -17,17 +17,21 @@
 package restore
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
 	"github.com/PlakarKorp/plakar/context"
+	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/snapshot"
 	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/PlakarKorp/plakar/snapshot/exporter/plakar"
 )
 
 func init() {
@@ -40,18 +44,64 @@ func cmd_restore(ctx *context.Context, repo *repository.Repository, args []strin
 	var exporterInstance exporter.Exporter
 	var opt_concurrency uint64
 	var opt_quiet bool
+	var opt_noOwner bool
+	var opt_numericOwner bool
+	var opt_noXattrs bool
+	var opt_conflict string
+	var opt_replaceDirs bool
+	var opt_verify bool
+	var opt_report string
 
 	flags := flag.NewFlagSet("restore", flag.ExitOnError)
 	flags.Uint64Var(&opt_concurrency, "concurrency", uint64(ctx.GetMaxConcurrency()), "maximum number of parallel tasks")
 	flags.StringVar(&pullPath, "to", "", "base directory where pull will restore")
 	flags.BoolVar(&pullRebase, "rebase", false, "strip pathname when pulling")
 	flags.BoolVar(&opt_quiet, "quiet", false, "do not print progress")
+	flags.BoolVar(&opt_noOwner, "no-owner", false, "do not restore the owner of files")
+	flags.BoolVar(&opt_numericOwner, "numeric-owner", false, "restore owners by uid and gid rather than by name")
+	flags.BoolVar(&opt_noXattrs, "no-xattrs", false, "do not restore extended attributes and ACLs")
+	flags.StringVar(&opt_conflict, "conflict", "overwrite", "what to do with existing files: overwrite, skip, newer or sync")
+	flags.BoolVar(&opt_replaceDirs, "replace-dirs", false, "remove directories where the snapshot has a file")
+	flags.BoolVar(&opt_verify, "verify", false, "read back restored files and check them against the snapshot")
+	flags.StringVar(&opt_report, "report", "", "write a JSON report of the restore to this file, - for stdout")
+	pathFilter := utils.PathFilterFlags(flags)
 	flags.Parse(args)
 
+	filter, err := pathFilter()
+	if err != nil {
+		ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+		return 1
+	}
+
+	conflict, err := snapshot.ParseConflictPolicy(opt_conflict)
+	if err != nil {
+		ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+		return 1
+	}
+
+	// an archive streamed to stdout must not be mixed with anything else
+	if pullPath == "-" || strings.HasSuffix(pullPath, "://-") {
+		if opt_report == "-" {
+			ctx.GetLogger().Error("%s: cannot write both the archive and the report to stdout", flags.Name())
+			return 1
+		}
+		opt_quiet = true
+	}
+
 	go eventsProcessorStdio(ctx, opt_quiet)
 
-	var err error
-	if pullPath == "" {
+	var plakarExporter *plakar.PlakarExporter
+	if strings.HasPrefix(pullPath, "plakar://") {
+		dstRepository, err := utils.OpenRepository(ctx, strings.TrimPrefix(pullPath, "plakar://"), "destination repository")
+		if err != nil {
+			ctx.GetLogger().Error("%s: could not open repository: %s", flags.Name(), err)
+			return 1
+		}
+		defer dstRepository.Close()
+
+		plakarExporter = plakar.NewPlakarExporter(dstRepository, &snapshot.BackupOptions{MaxConcurrency: opt_concurrency})
+		exporterInstance = plakarExporter
+	} else if pullPath == "" {
 		exporterInstance, err = exporter.NewExporter(ctx.GetCWD())
 		if err != nil {
 			log.Fatal(err)
@@ -62,11 +112,49 @@ func cmd_restore(ctx *context.Context, repo *repository.Repository, args []strin
 			log.Fatal(err)
 		}
 	}
-	defer exporterInstance.Close()
+
+	// closing the exporter commits what it writes to a repository, which
+	// is not done for interrupted or failed restores nor for those missing
+	// files
+	closeExporter := func(reports []*snapshot.RestoreReport, errs []error, status int) int {
+		if plakarExporter != nil {
+			failed := 0
+			for _, report := range reports {
+				if report != nil {
+					failed += len(report.Failed)
+				}
+			}
+			if failed != 0 || len(errs) != 0 || status != 0 {
+				plakarExporter.Abort()
+				if failed != 0 {
+					ctx.GetLogger().Error("%s: %d files could not be restored, no snapshot created in %s", flags.Name(), failed, pullPath)
+				} else if len(errs) != 0 {
+					ctx.GetLogger().Error("%s: restore failed, no snapshot created in %s", flags.Name(), pullPath)
+				}
+				status = 1
+			}
+		}
+		if err := exporterInstance.Close(); err != nil {
+			ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+			return 1
+		}
+		if plakarExporter != nil && plakarExporter.SnapshotID() != (objects.Checksum{}) {
+			snapshotID := plakarExporter.SnapshotID()
+			ctx.GetLogger().Info("%s: created snapshot %x in %s", flags.Name(), snapshotID[:4], pullPath)
+		}
+		return status
+	}
 
 	opts := &snapshot.RestoreOptions{
-		MaxConcurrency: opt_concurrency,
-		Rebase:         pullRebase,
+		MaxConcurrency:     opt_concurrency,
+		Rebase:             pullRebase,
+		NoOwner:            opt_noOwner,
+		NumericOwner:       opt_numericOwner,
+		NoXattrs:           opt_noXattrs,
+		Conflict:           conflict,
+		ReplaceDirectories: opt_replaceDirs,
+		Verify:             opt_verify,
+		Filter:             filter,
 	}
 
 	if flags.NArg() == 0 {
@@ -77,13 +165,25 @@ func cmd_restore(ctx *context.Context, repo *repository.Repository, args []strin
 
 		for i := len(metadatas); i != 0; i-- {
 			metadata := metadatas[i-1]
-			if ctx.GetCWD() == metadata.Importer.Directory || strings.HasPrefix(ctx.GetCWD(), fmt.Sprintf("%s/", metadata.Importer.Directory)) {
+			for _, source := range metadata.GetSources() {
+				if ctx.GetCWD() != source.Directory && !strings.HasPrefix(ctx.GetCWD(), fmt.Sprintf("%s/", source.Directory)) {
+					continue
+				}
 				snap, err := snapshot.Load(repo, metadata.GetIndexID())
 				if err != nil {
 					return 1
 				}
-				snap.Restore(exporterInstance, ctx.GetCWD(), ctx.GetCWD(), opts)
-				return 0
+				report, err := snap.Restore(exporterInstance, exporterInstance.Root(), ctx.GetCWD(), opts)
+				if err := ctx.Err(); err != nil {
+					ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+					return 1
+				}
+				if err != nil {
+					ctx.GetLogger().Error("%s: could not restore %s from snapshot %x: %s", flags.Name(), ctx.GetCWD(), snap.Header.GetIndexShortID(), err)
+					return closeExporter(nil, []error{err}, 1)
+				}
+				reports := []*snapshot.RestoreReport{report}
+				return closeExporter(reports, nil, finish(ctx, flags.Name(), opt_report, reports))
 			}
 		}
 		log.Fatalf("%s: could not find a snapshot to restore this path from", flag.CommandLine.Name())
@@ -95,10 +195,68 @@ func cmd_restore(ctx *context.Context, repo *repository.Repository, args []strin
 		log.Fatal(err)
 	}
 
+	status := 0
+	reports := make([]*snapshot.RestoreReport, 0, len(snapshots))
+	errs := make([]error, 0)
 	for offset, snap := range snapshots {
 		_, pattern := utils.ParseSnapshotID(flags.Args()[offset])
-		snap.Restore(exporterInstance, ctx.GetCWD(), pattern, opts)
+		report, err := snap.Restore(exporterInstance, exporterInstance.Root(), pattern, opts)
+		if report != nil {
+			reports = append(reports, report)
+		}
+		if err := ctx.Err(); err != nil {
+			ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+			return 1
+		}
+		if err != nil {
+			ctx.GetLogger().Error("%s: could not restore %s from snapshot %x: %s", flags.Name(), pattern, snap.Header.GetIndexShortID(), err)
+			if exclude := snap.ExcludedBy(pattern); exclude != nil {
+				ctx.GetLogger().Warn("%s: %s was excluded from snapshot %x by %s", flags.Name(), pattern, snap.Header.GetIndexShortID(), exclude)
+			}
+			errs = append(errs, err)
+			status = 1
+		}
+	}
+
+	if finish(ctx, flags.Name(), opt_report, reports) != 0 {
+		status = 1
+	}
+	return closeExporter(reports, errs, status)
+}
+
+// finish writes the restore reports if asked to and fails if any restored
+// file did not match the snapshot.
+func finish(ctx *context.Context, name string, reportPath string, reports []*snapshot.RestoreReport) int {
+	if reportPath != "" {
+		if err := writeReport(reportPath, reports); err != nil {
+			ctx.GetLogger().Error("%s: could not write report: %s", name, err)
+			return 1
+		}
 	}
 
+	mismatched := 0
+	for _, report := range reports {
+		mismatched += len(report.Mismatched)
+	}
+	if mismatched != 0 {
+		ctx.GetLogger().Error("%s: %d restored files do not match the snapshot", name, mismatched)
+		return 1
+	}
 	return 0
 }
+
+func writeReport(reportPath string, reports []*snapshot.RestoreReport) error {
+	if reportPath == "-" {
+		return json.NewEncoder(os.Stdout).Encode(reports)
+	}
+
+	fp, err := os.Create(reportPath)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(fp).Encode(reports); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2024 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package rewrite
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
+	"github.com/PlakarKorp/plakar/compression"
+	"github.com/PlakarKorp/plakar/context"
+	"github.com/PlakarKorp/plakar/encryption"
+	"github.com/PlakarKorp/plakar/hashing"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/storage"
+	"github.com/dustin/go-humanize"
+)
+
+func init() {
+	subcommands.Register("rewrite", cmd_rewrite)
+}
+
+func cmd_rewrite(ctx *context.Context, repo *repository.Repository, args []string) int {
+	var opt_noencryption bool
+	var opt_nocompression bool
+	var opt_hashing string
+	var opt_compression string
+	var opt_chunkingMin string
+	var opt_chunkingNormal string
+	var opt_chunkingMax string
+	var opt_concurrency uint64
+	var opt_quiet bool
+	var opt_padding bool
+	var opt_keyfile string
+
+	srcConfiguration := repo.Configuration()
+
+	defaultCompression := "LZ4"
+	if srcConfiguration.Compression != nil {
+		defaultCompression = srcConfiguration.Compression.Algorithm
+	}
+
+	flags := flag.NewFlagSet("rewrite", flag.ExitOnError)
+	flags.BoolVar(&opt_noencryption, "no-encryption", srcConfiguration.Encryption == nil, "disable transparent encryption")
+	flags.BoolVar(&opt_nocompression, "no-compression", srcConfiguration.Compression == nil, "disable transparent compression")
+	flags.StringVar(&opt_hashing, "hashing", srcConfiguration.Hashing.Algorithm, "swap the hashing function")
+	flags.StringVar(&opt_compression, "compression", defaultCompression, "swap the compression function")
+	flags.StringVar(&opt_chunkingMin, "chunking-min", humanize.IBytes(uint64(srcConfiguration.Chunking.MinSize)), "minimum chunk size")
+	flags.StringVar(&opt_chunkingNormal, "chunking-normal", humanize.IBytes(uint64(srcConfiguration.Chunking.NormalSize)), "normal chunk size")
+	flags.StringVar(&opt_chunkingMax, "chunking-max", humanize.IBytes(uint64(srcConfiguration.Chunking.MaxSize)), "maximum chunk size")
+	flags.Uint64Var(&opt_concurrency, "concurrency", uint64(ctx.GetMaxConcurrency()), "maximum number of parallel tasks")
+	flags.BoolVar(&opt_padding, "padding", srcConfiguration.Packfile.Padding, "pad packfiles to hide their exact size")
+	flags.BoolVar(&opt_quiet, "quiet", false, "suppress output")
+	flags.StringVar(&opt_keyfile, "keyfile", "", "read the passphrase of the new repository from this file")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		ctx.GetLogger().Error("usage: %s [options] repository [snapshotID ...]", flags.Name())
+		return 1
+	}
+
+	dstConfiguration := storage.NewConfiguration()
+	dstConfiguration.Packfile = srcConfiguration.Packfile
+	dstConfiguration.Packfile.Padding = opt_padding
+	dstConfiguration.Chunking = srcConfiguration.Chunking
+
+	if opt_nocompression {
+		dstConfiguration.Compression = nil
+	} else {
+		compressionConfiguration, err := compression.LookupDefaultConfiguration(strings.ToUpper(opt_compression))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+			return 1
+		}
+		dstConfiguration.Compression = compressionConfiguration
+	}
+
+	hashingConfiguration, err := hashing.LookupDefaultConfiguration(strings.ToUpper(opt_hashing))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+		return 1
+	}
+	dstConfiguration.Hashing = *hashingConfiguration
+
+	for _, size := range []struct {
+		value string
+		dest  *uint32
+	}{
+		{opt_chunkingMin, &dstConfiguration.Chunking.MinSize},
+		{opt_chunkingNormal, &dstConfiguration.Chunking.NormalSize},
+		{opt_chunkingMax, &dstConfiguration.Chunking.MaxSize},
+	} {
+		parsed, err := humanize.ParseBytes(size.value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: invalid chunk size: %s\n", flag.CommandLine.Name(), flags.Name(), size.value)
+			return 1
+		}
+		*size.dest = uint32(parsed)
+	}
+	if dstConfiguration.Chunking.MinSize > dstConfiguration.Chunking.NormalSize ||
+		dstConfiguration.Chunking.NormalSize > dstConfiguration.Chunking.MaxSize {
+		fmt.Fprintf(os.Stderr, "%s: %s: chunk sizes must satisfy min <= normal <= max\n", flag.CommandLine.Name(), flags.Name())
+		return 1
+	}
+
+	var dstSecret []byte
+	if !opt_noencryption {
+		// the passphrase of the new repository comes from a key file, the
+		// environment or a prompt, in that order
+		var passphrase []byte
+		if opt_keyfile != "" {
+			data, err := os.ReadFile(opt_keyfile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s: could not read key file: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+				return 1
+			}
+			passphrase = []byte(strings.TrimSuffix(string(data), "\n"))
+		} else if envPassphrase := os.Getenv("PLAKAR_DESTINATION_PASSPHRASE"); envPassphrase != "" {
+			passphrase = []byte(envPassphrase)
+		} else {
+			passphrase, err = utils.GetPassphraseConfirm("destination repository")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+				return 1
+			}
+		}
+
+		encryptionKey, err := encryption.BuildSecretFromPassphrase(passphrase)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+			return 1
+		}
+		dstConfiguration.Encryption.Algorithm = encryption.DefaultConfiguration().Algorithm
+		dstConfiguration.Encryption.Key = encryptionKey
+
+		dstSecret, err = encryption.DeriveSecret(passphrase, encryptionKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", flag.CommandLine.Name(), flags.Name(), err)
+			return 1
+		}
+	} else {
+		dstConfiguration.Encryption = nil
+	}
+
+	dstStore, err := storage.Create(ctx, flags.Arg(0), *dstConfiguration)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: could not create repository: %s\n", flags.Arg(0), err)
+		return 1
+	}
+
+	dstRepository, err := repository.New(ctx, dstStore, dstSecret)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: could not open repository: %s\n", dstStore.Location(), err)
+		return 1
+	}
+	defer dstRepository.Close()
+
+	var snapshots []*snapshot.Snapshot
+	if flags.NArg() > 1 {
+		snapshots, err = utils.GetSnapshots(repo, flags.Args()[1:])
+	} else {
+		snapshots, err = utils.GetSnapshots(repo, nil)
+	}
+	if err != nil {
+		ctx.GetLogger().Error("%s: could not list snapshots: %s", flags.Name(), err)
+		return 1
+	}
+
+	options := &snapshot.RewriteOptions{
+		MaxConcurrency: opt_concurrency,
+	}
+
+	retval := 0
+	rewritten := make([]*snapshot.Snapshot, 0, len(snapshots))
+	copies := make([]*snapshot.Snapshot, 0, len(snapshots))
+	for _, snap := range snapshots {
+		if err := snap.Rewrite(dstRepository, options); err != nil {
+			ctx.GetLogger().Error("%s: could not rewrite snapshot %x: %s", flags.Name(), snap.Header.GetIndexShortID(), err)
+			retval = 1
+			continue
+		}
+		dstSnapshot, err := snapshot.Load(dstRepository, snap.Header.Identifier)
+		if err != nil {
+			ctx.GetLogger().Error("%s: could not load rewritten snapshot %x: %s", flags.Name(), snap.Header.GetIndexShortID(), err)
+			retval = 1
+			continue
+		}
+		rewritten = append(rewritten, snap)
+		copies = append(copies, dstSnapshot)
+		if !opt_quiet {
+			fmt.Printf("%x: rewritten\n", snap.Header.GetIndexShortID())
+		}
+	}
+
+	srcSize, err := snapshot.StoredSize(rewritten)
+	if err != nil {
+		ctx.GetLogger().Error("%s: could not compute size of %s: %s", flags.Name(), repo.Location(), err)
+		return 1
+	}
+	dstSize, err := snapshot.StoredSize(copies)
+	if err != nil {
+		ctx.GetLogger().Error("%s: could not compute size of %s: %s", flags.Name(), dstRepository.Location(), err)
+		return 1
+	}
+	fmt.Printf("%s: %s (%d bytes) -> %s: %s (%d bytes)\n",
+		repo.Location(), humanize.Bytes(srcSize), srcSize,
+		dstRepository.Location(), humanize.Bytes(dstSize), dstSize)
+	if srcSize != 0 {
+		fmt.Printf("ratio: %.2f%%\n", float64(dstSize)/float64(srcSize)*100)
+	}
+
+	return retval
+}
@@ -39,9 +39,8 @@ func init() {
 
 func cmd_rm(ctx *context.Context, repo *repository.Repository, args []string) int {
 	var opt_older string
-	var opt_tag string
 	flags := flag.NewFlagSet("rm", flag.ExitOnError)
-	flags.StringVar(&opt_tag, "tag", "", "filter by tag")
+	filter := utils.FilterFlags(flags)
 	flags.StringVar(&opt_older, "older", "", "remove snapshots older than this date")
 	flags.Parse(args)
 
@@ -105,12 +104,12 @@ func cmd_rm(ctx *context.Context, repo *repository.Repository, args []string) in
 		}
 	}
 
-	if flags.NArg() == 0 && opt_older == "" && opt_tag == "" {
+	if flags.NArg() == 0 && opt_older == "" && filter.IsEmpty() {
 		log.Fatalf("%s: need at least one snapshot ID to rm", flag.CommandLine.Name())
 	}
 
 	var snapshots []*snapshot.Snapshot
-	if opt_older != "" || opt_tag != "" {
+	if opt_older != "" || !filter.IsEmpty() {
 		if flags.NArg() != 0 {
 			tmp, err := utils.GetSnapshots(repo, flags.Args())
 			if err != nil {
@@ -132,23 +131,21 @@ func cmd_rm(ctx *context.Context, repo *repository.Repository, args []string) in
 		snapshots = tmp
 	}
 
+	annotations := snapshot.LoadAnnotations(repo)
+
 	errors := 0
 	wg := sync.WaitGroup{}
 	for _, snap := range snapshots {
+		annotations.Apply(snap.Header)
 		if opt_older != "" && snap.Header.Timestamp.After(beforeDate) {
 			continue
 		}
-		if opt_tag != "" {
-			found := false
-			for _, t := range snap.Header.Tags {
-				if opt_tag == t {
-					found = true
-					break
-				}
-			}
-			if !found {
-				continue
-			}
+		if !filter.Match(snap.Header) {
+			continue
+		}
+		if snap.Header.Pinned {
+			ctx.GetLogger().Warn("snapshot %x is pinned, not removing it", snap.Header.GetIndexShortID())
+			continue
 		}
 
 		wg.Add(1)
@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2024 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package stats
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
+	"github.com/PlakarKorp/plakar/context"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/dustin/go-humanize"
+)
+
+func init() {
+	subcommands.Register("stats", cmd_stats)
+}
+
+func cmd_stats(ctx *context.Context, repo *repository.Repository, args []string) int {
+	var opt_json bool
+	var opt_top int
+
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	flags.BoolVar(&opt_json, "json", false, "output statistics as JSON")
+	flags.IntVar(&opt_top, "top", 10, "number of snapshots to list, 0 lists all")
+	flags.Parse(args)
+
+	snapshotIDs, err := repo.GetSnapshots()
+	if err != nil {
+		ctx.GetLogger().Error("%s: could not fetch snapshots list: %s", flags.Name(), err)
+		return 1
+	}
+
+	// reference counts are always computed over all snapshots, arguments
+	// only restrict which snapshots are reported
+	refCounts, err := snapshot.NewRefCounts(repo, snapshotIDs)
+	if err != nil {
+		ctx.GetLogger().Error("%s: could not compute reference counts: %s", flags.Name(), err)
+		return 1
+	}
+	stats := refCounts.Stats()
+
+	if flags.NArg() != 0 {
+		headers, err := utils.GetHeaders(repo, flags.Args())
+		if err != nil {
+			ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+			return 1
+		}
+		selected := make(map[objects.Checksum]bool)
+		for _, header := range headers {
+			selected[header.Identifier] = true
+		}
+
+		snapshotsStats := make([]snapshot.SnapshotStats, 0, len(headers))
+		for _, snapshotStats := range stats.SnapshotsStats {
+			if selected[snapshotStats.SnapshotID] {
+				snapshotsStats = append(snapshotsStats, snapshotStats)
+			}
+		}
+		stats.SnapshotsStats = snapshotsStats
+	} else if opt_top > 0 && len(stats.SnapshotsStats) > opt_top {
+		stats.SnapshotsStats = stats.SnapshotsStats[:opt_top]
+	}
+
+	if opt_json {
+		if err := json.NewEncoder(os.Stdout).Encode(stats); err != nil {
+			ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Println("Snapshots:", stats.Snapshots)
+	fmt.Println("Chunks:", stats.Chunks)
+	fmt.Printf("Logical size: %s (%d bytes)\n", humanize.Bytes(stats.LogicalSize), stats.LogicalSize)
+	fmt.Printf("Deduplicated size: %s (%d bytes)\n", humanize.Bytes(stats.DedupedSize), stats.DedupedSize)
+	fmt.Printf("Stored size: %s (%d bytes)\n", humanize.Bytes(stats.StoredSize), stats.StoredSize)
+	fmt.Printf("Deduplication ratio: %.2fx\n", stats.DedupRatio)
+	fmt.Printf("Compression ratio: %.2fx\n", stats.CompressionRatio)
+
+	fmt.Println("Hosts:")
+	for _, host := range stats.Hosts {
+		fmt.Printf(" - %s: %d snapshots, %s logical, %s stored, %.2fx deduplication\n",
+			host.Hostname,
+			host.Snapshots,
+			humanize.Bytes(host.LogicalSize),
+			humanize.Bytes(host.StoredSize),
+			host.DedupRatio)
+	}
+
+	fmt.Println("Snapshots:")
+	fmt.Printf("%-20s %8s %10s %10s %10s %10s %6s %s\n",
+		"TIMESTAMP", "ID", "LOGICAL", "STORED", "UNIQUE", "SHARED", "RATIO", "HOSTNAME")
+	for _, snapshotStats := range stats.SnapshotsStats {
+		fmt.Printf("%-20s %8s %10s %10s %10s %10s %5.2fx %s\n",
+			snapshotStats.Timestamp.UTC().Format(time.RFC3339),
+			hex.EncodeToString(snapshotStats.SnapshotID[:4]),
+			humanize.Bytes(snapshotStats.LogicalSize),
+			humanize.Bytes(snapshotStats.StoredSize),
+			humanize.Bytes(snapshotStats.UniqueSize),
+			humanize.Bytes(snapshotStats.SharedSize),
+			snapshotStats.CompressionRatio,
+			snapshotStats.Hostname)
+	}
+
+	return 0
+}
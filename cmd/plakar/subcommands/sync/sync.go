@@ -60,7 +60,7 @@ func cmd_sync(ctx *context.Context, repo *repository.Repository, args []string)
 		return 1
 	}
 
-	peerStore, err := storage.Open(peerRepositoryPath)
+	peerStore, err := storage.Open(ctx, peerRepositoryPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s: could not open repository: %s\n", peerRepositoryPath, err)
 		return 1
@@ -148,6 +148,9 @@ func cmd_sync(ctx *context.Context, repo *repository.Repository, args []string)
 	fmt.Printf("Synchronizing %d snapshots\n", len(srcSyncList))
 
 	for _, snapshotID := range srcSyncList {
+		if ctx.Err() != nil {
+			break
+		}
 		err := synchronize(srcRepository, dstRepository, snapshotID)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: could not synchronize snapshot %x from repository: %s\n", srcRepository.Location(), snapshotID, err)
@@ -169,6 +172,9 @@ func cmd_sync(ctx *context.Context, repo *repository.Repository, args []string)
 		}
 
 		for _, snapshotID := range dstSyncList {
+			if ctx.Err() != nil {
+				break
+			}
 			err := synchronize(dstRepository, srcRepository, snapshotID)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%s: could not synchronize snapshot %x from repository: %s\n", dstRepository.Location(), snapshotID, err)
@@ -176,10 +182,16 @@ func cmd_sync(ctx *context.Context, repo *repository.Repository, args []string)
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", flags.Name(), err)
+		return 1
+	}
 	return 0
 }
 
 func synchronize(srcRepository *repository.Repository, dstRepository *repository.Repository, snapshotID objects.Checksum) error {
+	ctx := srcRepository.Context()
+
 	srcSnapshot, err := snapshot.Load(srcRepository, snapshotID)
 	if err != nil {
 		return err
@@ -197,6 +209,9 @@ func synchronize(srcRepository *repository.Repository, dstRepository *repository
 		return err
 	}
 	for chunkID := range c {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if !dstRepository.BlobExists(packfile.TYPE_CHUNK, chunkID) {
 			chunkData, err := srcSnapshot.GetBlob(packfile.TYPE_CHUNK, chunkID)
 			if err != nil {
@@ -211,6 +226,9 @@ func synchronize(srcRepository *repository.Repository, dstRepository *repository
 		return err
 	}
 	for objectID := range c {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if !dstRepository.BlobExists(packfile.TYPE_OBJECT, objectID) {
 			objectData, err := srcSnapshot.GetBlob(packfile.TYPE_OBJECT, objectID)
 			if err != nil {
@@ -225,6 +243,9 @@ func synchronize(srcRepository *repository.Repository, dstRepository *repository
 		return err
 	}
 	for fileID := range c {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if !dstRepository.BlobExists(packfile.TYPE_FILE, fileID) {
 			fileData, err := srcSnapshot.GetBlob(packfile.TYPE_FILE, fileID)
 			if err != nil {
@@ -239,6 +260,9 @@ func synchronize(srcRepository *repository.Repository, dstRepository *repository
 		return err
 	}
 	for directoryID := range c {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if !dstRepository.BlobExists(packfile.TYPE_DIRECTORY, directoryID) {
 			directoryData, err := srcSnapshot.GetBlob(packfile.TYPE_DIRECTORY, directoryID)
 			if err != nil {
@@ -253,6 +277,9 @@ func synchronize(srcRepository *repository.Repository, dstRepository *repository
 		return err
 	}
 	for dataID := range c {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if !dstRepository.BlobExists(packfile.TYPE_DATA, dataID) {
 			dataData, err := srcSnapshot.GetBlob(packfile.TYPE_DATA, dataID)
 			if err != nil {
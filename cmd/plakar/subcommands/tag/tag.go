@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2024 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package tag
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
+	"github.com/PlakarKorp/plakar/context"
+	"github.com/PlakarKorp/plakar/identity"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot"
+)
+
+func init() {
+	subcommands.Register("tag", cmd_tag)
+}
+
+func cmd_tag(ctx *context.Context, repo *repository.Repository, args []string) int {
+	var opt_identity string
+
+	flags := flag.NewFlagSet("tag", flag.ExitOnError)
+	flags.StringVar(&opt_identity, "identity", "", "use identity from keyring")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		ctx.GetLogger().Error("usage: %s add|rm|note|pin|unpin|log snapshotID [argument ...]", flags.Name())
+		return 1
+	}
+	action, prefix, arguments := flags.Arg(0), flags.Arg(1), flags.Args()[2:]
+
+	snapshotID, err := utils.LocateSnapshotByPrefix(repo, prefix)
+	if err != nil {
+		ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+		return 1
+	}
+
+	if action == "log" {
+		for _, annotation := range snapshot.LoadAnnotations(repo)[snapshotID] {
+			fmt.Fprintf(os.Stdout, "%s %x %s\n",
+				annotation.Timestamp.UTC().Format(time.RFC3339),
+				annotation.Identifier[:4],
+				describe(annotation))
+		}
+		return 0
+	}
+
+	if err := identity.UseIdentity(ctx, opt_identity); err != nil {
+		ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+		return 1
+	}
+
+	annotation, err := snapshot.NewAnnotation(repo, snapshotID)
+	if err != nil {
+		ctx.GetLogger().Error("%s: %s", flags.Name(), err)
+		return 1
+	}
+
+	switch action {
+	case "add", "rm":
+		if len(arguments) == 0 {
+			ctx.GetLogger().Error("%s: %s needs at least one tag", flags.Name(), action)
+			return 1
+		}
+		if action == "add" {
+			annotation.AddTags = arguments
+		} else {
+			annotation.RemoveTags = arguments
+		}
+	case "note":
+		note := strings.Join(arguments, " ")
+		annotation.Note = &note
+	case "pin", "unpin":
+		if len(arguments) != 0 {
+			ctx.GetLogger().Error("%s: %s takes no argument", flags.Name(), action)
+			return 1
+		}
+		pinned := action == "pin"
+		annotation.Pinned = &pinned
+	default:
+		ctx.GetLogger().Error("%s: unknown action %q", flags.Name(), action)
+		return 1
+	}
+
+	if err := snapshot.PutAnnotation(repo, annotation); err != nil {
+		ctx.GetLogger().Error("%s: could not annotate snapshot: %s", flags.Name(), err)
+		return 1
+	}
+	ctx.GetLogger().Info("annotated snapshot %x: %s", snapshotID[:4], describe(annotation))
+	return 0
+}
+
+func describe(annotation *snapshot.Annotation) string {
+	changes := make([]string, 0)
+	for _, tag := range annotation.AddTags {
+		changes = append(changes, "+"+tag)
+	}
+	for _, tag := range annotation.RemoveTags {
+		changes = append(changes, "-"+tag)
+	}
+	if annotation.Note != nil {
+		changes = append(changes, fmt.Sprintf("note=%q", *annotation.Note))
+	}
+	if annotation.Pinned != nil {
+		if *annotation.Pinned {
+			changes = append(changes, "pinned")
+		} else {
+			changes = append(changes, "unpinned")
+		}
+	}
+	return strings.Join(changes, " ")
+}
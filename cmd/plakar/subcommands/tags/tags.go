@@ -24,9 +24,11 @@ import (
 	"strings"
 
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
 	"github.com/PlakarKorp/plakar/context"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/header"
 )
 
 func init() {
@@ -37,6 +39,7 @@ func cmd_tags(ctx *context.Context, repo *repository.Repository, args []string)
 	var opt_display string
 	flags := flag.NewFlagSet("tags", flag.ExitOnError)
 	flags.StringVar(&opt_display, "display", "tags", "display tags")
+	filter := utils.FilterFlags(flags)
 	flags.Parse(args)
 
 	if opt_display != "tags" && opt_display != "count" && opt_display != "snapshots" {
@@ -44,17 +47,23 @@ func cmd_tags(ctx *context.Context, repo *repository.Repository, args []string)
 		return 1
 	}
 
-	list_tags(repo, opt_display)
+	list_tags(repo, opt_display, filter)
 	return 0
 }
 
-func list_tags(repo *repository.Repository, display string) {
+func list_tags(repo *repository.Repository, display string, filter *header.Filter) {
+	annotations := snapshot.LoadAnnotations(repo)
+
 	tags := make(map[string][][32]byte)
 	for snapshotID := range repo.ListSnapshots() {
 		snap, err := snapshot.Load(repo, snapshotID)
 		if err != nil {
 			continue
 		}
+		annotations.Apply(snap.Header)
+		if !filter.Match(snap.Header) {
+			continue
+		}
 		for _, tag := range snap.Header.Tags {
 			if _, ok := tags[tag]; !ok {
 				tags[tag] = make([][32]byte, 0)
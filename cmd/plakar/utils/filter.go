@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2024 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utils
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/header"
+)
+
+// MetaFlags collects repeated -meta key=value flags.
+type MetaFlags []header.KeyValue
+
+func (m *MetaFlags) String() string {
+	pairs := make([]string, 0, len(*m))
+	for _, kv := range *m {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", kv.Key, kv.Value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *MetaFlags) Set(value string) error {
+	kv, err := header.ParseKeyValue(value)
+	if err != nil {
+		return err
+	}
+	*m = append(*m, kv)
+	return nil
+}
+
+// FilterFlags registers the flags selecting snapshots on their name,
+// classification, tag and metadata, the returned filter is filled once
+// flags are parsed.
+func FilterFlags(flags *flag.FlagSet) *header.Filter {
+	filter := &header.Filter{}
+	flags.StringVar(&filter.Name, "name", "", "filter by name")
+	flags.StringVar(&filter.Category, "category", "", "filter by category")
+	flags.StringVar(&filter.Environment, "environment", "", "filter by environment")
+	flags.StringVar(&filter.Perimeter, "perimeter", "", "filter by perimeter")
+	flags.StringVar(&filter.Tag, "tag", "", "filter by tag")
+	flags.Var((*MetaFlags)(&filter.Meta), "meta", "filter by metadata, as key=value")
+	return filter
+}
+
+// PatternFlags collects repeated glob flags.
+type PatternFlags []string
+
+func (p *PatternFlags) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *PatternFlags) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// MappingFlags collects repeated -map src=dst flags.
+type MappingFlags []snapshot.PathMapping
+
+func (m *MappingFlags) String() string {
+	pairs := make([]string, 0, len(*m))
+	for _, mapping := range *m {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", mapping.Source, mapping.Destination))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *MappingFlags) Set(value string) error {
+	mapping, err := snapshot.ParsePathMapping(value)
+	if err != nil {
+		return err
+	}
+	*m = append(*m, mapping)
+	return nil
+}
+
+// PathFilterFlags registers the flags selecting and relocating the entries
+// of a snapshot, the returned function builds the filter once flags are
+// parsed.
+func PathFilterFlags(flags *flag.FlagSet) func() (*snapshot.PathFilter, error) {
+	var includes, excludes PatternFlags
+	var mappings MappingFlags
+	var query string
+	flags.Var(&includes, "include", "only select files matching this glob")
+	flags.Var(&excludes, "exclude", "skip entries matching this glob")
+	flags.Var(&mappings, "map", "relocate the entries below src to dst, as src=dst")
+	flags.StringVar(&query, "query", "", "only select files matching this search query")
+	return func() (*snapshot.PathFilter, error) {
+		return snapshot.NewPathFilter(includes, excludes, query, mappings)
+	}
+}
@@ -34,10 +34,13 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/PlakarKorp/plakar/context"
+	"github.com/PlakarKorp/plakar/encryption"
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/snapshot"
 	"github.com/PlakarKorp/plakar/snapshot/header"
+	"github.com/PlakarKorp/plakar/storage"
 	"golang.org/x/mod/semver"
 	"golang.org/x/term"
 	"golang.org/x/tools/blog/atom"
@@ -201,6 +204,7 @@ func GetHeaders(repo *repository.Repository, prefixes []string) ([]*header.Heade
 		return result, nil
 	}
 
+	annotations := snapshot.LoadAnnotations(repo)
 	tags := make(map[string]objects.Checksum)
 	tagsTimestamp := make(map[string]time.Time)
 
@@ -209,6 +213,7 @@ func GetHeaders(repo *repository.Repository, prefixes []string) ([]*header.Heade
 		if err != nil {
 			return nil, err
 		}
+		annotations.Apply(hdr)
 		for _, tag := range hdr.Tags {
 			if recordTime, exists := tagsTimestamp[tag]; !exists {
 				tags[tag] = snapshotID
@@ -280,6 +285,7 @@ func GetSnapshots(repo *repository.Repository, prefixes []string) ([]*snapshot.S
 		return sortSnapshotsByDate(result), nil
 	}
 
+	annotations := snapshot.LoadAnnotations(repo)
 	tags := make(map[string]objects.Checksum)
 	tagsTimestamp := make(map[string]time.Time)
 
@@ -288,6 +294,7 @@ func GetSnapshots(repo *repository.Repository, prefixes []string) ([]*snapshot.S
 		if err != nil {
 			return nil, err
 		}
+		annotations.Apply(metadata)
 		for _, tag := range metadata.Tags {
 			if recordTime, exists := tagsTimestamp[tag]; !exists {
 				tags[tag] = snapshotID
@@ -477,6 +484,37 @@ func GetPassphraseConfirm(prefix string) ([]byte, error) {
 	return passphrase1, nil
 }
 
+// OpenRepository opens another repository than the one the command runs
+// on, asking for its passphrase if it is encrypted.
+func OpenRepository(ctx *context.Context, location string, prefix string) (*repository.Repository, error) {
+	store, err := storage.Open(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	if store.Configuration().Version != storage.VERSION {
+		return nil, fmt.Errorf("incompatible repository version: %s != %s", store.Configuration().Version, storage.VERSION)
+	}
+
+	var secret []byte
+	if store.Configuration().Encryption != nil {
+		for attempts := 0; ; attempts++ {
+			passphrase, err := GetPassphrase(prefix)
+			if err != nil {
+				return nil, err
+			}
+			secret, err = encryption.DeriveSecret(passphrase, store.Configuration().Encryption.Key)
+			if err == nil {
+				break
+			}
+			if attempts == 2 {
+				return nil, err
+			}
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}
+	return repository.New(ctx, store, secret)
+}
+
 func GetCacheDir(appName string) (string, error) {
 	var cacheDir string
 
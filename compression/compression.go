@@ -54,6 +54,36 @@ func LookupDefaultConfiguration(algorithm string) (*Configuration, error) {
 	}
 }
 
+// Codec identifiers recorded in per-blob packfile flags, they are part of
+// the on-disk format and must never be renumbered.
+const (
+	CODEC_NONE uint8 = 0
+	CODEC_LZ4  uint8 = 1
+	CODEC_GZIP uint8 = 2
+)
+
+func LookupCodec(algorithm string) (uint8, error) {
+	switch algorithm {
+	case "LZ4":
+		return CODEC_LZ4, nil
+	case "GZIP":
+		return CODEC_GZIP, nil
+	default:
+		return CODEC_NONE, fmt.Errorf("unknown compression algorithm: %s", algorithm)
+	}
+}
+
+func CodecName(codec uint8) (string, error) {
+	switch codec {
+	case CODEC_LZ4:
+		return "LZ4", nil
+	case CODEC_GZIP:
+		return "GZIP", nil
+	default:
+		return "", fmt.Errorf("unknown compression codec: %d", codec)
+	}
+}
+
 func DeflateStream(name string, r io.Reader) (io.Reader, error) {
 	// Check if input is empty
 	buf := make([]byte, 1)
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2024 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"gopkg.in/ini.v1"
+)
+
+// Config holds the defaults of the configuration file, an ini file with a
+// section per subcommand:
+//
+//	[backup]
+//	pre-scan = /usr/local/bin/quiesce
+type Config struct {
+	file *ini.File
+}
+
+// Load reads the configuration file at pathname, a missing file yields an
+// empty configuration.
+func Load(pathname string) (*Config, error) {
+	data, err := os.ReadFile(pathname)
+	if errors.Is(err, fs.ErrNotExist) {
+		return New(), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// values are often shell commands, ; and # are not comments there
+	file, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{file: file}, nil
+}
+
+func New() *Config {
+	return &Config{file: ini.Empty()}
+}
+
+// Get returns the value of key in section, or an empty string.
+func (c *Config) Get(section string, key string) string {
+	if c == nil || !c.file.Section(section).HasKey(key) {
+		return ""
+	}
+	return c.file.Section(section).Key(key).String()
+}
+
+// Keys returns the keys of section in the order of the file.
+func (c *Config) Keys(section string) []string {
+	if c == nil {
+		return nil
+	}
+	return c.file.Section(section).KeyStrings()
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	pathname := filepath.Join(t.TempDir(), "plakarconfig")
+	data := "[backup]\npre-scan = lvcreate -s vg/data; sync # quiesce\npre-scan-policy = warn\n"
+	if err := os.WriteFile(pathname, []byte(data), 0600); err != nil {
+		t.Fatalf("Failed to write configuration: %v", err)
+	}
+
+	cfg, err := Load(pathname)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	if value := cfg.Get("backup", "pre-scan"); value != "lvcreate -s vg/data; sync # quiesce" {
+		t.Errorf("Expected the whole command, got %q", value)
+	}
+	if value := cfg.Get("backup", "pre-scan-policy"); value != "warn" {
+		t.Errorf("Expected warn, got %q", value)
+	}
+	if value := cfg.Get("restore", "pre-scan"); value != "" {
+		t.Errorf("Expected an empty value, got %q", value)
+	}
+	if keys := cfg.Keys("backup"); len(keys) != 2 || keys[0] != "pre-scan" {
+		t.Errorf("Expected the keys in file order, got %v", keys)
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	cfg, err := Load(filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatalf("Expected a missing file to be ignored, got %v", err)
+	}
+	if value := cfg.Get("backup", "pre-scan"); value != "" {
+		t.Errorf("Expected an empty value, got %q", value)
+	}
+
+	var nilConfig *Config
+	if value := nilConfig.Get("backup", "pre-scan"); value != "" {
+		t.Errorf("Expected an empty value from a nil configuration, got %q", value)
+	}
+}
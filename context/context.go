@@ -1,17 +1,26 @@
 package context
 
 import (
+	stdcontext "context"
+
 	"github.com/PlakarKorp/plakar/caching"
+	"github.com/PlakarKorp/plakar/config"
 	"github.com/PlakarKorp/plakar/encryption/keypair"
 	"github.com/PlakarKorp/plakar/events"
 	"github.com/PlakarKorp/plakar/logging"
 	"github.com/google/uuid"
 )
 
+// Context implements context.Context, it is cancelled when the command
+// should stop, as on SIGINT.
 type Context struct {
+	stdcontext.Context
+	cancel stdcontext.CancelFunc
+
 	events *events.Receiver
 	cache  *caching.Manager
 	logger *logging.Logger
+	config *config.Config
 
 	numCPU      int
 	username    string
@@ -38,15 +47,24 @@ type Context struct {
 }
 
 func NewContext() *Context {
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
 	return &Context{
-		events: events.New(),
+		Context: ctx,
+		cancel:  cancel,
+		events:  events.New(),
 	}
 }
 
 func (c *Context) Close() {
+	c.cancel()
 	c.events.Close()
 }
 
+// Cancel requests the current command to stop.
+func (c *Context) Cancel() {
+	c.cancel()
+}
+
 func (c *Context) Events() *events.Receiver {
 	return c.events
 }
@@ -115,6 +133,15 @@ func (c *Context) GetHomeDir() string {
 	return c.homeDir
 }
 
+func (c *Context) SetConfig(config *config.Config) {
+	c.config = config
+}
+
+// GetConfig returns the configuration file defaults, which may be nil.
+func (c *Context) GetConfig() *config.Config {
+	return c.config
+}
+
 func (c *Context) SetCacheDir(cacheDir string) {
 	c.cacheDir = cacheDir
 }
@@ -68,6 +68,16 @@ func DeriveSecret(passphrase []byte, secret string) ([]byte, error) {
 	return dk, nil
 }
 
+// EncryptedSize returns the size of the output of EncryptStream for an
+// input of the given size: the encrypted subkey followed by one nonce and
+// authentication tag per chunk.
+func EncryptedSize(size int) int {
+	const nonceSize, tagSize = 12, 16
+
+	chunks := (size + chunkSize - 1) / chunkSize
+	return nonceSize + 32 + tagSize + chunks*(nonceSize+tagSize) + size
+}
+
 // EncryptStream encrypts a stream using AES-GCM with a random session-specific subkey
 func EncryptStream(key []byte, r io.Reader) (io.Reader, error) {
 	// Generate a random subkey for data encryption
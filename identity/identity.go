@@ -3,6 +3,7 @@ package identity
 import (
 	"bytes"
 	"crypto/rand"
+	"fmt"
 	"io"
 	"net/mail"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
+	"github.com/PlakarKorp/plakar/context"
 	"github.com/PlakarKorp/plakar/encryption"
 	"github.com/PlakarKorp/plakar/encryption/keypair"
 	"github.com/google/uuid"
@@ -88,6 +90,30 @@ func UnsealIdentity(keyringDir string, identifier uuid.UUID) (*Identity, error)
 	return nil, err
 }
 
+// UseIdentity unseals the identity named by identifier, or by the
+// PLAKAR_IDENTITY environment variable when empty, and signs with it from
+// ctx.  Nothing is done if neither is set.
+func UseIdentity(ctx *context.Context, identifier string) error {
+	if identifier == "" {
+		identifier = os.Getenv("PLAKAR_IDENTITY")
+	}
+	if identifier == "" {
+		return nil
+	}
+
+	parsedID, err := uuid.Parse(identifier)
+	if err != nil {
+		return fmt.Errorf("invalid identity: %w", err)
+	}
+	id, err := UnsealIdentity(ctx.GetKeyringDir(), parsedID)
+	if err != nil {
+		return fmt.Errorf("could not unseal identity: %w", err)
+	}
+	ctx.SetIdentity(id.Identifier)
+	ctx.SetKeypair(&id.KeyPair)
+	return nil
+}
+
 func Unseal(data []byte, passphrase []byte) (*Identity, error) {
 	var si SealedIdentity
 	if err := msgpack.Unmarshal(data, &si); err != nil {
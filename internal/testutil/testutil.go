@@ -0,0 +1,80 @@
+// Package testutil holds the fixtures shared by the tests of several
+// packages.
+package testutil
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/caching"
+	"github.com/PlakarKorp/plakar/context"
+	"github.com/PlakarKorp/plakar/logging"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/storage"
+
+	_ "github.com/PlakarKorp/plakar/storage/backends/fs"
+)
+
+// NewContext returns a context logging nowhere, with cache as its cache.
+func NewContext(t *testing.T, cache *caching.Manager) *context.Context {
+	t.Helper()
+
+	ctx := context.NewContext()
+	ctx.SetLogger(logging.NewLogger(io.Discard, io.Discard))
+	ctx.SetCache(cache)
+	ctx.SetMaxConcurrency(4)
+	t.Cleanup(ctx.Close)
+	return ctx
+}
+
+// NewRepository creates an unencrypted repository in a temporary directory,
+// with its own cache.  If configure is not nil, it is called to change the
+// configuration before the repository is created.
+func NewRepository(t *testing.T, configure func(*storage.Configuration)) *repository.Repository {
+	t.Helper()
+
+	cache := caching.NewManager(t.TempDir())
+	t.Cleanup(func() { cache.Close() })
+	ctx := NewContext(t, cache)
+
+	configuration := storage.NewConfiguration()
+	configuration.Encryption = nil
+	if configure != nil {
+		configure(configuration)
+	}
+
+	location := filepath.Join(t.TempDir(), "repository")
+	store, err := storage.Create(ctx, location, *configuration)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	store.Close()
+
+	return OpenRepository(t, ctx, location)
+}
+
+// OpenRepository opens the repository at location with ctx.
+func OpenRepository(t *testing.T, ctx *context.Context, location string) *repository.Repository {
+	t.Helper()
+
+	store, err := storage.Open(ctx, location)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	repo, err := repository.New(ctx, store, nil)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+// ReopenRepository opens repo again with a new context sharing its cache, as
+// the next run of a command would, for instance once the context of repo is
+// cancelled.
+func ReopenRepository(t *testing.T, repo *repository.Repository) *repository.Repository {
+	t.Helper()
+
+	return OpenRepository(t, NewContext(t, repo.Context().GetCache()), repo.Location())
+}
@@ -23,6 +23,7 @@ type FileInfo struct {
 	Luid       uint64      `json:"uid" msgpack:"uid"`
 	Lgid       uint64      `json:"gid" msgpack:"gid"`
 	Lnlink     uint16      `json:"nlink" msgpack:"nlink"`
+	Lrdev      uint64      `json:"rdev" msgpack:"rdev,omitempty"`
 	Lusername  string      `json:"username" msgpack:"username"`
 	Lgroupname string      `json:"groupname" msgpack:"groupname"`
 }
@@ -67,6 +68,10 @@ func (f FileInfo) Nlink() uint16 {
 	return f.Lnlink
 }
 
+func (f FileInfo) Rdev() uint64 {
+	return f.Lrdev
+}
+
 func (f FileInfo) Sys() any {
 	return nil
 }
@@ -85,6 +90,7 @@ func FileInfoFromStat(stat fs.FileInfo) FileInfo {
 	Luid := uint64(0)
 	Lgid := uint64(0)
 	Lnlink := uint16(0)
+	Lrdev := uint64(0)
 
 	if _, ok := stat.Sys().(*syscall.Stat_t); ok {
 		Ldev = uint64(stat.Sys().(*syscall.Stat_t).Dev)
@@ -92,6 +98,7 @@ func FileInfoFromStat(stat fs.FileInfo) FileInfo {
 		Luid = uint64(stat.Sys().(*syscall.Stat_t).Uid)
 		Lgid = uint64(stat.Sys().(*syscall.Stat_t).Gid)
 		Lnlink = uint16(stat.Sys().(*syscall.Stat_t).Nlink)
+		Lrdev = uint64(stat.Sys().(*syscall.Stat_t).Rdev)
 	}
 
 	return FileInfo{
@@ -104,6 +111,7 @@ func FileInfoFromStat(stat fs.FileInfo) FileInfo {
 		Luid:     Luid,
 		Lgid:     Lgid,
 		Lnlink:   Lnlink,
+		Lrdev:    Lrdev,
 	}
 }
 
@@ -134,7 +142,8 @@ func (fileinfo *FileInfo) Equal(fi *FileInfo) bool {
 		fileinfo.Lino == fi.Lino &&
 		fileinfo.Luid == fi.Luid &&
 		fileinfo.Lgid == fi.Lgid &&
-		fileinfo.Lnlink == fi.Lnlink
+		fileinfo.Lnlink == fi.Lnlink &&
+		fileinfo.Lrdev == fi.Lrdev
 }
 
 var sortKeyMapping = map[string]string{
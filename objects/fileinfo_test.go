@@ -1,6 +1,7 @@
 package objects
 
 import (
+	"os"
 	"testing"
 	"time"
 )
@@ -101,3 +102,24 @@ func TestSortFileInfos(t *testing.T) {
 		})
 	}
 }
+
+func TestFileInfoFromStatDevice(t *testing.T) {
+	stat, err := os.Lstat("/dev/null")
+	if err != nil {
+		t.Skip("no /dev/null")
+	}
+
+	fileinfo := FileInfoFromStat(stat)
+	if fileinfo.Mode()&os.ModeCharDevice == 0 {
+		t.Fatalf("Expected a character device but got %s", fileinfo.Mode())
+	}
+	if fileinfo.Rdev() == 0 {
+		t.Fatalf("Expected a device number but got 0")
+	}
+
+	other := fileinfo
+	other.Lrdev++
+	if fileinfo.Equal(&other) {
+		t.Fatalf("Expected devices with different numbers to differ")
+	}
+}
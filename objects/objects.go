@@ -77,3 +77,27 @@ type Chunk struct {
 	Flags        uint32       `msgpack:"flags" json:"flags"`
 	Distribution [256]float64 `msgpack:"distribution,omitempty" json:"distribution"`
 }
+
+const (
+	// CHUNK_FLAG_HOLE marks a run of zeros with no data on disk, such a
+	// chunk has no checksum and no blob.
+	CHUNK_FLAG_HOLE uint32 = 1 << 0
+)
+
+func NewHoleChunk(length uint32) Chunk {
+	return Chunk{Length: length, Flags: CHUNK_FLAG_HOLE}
+}
+
+func (c *Chunk) IsHole() bool {
+	return c.Flags&CHUNK_FLAG_HOLE != 0
+}
+
+// IsSparse reports whether the object has holes.
+func (o *Object) IsSparse() bool {
+	for i := range o.Chunks {
+		if o.Chunks[i].IsHole() {
+			return true
+		}
+	}
+	return false
+}
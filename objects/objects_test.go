@@ -0,0 +1,36 @@
+package objects
+
+import (
+	"testing"
+)
+
+func TestObjectHoles(t *testing.T) {
+	object := NewObject()
+	object.Chunks = append(object.Chunks, Chunk{Checksum: Checksum{1}, Length: 4096})
+	if object.IsSparse() {
+		t.Fatalf("Expected an object without holes not to be sparse")
+	}
+
+	object.Chunks = append(object.Chunks, NewHoleChunk(1<<20))
+	if !object.IsSparse() {
+		t.Fatalf("Expected an object with a hole to be sparse")
+	}
+
+	serialized, err := object.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize object: %v", err)
+	}
+	deserialized, err := NewObjectFromBytes(serialized)
+	if err != nil {
+		t.Fatalf("Failed to deserialize object: %v", err)
+	}
+	if len(deserialized.Chunks) != 2 {
+		t.Fatalf("Expected 2 chunks but got %d", len(deserialized.Chunks))
+	}
+	if deserialized.Chunks[0].IsHole() {
+		t.Fatalf("Expected the first chunk not to be a hole")
+	}
+	if hole := deserialized.Chunks[1]; !hole.IsHole() || hole.Length != 1<<20 {
+		t.Fatalf("Expected a hole of %d bytes but got %+v", 1<<20, hole)
+	}
+}
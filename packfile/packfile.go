@@ -6,34 +6,79 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math/bits"
 	"time"
 )
 
-const VERSION = 100
+const VERSION = 200
+
+// VERSION_V1 packfiles have no per-blob flags, their blobs are all encoded
+// with the repository-wide codecs.
+const VERSION_V1 = 100
+
+const (
+	FOOTER_SIZE_V1 = 52
+	FOOTER_SIZE    = 64
+
+	INDEX_ENTRY_SIZE_V1 = 41
+	INDEX_ENTRY_SIZE    = 45
+)
+
+// MAGIC terminates v2 footers so they can be told apart from v1 ones.
+var MAGIC = [8]byte{'P', 'L', 'A', 'K', 'P', 'A', 'C', 'K'}
 
 type Type uint8
 
 const (
-	TYPE_SNAPSHOT  Type = 0
-	TYPE_CHUNK     Type = 1
-	TYPE_OBJECT    Type = 2
-	TYPE_FILE      Type = 3
-	TYPE_DIRECTORY Type = 4
-	TYPE_CHILD     Type = 5
-	TYPE_DATA      Type = 6
-	TYPE_SIGNATURE Type = 7
-	TYPE_ERROR     Type = 8
+	TYPE_SNAPSHOT   Type = 0
+	TYPE_CHUNK      Type = 1
+	TYPE_OBJECT     Type = 2
+	TYPE_FILE       Type = 3
+	TYPE_DIRECTORY  Type = 4
+	TYPE_CHILD      Type = 5
+	TYPE_DATA       Type = 6
+	TYPE_SIGNATURE  Type = 7
+	TYPE_ERROR      Type = 8
+	TYPE_ANNOTATION Type = 9
 )
 
+// Flags describe how a blob was encoded. Blobs from v1 packfiles have no
+// flags and FLAG_V2 is always set for blobs written in v2 packfiles, so
+// readers can fall back to the repository-wide codecs when it is missing.
+type Flags uint32
+
+const (
+	FLAG_V2         Flags = 1 << 0
+	FLAG_COMPRESSED Flags = 1 << 1
+	FLAG_ENCRYPTED  Flags = 1 << 2
+	FLAG_METADATA   Flags = 1 << 3 // blob is stored in a metadata packfile
+)
+
+// Codec returns the compression codec identifier stored in bits 8-15.
+func (f Flags) Codec() uint8 {
+	return uint8(f >> 8)
+}
+
+func (f Flags) WithCodec(codec uint8) Flags {
+	return f&^(0xff<<8) | Flags(codec)<<8
+}
+
 type Blob struct {
 	Type     Type
 	Checksum [32]byte
 	Offset   uint32
 	Length   uint32
+	Flags    Flags
 }
 
 func Types() []Type {
-	return []Type{TYPE_SNAPSHOT, TYPE_CHUNK, TYPE_OBJECT, TYPE_FILE, TYPE_DIRECTORY, TYPE_CHILD, TYPE_DATA, TYPE_SIGNATURE, TYPE_ERROR}
+	return []Type{TYPE_SNAPSHOT, TYPE_CHUNK, TYPE_OBJECT, TYPE_FILE, TYPE_DIRECTORY, TYPE_CHILD, TYPE_DATA, TYPE_SIGNATURE, TYPE_ERROR, TYPE_ANNOTATION}
+}
+
+// IsMetadata reports whether blobs of this type describe snapshots rather
+// than hold their content, they are packed apart from data blobs.
+func (t Type) IsMetadata() bool {
+	return t != TYPE_CHUNK && t != TYPE_DATA
 }
 
 func (b Blob) TypeName() string {
@@ -56,6 +101,8 @@ func (b Blob) TypeName() string {
 		return "signature"
 	case TYPE_ERROR:
 		return "error"
+	case TYPE_ANNOTATION:
+		return "annotation"
 	default:
 		return "unknown"
 	}
@@ -73,19 +120,52 @@ type PackFileFooter struct {
 	Count         uint32
 	IndexOffset   uint32
 	IndexChecksum [32]byte
+
+	// v2 only: length of the encoded index stored right after the data,
+	// the index may be followed by padding up to the trailer.
+	IndexLength uint32
 }
 
 type Configuration struct {
-	MaxSize uint32
+	MaxSize         uint32
+	MaxMetadataSize uint32
+	Padding         bool
 }
 
 func DefaultConfiguration() *Configuration {
 	return &Configuration{
-		MaxSize: (20 << 10) << 10,
+		MaxSize:         (20 << 10) << 10,
+		MaxMetadataSize: (4 << 10) << 10,
 	}
 }
 
+// MaxSizeFor returns the maximum size of packfiles holding blobs of the
+// given type, repositories predating metadata packfiles use MaxSize.
+func (c Configuration) MaxSizeFor(t Type) uint32 {
+	if t.IsMetadata() && c.MaxMetadataSize != 0 {
+		return c.MaxMetadataSize
+	}
+	return c.MaxSize
+}
+
+// PaddedSize rounds length up to the next size bucket using the Padmé
+// scheme, which leaks O(log log length) bits about the actual size while
+// keeping the overhead under 12%.
+func PaddedSize(length uint64) uint64 {
+	if length < 2 {
+		return length
+	}
+	e := uint64(bits.Len64(length) - 1)
+	s := uint64(bits.Len64(e))
+	mask := uint64(1)<<(e-s) - 1
+	return (length + mask) &^ mask
+}
+
 func NewFooterFromBytes(serialized []byte) (PackFileFooter, error) {
+	version := uint32(VERSION_V1)
+	if len(serialized) == FOOTER_SIZE && bytes.Equal(serialized[FOOTER_SIZE-len(MAGIC):], MAGIC[:]) {
+		version = VERSION
+	}
 
 	reader := bytes.NewReader(serialized)
 	var footer PackFileFooter
@@ -101,38 +181,74 @@ func NewFooterFromBytes(serialized []byte) (PackFileFooter, error) {
 	if err := binary.Read(reader, binary.LittleEndian, &footer.IndexOffset); err != nil {
 		return footer, err
 	}
+	if version >= VERSION {
+		if err := binary.Read(reader, binary.LittleEndian, &footer.IndexLength); err != nil {
+			return footer, err
+		}
+	}
 	if err := binary.Read(reader, binary.LittleEndian, &footer.IndexChecksum); err != nil {
 		return footer, err
 	}
+	if footer.Version != version {
+		return footer, fmt.Errorf("packfile footer version mismatch")
+	}
 	return footer, nil
 }
 
-func NewIndexFromBytes(serialized []byte) ([]Blob, error) {
+func readBlob(reader io.Reader, version uint32) (Blob, error) {
+	var dataType uint8
+	var blob Blob
+	if err := binary.Read(reader, binary.LittleEndian, &dataType); err != nil {
+		return blob, err
+	}
+	blob.Type = Type(dataType)
+	if err := binary.Read(reader, binary.LittleEndian, &blob.Checksum); err != nil {
+		return blob, err
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &blob.Offset); err != nil {
+		return blob, err
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &blob.Length); err != nil {
+		return blob, err
+	}
+	if version >= VERSION {
+		if err := binary.Read(reader, binary.LittleEndian, &blob.Flags); err != nil {
+			return blob, err
+		}
+	}
+	return blob, nil
+}
+
+func writeBlob(w io.Writer, blob Blob, version uint32) error {
+	if err := binary.Write(w, binary.LittleEndian, blob.Type); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, blob.Checksum); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, blob.Offset); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, blob.Length); err != nil {
+		return err
+	}
+	if version >= VERSION {
+		if err := binary.Write(w, binary.LittleEndian, blob.Flags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewIndexFromBytes(version uint32, serialized []byte) ([]Blob, error) {
 	reader := bytes.NewReader(serialized)
 	index := make([]Blob, 0)
 	for reader.Len() > 0 {
-		var dataType uint8
-		var checksum [32]byte
-		var chunkOffset uint32
-		var chunkLength uint32
-		if err := binary.Read(reader, binary.LittleEndian, &dataType); err != nil {
-			return nil, err
-		}
-		if err := binary.Read(reader, binary.LittleEndian, &checksum); err != nil {
-			return nil, err
-		}
-		if err := binary.Read(reader, binary.LittleEndian, &chunkOffset); err != nil {
+		blob, err := readBlob(reader, version)
+		if err != nil {
 			return nil, err
 		}
-		if err := binary.Read(reader, binary.LittleEndian, &chunkLength); err != nil {
-			return nil, err
-		}
-		index = append(index, Blob{
-			Type:     Type(dataType),
-			Checksum: checksum,
-			Offset:   chunkOffset,
-			Length:   chunkLength,
-		})
+		index = append(index, blob)
 	}
 	return index, nil
 }
@@ -150,91 +266,52 @@ func New() *PackFile {
 }
 
 func NewFromBytes(serialized []byte) (*PackFile, error) {
-	reader := bytes.NewReader(serialized)
-	var footer PackFileFooter
-	_, err := reader.Seek(-52, io.SeekEnd)
-	if err != nil {
-		return nil, err
+	footerSize := FOOTER_SIZE_V1
+	if len(serialized) >= FOOTER_SIZE && bytes.Equal(serialized[len(serialized)-len(MAGIC):], MAGIC[:]) {
+		footerSize = FOOTER_SIZE
 	}
-	if err := binary.Read(reader, binary.LittleEndian, &footer.Version); err != nil {
-		return nil, err
+	if len(serialized) < footerSize {
+		return nil, fmt.Errorf("packfile too short")
 	}
-	if err := binary.Read(reader, binary.LittleEndian, &footer.Timestamp); err != nil {
+
+	footer, err := NewFooterFromBytes(serialized[len(serialized)-footerSize:])
+	if err != nil {
 		return nil, err
 	}
-	if err := binary.Read(reader, binary.LittleEndian, &footer.Count); err != nil {
-		return nil, err
+
+	serialized = serialized[:len(serialized)-footerSize]
+	if footer.IndexOffset > uint32(len(serialized)) {
+		return nil, fmt.Errorf("index offset exceeds total length of packfile")
 	}
-	if err := binary.Read(reader, binary.LittleEndian, &footer.IndexOffset); err != nil {
-		return nil, err
+	return NewFromParts(serialized[:footer.IndexOffset], serialized[footer.IndexOffset:], footer)
+}
+
+// NewFromParts assembles a packfile from its data section, its serialized
+// index and its footer, verifying the index against the footer checksum.
+func NewFromParts(data []byte, serializedIndex []byte, footer PackFileFooter) (*PackFile, error) {
+	if footer.IndexOffset != uint32(len(data)) {
+		return nil, fmt.Errorf("index offset does not match length of data")
 	}
-	if err := binary.Read(reader, binary.LittleEndian, &footer.IndexChecksum); err != nil {
-		return nil, err
+
+	checksum := sha256.Sum256(serializedIndex)
+	if checksum != footer.IndexChecksum {
+		return nil, fmt.Errorf("index checksum mismatch")
 	}
 
-	_, err = reader.Seek(0, io.SeekStart)
+	index, err := NewIndexFromBytes(footer.Version, serializedIndex)
 	if err != nil {
 		return nil, err
 	}
-	data := make([]byte, footer.IndexOffset)
-	if err := binary.Read(reader, binary.LittleEndian, &data); err != nil {
-		return nil, err
+	for _, blob := range index {
+		if uint64(blob.Offset)+uint64(blob.Length) > uint64(footer.IndexOffset) {
+			return nil, fmt.Errorf("chunk offset + chunk length exceeds total length of packfile")
+		}
 	}
 
-	// we won't read the totalLength again
-	remaining := reader.Len() - 52
-
 	p := New()
 	p.Footer = footer
 	p.Blobs = data
-	hasher := sha256.New()
-	for remaining > 0 {
-		var dataType uint8
-		var checksum [32]byte
-		var chunkOffset uint32
-		var chunkLength uint32
-		if err := binary.Read(reader, binary.LittleEndian, &dataType); err != nil {
-			return nil, err
-		}
-		if err := binary.Read(reader, binary.LittleEndian, &checksum); err != nil {
-			return nil, err
-		}
-		if err := binary.Read(reader, binary.LittleEndian, &chunkOffset); err != nil {
-			return nil, err
-		}
-		if err := binary.Read(reader, binary.LittleEndian, &chunkLength); err != nil {
-			return nil, err
-		}
-
-		if chunkOffset+chunkLength > p.Footer.IndexOffset {
-			return nil, fmt.Errorf("chunk offset + chunk length exceeds total length of packfile")
-		}
-
-		if err := binary.Write(hasher, binary.LittleEndian, dataType); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(hasher, binary.LittleEndian, checksum); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(hasher, binary.LittleEndian, chunkOffset); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(hasher, binary.LittleEndian, chunkLength); err != nil {
-			return nil, err
-		}
-		p.Index = append(p.Index, Blob{
-			Type:     Type(dataType),
-			Checksum: checksum,
-			Offset:   chunkOffset,
-			Length:   chunkLength,
-		})
-		remaining -= (len(checksum) + 9)
-	}
-	checksum := [32]byte(hasher.Sum(nil))
-	if checksum != p.Footer.IndexChecksum {
-		return nil, fmt.Errorf("index checksum mismatch")
-	}
-
+	p.Index = index
 	return p, nil
 }
 
@@ -244,49 +321,20 @@ func (p *PackFile) Serialize() ([]byte, error) {
 		return nil, err
 	}
 
-	hasher := sha256.New()
-	for _, chunk := range p.Index {
-		if err := binary.Write(&buffer, binary.LittleEndian, chunk.Type); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(&buffer, binary.LittleEndian, chunk.Checksum); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(&buffer, binary.LittleEndian, chunk.Offset); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(&buffer, binary.LittleEndian, chunk.Length); err != nil {
-			return nil, err
-		}
-
-		if err := binary.Write(hasher, binary.LittleEndian, chunk.Type); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(hasher, binary.LittleEndian, chunk.Checksum); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(hasher, binary.LittleEndian, chunk.Offset); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(hasher, binary.LittleEndian, chunk.Length); err != nil {
-			return nil, err
-		}
-	}
-	p.Footer.IndexChecksum = [32]byte(hasher.Sum(nil))
-
-	if err := binary.Write(&buffer, binary.LittleEndian, p.Footer.Version); err != nil {
-		return nil, err
-	}
-	if err := binary.Write(&buffer, binary.LittleEndian, p.Footer.Timestamp); err != nil {
+	serializedIndex, err := p.SerializeIndex()
+	if err != nil {
 		return nil, err
 	}
-	if err := binary.Write(&buffer, binary.LittleEndian, p.Footer.Count); err != nil {
+	p.Footer.IndexLength = uint32(len(serializedIndex))
+	if _, err := buffer.Write(serializedIndex); err != nil {
 		return nil, err
 	}
-	if err := binary.Write(&buffer, binary.LittleEndian, p.Footer.IndexOffset); err != nil {
+
+	serializedFooter, err := p.SerializeFooter()
+	if err != nil {
 		return nil, err
 	}
-	if err := binary.Write(&buffer, binary.LittleEndian, p.Footer.IndexChecksum); err != nil {
+	if _, err := buffer.Write(serializedFooter); err != nil {
 		return nil, err
 	}
 
@@ -303,70 +351,25 @@ func (p *PackFile) SerializeData() ([]byte, error) {
 
 func (p *PackFile) SerializeIndex() ([]byte, error) {
 	var buffer bytes.Buffer
-	hasher := sha256.New()
-	for _, chunk := range p.Index {
-		if err := binary.Write(&buffer, binary.LittleEndian, chunk.Type); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(&buffer, binary.LittleEndian, chunk.Checksum); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(&buffer, binary.LittleEndian, chunk.Offset); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(&buffer, binary.LittleEndian, chunk.Length); err != nil {
-			return nil, err
-		}
-
-		if err := binary.Write(hasher, binary.LittleEndian, chunk.Type); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(hasher, binary.LittleEndian, chunk.Checksum); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(hasher, binary.LittleEndian, chunk.Offset); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(hasher, binary.LittleEndian, chunk.Length); err != nil {
+	for _, blob := range p.Index {
+		if err := writeBlob(&buffer, blob, p.Footer.Version); err != nil {
 			return nil, err
 		}
 	}
 	return buffer.Bytes(), nil
 }
 
+// SerializeFooter computes the index checksum and serializes the footer,
+// IndexLength must be set beforehand by the caller for v2 packfiles as it
+// depends on how the index is encoded.
 func (p *PackFile) SerializeFooter() ([]byte, error) {
-	var buffer bytes.Buffer
-	hasher := sha256.New()
-	for _, chunk := range p.Index {
-		if err := binary.Write(&buffer, binary.LittleEndian, chunk.Type); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(&buffer, binary.LittleEndian, chunk.Checksum); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(&buffer, binary.LittleEndian, chunk.Offset); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(&buffer, binary.LittleEndian, chunk.Length); err != nil {
-			return nil, err
-		}
-
-		if err := binary.Write(hasher, binary.LittleEndian, chunk.Type); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(hasher, binary.LittleEndian, chunk.Checksum); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(hasher, binary.LittleEndian, chunk.Offset); err != nil {
-			return nil, err
-		}
-		if err := binary.Write(hasher, binary.LittleEndian, chunk.Length); err != nil {
-			return nil, err
-		}
+	serializedIndex, err := p.SerializeIndex()
+	if err != nil {
+		return nil, err
 	}
-	p.Footer.IndexChecksum = [32]byte(hasher.Sum(nil))
+	p.Footer.IndexChecksum = sha256.Sum256(serializedIndex)
 
-	buffer.Reset()
+	var buffer bytes.Buffer
 	if err := binary.Write(&buffer, binary.LittleEndian, p.Footer.Version); err != nil {
 		return nil, err
 	}
@@ -379,15 +382,29 @@ func (p *PackFile) SerializeFooter() ([]byte, error) {
 	if err := binary.Write(&buffer, binary.LittleEndian, p.Footer.IndexOffset); err != nil {
 		return nil, err
 	}
+	if p.Footer.Version >= VERSION {
+		if err := binary.Write(&buffer, binary.LittleEndian, p.Footer.IndexLength); err != nil {
+			return nil, err
+		}
+	}
 	if err := binary.Write(&buffer, binary.LittleEndian, p.Footer.IndexChecksum); err != nil {
 		return nil, err
 	}
+	if p.Footer.Version >= VERSION {
+		if err := binary.Write(&buffer, binary.LittleEndian, MAGIC); err != nil {
+			return nil, err
+		}
+	}
 
 	return buffer.Bytes(), nil
 }
 
 func (p *PackFile) AddBlob(dataType Type, checksum [32]byte, data []byte) {
-	p.Index = append(p.Index, Blob{dataType, checksum, uint32(len(p.Blobs)), uint32(len(data))})
+	p.AddBlobWithFlags(dataType, checksum, data, 0)
+}
+
+func (p *PackFile) AddBlobWithFlags(dataType Type, checksum [32]byte, data []byte, flags Flags) {
+	p.Index = append(p.Index, Blob{dataType, checksum, uint32(len(p.Blobs)), uint32(len(data)), flags})
 	p.Blobs = append(p.Blobs, data...)
 	p.Footer.Count++
 	p.Footer.IndexOffset = uint32(len(p.Blobs))
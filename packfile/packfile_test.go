@@ -87,3 +87,115 @@ func TestPackFileSerialization(t *testing.T) {
 		t.Fatalf("Expected %s but got %s", chunk2, retrievedChunk2)
 	}
 }
+
+func TestPackFileFlagsSerialization(t *testing.T) {
+	p := New()
+
+	chunk1 := []byte("This is chunk number 1")
+	chunk2 := []byte("This is chunk number 2")
+	checksum1 := [32]byte{1}
+	checksum2 := [32]byte{2}
+	flags1 := (FLAG_V2 | FLAG_COMPRESSED).WithCodec(2)
+	flags2 := FLAG_V2 | FLAG_ENCRYPTED
+
+	p.AddBlobWithFlags(TYPE_CHUNK, checksum1, chunk1, flags1)
+	p.AddBlobWithFlags(TYPE_OBJECT, checksum2, chunk2, flags2)
+
+	serialized, err := p.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize PackFile: %v", err)
+	}
+	if !bytes.Equal(serialized[len(serialized)-len(MAGIC):], MAGIC[:]) {
+		t.Fatalf("Expected serialized PackFile to end with magic")
+	}
+
+	p2, err := NewFromBytes(serialized)
+	if err != nil {
+		t.Fatalf("Failed to create PackFile from bytes: %v", err)
+	}
+	if p2.Footer.Version != VERSION {
+		t.Fatalf("Expected Footer.Version to be %d but got %d", VERSION, p2.Footer.Version)
+	}
+	if len(p2.Index) != 2 || p2.Index[0].Flags != flags1 || p2.Index[1].Flags != flags2 {
+		t.Fatalf("Expected flags %x and %x but got %+v", flags1, flags2, p2.Index)
+	}
+	if p2.Index[0].Flags.Codec() != 2 {
+		t.Fatalf("Expected codec 2 but got %d", p2.Index[0].Flags.Codec())
+	}
+
+	serialized[0] ^= 0xff
+	serialized[len(serialized)-FOOTER_SIZE-1] ^= 0xff
+	if _, err := NewFromBytes(serialized); err == nil {
+		t.Fatalf("Expected tampered index to be rejected")
+	}
+}
+
+func TestPackFileV1Serialization(t *testing.T) {
+	p := New()
+	p.Footer.Version = VERSION_V1
+
+	chunk1 := []byte("This is chunk number 1")
+	checksum1 := [32]byte{1}
+	p.AddBlob(TYPE_CHUNK, checksum1, chunk1)
+
+	serialized, err := p.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize PackFile: %v", err)
+	}
+	if len(serialized) != len(chunk1)+INDEX_ENTRY_SIZE_V1+FOOTER_SIZE_V1 {
+		t.Fatalf("Expected v1 PackFile of %d bytes but got %d", len(chunk1)+INDEX_ENTRY_SIZE_V1+FOOTER_SIZE_V1, len(serialized))
+	}
+
+	p2, err := NewFromBytes(serialized)
+	if err != nil {
+		t.Fatalf("Failed to create PackFile from bytes: %v", err)
+	}
+	if p2.Footer.Version != VERSION_V1 {
+		t.Fatalf("Expected Footer.Version to be %d but got %d", VERSION_V1, p2.Footer.Version)
+	}
+
+	retrievedChunk1, exists := p2.GetBlob(checksum1)
+	if !exists || !bytes.Equal(retrievedChunk1, chunk1) {
+		t.Fatalf("Expected %s but got %s", chunk1, retrievedChunk1)
+	}
+	if p2.Index[0].Flags != 0 {
+		t.Fatalf("Expected no flags on v1 blob but got %x", p2.Index[0].Flags)
+	}
+}
+
+func TestPaddedSize(t *testing.T) {
+	for _, size := range []uint64{0, 1, 2, 100, 4096, 65537, 20 << 20, 20<<20 + 12345} {
+		padded := PaddedSize(size)
+		if padded < size {
+			t.Fatalf("PaddedSize(%d) = %d is smaller than input", size, padded)
+		}
+		if float64(padded-size) > float64(size)*0.12 {
+			t.Fatalf("PaddedSize(%d) = %d exceeds 12%% overhead", size, padded)
+		}
+		if PaddedSize(padded) != padded {
+			t.Fatalf("PaddedSize(%d) is not a fixed point", padded)
+		}
+	}
+	if PaddedSize(20<<20+1) != PaddedSize(20<<20+1000) {
+		t.Fatalf("Expected nearby sizes to share a bucket")
+	}
+}
+
+func TestConfigurationMaxSizeFor(t *testing.T) {
+	c := DefaultConfiguration()
+	for _, Type := range Types() {
+		expected := c.MaxMetadataSize
+		if Type == TYPE_CHUNK || Type == TYPE_DATA {
+			expected = c.MaxSize
+		}
+		if c.MaxSizeFor(Type) != expected {
+			t.Fatalf("Expected MaxSizeFor(%d) to be %d but got %d", Type, expected, c.MaxSizeFor(Type))
+		}
+	}
+
+	// configurations predating metadata packfiles only have MaxSize
+	legacy := Configuration{MaxSize: 1024}
+	if legacy.MaxSizeFor(TYPE_DIRECTORY) != 1024 {
+		t.Fatalf("Expected legacy MaxSizeFor to fall back to MaxSize but got %d", legacy.MaxSizeFor(TYPE_DIRECTORY))
+	}
+}
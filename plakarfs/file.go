@@ -8,8 +8,10 @@ import (
 	"syscall"
 
 	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot"
 	"github.com/PlakarKorp/plakar/snapshot/vfs"
 	"github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
 )
 
 // File implements both Node and Handle for the hello file.
@@ -48,10 +50,29 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
 	return nil
 }
 
-func (f *File) ReadAll(ctx context.Context) ([]byte, error) {
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
 	rd, err := f.parent.snap.NewReader(f.fullpath)
 	if err != nil {
 		return nil, err
 	}
-	return io.ReadAll(rd)
+	return &FileHandle{rd: rd}, nil
+}
+
+// FileHandle reads an open file at the offsets requested by the kernel,
+// only the chunks holding them are fetched.
+type FileHandle struct {
+	rd snapshot.Reader
+}
+
+func (h *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	n, err := h.rd.ReadAt(resp.Data[:req.Size], req.Offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	resp.Data = resp.Data[:n]
+	return nil
+}
+
+func (h *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return h.rd.Close()
 }
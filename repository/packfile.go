@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/PlakarKorp/plakar/compression"
+	"github.com/PlakarKorp/plakar/encryption"
+	"github.com/PlakarKorp/plakar/packfile"
+)
+
+var ErrInvalidPackfile = errors.New("invalid packfile")
+
+func (r *Repository) encrypt(buffer []byte) ([]byte, error) {
+	if r.secret == nil {
+		return buffer, nil
+	}
+	rd, err := encryption.EncryptStream(r.secret, bytes.NewReader(buffer))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rd)
+}
+
+func (r *Repository) decrypt(buffer []byte) ([]byte, error) {
+	if r.secret == nil {
+		return buffer, nil
+	}
+	rd, err := encryption.DecryptStream(r.secret, bytes.NewReader(buffer))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rd)
+}
+
+// EncodeBlob encodes a blob for storage in a v2 packfile and returns the
+// flags describing how it was encoded. Data that doesn't shrink when
+// compressed is stored uncompressed.
+func (r *Repository) EncodeBlob(buffer []byte) ([]byte, packfile.Flags, error) {
+	t0 := time.Now()
+	defer func() {
+		r.Logger().Trace("repository", "EncodeBlob(%d): %s", len(buffer), time.Since(t0))
+	}()
+
+	flags := packfile.FLAG_V2
+	encoded := buffer
+
+	if r.configuration.Compression != nil {
+		codec, err := compression.LookupCodec(r.configuration.Compression.Algorithm)
+		if err != nil {
+			return nil, 0, err
+		}
+		rd, err := compression.DeflateStream(r.configuration.Compression.Algorithm, bytes.NewReader(buffer))
+		if err != nil {
+			return nil, 0, err
+		}
+		compressed, err := io.ReadAll(rd)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(compressed) < len(buffer) {
+			encoded = compressed
+			flags |= packfile.FLAG_COMPRESSED
+			flags = flags.WithCodec(codec)
+		}
+	}
+
+	if r.secret != nil {
+		encrypted, err := r.encrypt(encoded)
+		if err != nil {
+			return nil, 0, err
+		}
+		encoded = encrypted
+		flags |= packfile.FLAG_ENCRYPTED
+	}
+
+	// the encoded blob is queued to the packer while the caller reuses its
+	// buffer, so it must never be returned as is
+	if flags&(packfile.FLAG_COMPRESSED|packfile.FLAG_ENCRYPTED) == 0 {
+		encoded = bytes.Clone(buffer)
+	}
+
+	return encoded, flags, nil
+}
+
+// DecodeBlob reverses EncodeBlob, blobs without FLAG_V2 come from v1
+// packfiles and are decoded with the repository-wide codecs.
+func (r *Repository) DecodeBlob(buffer []byte, flags packfile.Flags) ([]byte, error) {
+	t0 := time.Now()
+	defer func() {
+		r.Logger().Trace("repository", "DecodeBlob(%d, %x): %s", len(buffer), flags, time.Since(t0))
+	}()
+
+	if flags&packfile.FLAG_V2 == 0 {
+		return r.DecodeBuffer(buffer)
+	}
+
+	decoded := buffer
+	if flags&packfile.FLAG_ENCRYPTED != 0 {
+		if r.secret == nil {
+			return nil, fmt.Errorf("blob is encrypted but no secret is available")
+		}
+		decrypted, err := r.decrypt(decoded)
+		if err != nil {
+			return nil, err
+		}
+		decoded = decrypted
+	}
+
+	if flags&packfile.FLAG_COMPRESSED != 0 {
+		algorithm, err := compression.CodecName(flags.Codec())
+		if err != nil {
+			return nil, err
+		}
+		rd, err := compression.InflateStream(algorithm, bytes.NewReader(decoded))
+		if err != nil {
+			return nil, err
+		}
+		decoded, err = io.ReadAll(rd)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return decoded, nil
+}
+
+// packfileTrailerSize is the size of the encrypted v2 footer, it only
+// depends on whether the repository is encrypted so that all packfiles of
+// a repository end with a trailer of the same size.
+func (r *Repository) packfileTrailerSize() int {
+	if r.secret == nil {
+		return packfile.FOOTER_SIZE
+	}
+	return encryption.EncryptedSize(packfile.FOOTER_SIZE)
+}
+
+// EncodePackfile serializes a packfile in the v2 layout:
+//
+//	data | encoded index | padding | encrypted footer
+//
+// The footer is encrypted but not compressed so it has a fixed size and
+// can be located without any plaintext framing.
+func (r *Repository) EncodePackfile(p *packfile.PackFile) ([]byte, error) {
+	t0 := time.Now()
+	defer func() {
+		r.Logger().Trace("repository", "EncodePackfile(): %s", time.Since(t0))
+	}()
+
+	if p.Footer.Version != packfile.VERSION {
+		return nil, fmt.Errorf("can't encode packfile version %d", p.Footer.Version)
+	}
+
+	serializedData, err := p.SerializeData()
+	if err != nil {
+		return nil, err
+	}
+	serializedIndex, err := p.SerializeIndex()
+	if err != nil {
+		return nil, err
+	}
+	encodedIndex, err := r.EncodeBuffer(serializedIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Footer.IndexLength = uint32(len(encodedIndex))
+	serializedFooter, err := p.SerializeFooter()
+	if err != nil {
+		return nil, err
+	}
+	encryptedFooter, err := r.encrypt(serializedFooter)
+	if err != nil {
+		return nil, err
+	}
+
+	padding := 0
+	if r.configuration.Packfile.Padding {
+		size := uint64(len(serializedData) + len(encodedIndex) + len(encryptedFooter))
+		padding = int(packfile.PaddedSize(size) - size)
+	}
+
+	buffer := make([]byte, 0, len(serializedData)+len(encodedIndex)+padding+len(encryptedFooter))
+	buffer = append(buffer, serializedData...)
+	buffer = append(buffer, encodedIndex...)
+	if padding != 0 {
+		randomPadding := make([]byte, padding)
+		if _, err := rand.Read(randomPadding); err != nil {
+			return nil, err
+		}
+		buffer = append(buffer, randomPadding...)
+	}
+	buffer = append(buffer, encryptedFooter...)
+
+	return buffer, nil
+}
+
+// DecodePackfile parses a packfile as written by EncodePackfile, falling
+// back to the v1 layout when no valid v2 footer is found. Blobs are left
+// encoded.
+func (r *Repository) DecodePackfile(buffer []byte) (*packfile.PackFile, error) {
+	t0 := time.Now()
+	defer func() {
+		r.Logger().Trace("repository", "DecodePackfile(%d): %s", len(buffer), time.Since(t0))
+	}()
+
+	trailerSize := r.packfileTrailerSize()
+	if len(buffer) >= trailerSize {
+		serializedFooter, err := r.decrypt(buffer[len(buffer)-trailerSize:])
+		if err == nil {
+			footer, err := packfile.NewFooterFromBytes(serializedFooter)
+			if err == nil && footer.Version == packfile.VERSION {
+				return r.decodePackfile(buffer, footer)
+			}
+		}
+	}
+	return r.decodePackfileV1(buffer)
+}
+
+func (r *Repository) decodePackfile(buffer []byte, footer packfile.PackFileFooter) (*packfile.PackFile, error) {
+	indexEnd := uint64(footer.IndexOffset) + uint64(footer.IndexLength)
+	if indexEnd > uint64(len(buffer)) {
+		return nil, ErrInvalidPackfile
+	}
+
+	serializedIndex, err := r.DecodeBuffer(buffer[footer.IndexOffset:indexEnd])
+	if err != nil {
+		return nil, err
+	}
+	return packfile.NewFromParts(buffer[:footer.IndexOffset], serializedIndex, footer)
+}
+
+// v1 packfiles end with the encoded footer, the plaintext version and the
+// length of the encoded footer.
+func (r *Repository) decodePackfileV1(buffer []byte) (*packfile.PackFile, error) {
+	if len(buffer) < 5 {
+		return nil, ErrInvalidPackfile
+	}
+
+	version := binary.LittleEndian.Uint32(buffer[len(buffer)-5:])
+	footerLength := int(buffer[len(buffer)-1])
+	buffer = buffer[:len(buffer)-5]
+	if version != packfile.VERSION_V1 || footerLength > len(buffer) {
+		return nil, ErrInvalidPackfile
+	}
+
+	serializedFooter, err := r.DecodeBuffer(buffer[len(buffer)-footerLength:])
+	if err != nil {
+		return nil, err
+	}
+	footer, err := packfile.NewFooterFromBytes(serializedFooter)
+	if err != nil {
+		return nil, err
+	}
+	buffer = buffer[:len(buffer)-footerLength]
+	if int(footer.IndexOffset) > len(buffer) {
+		return nil, ErrInvalidPackfile
+	}
+
+	serializedIndex, err := r.DecodeBuffer(buffer[footer.IndexOffset:])
+	if err != nil {
+		return nil, err
+	}
+	return packfile.NewFromParts(buffer[:footer.IndexOffset], serializedIndex, footer)
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/compression"
+	"github.com/PlakarKorp/plakar/context"
+	"github.com/PlakarKorp/plakar/logging"
+	"github.com/PlakarKorp/plakar/storage"
+)
+
+func TestEncodeBlobIncompressible(t *testing.T) {
+	ctx := context.NewContext()
+	ctx.SetLogger(logging.NewLogger(io.Discard, io.Discard))
+	defer ctx.Close()
+
+	r := &Repository{
+		configuration: storage.Configuration{Compression: compression.DefaultConfiguration()},
+		context:       ctx,
+	}
+
+	buffer := make([]byte, 4096)
+	if _, err := rand.Read(buffer); err != nil {
+		t.Fatalf("Failed to generate data: %v", err)
+	}
+	expected := bytes.Clone(buffer)
+
+	encoded, flags, err := r.EncodeBlob(buffer)
+	if err != nil {
+		t.Fatalf("Failed to encode blob: %v", err)
+	}
+	clear(buffer)
+
+	decoded, err := r.DecodeBlob(encoded, flags)
+	if err != nil {
+		t.Fatalf("Failed to decode blob: %v", err)
+	}
+	if !bytes.Equal(decoded, expected) {
+		t.Errorf("Expected the encoded blob not to share the caller's buffer")
+	}
+}
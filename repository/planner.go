@@ -0,0 +1,261 @@
+package repository
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/packfile"
+)
+
+type ReadOptions struct {
+	// ranges separated by at most MaxGap bytes are merged into one read
+	MaxGap uint32
+	// merged reads never exceed MaxReadSize bytes
+	MaxReadSize uint32
+	// bytes of encoded blobs planned and fetched at once, bounding memory
+	WindowSize uint64
+	// number of reads issued in parallel
+	MaxConcurrency int
+}
+
+func DefaultReadOptions() *ReadOptions {
+	return &ReadOptions{
+		MaxGap:         256 << 10,
+		MaxReadSize:    4 << 20,
+		WindowSize:     8 << 20,
+		MaxConcurrency: 2,
+	}
+}
+
+type plannedBlob struct {
+	checksum objects.Checksum
+	packfile objects.Checksum
+	offset   uint32
+	length   uint32
+	flags    packfile.Flags
+	exists   bool
+
+	data []byte
+	err  error
+}
+
+type plannedRead struct {
+	packfile objects.Checksum
+	offset   uint32
+	length   uint32
+	blobs    []*plannedBlob
+}
+
+// planReads groups blobs by packfile and merges ranges that are adjacent
+// or close enough that reading the gap is cheaper than another request.
+func planReads(blobs []*plannedBlob, options *ReadOptions) []*plannedRead {
+	byPackfile := make(map[objects.Checksum][]*plannedBlob)
+	packfiles := make([]objects.Checksum, 0)
+	for _, blob := range blobs {
+		if !blob.exists {
+			continue
+		}
+		if _, exists := byPackfile[blob.packfile]; !exists {
+			packfiles = append(packfiles, blob.packfile)
+		}
+		byPackfile[blob.packfile] = append(byPackfile[blob.packfile], blob)
+	}
+
+	reads := make([]*plannedRead, 0)
+	for _, packfileChecksum := range packfiles {
+		sorted := byPackfile[packfileChecksum]
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].offset < sorted[j].offset
+		})
+
+		var current *plannedRead
+		for _, blob := range sorted {
+			if current != nil {
+				end := uint64(current.offset) + uint64(current.length)
+				blobEnd := uint64(blob.offset) + uint64(blob.length)
+				if uint64(blob.offset) <= end+uint64(options.MaxGap) &&
+					max(end, blobEnd)-uint64(current.offset) <= uint64(options.MaxReadSize) {
+					current.length = uint32(max(end, blobEnd) - uint64(current.offset))
+					current.blobs = append(current.blobs, blob)
+					continue
+				}
+			}
+			current = &plannedRead{
+				packfile: packfileChecksum,
+				offset:   blob.offset,
+				length:   blob.length,
+				blobs:    []*plannedBlob{blob},
+			}
+			reads = append(reads, current)
+		}
+	}
+	return reads
+}
+
+// BlobStream returns the blobs requested from ReadBlobs in order.
+type BlobStream struct {
+	windows chan []*plannedBlob
+	done    chan struct{}
+	once    sync.Once
+
+	current []*plannedBlob
+	pos     int
+}
+
+// ReadBlobs fetches blobs through a read planner: the ordered list of
+// checksums is split in windows of bounded size, reads within a window are
+// grouped by packfile and coalesced, and the next window is prefetched
+// while the current one is consumed.
+func (r *Repository) ReadBlobs(Type packfile.Type, checksums []objects.Checksum, options *ReadOptions) *BlobStream {
+	if options == nil {
+		options = DefaultReadOptions()
+	}
+
+	s := &BlobStream{
+		windows: make(chan []*plannedBlob, 1),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.windows)
+
+		start := 0
+		for start < len(checksums) {
+			window := make([]*plannedBlob, 0)
+			windowSize := uint64(0)
+			for start < len(checksums) {
+				blob := &plannedBlob{checksum: checksums[start]}
+				blob.packfile, blob.offset, blob.length, blob.exists = r.state.GetSubpartForBlob(Type, blob.checksum)
+				if blob.exists {
+					blob.flags, _ = r.state.GetFlagsForBlob(Type, blob.checksum)
+				} else {
+					blob.err = ErrBlobNotFound
+				}
+				if len(window) != 0 && windowSize+uint64(blob.length) > options.WindowSize {
+					break
+				}
+				window = append(window, blob)
+				windowSize += uint64(blob.length)
+				start++
+			}
+
+			r.fetchWindow(window, options)
+
+			select {
+			case s.windows <- window:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return s
+}
+
+func (r *Repository) fetchWindow(window []*plannedBlob, options *ReadOptions) {
+	concurrency := make(chan bool, max(options.MaxConcurrency, 1))
+	wg := sync.WaitGroup{}
+
+	for _, read := range planReads(window, options) {
+		concurrency <- true
+		wg.Add(1)
+		go func(read *plannedRead) {
+			defer func() {
+				<-concurrency
+				wg.Done()
+			}()
+
+			t0 := time.Now()
+			data, err := r.readRange(read)
+			r.Logger().Trace("repository", "ReadBlobs(): read %x[%d:%d] for %d blobs: %s", read.packfile, read.offset, read.offset+read.length, len(read.blobs), time.Since(t0))
+
+			for _, blob := range read.blobs {
+				if err != nil {
+					blob.err = err
+					continue
+				}
+				start := blob.offset - read.offset
+				blob.data, blob.err = r.DecodeBlob(data[start:start+blob.length], blob.flags)
+			}
+		}(read)
+	}
+	wg.Wait()
+}
+
+func (r *Repository) readRange(read *plannedRead) ([]byte, error) {
+	// blobs of a cancelled read fail so that readers stop promptly
+	if err := r.context.Err(); err != nil {
+		return nil, err
+	}
+	rd, err := r.store.GetPackfileBlob(read.packfile, read.offset, read.length)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != int(read.length) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// Next returns the next blob, or io.EOF once all blobs were returned.
+func (s *BlobStream) Next() ([]byte, error) {
+	for s.pos == len(s.current) {
+		window, ok := <-s.windows
+		if !ok {
+			return nil, io.EOF
+		}
+		s.current = window
+		s.pos = 0
+	}
+
+	blob := s.current[s.pos]
+	s.current[s.pos] = nil
+	s.pos++
+	return blob.data, blob.err
+}
+
+func (s *BlobStream) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
+
+// blobStreamReader concatenates the next count blobs of a stream.
+type blobStreamReader struct {
+	stream *BlobStream
+	count  int
+	buf    *bytes.Reader
+}
+
+// NextReader returns a reader over the concatenation of the next count
+// blobs of the stream, which must be fully read before calling NextReader
+// again.
+func (s *BlobStream) NextReader(count int) io.Reader {
+	return &blobStreamReader{stream: s, count: count, buf: bytes.NewReader(nil)}
+}
+
+func (rd *blobStreamReader) Read(p []byte) (int, error) {
+	for rd.buf.Len() == 0 {
+		if rd.count == 0 {
+			return 0, io.EOF
+		}
+		data, err := rd.stream.Next()
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+		rd.count--
+		rd.buf.Reset(data)
+	}
+	return rd.buf.Read(p)
+}
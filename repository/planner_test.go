@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/plakar/objects"
+)
+
+func TestPlanReads(t *testing.T) {
+	packA := objects.Checksum{1}
+	packB := objects.Checksum{2}
+
+	blobs := []*plannedBlob{
+		{checksum: objects.Checksum{10}, packfile: packA, offset: 100, length: 50, exists: true},
+		{checksum: objects.Checksum{11}, packfile: packB, offset: 0, length: 10, exists: true},
+		{checksum: objects.Checksum{12}, packfile: packA, offset: 0, length: 100, exists: true},
+		{checksum: objects.Checksum{13}, packfile: packA, offset: 160, length: 40, exists: true},
+		{checksum: objects.Checksum{14}, exists: false},
+		{checksum: objects.Checksum{15}, packfile: packA, offset: 1000, length: 10, exists: true},
+	}
+
+	options := &ReadOptions{MaxGap: 16, MaxReadSize: 1024}
+	reads := planReads(blobs, options)
+
+	if len(reads) != 3 {
+		t.Fatalf("Expected 3 reads, got %d", len(reads))
+	}
+
+	if reads[0].packfile != packA || reads[0].offset != 0 || reads[0].length != 200 {
+		t.Errorf("Unexpected first read %x[%d:+%d]", reads[0].packfile, reads[0].offset, reads[0].length)
+	}
+	if len(reads[0].blobs) != 3 {
+		t.Errorf("Expected 3 blobs in first read, got %d", len(reads[0].blobs))
+	}
+	if reads[1].packfile != packA || reads[1].offset != 1000 || reads[1].length != 10 {
+		t.Errorf("Unexpected second read %x[%d:+%d]", reads[1].packfile, reads[1].offset, reads[1].length)
+	}
+	if reads[2].packfile != packB || reads[2].offset != 0 || reads[2].length != 10 {
+		t.Errorf("Unexpected third read %x[%d:+%d]", reads[2].packfile, reads[2].offset, reads[2].length)
+	}
+}
+
+func TestPlanReadsMaxReadSize(t *testing.T) {
+	pack := objects.Checksum{1}
+
+	blobs := []*plannedBlob{
+		{checksum: objects.Checksum{10}, packfile: pack, offset: 0, length: 60, exists: true},
+		{checksum: objects.Checksum{11}, packfile: pack, offset: 60, length: 60, exists: true},
+		{checksum: objects.Checksum{12}, packfile: pack, offset: 120, length: 60, exists: true},
+	}
+
+	reads := planReads(blobs, &ReadOptions{MaxGap: 0, MaxReadSize: 128})
+	if len(reads) != 2 {
+		t.Fatalf("Expected 2 reads, got %d", len(reads))
+	}
+	if reads[0].length != 120 || len(reads[0].blobs) != 2 {
+		t.Errorf("Expected first read of 120 bytes over 2 blobs, got %d bytes over %d blobs", reads[0].length, len(reads[0].blobs))
+	}
+	if reads[1].offset != 120 || reads[1].length != 60 {
+		t.Errorf("Unexpected second read [%d:+%d]", reads[1].offset, reads[1].length)
+	}
+}
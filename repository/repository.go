@@ -329,8 +329,16 @@ func (r *Repository) DeleteSnapshot(snapshotID objects.Checksum) error {
 		return ret
 	}
 
+	cacheInstance, err := r.Context().GetCache().Repository(r.Configuration().RepositoryID)
+	if err != nil {
+		return err
+	}
+	if err := cacheInstance.DelSnapshotRefs(snapshotID); err != nil {
+		return err
+	}
+
 	var buffer bytes.Buffer
-	err := r.state.SerializeStream(&buffer)
+	err = r.state.SerializeStream(&buffer)
 	if err != nil {
 		return err
 	}
@@ -404,10 +412,10 @@ func (r *Repository) GetPackfile(checksum objects.Checksum) (io.Reader, error) {
 	return r.store.GetPackfile(checksum)
 }
 
-func (r *Repository) GetPackfileBlob(checksum objects.Checksum, offset uint32, length uint32) (io.Reader, error) {
+func (r *Repository) GetPackfileBlob(checksum objects.Checksum, offset uint32, length uint32, flags packfile.Flags) (io.Reader, error) {
 	t0 := time.Now()
 	defer func() {
-		r.Logger().Trace("repository", "GetPackfileBlob(%x, %d, %d): %s", checksum, offset, length, time.Since(t0))
+		r.Logger().Trace("repository", "GetPackfileBlob(%x, %d, %d, %x): %s", checksum, offset, length, flags, time.Since(t0))
 	}()
 
 	rd, err := r.store.GetPackfileBlob(checksum, offset, length)
@@ -420,7 +428,7 @@ func (r *Repository) GetPackfileBlob(checksum objects.Checksum, offset uint32, l
 		return nil, err
 	}
 
-	decoded, err := r.DecodeBuffer(data)
+	decoded, err := r.DecodeBlob(data, flags)
 	if err != nil {
 		return nil, err
 	}
@@ -443,7 +451,20 @@ func (r *Repository) DeletePackfile(checksum objects.Checksum) error {
 		r.Logger().Trace("repository", "DeletePackfile(%x): %s", checksum, time.Since(t0))
 	}()
 
-	return r.store.DeletePackfile(checksum)
+	if err := r.store.DeletePackfile(checksum); err != nil {
+		return err
+	}
+
+	// packfiles are addressed by their checksum, a copy left in the cache
+	// is never served for another packfile and is evicted in time
+	cacheInstance, err := r.Context().GetCache().Repository(r.Configuration().RepositoryID)
+	if err == nil {
+		err = cacheInstance.DelPackfile(checksum)
+	}
+	if err != nil {
+		r.Logger().Warn("could not remove packfile %x from cache: %s", checksum, err)
+	}
+	return nil
 }
 
 func (r *Repository) GetBlob(Type packfile.Type, checksum objects.Checksum) (io.Reader, error) {
@@ -456,8 +477,13 @@ func (r *Repository) GetBlob(Type packfile.Type, checksum objects.Checksum) (io.
 	if !exists {
 		return nil, ErrPackfileNotFound
 	}
+	flags, _ := r.state.GetFlagsForBlob(Type, checksum)
 
-	rd, err := r.GetPackfileBlob(packfileChecksum, offset, length)
+	if flags&packfile.FLAG_METADATA != 0 {
+		return r.getMetadataBlob(packfileChecksum, offset, length, flags)
+	}
+
+	rd, err := r.GetPackfileBlob(packfileChecksum, offset, length, flags)
 	if err != nil {
 		return nil, err
 	}
@@ -465,6 +491,46 @@ func (r *Repository) GetBlob(Type packfile.Type, checksum objects.Checksum) (io.
 	return rd, nil
 }
 
+// getMetadataBlob serves blobs from metadata packfiles, which are fetched
+// whole and kept in the local cache as neighbouring blobs are likely to be
+// needed next when browsing a snapshot.  The cache only keeps the most
+// recently used ones.
+func (r *Repository) getMetadataBlob(packfileChecksum objects.Checksum, offset uint32, length uint32, flags packfile.Flags) (io.Reader, error) {
+	cacheInstance, err := r.Context().GetCache().Repository(r.Configuration().RepositoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := cacheInstance.GetPackfile(packfileChecksum)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		rd, err := r.GetPackfile(packfileChecksum)
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(rd)
+		if err != nil {
+			return nil, err
+		}
+		if err := cacheInstance.PutPackfile(packfileChecksum, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if uint64(offset)+uint64(length) > uint64(len(data)) {
+		return nil, ErrInvalidPackfile
+	}
+
+	decoded, err := r.DecodeBlob(data[offset:offset+length], flags)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(decoded), nil
+}
+
 func (r *Repository) BlobExists(Type packfile.Type, checksum objects.Checksum) bool {
 	t0 := time.Now()
 	defer func() {
@@ -474,6 +540,27 @@ func (r *Repository) BlobExists(Type packfile.Type, checksum objects.Checksum) b
 	return r.state.BlobExists(Type, checksum)
 }
 
+func (r *Repository) GetBlobSize(Type packfile.Type, checksum objects.Checksum) (uint32, error) {
+	t0 := time.Now()
+	defer func() {
+		r.Logger().Trace("repository", "GetBlobSize(%x): %s", checksum, time.Since(t0))
+	}()
+
+	_, _, length, exists := r.state.GetSubpartForBlob(Type, checksum)
+	if !exists {
+		return 0, ErrBlobNotFound
+	}
+	return length, nil
+}
+
+func (r *Repository) ListBlobs(Type packfile.Type) <-chan objects.Checksum {
+	t0 := time.Now()
+	defer func() {
+		r.Logger().Trace("repository", "ListBlobs(%d): %s", Type, time.Since(t0))
+	}()
+	return r.state.ListBlobs(Type)
+}
+
 func (r *Repository) ListSnapshots() <-chan objects.Checksum {
 	t0 := time.Now()
 	defer func() {
@@ -482,13 +569,13 @@ func (r *Repository) ListSnapshots() <-chan objects.Checksum {
 	return r.state.ListSnapshots()
 }
 
-func (r *Repository) SetPackfileForBlob(Type packfile.Type, packfileChecksum objects.Checksum, chunkChecksum objects.Checksum, offset uint32, length uint32) {
+func (r *Repository) SetPackfileForBlob(Type packfile.Type, packfileChecksum objects.Checksum, chunkChecksum objects.Checksum, offset uint32, length uint32, flags packfile.Flags) {
 	t0 := time.Now()
 	defer func() {
-		r.Logger().Trace("repository", "SetPackfileForBlob(%x, %x, %d, %d): %s", packfileChecksum, chunkChecksum, offset, length, time.Since(t0))
+		r.Logger().Trace("repository", "SetPackfileForBlob(%x, %x, %d, %d, %x): %s", packfileChecksum, chunkChecksum, offset, length, flags, time.Since(t0))
 	}()
 
-	r.state.SetPackfileForBlob(Type, packfileChecksum, chunkChecksum, offset, length)
+	r.state.SetPackfileForBlobWithFlags(Type, packfileChecksum, chunkChecksum, offset, length, flags)
 }
 
 func (r *Repository) Logger() *logging.Logger {
@@ -28,7 +28,13 @@ import (
 	"github.com/PlakarKorp/plakar/packfile"
 )
 
-const VERSION = 100
+const VERSION = 120
+
+// versionBlobFlags is the first state version recording per-blob flags.
+const versionBlobFlags = 110
+
+// versionAnnotations is the first state version recording annotations.
+const versionAnnotations = 120
 
 type Metadata struct {
 	Version   uint32
@@ -41,8 +47,12 @@ type Location struct {
 	Packfile uint64
 	Offset   uint32
 	Length   uint32
+	Flags    packfile.Flags
 }
 
+// State locks are always taken in the order of the fields below: muChecksum
+// first, then the mutexes of the blob types and muDeletedSnapshots last.  A
+// lock is released before an earlier one is taken, never held across it.
 type State struct {
 	muChecksum   sync.Mutex
 	checksumToId map[objects.Checksum]uint64
@@ -75,6 +85,9 @@ type State struct {
 	muErrors sync.Mutex
 	Errors   map[uint64]Location
 
+	muAnnotations sync.Mutex
+	Annotations   map[uint64]Location
+
 	muList sync.Mutex
 	List   map[uint64]Location
 
@@ -99,6 +112,7 @@ func New() *State {
 		Snapshots:        make(map[uint64]Location),
 		Signatures:       make(map[uint64]Location),
 		Errors:           make(map[uint64]Location),
+		Annotations:      make(map[uint64]Location),
 		DeletedSnapshots: make(map[uint64]time.Time),
 		Metadata: Metadata{
 			Version:   VERSION,
@@ -129,7 +143,30 @@ func (st *State) getOrCreateIdForChecksum(checksum objects.Checksum) uint64 {
 	return newID
 }
 
+// lock acquires all the locks of the state in order so that it can be
+// serialized while blobs are being added to it, it returns the matching
+// unlock.
+func (st *State) lock() func() {
+	mutexes := []*sync.Mutex{
+		&st.muChecksum, &st.muChunks, &st.muObjects, &st.muFiles,
+		&st.muDirectories, &st.muChildren, &st.muDatas, &st.muSnapshots,
+		&st.muSignatures, &st.muErrors, &st.muAnnotations, &st.muList,
+		&st.muDeletedSnapshots,
+	}
+	for _, mu := range mutexes {
+		mu.Lock()
+	}
+	return func() {
+		for _, mu := range mutexes {
+			mu.Unlock()
+		}
+	}
+}
+
 func (st *State) SerializeStream(w io.Writer) error {
+	unlock := st.lock()
+	defer unlock()
+
 	writeUint64 := func(value uint64) error {
 		buf := make([]byte, 8)
 		binary.LittleEndian.PutUint64(buf, value)
@@ -151,7 +188,13 @@ func (st *State) SerializeStream(w io.Writer) error {
 		if err := writeUint32(loc.Offset); err != nil {
 			return err
 		}
-		return writeUint32(loc.Length)
+		if err := writeUint32(loc.Length); err != nil {
+			return err
+		}
+		if st.Metadata.Version >= versionBlobFlags {
+			return writeUint32(uint32(loc.Flags))
+		}
+		return nil
 	}
 
 	// Serialize Metadata
@@ -206,6 +249,13 @@ func (st *State) SerializeStream(w io.Writer) error {
 		{"Errors", st.Errors},
 	}
 
+	if st.Metadata.Version >= versionAnnotations {
+		mappings = append(mappings, struct {
+			name string
+			data map[uint64]Location
+		}{"Annotations", st.Annotations})
+	}
+
 	for _, m := range mappings {
 		if err := serializeMapping(w, m.data, writeUint64, writeLocation); err != nil {
 			return fmt.Errorf("failed to serialize %s: %w", m.name, err)
@@ -249,8 +299,10 @@ func DeserializeStream(r io.Reader) (*State, error) {
 		return binary.LittleEndian.Uint32(buf), nil
 	}
 
+	st := &State{}
+
 	readLocation := func() (Location, error) {
-		packfile, err := readUint64()
+		packfileID, err := readUint64()
 		if err != nil {
 			return Location{}, err
 		}
@@ -262,11 +314,16 @@ func DeserializeStream(r io.Reader) (*State, error) {
 		if err != nil {
 			return Location{}, err
 		}
-		return Location{Packfile: packfile, Offset: offset, Length: length}, nil
+		var flags uint32
+		if st.Metadata.Version >= versionBlobFlags {
+			flags, err = readUint32()
+			if err != nil {
+				return Location{}, err
+			}
+		}
+		return Location{Packfile: packfileID, Offset: offset, Length: length, Flags: packfile.Flags(flags)}, nil
 	}
 
-	st := &State{}
-
 	// Deserialize Metadata
 	version, err := readUint32()
 	if err != nil {
@@ -337,6 +394,14 @@ func DeserializeStream(r io.Reader) (*State, error) {
 		{"Errors", &st.Errors},
 	}
 
+	st.Annotations = make(map[uint64]Location)
+	if st.Metadata.Version >= versionAnnotations {
+		mappings = append(mappings, struct {
+			name string
+			data *map[uint64]Location
+		}{"Annotations", &st.Annotations})
+	}
+
 	for _, m := range mappings {
 		*m.data = make(map[uint64]Location)
 		if err := deserializeMapping(r, *m.data, readUint64, readLocation); err != nil {
@@ -382,54 +447,32 @@ func (st *State) Extends(stateID objects.Checksum) {
 }
 
 func (st *State) mergeLocationMaps(Type packfile.Type, deltaState *State) {
-	var mapPtr *map[uint64]Location
-	switch Type {
-	case packfile.TYPE_SNAPSHOT:
-		deltaState.muSnapshots.Lock()
-		defer deltaState.muSnapshots.Unlock()
-		mapPtr = &deltaState.Snapshots
-	case packfile.TYPE_CHUNK:
-		deltaState.muChunks.Lock()
-		defer deltaState.muChunks.Unlock()
-		mapPtr = &deltaState.Chunks
-	case packfile.TYPE_OBJECT:
-		deltaState.muObjects.Lock()
-		defer deltaState.muObjects.Unlock()
-		mapPtr = &deltaState.Objects
-	case packfile.TYPE_FILE:
-		deltaState.muFiles.Lock()
-		defer deltaState.muFiles.Unlock()
-		mapPtr = &deltaState.Files
-	case packfile.TYPE_DIRECTORY:
-		deltaState.muDirectories.Lock()
-		defer deltaState.muDirectories.Unlock()
-		mapPtr = &deltaState.Directories
-	case packfile.TYPE_CHILD:
-		deltaState.muChildren.Lock()
-		defer deltaState.muChildren.Unlock()
-		mapPtr = &deltaState.Children
-	case packfile.TYPE_DATA:
-		deltaState.muDatas.Lock()
-		defer deltaState.muDatas.Unlock()
-		mapPtr = &deltaState.Datas
-	case packfile.TYPE_SIGNATURE:
-		deltaState.muSignatures.Lock()
-		defer deltaState.muSignatures.Unlock()
-		mapPtr = &deltaState.Signatures
-	case packfile.TYPE_ERROR:
-		deltaState.muErrors.Lock()
-		defer deltaState.muErrors.Unlock()
-		mapPtr = &deltaState.Errors
-	default:
-		panic("invalid blob type")
-	}
-
-	for deltaBlobChecksumID, subpart := range *mapPtr {
-		packfileChecksum := deltaState.IdToChecksum[subpart.Packfile]
-		deltaChunkChecksum := deltaState.IdToChecksum[deltaBlobChecksumID]
-		st.SetPackfileForBlob(Type, packfileChecksum, deltaChunkChecksum,
-			subpart.Offset,
-			subpart.Length,
+	type deltaBlob struct {
+		packfile objects.Checksum
+		blob     objects.Checksum
+		subpart  Location
+	}
+
+	// collected first, the locks of st are not taken under those of delta
+	mu, locations := deltaState.locations(Type)
+	deltaState.muChecksum.Lock()
+	mu.Lock()
+	blobs := make([]deltaBlob, 0, len(locations))
+	for deltaBlobChecksumID, subpart := range locations {
+		blobs = append(blobs, deltaBlob{
+			packfile: deltaState.IdToChecksum[subpart.Packfile],
+			blob:     deltaState.IdToChecksum[deltaBlobChecksumID],
+			subpart:  subpart,
+		})
+	}
+	mu.Unlock()
+	deltaState.muChecksum.Unlock()
+
+	for _, b := range blobs {
+		st.SetPackfileForBlobWithFlags(Type, b.packfile, b.blob,
+			b.subpart.Offset,
+			b.subpart.Length,
+			b.subpart.Flags,
 		)
 	}
 }
@@ -444,121 +487,87 @@ func (st *State) Merge(stateID objects.Checksum, deltaState *State) {
 	st.mergeLocationMaps(packfile.TYPE_SNAPSHOT, deltaState)
 	st.mergeLocationMaps(packfile.TYPE_SIGNATURE, deltaState)
 	st.mergeLocationMaps(packfile.TYPE_ERROR, deltaState)
+	st.mergeLocationMaps(packfile.TYPE_ANNOTATION, deltaState)
 
+	deleted := make(map[objects.Checksum]time.Time)
+	deltaState.muChecksum.Lock()
 	deltaState.muDeletedSnapshots.Lock()
 	for originalSnapshotID, tm := range deltaState.DeletedSnapshots {
-		originalChecksum := deltaState.IdToChecksum[originalSnapshotID]
+		deleted[deltaState.IdToChecksum[originalSnapshotID]] = tm
+	}
+	deltaState.muDeletedSnapshots.Unlock()
+	deltaState.muChecksum.Unlock()
+
+	for originalChecksum, tm := range deleted {
 		snapshotID := st.getOrCreateIdForChecksum(originalChecksum)
+		st.muDeletedSnapshots.Lock()
 		st.DeletedSnapshots[snapshotID] = tm
+		st.muDeletedSnapshots.Unlock()
 	}
-	deltaState.muDeletedSnapshots.Unlock()
 }
 
-func (st *State) GetSubpartForBlob(Type packfile.Type, blobChecksum objects.Checksum) (objects.Checksum, uint32, uint32, bool) {
-	blobID := st.getOrCreateIdForChecksum(blobChecksum)
-
-	var mapPtr *map[uint64]Location
+// locations returns the locations of the blobs of a type and the mutex
+// guarding them.
+func (st *State) locations(Type packfile.Type) (*sync.Mutex, map[uint64]Location) {
 	switch Type {
 	case packfile.TYPE_SNAPSHOT:
-		st.muSnapshots.Lock()
-		defer st.muSnapshots.Unlock()
-		mapPtr = &st.Snapshots
+		return &st.muSnapshots, st.Snapshots
 	case packfile.TYPE_CHUNK:
-		st.muChunks.Lock()
-		defer st.muChunks.Unlock()
-		mapPtr = &st.Chunks
+		return &st.muChunks, st.Chunks
 	case packfile.TYPE_OBJECT:
-		st.muObjects.Lock()
-		defer st.muObjects.Unlock()
-		mapPtr = &st.Objects
+		return &st.muObjects, st.Objects
 	case packfile.TYPE_FILE:
-		st.muFiles.Lock()
-		defer st.muFiles.Unlock()
-		mapPtr = &st.Files
+		return &st.muFiles, st.Files
 	case packfile.TYPE_DIRECTORY:
-		st.muDirectories.Lock()
-		defer st.muDirectories.Unlock()
-		mapPtr = &st.Directories
+		return &st.muDirectories, st.Directories
 	case packfile.TYPE_CHILD:
-		st.muChildren.Lock()
-		defer st.muChildren.Unlock()
-		mapPtr = &st.Children
+		return &st.muChildren, st.Children
 	case packfile.TYPE_DATA:
-		st.muDatas.Lock()
-		defer st.muDatas.Unlock()
-		mapPtr = &st.Datas
+		return &st.muDatas, st.Datas
 	case packfile.TYPE_SIGNATURE:
-		st.muSignatures.Lock()
-		defer st.muSignatures.Unlock()
-		mapPtr = &st.Signatures
+		return &st.muSignatures, st.Signatures
 	case packfile.TYPE_ERROR:
-		st.muErrors.Lock()
-		defer st.muErrors.Unlock()
-		mapPtr = &st.Errors
-	default:
-		panic("invalid blob type")
+		return &st.muErrors, st.Errors
+	case packfile.TYPE_ANNOTATION:
+		return &st.muAnnotations, st.Annotations
 	}
+	panic("invalid blob type")
+}
+
+// lookup returns the location of a blob.
+func (st *State) lookup(Type packfile.Type, blobChecksum objects.Checksum) (Location, bool) {
+	blobID := st.getOrCreateIdForChecksum(blobChecksum)
+
+	mu, locations := st.locations(Type)
+	mu.Lock()
+	defer mu.Unlock()
+	blob, exists := locations[blobID]
+	return blob, exists
+}
 
-	if blob, exists := (*mapPtr)[blobID]; !exists {
+func (st *State) GetSubpartForBlob(Type packfile.Type, blobChecksum objects.Checksum) (objects.Checksum, uint32, uint32, bool) {
+	blob, exists := st.lookup(Type, blobChecksum)
+	if !exists {
 		return objects.Checksum{}, 0, 0, false
-	} else {
-		st.muChecksum.Lock()
-		packfileChecksum := st.IdToChecksum[blob.Packfile]
-		st.muChecksum.Unlock()
-		return packfileChecksum, blob.Offset, blob.Length, true
 	}
+
+	st.muChecksum.Lock()
+	packfileChecksum := st.IdToChecksum[blob.Packfile]
+	st.muChecksum.Unlock()
+	return packfileChecksum, blob.Offset, blob.Length, true
 }
 
 func (st *State) BlobExists(Type packfile.Type, blobChecksum objects.Checksum) bool {
-	blobID := st.getOrCreateIdForChecksum(blobChecksum)
-
-	var mapPtr *map[uint64]Location
-	switch Type {
-	case packfile.TYPE_SNAPSHOT:
-		st.muSnapshots.Lock()
-		defer st.muSnapshots.Unlock()
-		mapPtr = &st.Snapshots
-	case packfile.TYPE_CHUNK:
-		st.muChunks.Lock()
-		defer st.muChunks.Unlock()
-		mapPtr = &st.Chunks
-	case packfile.TYPE_OBJECT:
-		st.muObjects.Lock()
-		defer st.muObjects.Unlock()
-		mapPtr = &st.Objects
-	case packfile.TYPE_FILE:
-		st.muFiles.Lock()
-		defer st.muFiles.Unlock()
-		mapPtr = &st.Files
-	case packfile.TYPE_DIRECTORY:
-		st.muDirectories.Lock()
-		defer st.muDirectories.Unlock()
-		mapPtr = &st.Directories
-	case packfile.TYPE_CHILD:
-		st.muChildren.Lock()
-		defer st.muChildren.Unlock()
-		mapPtr = &st.Children
-	case packfile.TYPE_DATA:
-		st.muDatas.Lock()
-		defer st.muDatas.Unlock()
-		mapPtr = &st.Datas
-	case packfile.TYPE_SIGNATURE:
-		st.muSignatures.Lock()
-		defer st.muSignatures.Unlock()
-		mapPtr = &st.Signatures
-	case packfile.TYPE_ERROR:
-		st.muErrors.Lock()
-		defer st.muErrors.Unlock()
-		mapPtr = &st.Errors
-	default:
-		panic("invalid blob type")
-	}
+	_, exists := st.lookup(Type, blobChecksum)
+	return exists
+}
 
-	if _, exists := (*mapPtr)[blobID]; !exists {
-		return false
-	} else {
-		return true
+func (st *State) GetFlagsForBlob(Type packfile.Type, blobChecksum objects.Checksum) (packfile.Flags, bool) {
+	blob, exists := st.lookup(Type, blobChecksum)
+	if !exists {
+		return 0, false
 	}
+	return blob.Flags, true
 }
 
 func (st *State) Dirty() bool {
@@ -570,56 +579,23 @@ func (st *State) ResetDirty() {
 }
 
 func (st *State) SetPackfileForBlob(Type packfile.Type, packfileChecksum objects.Checksum, blobChecksum objects.Checksum, packfileOffset uint32, chunkLength uint32) {
+	st.SetPackfileForBlobWithFlags(Type, packfileChecksum, blobChecksum, packfileOffset, chunkLength, 0)
+}
+
+func (st *State) SetPackfileForBlobWithFlags(Type packfile.Type, packfileChecksum objects.Checksum, blobChecksum objects.Checksum, packfileOffset uint32, chunkLength uint32, flags packfile.Flags) {
 	packfileID := st.getOrCreateIdForChecksum(packfileChecksum)
 	blobID := st.getOrCreateIdForChecksum(blobChecksum)
 
-	var mapPtr *map[uint64]Location
-	switch Type {
-	case packfile.TYPE_SNAPSHOT:
-		st.muSnapshots.Lock()
-		defer st.muSnapshots.Unlock()
-		mapPtr = &st.Snapshots
-	case packfile.TYPE_CHUNK:
-		st.muChunks.Lock()
-		defer st.muChunks.Unlock()
-		mapPtr = &st.Chunks
-	case packfile.TYPE_OBJECT:
-		st.muObjects.Lock()
-		defer st.muObjects.Unlock()
-		mapPtr = &st.Objects
-	case packfile.TYPE_FILE:
-		st.muFiles.Lock()
-		defer st.muFiles.Unlock()
-		mapPtr = &st.Files
-	case packfile.TYPE_DIRECTORY:
-		st.muDirectories.Lock()
-		defer st.muDirectories.Unlock()
-		mapPtr = &st.Directories
-	case packfile.TYPE_CHILD:
-		st.muChildren.Lock()
-		defer st.muChildren.Unlock()
-		mapPtr = &st.Children
-	case packfile.TYPE_DATA:
-		st.muDatas.Lock()
-		defer st.muDatas.Unlock()
-		mapPtr = &st.Datas
-	case packfile.TYPE_SIGNATURE:
-		st.muSignatures.Lock()
-		defer st.muSignatures.Unlock()
-		mapPtr = &st.Signatures
-	case packfile.TYPE_ERROR:
-		st.muErrors.Lock()
-		defer st.muErrors.Unlock()
-		mapPtr = &st.Errors
-	default:
-		panic("invalid blob type")
-	}
+	mu, locations := st.locations(Type)
+	mu.Lock()
+	defer mu.Unlock()
 
-	if _, exists := (*mapPtr)[blobID]; !exists {
-		(*mapPtr)[blobID] = Location{
+	if _, exists := locations[blobID]; !exists {
+		locations[blobID] = Location{
 			Packfile: packfileID,
 			Offset:   packfileOffset,
 			Length:   chunkLength,
+			Flags:    flags,
 		}
 		atomic.StoreInt32(&st.dirty, 1)
 	}
@@ -649,43 +625,16 @@ func (st *State) DeleteSnapshot(snapshotChecksum objects.Checksum) error {
 func (st *State) ListBlobs(Type packfile.Type) <-chan objects.Checksum {
 	ch := make(chan objects.Checksum)
 	go func() {
-		var mapPtr *map[uint64]Location
-		var mtx *sync.Mutex
-		switch Type {
-		case packfile.TYPE_CHUNK:
-			mtx = &st.muChunks
-			mapPtr = &st.Chunks
-		case packfile.TYPE_OBJECT:
-			mtx = &st.muObjects
-			mapPtr = &st.Objects
-		case packfile.TYPE_FILE:
-			mtx = &st.muFiles
-			mapPtr = &st.Files
-		case packfile.TYPE_DIRECTORY:
-			mtx = &st.muDirectories
-			mapPtr = &st.Directories
-		case packfile.TYPE_CHILD:
-			mtx = &st.muChildren
-			mapPtr = &st.Children
-		case packfile.TYPE_DATA:
-			mtx = &st.muDatas
-			mapPtr = &st.Datas
-		case packfile.TYPE_SIGNATURE:
-			mtx = &st.muSignatures
-			mapPtr = &st.Signatures
-		case packfile.TYPE_ERROR:
-			mtx = &st.muErrors
-			mapPtr = &st.Errors
-		default:
-			panic("invalid blob type")
-		}
+		mtx, locations := st.locations(Type)
 
 		blobsList := make([]objects.Checksum, 0)
+		st.muChecksum.Lock()
 		mtx.Lock()
-		for k := range *mapPtr {
+		for k := range locations {
 			blobsList = append(blobsList, st.IdToChecksum[k])
 		}
 		mtx.Unlock()
+		st.muChecksum.Unlock()
 
 		for _, checksum := range blobsList {
 			ch <- checksum
@@ -699,6 +648,7 @@ func (st *State) ListSnapshots() <-chan objects.Checksum {
 	ch := make(chan objects.Checksum)
 	go func() {
 		snapshotsList := make([]objects.Checksum, 0)
+		st.muChecksum.Lock()
 		st.muSnapshots.Lock()
 		for k := range st.Snapshots {
 			st.muDeletedSnapshots.Lock()
@@ -709,6 +659,7 @@ func (st *State) ListSnapshots() <-chan objects.Checksum {
 			}
 		}
 		st.muSnapshots.Unlock()
+		st.muChecksum.Unlock()
 
 		for _, checksum := range snapshotsList {
 			ch <- checksum
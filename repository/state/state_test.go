@@ -2,7 +2,9 @@ package state
 
 import (
 	"bytes"
+	"io"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -138,6 +140,73 @@ func TestGetSubpartForObject(t *testing.T) {
 	}
 }
 
+func TestBlobFlags(t *testing.T) {
+	st := New()
+
+	packfileChecksum := [32]byte{10, 11, 12}
+	chunkChecksum := [32]byte{13, 14, 15}
+	flags := (packfile.FLAG_V2 | packfile.FLAG_COMPRESSED).WithCodec(1)
+
+	st.SetPackfileForBlobWithFlags(packfile.TYPE_CHUNK, packfileChecksum, chunkChecksum, 100, 200, flags)
+
+	var buffer bytes.Buffer
+	if err := st.SerializeStream(&buffer); err != nil {
+		t.Fatalf("Failed to serialize state: %v", err)
+	}
+	deserializedState, err := DeserializeStream(&buffer)
+	if err != nil {
+		t.Fatalf("Failed to deserialize state: %v", err)
+	}
+
+	merged := New()
+	merged.Merge(objects.Checksum{1}, deserializedState)
+
+	got, exists := merged.GetFlagsForBlob(packfile.TYPE_CHUNK, chunkChecksum)
+	if !exists {
+		t.Fatalf("Expected flags for chunk %v to exist", chunkChecksum)
+	}
+	if got != flags {
+		t.Errorf("Expected flags %x, got %x", flags, got)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	st := New()
+
+	packfileChecksum := [32]byte{10, 11, 12}
+	annotationChecksum := [32]byte{16, 17, 18}
+	st.SetPackfileForBlob(packfile.TYPE_ANNOTATION, packfileChecksum, annotationChecksum, 100, 200)
+
+	var buffer bytes.Buffer
+	if err := st.SerializeStream(&buffer); err != nil {
+		t.Fatalf("Failed to serialize state: %v", err)
+	}
+	deserializedState, err := DeserializeStream(&buffer)
+	if err != nil {
+		t.Fatalf("Failed to deserialize state: %v", err)
+	}
+
+	merged := New()
+	merged.Merge(objects.Checksum{1}, deserializedState)
+	if !merged.BlobExists(packfile.TYPE_ANNOTATION, annotationChecksum) {
+		t.Errorf("Expected annotation %v to exist", annotationChecksum)
+	}
+
+	// states written before annotations have no such mapping
+	st.Metadata.Version = versionBlobFlags
+	buffer.Reset()
+	if err := st.SerializeStream(&buffer); err != nil {
+		t.Fatalf("Failed to serialize state: %v", err)
+	}
+	deserializedState, err = DeserializeStream(&buffer)
+	if err != nil {
+		t.Fatalf("Failed to deserialize state: %v", err)
+	}
+	if len(deserializedState.Annotations) != 0 {
+		t.Errorf("Expected no annotations, got %d", len(deserializedState.Annotations))
+	}
+}
+
 func TestSerializeDeserialize(t *testing.T) {
 	// Create a test State object
 	originalState := &State{
@@ -247,3 +316,106 @@ func TestIdToChecksumSerialization(t *testing.T) {
 			originalState.IdToChecksum, deserializedState.IdToChecksum)
 	}
 }
+
+func TestSerializeWhileAdding(t *testing.T) {
+	st := New()
+	packfileChecksum := [32]byte{1}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			st.SetPackfileForBlob(packfile.TYPE_CHUNK, packfileChecksum, [32]byte{byte(i), byte(i >> 8), 2}, uint32(i), 1)
+		}
+	}()
+
+	for {
+		var buffer bytes.Buffer
+		if err := st.SerializeStream(&buffer); err != nil {
+			t.Fatalf("Failed to serialize: %v", err)
+		}
+		deserialized, err := DeserializeStream(&buffer)
+		if err != nil {
+			t.Fatalf("Failed to deserialize: %v", err)
+		}
+		for id, location := range deserialized.Chunks {
+			if _, exists := deserialized.IdToChecksum[id]; !exists {
+				t.Fatalf("Chunk %d has no checksum", id)
+			}
+			if _, exists := deserialized.IdToChecksum[location.Packfile]; !exists {
+				t.Fatalf("Packfile %d has no checksum", location.Packfile)
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+func TestSerializeWhileLookingUp(t *testing.T) {
+	st := New()
+	packfileChecksum := [32]byte{1}
+	for i := 0; i < 100; i++ {
+		st.SetPackfileForBlob(packfile.TYPE_CHUNK, packfileChecksum, [32]byte{byte(i), 3}, uint32(i), 1)
+		st.SetPackfileForBlob(packfile.TYPE_SNAPSHOT, packfileChecksum, [32]byte{byte(i), 4}, uint32(i), 1)
+	}
+
+	wg := sync.WaitGroup{}
+	run := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				fn(i)
+			}
+		}()
+	}
+
+	run(func(i int) {
+		if err := st.SerializeStream(io.Discard); err != nil {
+			t.Errorf("Failed to serialize: %v", err)
+		}
+	})
+	run(func(i int) {
+		if _, _, _, exists := st.GetSubpartForBlob(packfile.TYPE_CHUNK, [32]byte{byte(i % 100), 3}); !exists {
+			t.Errorf("Expected chunk %d to exist", i%100)
+		}
+	})
+	run(func(i int) {
+		st.BlobExists(packfile.TYPE_OBJECT, [32]byte{byte(i), byte(i >> 8), 5})
+		st.GetFlagsForBlob(packfile.TYPE_CHUNK, [32]byte{byte(i % 100), 3})
+	})
+	run(func(i int) {
+		st.SetPackfileForBlob(packfile.TYPE_CHUNK, packfileChecksum, [32]byte{byte(i), byte(i >> 8), 2}, uint32(i), 1)
+	})
+	run(func(i int) {
+		if i%10 == 0 {
+			for range st.ListBlobs(packfile.TYPE_CHUNK) {
+			}
+			for range st.ListSnapshots() {
+			}
+		}
+	})
+	run(func(i int) {
+		if i < 100 {
+			st.DeleteSnapshot([32]byte{byte(i), 4})
+		}
+		delta := New()
+		delta.SetPackfileForBlob(packfile.TYPE_CHUNK, packfileChecksum, [32]byte{byte(i), byte(i >> 8), 6}, uint32(i), 1)
+		st.Merge([32]byte{7}, delta)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatalf("Expected concurrent accesses to complete, they deadlocked")
+	}
+}
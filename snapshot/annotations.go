@@ -0,0 +1,218 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/packfile"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot/header"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Annotation changes the tags, note or pin of a snapshot after its creation.
+// Headers are immutable, the annotations of a snapshot are replayed in order
+// over its header, nil fields are left unchanged.
+type Annotation struct {
+	Identifier objects.Checksum `msgpack:"identifier" json:"identifier"`
+	Snapshot   objects.Checksum `msgpack:"snapshot" json:"snapshot"`
+	Timestamp  time.Time        `msgpack:"timestamp" json:"timestamp"`
+	Identity   header.Identity  `msgpack:"identity" json:"identity"`
+	AddTags    []string         `msgpack:"add_tags" json:"add_tags,omitempty"`
+	RemoveTags []string         `msgpack:"remove_tags" json:"remove_tags,omitempty"`
+	Note       *string          `msgpack:"note" json:"note,omitempty"`
+	Pinned     *bool            `msgpack:"pinned" json:"pinned,omitempty"`
+}
+
+// Annotations maps snapshots to their annotations, oldest first.
+type Annotations map[objects.Checksum][]*Annotation
+
+func NewAnnotation(repo *repository.Repository, snapshotID objects.Checksum) (*Annotation, error) {
+	var identifier objects.Checksum
+
+	n, err := rand.Read(identifier[:])
+	if err != nil {
+		return nil, err
+	}
+	if n != len(identifier) {
+		return nil, io.ErrShortWrite
+	}
+
+	annotation := &Annotation{
+		Identifier: identifier,
+		Snapshot:   snapshotID,
+		Timestamp:  time.Now(),
+	}
+	if repo.Context().GetIdentity() != uuid.Nil {
+		annotation.Identity.Identifier = repo.Context().GetIdentity()
+		annotation.Identity.PublicKey = repo.Context().GetKeypair().PublicKey
+	}
+	return annotation, nil
+}
+
+func (a *Annotation) Serialize() ([]byte, error) {
+	return msgpack.Marshal(a)
+}
+
+// PutAnnotation writes the annotation in a packfile of its own along with
+// its signature when an identity is set, then records them in a new state.
+// A signed snapshot is only annotated with the identity that signed it.
+func PutAnnotation(repo *repository.Repository, annotation *Annotation) error {
+	snap, err := Load(repo, annotation.Snapshot)
+	if err != nil {
+		return err
+	}
+	if !annotation.authorized(snap.Header) {
+		return fmt.Errorf("snapshot %x is signed, it can only be annotated with identity %s",
+			annotation.Snapshot[:4], snap.Header.Identity.Identifier)
+	}
+
+	serialized, err := annotation.Serialize()
+	if err != nil {
+		return err
+	}
+
+	writer := &Snapshot{
+		repository: repo,
+		stateDelta: repo.NewStateDelta(),
+
+		Header: header.NewHeader("annotation", annotation.Identifier),
+
+		packerChan:     make(chan interface{}, 2),
+		packerChanDone: make(chan bool),
+	}
+	go packerJob(writer)
+	defer writer.closePacker()
+
+	if kp := repo.Context().GetKeypair(); kp != nil && annotation.Identity.Identifier != uuid.Nil {
+		checksum := repo.Checksum(serialized)
+		if err := writer.PutBlob(packfile.TYPE_SIGNATURE, annotation.Identifier, kp.Sign(checksum[:])); err != nil {
+			return err
+		}
+	}
+	if err := writer.PutBlob(packfile.TYPE_ANNOTATION, annotation.Identifier, serialized); err != nil {
+		return err
+	}
+	return writer.commitState()
+}
+
+// GetAnnotation reads an annotation and checks its signature if it was
+// written with an identity.
+func GetAnnotation(repo *repository.Repository, identifier objects.Checksum) (*Annotation, error) {
+	rd, err := repo.GetBlob(packfile.TYPE_ANNOTATION, identifier)
+	if err != nil {
+		return nil, err
+	}
+	serialized, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	var annotation Annotation
+	if err := msgpack.Unmarshal(serialized, &annotation); err != nil {
+		return nil, err
+	}
+	if annotation.Identifier != identifier {
+		return nil, fmt.Errorf("annotation %x: identifier mismatch", identifier[:4])
+	}
+
+	if annotation.Identity.Identifier != uuid.Nil {
+		rd, err := repo.GetBlob(packfile.TYPE_SIGNATURE, identifier)
+		if err != nil {
+			return nil, fmt.Errorf("annotation %x: missing signature: %w", identifier[:4], err)
+		}
+		signature, err := io.ReadAll(rd)
+		if err != nil {
+			return nil, err
+		}
+		checksum := repo.Checksum(serialized)
+		if !ed25519.Verify(annotation.Identity.PublicKey, checksum[:], signature) {
+			return nil, fmt.Errorf("annotation %x: signature verification failed", identifier[:4])
+		}
+	}
+	return &annotation, nil
+}
+
+// LoadAnnotations reads all the annotations of the repository, those that
+// cannot be read or verified are skipped with a warning.
+func LoadAnnotations(repo *repository.Repository) Annotations {
+	annotations := make(Annotations)
+	for identifier := range repo.ListBlobs(packfile.TYPE_ANNOTATION) {
+		annotation, err := GetAnnotation(repo, identifier)
+		if err != nil {
+			repo.Logger().Warn("%s", err)
+			continue
+		}
+		annotations[annotation.Snapshot] = append(annotations[annotation.Snapshot], annotation)
+	}
+
+	for _, list := range annotations {
+		sort.Slice(list, func(i, j int) bool {
+			if !list[i].Timestamp.Equal(list[j].Timestamp) {
+				return list[i].Timestamp.Before(list[j].Timestamp)
+			}
+			return string(list[i].Identifier[:]) < string(list[j].Identifier[:])
+		})
+	}
+	return annotations
+}
+
+// Apply overlays the annotations of the snapshot on hdr.  The header no
+// longer matches its signature, it must not be verified nor written.
+// Annotations of a signed snapshot made with another identity are ignored.
+func (annotations Annotations) Apply(hdr *header.Header) {
+	for _, annotation := range annotations[hdr.Identifier] {
+		if annotation.authorized(hdr) {
+			annotation.apply(hdr)
+		}
+	}
+}
+
+// authorized reports whether the annotation may change hdr, that of a signed
+// snapshot must be signed by the same identity.  The signature of the
+// annotation itself is checked when it is read.
+func (a *Annotation) authorized(hdr *header.Header) bool {
+	if hdr.Identity.Identifier == uuid.Nil {
+		return true
+	}
+	return a.Identity.Identifier == hdr.Identity.Identifier &&
+		bytes.Equal(a.Identity.PublicKey, hdr.Identity.PublicKey)
+}
+
+func (a *Annotation) apply(hdr *header.Header) {
+	tags := make([]string, 0, len(hdr.Tags)+len(a.AddTags))
+	for _, tag := range hdr.Tags {
+		if !containsTag(a.RemoveTags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	for _, tag := range a.AddTags {
+		if !containsTag(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	hdr.Tags = tags
+
+	if a.Note != nil {
+		hdr.Note = *a.Note
+	}
+	if a.Pinned != nil {
+		hdr.Pinned = *a.Pinned
+	}
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package snapshot
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/plakar/encryption/keypair"
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot/header"
+	"github.com/google/uuid"
+)
+
+func TestAnnotationsApply(t *testing.T) {
+	var snapshotID objects.Checksum
+	snapshotID[0] = 1
+
+	hdr := header.NewHeader("test", snapshotID)
+	hdr.Tags = []string{"daily", "db"}
+
+	note := "before upgrade"
+	pinned := true
+	unpinned := false
+	annotations := Annotations{
+		snapshotID: {
+			{Snapshot: snapshotID, AddTags: []string{"keep", "db"}, RemoveTags: []string{"daily"}},
+			{Snapshot: snapshotID, Note: &note, Pinned: &pinned},
+			{Snapshot: snapshotID, RemoveTags: []string{"keep"}, Pinned: &unpinned},
+			{Snapshot: snapshotID, AddTags: []string{"keep"}},
+		},
+	}
+	annotations.Apply(hdr)
+
+	if len(hdr.Tags) != 2 || hdr.Tags[0] != "db" || hdr.Tags[1] != "keep" {
+		t.Errorf("Expected tags [db keep], got %v", hdr.Tags)
+	}
+	if hdr.Note != note {
+		t.Errorf("Expected note %q, got %q", note, hdr.Note)
+	}
+	if hdr.Pinned {
+		t.Errorf("Expected the last annotation to unpin the snapshot")
+	}
+
+	// annotations of other snapshots are left out
+	var otherID objects.Checksum
+	otherID[0] = 2
+	other := header.NewHeader("other", otherID)
+	annotations.Apply(other)
+	if len(other.Tags) != 0 || other.Note != "" || other.Pinned {
+		t.Errorf("Expected other snapshot to be left unchanged, got %v %q %v", other.Tags, other.Note, other.Pinned)
+	}
+}
+
+func TestAnnotationsSigned(t *testing.T) {
+	kp, err := keypair.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate keypair: %v", err)
+	}
+	owner := header.Identity{Identifier: uuid.New(), PublicKey: kp.PublicKey}
+
+	var snapshotID objects.Checksum
+	snapshotID[0] = 1
+	hdr := header.NewHeader("test", snapshotID)
+	hdr.Identity = owner
+
+	pinned := true
+	unpinned := false
+	annotations := Annotations{
+		snapshotID: {
+			{Snapshot: snapshotID, Identity: owner, Pinned: &pinned},
+			{Snapshot: snapshotID, Pinned: &unpinned},
+			{Snapshot: snapshotID, Identity: header.Identity{Identifier: uuid.New(), PublicKey: kp.PublicKey}, AddTags: []string{"other"}},
+		},
+	}
+	annotations.Apply(hdr)
+
+	if !hdr.Pinned {
+		t.Errorf("Expected an unsigned annotation not to unpin a signed snapshot")
+	}
+	if len(hdr.Tags) != 0 {
+		t.Errorf("Expected an annotation of another identity to be ignored, got tags %v", hdr.Tags)
+	}
+}
+
+func TestPutAnnotation(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	kp, err := keypair.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate keypair: %v", err)
+	}
+	repo.Context().SetIdentity(uuid.New())
+	repo.Context().SetKeypair(kp)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "hello"})
+	snap := backupTree(t, repo, src, nil)
+
+	annotation, err := NewAnnotation(repo, snap.Header.Identifier)
+	if err != nil {
+		t.Fatalf("Failed to create annotation: %v", err)
+	}
+	annotation.AddTags = []string{"keep"}
+	if err := PutAnnotation(repo, annotation); err != nil {
+		t.Fatalf("Failed to put annotation: %v", err)
+	}
+
+	repo.Context().SetIdentity(uuid.Nil)
+	repo.Context().SetKeypair(nil)
+	unsigned, err := NewAnnotation(repo, snap.Header.Identifier)
+	if err != nil {
+		t.Fatalf("Failed to create annotation: %v", err)
+	}
+	unpinned := false
+	unsigned.Pinned = &unpinned
+	if err := PutAnnotation(repo, unsigned); err == nil {
+		t.Errorf("Expected an unsigned annotation of a signed snapshot to be rejected")
+	}
+
+	annotations := LoadAnnotations(repo)
+	if len(annotations[snap.Header.Identifier]) != 1 {
+		t.Fatalf("Expected 1 annotation, got %d", len(annotations[snap.Header.Identifier]))
+	}
+	loaded, err := Load(repo, snap.Header.Identifier)
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	annotations.Apply(loaded.Header)
+	if len(loaded.Header.Tags) != 1 || loaded.Header.Tags[0] != "keep" {
+		t.Errorf("Expected tags [keep], got %v", loaded.Header.Tags)
+	}
+}
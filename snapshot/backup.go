@@ -1,9 +1,12 @@
 package snapshot
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"math"
 	"mime"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -16,6 +19,9 @@ import (
 	"github.com/PlakarKorp/plakar/events"
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/packfile"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/PlakarKorp/plakar/snapshot/header"
 	"github.com/PlakarKorp/plakar/snapshot/importer"
 	"github.com/PlakarKorp/plakar/snapshot/vfs"
 	"github.com/gabriel-vasile/mimetype"
@@ -28,15 +34,34 @@ type BackupContext struct {
 	imp            importer.Importer
 	sc             *caching.ScanCache
 	maxConcurrency chan bool
+	excludes       []glob.Glob
 	tree           *btree.BTree[string, int, ErrorItem]
 	mutree         sync.Mutex
 }
 
 type BackupOptions struct {
-	MaxConcurrency uint64
-	Name           string
-	Tags           []string
-	Excludes       []glob.Glob
+	MaxConcurrency     uint64
+	Name               string
+	Category           string
+	Environment        string
+	Perimeter          string
+	Tags               []string
+	Meta               []header.KeyValue
+	Excludes           []string
+	CheckpointInterval time.Duration
+	DiscardCheckpoint  bool
+
+	// filters on files, zero values disable them
+	MinSize      int64
+	MaxSize      int64
+	NewerThan    time.Time
+	OlderThan    time.Time
+	ExcludeTypes []importer.RecordType
+
+	OneFileSystem  bool
+	ExcludeMarkers []string
+
+	Hooks []Hook
 }
 
 func (bc *BackupContext) recordError(path string, err error) error {
@@ -49,7 +74,7 @@ func (bc *BackupContext) recordError(path string, err error) error {
 	return e
 }
 
-func (snapshot *Snapshot) skipExcludedPathname(options *BackupOptions, record importer.ScanResult) bool {
+func (snapshot *Snapshot) skipExcludedPathname(excludes []glob.Glob, record importer.ScanResult) bool {
 	var pathname string
 	switch record := record.(type) {
 	case importer.ScanError:
@@ -58,7 +83,7 @@ func (snapshot *Snapshot) skipExcludedPathname(options *BackupOptions, record im
 		pathname = record.Pathname
 	}
 	doExclude := false
-	for _, exclude := range options.Excludes {
+	for _, exclude := range excludes {
 		if exclude.Match(pathname) {
 			doExclude = true
 			break
@@ -68,7 +93,7 @@ func (snapshot *Snapshot) skipExcludedPathname(options *BackupOptions, record im
 }
 
 func (snap *Snapshot) importerJob(backupCtx *BackupContext, options *BackupOptions) (chan importer.ScanRecord, error) {
-	scanner, err := backupCtx.imp.Scan()
+	scanner, err := backupCtx.imp.Scan(snap.Context(), options.scanOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -81,7 +106,11 @@ func (snap *Snapshot) importerJob(backupCtx *BackupContext, options *BackupOptio
 			if backupCtx.aborted.Load() {
 				break
 			}
-			if snap.skipExcludedPathname(options, _record) {
+			// keep draining so that the importer can terminate
+			if snap.Context().Err() != nil {
+				continue
+			}
+			if snap.skipExcludedPathname(backupCtx.excludes, _record) || options.skipFilteredRecord(_record) {
 				continue
 			}
 
@@ -135,62 +164,259 @@ func (snap *Snapshot) importerJob(backupCtx *BackupContext, options *BackupOptio
 }
 
 func (snap *Snapshot) Backup(scanDir string, options *BackupOptions) error {
+	return snap.BackupSources([]string{scanDir}, options)
+}
+
+// BackupSources is like Backup for several directories or locations, each
+// of them is found under its own path in the snapshot.
+func (snap *Snapshot) BackupSources(scanDirs []string, options *BackupOptions) error {
+	defer snap.closePacker()
+
+	imps := make([]importer.Importer, 0, len(scanDirs))
+	directories := make([]string, 0, len(scanDirs))
+	for _, scanDir := range scanDirs {
+		imp, err := importer.NewImporter(scanDir)
+		if err != nil {
+			return err
+		}
+		defer imp.Close()
+
+		if !strings.Contains(scanDir, "://") {
+			scanDir, err = filepath.Abs(scanDir)
+			if err != nil {
+				snap.Logger().Warn("%s", err)
+				return err
+			}
+		} else {
+			scanDir = imp.Root()
+		}
+		imps = append(imps, imp)
+		directories = append(directories, filepath.ToSlash(scanDir))
+	}
+
+	name := strings.Join(scanDirs, ", ") + " @ " + imps[0].Origin()
+	return snap.backupFrom(imps, name, directories, options)
+}
+
+// BackupImporter is like Backup for an importer built by the caller, which
+// remains responsible for closing it.
+func (snap *Snapshot) BackupImporter(imp importer.Importer, options *BackupOptions) error {
+	return snap.backupFrom([]importer.Importer{imp}, imp.Root()+" @ "+imp.Origin(), []string{imp.Root()}, options)
+}
+
+func (snap *Snapshot) backupFrom(imps []importer.Importer, name string, directories []string, options *BackupOptions) error {
 	snap.Event(events.StartEvent())
 	defer snap.Event(events.DoneEvent())
+	// committing or checkpointing closes the packer, this stops it when
+	// the backup fails
+	defer snap.discardPacker()
 
-	sc2, err := snap.repository.Context().GetCache().Scan(snap.Header.Identifier)
-	if err != nil {
+	if len(imps) == 0 {
+		return fmt.Errorf("nothing to back up")
+	}
+	if err := checkOverlap(directories); err != nil {
 		return err
 	}
-	defer sc2.Close()
 
-	imp, err := importer.NewImporter(scanDir)
-	if err != nil {
+	var err error
+
+	sources := make([]header.Importer, 0, len(imps))
+	checkpointSources := make([]string, 0, len(imps))
+	for i, imp := range imps {
+		sources = append(sources, header.Importer{
+			Type:      imp.Type(),
+			Origin:    imp.Origin(),
+			Directory: directories[i],
+		})
+		checkpointSources = append(checkpointSources, checkpointSource(imp, directories[i]))
+	}
+	snap.Header.Tags = append(snap.Header.Tags, options.Tags...)
+
+	if options.Name == "" {
+		snap.Header.Name = name
+	} else {
+		snap.Header.Name = options.Name
+	}
+	if options.Category != "" {
+		snap.Header.Category = options.Category
+	}
+	if options.Environment != "" {
+		snap.Header.Environment = options.Environment
+	}
+	if options.Perimeter != "" {
+		snap.Header.Perimeter = options.Perimeter
+	}
+	for _, kv := range options.Meta {
+		snap.Header.SetMeta(kv.Key, kv.Value)
+	}
+
+	snap.setSources(sources, options)
+
+	source := strings.Join(checkpointSources, ",")
+	if err := snap.resumeCheckpoint(source, options.DiscardCheckpoint); err != nil {
 		return err
 	}
-	defer imp.Close()
 
-	vfsCache, err := snap.Repository().Context().GetCache().VFS(imp.Type(), imp.Origin())
+	if err := snap.runHooks(options.Hooks, HookPreScan, nil); err != nil {
+		return err
+	}
+	if options.CheckpointInterval != 0 {
+		stopCheckpoints := snap.checkpointJob(source, options.CheckpointInterval)
+		err = snap.backup(imps, options)
+		stopCheckpoints()
+	} else {
+		err = snap.backup(imps, options)
+	}
+	// post-scan hooks run even if the scan failed, to undo what pre-scan
+	// hooks did
+	if hookErr := snap.runHooks(options.Hooks, HookPostScan, err); err == nil {
+		err = hookErr
+	}
 	if err != nil {
+		// an interrupted backup is not committed, what was uploaded so far
+		// is kept in a last checkpoint for the next run to resume from.
+		// The store carries writes through after an interrupt, the pending
+		// packfiles are written before the checkpoint records them.
+		// Without a checkpoint they are dropped.
+		if snap.Context().Err() != nil && options.CheckpointInterval != 0 {
+			if packErr := snap.closePacker(); packErr != nil {
+				snap.Logger().Warn("could not write pending packfiles: %s", packErr)
+			}
+			if err := snap.writeCheckpoint(source); err != nil {
+				snap.Logger().Warn("could not checkpoint backup: %s", err)
+			}
+		}
 		return err
 	}
 
-	cf, err := classifier.NewClassifier(snap.Context())
-	if err != nil {
+	for i, imp := range imps {
+		// a snapshot of the output of a failed command is not committed
+		if cmd, ok := imp.(importer.Commander); ok {
+			sources[i].Command = cmd.Command()
+			sources[i].ExitStatus, err = cmd.Wait()
+			if err != nil {
+				return err
+			}
+		}
+		sources[i].Excludes = headerExcludes(options.Excludes, imp.IgnoreRules())
+	}
+
+	snap.setSources(sources, options)
+
+	if err := snap.runHooks(options.Hooks, HookPreCommit, nil); err != nil {
 		return err
 	}
-	defer cf.Close()
+	if err := snap.Commit(); err != nil {
+		return err
+	}
+	if err := snap.clearCheckpoint(source); err != nil {
+		return err
+	}
+	return snap.runHooks(options.Hooks, HookPostCommit, nil)
+}
 
-	snap.Header.Importer.Origin = imp.Origin()
-	snap.Header.Importer.Type = imp.Type()
-	snap.Header.Tags = append(snap.Header.Tags, options.Tags...)
+// setSources records the importers of the snapshot in its header.
+func (snap *Snapshot) setSources(sources []header.Importer, options *BackupOptions) {
+	if len(sources) == 1 {
+		snap.Header.Importer = sources[0]
+		return
+	}
 
-	if options.Name == "" {
-		snap.Header.Name = scanDir + " @ " + snap.Header.Importer.Origin
-	} else {
-		snap.Header.Name = options.Name
+	directories := make([]string, 0, len(sources))
+	for _, source := range sources {
+		directories = append(directories, source.Directory)
 	}
+	snap.Header.Importer = header.Importer{
+		Type:      sources[0].Type,
+		Origin:    sources[0].Origin,
+		Directory: commonDirectory(directories),
+		Excludes:  headerExcludes(options.Excludes, nil),
+	}
+	snap.Header.Sources = sources
+}
 
-	if !strings.Contains(scanDir, "://") {
-		scanDir, err = filepath.Abs(scanDir)
-		if err != nil {
-			snap.Logger().Warn("%s", err)
-			return err
+// checkOverlap fails if a directory is below another one, their content
+// would otherwise be backed up twice.
+func checkOverlap(directories []string) error {
+	for i := range directories {
+		for j := range directories {
+			if i == j {
+				continue
+			}
+			if directories[i] == directories[j] || isBelow(directories[i], directories[j]) {
+				return fmt.Errorf("%s overlaps with %s", directories[i], directories[j])
+			}
 		}
-	} else {
-		scanDir = imp.Root()
 	}
-	snap.Header.Importer.Directory = filepath.ToSlash(scanDir)
+	return nil
+}
+
+// isBelow reports whether pathname is below directory.
+func isBelow(pathname string, directory string) bool {
+	if directory == "/" {
+		return pathname != "/"
+	}
+	return strings.HasPrefix(pathname, directory+"/")
+}
+
+// commonDirectory returns the deepest directory holding all directories.
+func commonDirectory(directories []string) string {
+	common := directories[0]
+	for _, directory := range directories[1:] {
+		for common != "/" && directory != common && !isBelow(directory, common) {
+			common = path.Dir(common)
+		}
+	}
+	return common
+}
+
+// importerOf returns the importer whose root holds pathname.
+func importerOf(imps []importer.Importer, pathname string) importer.Importer {
+	var match importer.Importer
+	for _, imp := range imps {
+		root := imp.Root()
+		if pathname != root && !isBelow(pathname, root) {
+			continue
+		}
+		if match == nil || len(root) > len(match.Root()) {
+			match = imp
+		}
+	}
+	if match == nil {
+		return imps[0]
+	}
+	return match
+}
+
+// backup scans imps and builds the snapshot tree, filling the Root,
+// Duration, Summary and Errors fields of the header.  It does not commit.
+func (snap *Snapshot) backup(imps []importer.Importer, options *BackupOptions) error {
+	sc2, err := snap.repository.Context().GetCache().Scan(snap.Header.Identifier)
+	if err != nil {
+		return err
+	}
+	defer sc2.Close()
+
+	cf, err := classifier.NewClassifier(snap.Context())
+	if err != nil {
+		return err
+	}
+	defer cf.Close()
 
 	maxConcurrency := options.MaxConcurrency
 	if maxConcurrency == 0 {
 		maxConcurrency = uint64(snap.Context().GetMaxConcurrency())
 	}
 
+	excludes, err := compileExcludes(options.Excludes)
+	if err != nil {
+		return err
+	}
+
 	backupCtx := &BackupContext{
-		imp:            imp,
 		sc:             sc2,
 		maxConcurrency: make(chan bool, maxConcurrency),
+		excludes:       excludes,
 	}
 
 	ds := caching.DBStore[string, ErrorItem]{
@@ -205,6 +431,212 @@ func (snap *Snapshot) Backup(scanDir string, options *BackupOptions) error {
 	/* backup starts now */
 	beginTime := time.Now()
 
+	for _, imp := range imps {
+		backupCtx.imp = imp
+		if err := snap.scanImporter(backupCtx, cf, options); err != nil {
+			return err
+		}
+		if backupCtx.aborted.Load() {
+			return backupCtx.abortedReason
+		}
+	}
+
+	errcsum, err := persistIndex(snap, backupCtx.tree, packfile.TYPE_ERROR)
+	if err != nil {
+		return err
+	}
+
+	var rootSummary *vfs.Summary
+
+	directories, err := sc2.EnumerateKeysWithPrefixReverse("__pathname__", true)
+	if err != nil {
+		return err
+	}
+	for record := range directories {
+		dirEntry := vfs.NewDirectoryEntry(filepath.Dir(record.Pathname), &record)
+
+		childrenChan, err := sc2.EnumerateImmediateChildPathnames(record.Pathname, true)
+		if err != nil {
+			return err
+		}
+
+		/* children */
+		var lastChecksum *objects.Checksum
+		for child := range childrenChan {
+			childChecksum, err := sc2.GetChecksum(child.Pathname)
+			if err != nil {
+				continue
+			}
+			childEntry := &vfs.ChildEntry{
+				Lchecksum: childChecksum,
+				LfileInfo: child.FileInfo,
+			}
+			if child.FileInfo.Mode().IsDir() {
+				data, err := sc2.GetSummary(child.Pathname)
+				if err != nil {
+					continue
+				}
+
+				childSummary, err := vfs.SummaryFromBytes(data)
+				if err != nil {
+					continue
+				}
+
+				dirEntry.Summary.UpdateBelow(childSummary)
+				childEntry.Lsummary = childSummary
+			} else {
+				imp := importerOf(imps, child.Pathname)
+				vfsCache, err := snap.Repository().Context().GetCache().VFS(imp.Type(), imp.Origin())
+				if err != nil {
+					continue
+				}
+				data, err := vfsCache.GetFileSummary(child.Pathname)
+				if err != nil {
+					continue
+				}
+
+				fileSummary, err := vfs.FileSummaryFromBytes(data)
+				if err != nil {
+					continue
+				}
+
+				dirEntry.Summary.UpdateWithFileSummary(fileSummary)
+			}
+
+			if lastChecksum != nil {
+				childEntry.Successor = lastChecksum
+			}
+			childEntrySerialized, err := childEntry.ToBytes()
+			if err != nil {
+				continue
+			}
+			childEntryChecksum := snap.repository.Checksum(childEntrySerialized)
+			lastChecksum = &childEntryChecksum
+
+			if !snap.BlobExists(packfile.TYPE_CHILD, childEntryChecksum) {
+				if err := snap.PutBlob(packfile.TYPE_CHILD, childEntryChecksum, childEntrySerialized); err != nil {
+					continue
+				}
+			}
+			dirEntry.Summary.Directory.Children++
+		}
+		dirEntry.Children = lastChecksum
+
+		iter, err := backupCtx.tree.ScanFrom(record.Pathname)
+		if err != nil {
+			return err
+		}
+		for iter.Next() {
+			_, errentry := iter.Current()
+			if !strings.HasPrefix(errentry.Name, record.Pathname) {
+				break
+			}
+			dirEntry.Summary.Below.Errors++
+		}
+
+		dirEntry.Summary.UpdateAverages()
+
+		classifications := cf.Processor(record.Pathname).Directory(dirEntry)
+		for _, result := range classifications {
+			dirEntry.AddClassification(result.Analyzer, result.Classes)
+		}
+
+		serialized, err := dirEntry.Serialize()
+		if err != nil {
+			return err
+		}
+		dirEntryChecksum := snap.repository.Checksum(serialized)
+
+		if !snap.BlobExists(packfile.TYPE_DIRECTORY, dirEntryChecksum) {
+			err = snap.PutBlob(packfile.TYPE_DIRECTORY, dirEntryChecksum, serialized)
+			if err != nil {
+				backupCtx.recordError(record.Pathname, err)
+				return err
+			}
+		}
+		err = sc2.PutChecksum(record.Pathname, dirEntryChecksum)
+		if err != nil {
+			backupCtx.recordError(record.Pathname, err)
+			return err
+		}
+
+		serializedSummary, err := dirEntry.Summary.ToBytes()
+		if err != nil {
+			backupCtx.recordError(record.Pathname, err)
+			return err
+		}
+
+		err = sc2.PutSummary(record.Pathname, serializedSummary)
+		if err != nil {
+			backupCtx.recordError(record.Pathname, err)
+			return err
+		}
+
+		snap.Event(events.DirectoryOKEvent(snap.Header.Identifier, record.Pathname))
+		if record.Pathname == "/" {
+			rootSummary = &dirEntry.Summary
+		}
+	}
+
+	if backupCtx.aborted.Load() {
+		return backupCtx.abortedReason
+	}
+
+	value, err := sc2.GetChecksum("/")
+	if err != nil {
+		return err
+	}
+
+	snap.Header.Root = value
+	//snap.Header.Metadata = metadataChecksum
+	snap.Header.Duration = time.Since(beginTime)
+	snap.Header.Summary = *rootSummary
+	snap.Header.Errors = errcsum
+
+	/*
+		for _, key := range snap.Metadata.ListKeys() {
+			objectType := strings.Split(key, ";")[0]
+			objectKind := strings.Split(key, "/")[0]
+			if objectType == "" {
+				objectType = "unknown"
+				objectKind = "unknown"
+			}
+			if _, exists := snap.Header.FileKind[objectKind]; !exists {
+				snap.Header.FileKind[objectKind] = 0
+			}
+			snap.Header.FileKind[objectKind] += uint64(len(snap.Metadata.ListValues(key)))
+
+			if _, exists := snap.Header.FileType[objectType]; !exists {
+				snap.Header.FileType[objectType] = 0
+			}
+			snap.Header.FileType[objectType] += uint64(len(snap.Metadata.ListValues(key)))
+		}
+
+		for key, value := range snap.Header.FileType {
+			snap.Header.FilePercentType[key] = math.Round((float64(value)/float64(snap.Header.FilesCount)*100)*100) / 100
+		}
+		for key, value := range snap.Header.FileKind {
+			snap.Header.FilePercentKind[key] = math.Round((float64(value)/float64(snap.Header.FilesCount)*100)*100) / 100
+		}
+		for key, value := range snap.Header.FileExtension {
+			snap.Header.FilePercentExtension[key] = math.Round((float64(value)/float64(snap.Header.FilesCount)*100)*100) / 100
+		}
+	*/
+	return nil
+}
+
+// scanImporter scans the importer of backupCtx into the scan cache and
+// stores the content of its files, the tree is built once all importers are
+// scanned.
+func (snap *Snapshot) scanImporter(backupCtx *BackupContext, cf *classifier.Classifier, options *BackupOptions) error {
+	imp := backupCtx.imp
+	sc2 := backupCtx.sc
+
+	vfsCache, err := snap.Repository().Context().GetCache().VFS(imp.Type(), imp.Origin())
+	if err != nil {
+		return err
+	}
+
 	/* importer */
 	filesChannel, err := snap.importerJob(backupCtx, options)
 	if err != nil {
@@ -222,6 +654,10 @@ func (snap *Snapshot) Backup(scanDir string, options *BackupOptions) error {
 				scannerWg.Done()
 			}()
 
+			if snap.Context().Err() != nil {
+				return
+			}
+
 			snap.Event(events.FileEvent(snap.Header.Identifier, _record.Pathname))
 
 			var fileEntry *vfs.FileEntry
@@ -239,7 +675,13 @@ func (snap *Snapshot) Backup(scanDir string, options *BackupOptions) error {
 					snap.Logger().Warn("VFS CACHE: Error unmarshaling filename: %v", err)
 				} else {
 					cachedFileEntryChecksum = snap.repository.Checksum(data)
-					if cachedFileEntry.Stat().ModTime().Equal(record.FileInfo.ModTime()) && cachedFileEntry.Stat().Size() == record.FileInfo.Size() {
+					// chmod, chown, setfattr and setfacl leave the mtime alone,
+					// the cached entry must also agree on the metadata restore
+					// relies on
+					cachedStat := cachedFileEntry.Stat()
+					if cachedStat.ModTime().Equal(record.FileInfo.ModTime()) && cachedStat.Size() == record.FileInfo.Size() &&
+						cachedStat.Mode() == record.FileInfo.Mode() && cachedStat.Uid() == record.FileInfo.Uid() && cachedStat.Gid() == record.FileInfo.Gid() &&
+						sameExtendedAttributes(cachedFileEntry.ExtendedAttributes, record.ExtendedAttributes) {
 						fileEntry = cachedFileEntry
 						if fileEntry.Type == importer.RecordTypeFile {
 							data, err := vfsCache.GetObject(cachedFileEntry.Object.Checksum)
@@ -260,13 +702,30 @@ func (snap *Snapshot) Backup(scanDir string, options *BackupOptions) error {
 
 			// Chunkify the file if it is a regular file and we don't have a cached object
 			if record.FileInfo.Mode().IsRegular() {
-				if object == nil || !snap.BlobExists(packfile.TYPE_OBJECT, object.Checksum) {
+				if object == nil || !snap.objectExists(object) {
 					object, err = snap.chunkify(imp, cf, record)
 					if err != nil {
 						backupCtx.recordError(record.Pathname, err)
 						return
 					}
 
+					// streamed files only know their size once read
+					if record.FileInfo.Size() < 0 {
+						record.FileInfo.Lsize = 0
+						for _, chunk := range object.Chunks {
+							record.FileInfo.Lsize += int64(chunk.Length)
+						}
+						serializedRecord, err := record.ToBytes()
+						if err != nil {
+							backupCtx.recordError(record.Pathname, err)
+							return
+						}
+						if err := sc2.PutPathname(record.Pathname, serializedRecord); err != nil {
+							backupCtx.recordError(record.Pathname, err)
+							return
+						}
+					}
+
 					serializedObject, err := object.Serialize()
 					if err != nil {
 						backupCtx.recordError(record.Pathname, err)
@@ -298,251 +757,91 @@ func (snap *Snapshot) Backup(scanDir string, options *BackupOptions) error {
 			var fileEntryChecksum objects.Checksum
 			if fileEntry != nil && snap.BlobExists(packfile.TYPE_FILE, cachedFileEntryChecksum) {
 				fileEntryChecksum = cachedFileEntryChecksum
-			} else {
-				fileEntry = vfs.NewFileEntry(filepath.Dir(record.Pathname), &record)
-				if object != nil {
-					fileEntry.Object = object
-				}
-
-				classifications := cf.Processor(record.Pathname).File(fileEntry)
-				for _, result := range classifications {
-					fileEntry.AddClassification(result.Analyzer, result.Classes)
-				}
-
-				serialized, err := fileEntry.Serialize()
-				if err != nil {
-					backupCtx.recordError(record.Pathname, err)
-					return
-				}
-
-				fileEntryChecksum = snap.repository.Checksum(serialized)
-				err = snap.PutBlob(packfile.TYPE_FILE, fileEntryChecksum, serialized)
-				if err != nil {
-					backupCtx.recordError(record.Pathname, err)
-					return
-				}
-
-				// Store the newly generated FileEntry in the cache for future runs
-				err = vfsCache.PutFilename(record.Pathname, serialized)
-				if err != nil {
-					backupCtx.recordError(record.Pathname, err)
-					return
-				}
-
-				fileSummary := &vfs.FileSummary{
-					Type:    record.Type,
-					Size:    uint64(record.FileInfo.Size()),
-					Mode:    record.FileInfo.Mode(),
-					ModTime: record.FileInfo.ModTime().Unix(),
-				}
-				if object != nil {
-					fileSummary.Objects++
-					fileSummary.Chunks += uint64(len(object.Chunks))
-					fileSummary.ContentType = object.ContentType
-					fileSummary.Entropy = object.Entropy
-				}
-
-				seralizedFileSummary, err := fileSummary.Serialize()
-				if err != nil {
-					backupCtx.recordError(record.Pathname, err)
-					return
-				}
-
-				err = vfsCache.PutFileSummary(record.Pathname, seralizedFileSummary)
-				if err != nil {
-					backupCtx.recordError(record.Pathname, err)
-					return
-				}
-			}
-
-			// Record the checksum of the FileEntry in the cache
-			err = sc2.PutChecksum(record.Pathname, fileEntryChecksum)
-			if err != nil {
-				backupCtx.recordError(record.Pathname, err)
-				return
-			}
-			snap.Event(events.FileOKEvent(snap.Header.Identifier, record.Pathname))
-		}(_record)
-	}
-	scannerWg.Wait()
-
-	errcsum, err := persistIndex(snap, backupCtx.tree, packfile.TYPE_ERROR)
-	if err != nil {
-		return err
-	}
-
-	var rootSummary *vfs.Summary
-
-	directories, err := sc2.EnumerateKeysWithPrefixReverse("__pathname__", true)
-	if err != nil {
-		return err
-	}
-	for record := range directories {
-		dirEntry := vfs.NewDirectoryEntry(filepath.Dir(record.Pathname), &record)
-
-		childrenChan, err := sc2.EnumerateImmediateChildPathnames(record.Pathname, true)
-		if err != nil {
-			return err
-		}
-
-		/* children */
-		var lastChecksum *objects.Checksum
-		for child := range childrenChan {
-			childChecksum, err := sc2.GetChecksum(child.Pathname)
-			if err != nil {
-				continue
-			}
-			childEntry := &vfs.ChildEntry{
-				Lchecksum: childChecksum,
-				LfileInfo: child.FileInfo,
-			}
-			if child.FileInfo.Mode().IsDir() {
-				data, err := sc2.GetSummary(child.Pathname)
-				if err != nil {
-					continue
+			} else {
+				fileEntry = vfs.NewFileEntry(filepath.Dir(record.Pathname), &record)
+				if object != nil {
+					fileEntry.Object = object
 				}
 
-				childSummary, err := vfs.SummaryFromBytes(data)
-				if err != nil {
-					continue
+				classifications := cf.Processor(record.Pathname).File(fileEntry)
+				for _, result := range classifications {
+					fileEntry.AddClassification(result.Analyzer, result.Classes)
 				}
 
-				dirEntry.Summary.UpdateBelow(childSummary)
-				childEntry.Lsummary = childSummary
-			} else {
-				data, err := vfsCache.GetFileSummary(child.Pathname)
+				serialized, err := fileEntry.Serialize()
 				if err != nil {
-					continue
+					backupCtx.recordError(record.Pathname, err)
+					return
 				}
 
-				fileSummary, err := vfs.FileSummaryFromBytes(data)
+				fileEntryChecksum = snap.repository.Checksum(serialized)
+				err = snap.PutBlob(packfile.TYPE_FILE, fileEntryChecksum, serialized)
 				if err != nil {
-					continue
+					backupCtx.recordError(record.Pathname, err)
+					return
 				}
 
-				dirEntry.Summary.UpdateWithFileSummary(fileSummary)
-			}
+				// Store the newly generated FileEntry in the cache for future runs
+				err = vfsCache.PutFilename(record.Pathname, serialized)
+				if err != nil {
+					backupCtx.recordError(record.Pathname, err)
+					return
+				}
 
-			if lastChecksum != nil {
-				childEntry.Successor = lastChecksum
-			}
-			childEntrySerialized, err := childEntry.ToBytes()
-			if err != nil {
-				continue
-			}
-			childEntryChecksum := snap.repository.Checksum(childEntrySerialized)
-			lastChecksum = &childEntryChecksum
+				fileSummary := &vfs.FileSummary{
+					Type:    record.Type,
+					Size:    uint64(record.FileInfo.Size()),
+					Mode:    record.FileInfo.Mode(),
+					ModTime: record.FileInfo.ModTime().Unix(),
+				}
+				if object != nil {
+					fileSummary.Objects++
+					fileSummary.Chunks += uint64(len(object.Chunks))
+					fileSummary.ContentType = object.ContentType
+					fileSummary.Entropy = object.Entropy
+				}
 
-			if !snap.BlobExists(packfile.TYPE_CHILD, childEntryChecksum) {
-				if err := snap.PutBlob(packfile.TYPE_CHILD, childEntryChecksum, childEntrySerialized); err != nil {
-					continue
+				seralizedFileSummary, err := fileSummary.Serialize()
+				if err != nil {
+					backupCtx.recordError(record.Pathname, err)
+					return
 				}
-			}
-			dirEntry.Summary.Directory.Children++
-		}
-		dirEntry.Children = lastChecksum
 
-		iter, err := backupCtx.tree.ScanFrom(record.Pathname)
-		if err != nil {
-			return err
-		}
-		for iter.Next() {
-			_, errentry := iter.Current()
-			if !strings.HasPrefix(errentry.Name, record.Pathname) {
-				break
+				err = vfsCache.PutFileSummary(record.Pathname, seralizedFileSummary)
+				if err != nil {
+					backupCtx.recordError(record.Pathname, err)
+					return
+				}
 			}
-			dirEntry.Summary.Below.Errors++
-		}
-
-		dirEntry.Summary.UpdateAverages()
-
-		classifications := cf.Processor(record.Pathname).Directory(dirEntry)
-		for _, result := range classifications {
-			dirEntry.AddClassification(result.Analyzer, result.Classes)
-		}
-
-		serialized, err := dirEntry.Serialize()
-		if err != nil {
-			return err
-		}
-		dirEntryChecksum := snap.repository.Checksum(serialized)
 
-		if !snap.BlobExists(packfile.TYPE_DIRECTORY, dirEntryChecksum) {
-			err = snap.PutBlob(packfile.TYPE_DIRECTORY, dirEntryChecksum, serialized)
+			// Record the checksum of the FileEntry in the cache
+			err = sc2.PutChecksum(record.Pathname, fileEntryChecksum)
 			if err != nil {
 				backupCtx.recordError(record.Pathname, err)
-				return err
+				return
 			}
-		}
-		err = sc2.PutChecksum(record.Pathname, dirEntryChecksum)
-		if err != nil {
-			backupCtx.recordError(record.Pathname, err)
-			return err
-		}
-
-		serializedSummary, err := dirEntry.Summary.ToBytes()
-		if err != nil {
-			backupCtx.recordError(record.Pathname, err)
-			return err
-		}
-
-		err = sc2.PutSummary(record.Pathname, serializedSummary)
-		if err != nil {
-			backupCtx.recordError(record.Pathname, err)
-			return err
-		}
-
-		snap.Event(events.DirectoryOKEvent(snap.Header.Identifier, record.Pathname))
-		if record.Pathname == "/" {
-			rootSummary = &dirEntry.Summary
-		}
+			snap.Event(events.FileOKEvent(snap.Header.Identifier, record.Pathname))
+		}(_record)
 	}
+	scannerWg.Wait()
 
-	if backupCtx.aborted.Load() {
-		return backupCtx.abortedReason
-	}
+	return snap.Context().Err()
+}
 
-	value, err := sc2.GetChecksum("/")
-	if err != nil {
-		return err
+// objectExists checks that an object and all its chunks are stored, which
+// may not be the case for objects found in the checkpoint of an interrupted
+// backup as their chunks could have been in a packfile that was never
+// uploaded.
+func (snap *Snapshot) objectExists(object *objects.Object) bool {
+	if !snap.BlobExists(packfile.TYPE_OBJECT, object.Checksum) {
+		return false
 	}
-
-	snap.Header.Root = value
-	//snap.Header.Metadata = metadataChecksum
-	snap.Header.Duration = time.Since(beginTime)
-	snap.Header.Summary = *rootSummary
-	snap.Header.Errors = errcsum
-
-	/*
-		for _, key := range snap.Metadata.ListKeys() {
-			objectType := strings.Split(key, ";")[0]
-			objectKind := strings.Split(key, "/")[0]
-			if objectType == "" {
-				objectType = "unknown"
-				objectKind = "unknown"
-			}
-			if _, exists := snap.Header.FileKind[objectKind]; !exists {
-				snap.Header.FileKind[objectKind] = 0
-			}
-			snap.Header.FileKind[objectKind] += uint64(len(snap.Metadata.ListValues(key)))
-
-			if _, exists := snap.Header.FileType[objectType]; !exists {
-				snap.Header.FileType[objectType] = 0
-			}
-			snap.Header.FileType[objectType] += uint64(len(snap.Metadata.ListValues(key)))
-		}
-
-		for key, value := range snap.Header.FileType {
-			snap.Header.FilePercentType[key] = math.Round((float64(value)/float64(snap.Header.FilesCount)*100)*100) / 100
-		}
-		for key, value := range snap.Header.FileKind {
-			snap.Header.FilePercentKind[key] = math.Round((float64(value)/float64(snap.Header.FilesCount)*100)*100) / 100
-		}
-		for key, value := range snap.Header.FileExtension {
-			snap.Header.FilePercentExtension[key] = math.Round((float64(value)/float64(snap.Header.FilesCount)*100)*100) / 100
+	for _, chunk := range object.Chunks {
+		if !chunk.IsHole() && !snap.BlobExists(packfile.TYPE_CHUNK, chunk.Checksum) {
+			return false
 		}
-	*/
-	return snap.Commit()
+	}
+	return true
 }
 
 func entropy(data []byte) (float64, [256]float64) {
@@ -575,6 +874,12 @@ func (snap *Snapshot) chunkify(imp importer.Importer, cf *classifier.Classifier,
 	}
 	defer rd.Close()
 
+	// content read from a repository that would cut and hash it the same
+	// way is copied chunk by chunk
+	if src, ok := rd.(exporter.ObjectReader); ok && src.Object() != nil && snap.sameChunking(src.Repository()) {
+		return snap.copyObject(src.Repository(), src.Object())
+	}
+
 	cprocessor := cf.Processor(record.Pathname)
 
 	object := objects.NewObject()
@@ -592,6 +897,10 @@ func (snap *Snapshot) chunkify(imp importer.Importer, cf *classifier.Classifier,
 
 	// Helper function to process a chunk
 	processChunk := func(data []byte) error {
+		if err := snap.Context().Err(); err != nil {
+			return err
+		}
+
 		var chunk_t32 objects.Checksum
 		chunkHasher := snap.repository.Hasher()
 
@@ -628,60 +937,143 @@ func (snap *Snapshot) chunkify(imp importer.Importer, cf *classifier.Classifier,
 		return nil
 	}
 
-	if record.FileInfo.Size() == 0 {
-		// Produce an empty chunk for empty file
-		if err := processChunk([]byte{}); err != nil {
+	// holes are hashed into the object as zeros, so that its checksum is
+	// that of its content, but they are neither chunked nor stored.  This
+	// costs CPU time but no I/O, and keeps the checksum comparable with
+	// that of a file read back by restore -verify, sync and checksum.
+	processHole := func(length uint32) error {
+		if err := writeZeroes(objectHasher, int64(length)); err != nil {
+			return err
+		}
+		object.Chunks = append(object.Chunks, objects.NewHoleChunk(length))
+		cdcOffset += uint64(length)
+
+		totalFreq[0] += float64(length)
+		totalDataSize += uint64(length)
+		return nil
+	}
+
+	if err := snap.splitSparseChunks(rd, record.FileInfo.Size(), processChunk, processHole); err != nil {
+		return nil, err
+	}
+
+	if totalDataSize > 0 {
+		object.Entropy = totalEntropy / float64(totalDataSize)
+		for i := 0; i < 256; i++ {
+			totalFreq[i] /= float64(totalDataSize)
+		}
+	} else {
+		object.Entropy = 0.0
+		object.Distribution = [256]float64{}
+	}
+
+	copy(object_t32[:], objectHasher.Sum(nil))
+	object.Checksum = object_t32
+
+	classifications := cprocessor.Finalize()
+	for _, result := range classifications {
+		object.AddClassification(result.Analyzer, result.Classes)
+	}
+
+	return object, nil
+}
+
+// sameExtendedAttributes reports whether the extended attributes of an
+// entry are those of a scanned record.
+func sameExtendedAttributes(entries []vfs.ExtendedAttribute, attrs map[string][]byte) bool {
+	if len(entries) != len(attrs) {
+		return false
+	}
+	for _, entry := range entries {
+		value, exists := attrs[entry.Name]
+		if !exists || !bytes.Equal(value, entry.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameChunking reports whether repo shares the chunking and hashing
+// settings of the snapshot repository, so that its objects are valid as-is.
+func (snap *Snapshot) sameChunking(repo *repository.Repository) bool {
+	srcConfiguration := repo.Configuration()
+	dstConfiguration := snap.repository.Configuration()
+	return srcConfiguration.Hashing == dstConfiguration.Hashing && srcConfiguration.Chunking == dstConfiguration.Chunking
+}
+
+// copyObject stores the chunks of object, read from repo, that the snapshot
+// repository does not have yet.
+func (snap *Snapshot) copyObject(repo *repository.Repository, object *objects.Object) (*objects.Object, error) {
+	for _, chunk := range object.Chunks {
+		if err := snap.Context().Err(); err != nil {
 			return nil, err
 		}
-	} else if record.FileInfo.Size() < int64(snap.repository.Configuration().Chunking.MinSize) {
-		// Small file case: read entire file into memory
-		buf, err := io.ReadAll(rd)
+		if chunk.IsHole() || snap.BlobExists(packfile.TYPE_CHUNK, chunk.Checksum) {
+			continue
+		}
+
+		rd, err := repo.GetBlob(packfile.TYPE_CHUNK, chunk.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rd)
 		if err != nil {
 			return nil, err
 		}
-		if err := processChunk(buf); err != nil {
+		if err := snap.PutBlob(packfile.TYPE_CHUNK, chunk.Checksum, data); err != nil {
 			return nil, err
 		}
+	}
+	return object, nil
+}
+
+// splitChunks reads the content of a file of the given size and calls fn
+// for each of its chunks, as cut by the repository chunker.  A negative size
+// is used for streams whose size is unknown.
+func (snap *Snapshot) splitChunks(rd io.ReadCloser, size int64, fn func(data []byte) error) error {
+	if size == 0 {
+		// Produce an empty chunk for empty file
+		if err := fn([]byte{}); err != nil {
+			return err
+		}
+	} else if size > 0 && size < int64(snap.repository.Configuration().Chunking.MinSize) {
+		// Small file case: read entire file into memory
+		buf, err := io.ReadAll(rd)
+		if err != nil {
+			return err
+		}
+		if err := fn(buf); err != nil {
+			return err
+		}
 	} else {
 		// Large file case: chunk file with chunker
 		chk, err := snap.repository.Chunker(rd)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		chunks := 0
 		for {
 			cdcChunk, err := chk.Next()
 			if err != nil && err != io.EOF {
-				return nil, err
+				return err
 			}
 			if cdcChunk == nil {
 				break
 			}
-			if err := processChunk(cdcChunk); err != nil {
-				return nil, err
+			chunks++
+			if err := fn(cdcChunk); err != nil {
+				return err
 			}
 			if err == io.EOF {
 				break
 			}
 		}
-	}
-
-	if totalDataSize > 0 {
-		object.Entropy = totalEntropy / float64(totalDataSize)
-		for i := 0; i < 256; i++ {
-			totalFreq[i] /= float64(totalDataSize)
+		if chunks == 0 {
+			// An empty stream is stored as an empty file
+			if err := fn([]byte{}); err != nil {
+				return err
+			}
 		}
-	} else {
-		object.Entropy = 0.0
-		object.Distribution = [256]float64{}
-	}
-
-	copy(object_t32[:], objectHasher.Sum(nil))
-	object.Checksum = object_t32
-
-	classifications := cprocessor.Finalize()
-	for _, result := range classifications {
-		object.AddClassification(result.Analyzer, result.Classes)
 	}
-
-	return object, nil
+	return nil
 }
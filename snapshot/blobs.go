@@ -1,7 +1,6 @@
 package snapshot
 
 import (
-	"bytes"
 	"io"
 	"time"
 
@@ -11,17 +10,12 @@ import (
 func (snap *Snapshot) PutBlob(Type packfile.Type, checksum [32]byte, data []byte) error {
 	snap.Logger().Trace("snapshot", "%x: PutBlob(%d, %064x) len=%d", snap.Header.GetIndexShortID(), Type, checksum, len(data))
 
-	encodedReader, err := snap.repository.Encode(bytes.NewReader(data))
+	encoded, flags, err := snap.repository.EncodeBlob(data)
 	if err != nil {
 		return err
 	}
 
-	encoded, err := io.ReadAll(encodedReader)
-	if err != nil {
-		return err
-	}
-
-	snap.packerChan <- &PackerMsg{Type: Type, Timestamp: time.Now(), Checksum: checksum, Data: encoded}
+	snap.packerChan <- &PackerMsg{Type: Type, Timestamp: time.Now(), Checksum: checksum, Data: encoded, Flags: flags}
 	return nil
 }
 
@@ -0,0 +1,110 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/packfile"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+)
+
+func TestCancelBackup(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "hello"})
+
+	snap, err := New(repo)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	repo.Context().Cancel()
+	if err := snap.Backup(dir, &BackupOptions{MaxConcurrency: 4}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the backup to be cancelled, got %v", err)
+	}
+
+	repo = testutil.ReopenRepository(t, repo)
+	if snapshots, err := repo.GetSnapshots(); err != nil || len(snapshots) != 0 {
+		t.Errorf("Expected no snapshot to be committed, got %d: %v", len(snapshots), err)
+	}
+}
+
+func TestCancelBackupDropsPackfiles(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "uploaded before the interrupt", "b.txt": "interrupted"})
+	interruptBackup(t, repo, dir, filepath.Join(dir, "b.txt"), &BackupOptions{})
+
+	// without a checkpoint, no state would reference the pending packfiles
+	repo = testutil.ReopenRepository(t, repo)
+	if packfiles, err := repo.GetPackfiles(); err != nil || len(packfiles) != 0 {
+		t.Errorf("Expected no packfile to be written, got %d: %v", len(packfiles), err)
+	}
+}
+
+func TestCancelBackupWritesPending(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "uploaded before the interrupt", "b.txt": "interrupted"})
+	interruptBackup(t, repo, dir, filepath.Join(dir, "b.txt"), &BackupOptions{CheckpointInterval: time.Hour})
+
+	// the pending packfiles are written despite the cancelled context, the
+	// checkpoint makes them visible to the next run
+	repo = testutil.ReopenRepository(t, repo)
+	chunk := repo.Checksum([]byte("uploaded before the interrupt"))
+	rd, err := repo.GetBlob(packfile.TYPE_CHUNK, chunk)
+	if err != nil {
+		t.Fatalf("Expected the chunk read before the interrupt to be stored: %v", err)
+	}
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatalf("Failed to read chunk: %v", err)
+	}
+	if string(data) != "uploaded before the interrupt" {
+		t.Errorf("Unexpected content of the stored chunk: %q", data)
+	}
+}
+
+func TestCancelRestore(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "hello", "sub/b.txt": "world"})
+	snap := backupTree(t, repo, src, nil)
+
+	dst := t.TempDir()
+	exp, err := exporter.NewExporter(dst)
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+	defer exp.Close()
+
+	repo.Context().Cancel()
+	if _, err := snap.Restore(exp, exp.Root(), src, &RestoreOptions{MaxConcurrency: 4}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the restore to be cancelled, got %v", err)
+	}
+	if entries, err := os.ReadDir(dst); err != nil || len(entries) != 0 {
+		t.Errorf("Expected nothing to be restored, got %d entries: %v", len(entries), err)
+	}
+}
+
+func TestCancelCheck(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "hello", "sub/b.txt": "world"})
+	snap := backupTree(t, repo, src, nil)
+
+	repo.Context().Cancel()
+	if ok, err := snap.Check("/", &CheckOptions{MaxConcurrency: 4}); ok || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the check to be cancelled, got %v: %v", ok, err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/PlakarKorp/plakar/events"
+	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/packfile"
 	"github.com/PlakarKorp/plakar/snapshot/vfs"
 )
@@ -17,6 +18,10 @@ type CheckOptions struct {
 }
 
 func snapshotCheckPath(snap *Snapshot, fs *vfs.Filesystem, pathname string, opts *CheckOptions, concurrency chan bool, wg *sync.WaitGroup) (bool, error) {
+	if err := snap.Context().Err(); err != nil {
+		return false, err
+	}
+
 	snap.Event(events.PathEvent(snap.Header.Identifier, pathname))
 	fsinfo, err := fs.Stat(pathname)
 	if err != nil {
@@ -33,6 +38,9 @@ func snapshotCheckPath(snap *Snapshot, fs *vfs.Filesystem, pathname string, opts
 			return false, err
 		}
 		for child := range children {
+			if snap.Context().Err() != nil {
+				return false, snap.Context().Err()
+			}
 			ok, err := snapshotCheckPath(snap, fs, path.Join(pathname, child.Stat().Name()), opts, concurrency, wg)
 			if err != nil || !ok {
 				complete = false
@@ -54,6 +62,10 @@ func snapshotCheckPath(snap *Snapshot, fs *vfs.Filesystem, pathname string, opts
 				defer wg.Done()
 				defer func() { <-concurrency }()
 
+				if snap.Context().Err() != nil {
+					return
+				}
+
 				object, err := snap.LookupObject(_fileEntry.Object.Checksum)
 				if err != nil {
 					snap.Event(events.ObjectMissingEvent(snap.Header.Identifier, _fileEntry.Object.Checksum))
@@ -63,9 +75,12 @@ func snapshotCheckPath(snap *Snapshot, fs *vfs.Filesystem, pathname string, opts
 				hasher := snap.repository.Hasher()
 				snap.Event(events.ObjectEvent(snap.Header.Identifier, object.Checksum))
 				complete := true
-				for _, chunk := range object.Chunks {
-					snap.Event(events.ChunkEvent(snap.Header.Identifier, chunk.Checksum))
-					if opts.FastCheck {
+				if opts.FastCheck {
+					for _, chunk := range object.Chunks {
+						if chunk.IsHole() {
+							continue
+						}
+						snap.Event(events.ChunkEvent(snap.Header.Identifier, chunk.Checksum))
 						exists := snap.BlobExists(packfile.TYPE_CHUNK, chunk.Checksum)
 						if !exists {
 							snap.Event(events.ChunkMissingEvent(snap.Header.Identifier, chunk.Checksum))
@@ -73,14 +88,22 @@ func snapshotCheckPath(snap *Snapshot, fs *vfs.Filesystem, pathname string, opts
 							break
 						}
 						snap.Event(events.ChunkOKEvent(snap.Header.Identifier, chunk.Checksum))
-					} else {
-						exists := snap.BlobExists(packfile.TYPE_CHUNK, chunk.Checksum)
-						if !exists {
-							snap.Event(events.ChunkMissingEvent(snap.Header.Identifier, chunk.Checksum))
-							complete = false
-							break
+					}
+				} else {
+					checksums := make([]objects.Checksum, 0, len(object.Chunks))
+					for _, chunk := range object.Chunks {
+						if !chunk.IsHole() {
+							checksums = append(checksums, chunk.Checksum)
+						}
+					}
+					stream := snap.repository.ReadBlobs(packfile.TYPE_CHUNK, checksums, nil)
+					for _, chunk := range object.Chunks {
+						if chunk.IsHole() {
+							writeZeroes(hasher, int64(chunk.Length))
+							continue
 						}
-						data, err := snap.GetBlob(packfile.TYPE_CHUNK, chunk.Checksum)
+						snap.Event(events.ChunkEvent(snap.Header.Identifier, chunk.Checksum))
+						data, err := stream.Next()
 						if err != nil {
 							snap.Event(events.ChunkMissingEvent(snap.Header.Identifier, chunk.Checksum))
 							complete = false
@@ -97,6 +120,7 @@ func snapshotCheckPath(snap *Snapshot, fs *vfs.Filesystem, pathname string, opts
 							break
 						}
 					}
+					stream.Close()
 				}
 				if !complete {
 					snap.Event(events.ObjectCorruptedEvent(snap.Header.Identifier, object.Checksum))
@@ -134,8 +158,12 @@ func (snap *Snapshot) Check(pathname string, opts *CheckOptions) (bool, error) {
 
 	maxConcurrencyChan := make(chan bool, maxConcurrency)
 	wg := sync.WaitGroup{}
-	defer wg.Wait()
-	defer close(maxConcurrencyChan)
 
-	return snapshotCheckPath(snap, fs, pathname, opts, maxConcurrencyChan, &wg)
+	ok, err := snapshotCheckPath(snap, fs, pathname, opts, maxConcurrencyChan, &wg)
+	wg.Wait()
+	close(maxConcurrencyChan)
+	if ctxErr := snap.Context().Err(); ctxErr != nil {
+		return false, ctxErr
+	}
+	return ok, err
 }
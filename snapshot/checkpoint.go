@@ -0,0 +1,185 @@
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/repository/state"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// checkpoint marks a backup in progress, it is kept in the local cache and
+// points to a state listing the packfiles uploaded so far so that they are
+// not orphaned if the backup is interrupted.
+type checkpoint struct {
+	SnapshotID objects.Checksum `msgpack:"snapshot_id"`
+	StateID    objects.Checksum `msgpack:"state_id"`
+	Timestamp  time.Time        `msgpack:"timestamp"`
+}
+
+func checkpointSource(imp importer.Importer, directory string) string {
+	return fmt.Sprintf("%s://%s%s", imp.Type(), imp.Origin(), directory)
+}
+
+func (snap *Snapshot) getCheckpoint(source string) (*checkpoint, error) {
+	cache, err := snap.Context().GetCache().Repository(snap.repository.Configuration().RepositoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := cache.GetCheckpoint(source)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var cp checkpoint
+	if err := msgpack.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// writeCheckpoint persists the state delta accumulated so far and moves the
+// checkpoint of source to it, the previous checkpoint state is a subset of
+// the new one and is deleted.
+func (snap *Snapshot) writeCheckpoint(source string) error {
+	if !snap.stateDelta.Dirty() {
+		return nil
+	}
+	// reset before serializing so that blobs added meanwhile are caught
+	// by the next checkpoint
+	snap.stateDelta.ResetDirty()
+
+	var buffer bytes.Buffer
+	if err := snap.stateDelta.SerializeStream(&buffer); err != nil {
+		return err
+	}
+	stateID := snap.repository.Checksum(buffer.Bytes())
+	if err := snap.repository.PutState(stateID, &buffer); err != nil {
+		return err
+	}
+
+	previous, err := snap.getCheckpoint(source)
+	if err != nil {
+		return err
+	}
+
+	data, err := msgpack.Marshal(&checkpoint{
+		SnapshotID: snap.Header.Identifier,
+		StateID:    stateID,
+		Timestamp:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	cache, err := snap.Context().GetCache().Repository(snap.repository.Configuration().RepositoryID)
+	if err != nil {
+		return err
+	}
+	if err := cache.PutCheckpoint(source, data); err != nil {
+		return err
+	}
+
+	if previous != nil && previous.StateID != stateID {
+		if err := snap.repository.DeleteState(previous.StateID); err != nil {
+			return err
+		}
+	}
+
+	snap.Logger().Trace("snapshot", "%x: checkpoint(%s): state %x", snap.Header.GetIndexShortID(), source, stateID)
+	return nil
+}
+
+// checkpointJob writes a checkpoint every interval until done is closed.
+func (snap *Snapshot) checkpointJob(source string, interval time.Duration) func() {
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := snap.writeCheckpoint(source); err != nil {
+					snap.Logger().Warn("could not checkpoint backup: %s", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// resumeCheckpoint looks for the checkpoint of an interrupted backup of
+// source. Unless discard is set, the blobs it references are added to the
+// state delta so that the new snapshot reuses them, otherwise the
+// checkpoint state is deleted and the repository state rebuilt without it.
+func (snap *Snapshot) resumeCheckpoint(source string, discard bool) error {
+	cp, err := snap.getCheckpoint(source)
+	if err != nil || cp == nil {
+		return err
+	}
+
+	if discard {
+		snap.Logger().Info("discarding checkpoint of interrupted backup %x", cp.SnapshotID[:4])
+		return snap.clearCheckpoint(source)
+	}
+
+	rd, err := snap.repository.GetState(cp.StateID)
+	if err != nil {
+		snap.Logger().Warn("could not resume interrupted backup %x: %s", cp.SnapshotID[:4], err)
+		return snap.clearCheckpoint(source)
+	}
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	checkpointState, err := state.DeserializeStream(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	snap.Logger().Info("resuming interrupted backup %x from checkpoint of %s", cp.SnapshotID[:4], cp.Timestamp.UTC().Format(time.RFC3339))
+	snap.stateDelta.Merge(cp.StateID, checkpointState)
+	return nil
+}
+
+// clearCheckpoint deletes the checkpoint of source, it is called once the
+// snapshot is committed as its state is a superset of the checkpoint state.
+func (snap *Snapshot) clearCheckpoint(source string) error {
+	cp, err := snap.getCheckpoint(source)
+	if err != nil || cp == nil {
+		return err
+	}
+
+	// the checkpoint state may already be gone, as when a previous
+	// discard was interrupted
+	if err := snap.repository.DeleteState(cp.StateID); err != nil {
+		snap.Logger().Trace("snapshot", "%x: clearCheckpoint(%s): %s", snap.Header.GetIndexShortID(), source, err)
+	}
+
+	cache, err := snap.Context().GetCache().Repository(snap.repository.Configuration().RepositoryID)
+	if err != nil {
+		return err
+	}
+	if err := cache.DelCheckpoint(source); err != nil {
+		return err
+	}
+	return snap.repository.RebuildState()
+}
@@ -0,0 +1,262 @@
+package snapshot
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/context"
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/packfile"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/repository/state"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+)
+
+// interruptingImporter cancels the backup reading from it when it is asked
+// for interrupt, once the other files it was asked for are read.
+type interruptingImporter struct {
+	importer.Importer
+	ctx       *context.Context
+	interrupt string
+	pending   sync.WaitGroup
+}
+
+type doneReader struct {
+	io.ReadCloser
+	done func()
+}
+
+func (rd *doneReader) Close() error {
+	defer rd.done()
+	return rd.ReadCloser.Close()
+}
+
+func (imp *interruptingImporter) NewReader(pathname string) (io.ReadCloser, error) {
+	if pathname == imp.interrupt {
+		imp.pending.Wait()
+		imp.ctx.Cancel()
+		return nil, imp.ctx.Err()
+	}
+	rd, err := imp.Importer.NewReader(pathname)
+	if err != nil {
+		imp.pending.Done()
+		return nil, err
+	}
+	return &doneReader{ReadCloser: rd, done: imp.pending.Done}, nil
+}
+
+// interruptBackup backs up dir to repo and cancels the context of repo when
+// the backup reads interrupt, the other files of dir being backed up.
+func interruptBackup(t *testing.T, repo *repository.Repository, dir string, interrupt string, options *BackupOptions) *Snapshot {
+	t.Helper()
+
+	imp, err := importer.NewImporter(dir)
+	if err != nil {
+		t.Fatalf("Failed to create importer: %v", err)
+	}
+	defer imp.Close()
+
+	wrapper := &interruptingImporter{Importer: imp, ctx: repo.Context(), interrupt: interrupt}
+	err = filepath.Walk(dir, func(pathname string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() && pathname != interrupt {
+			wrapper.pending.Add(1)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk %s: %v", dir, err)
+	}
+
+	snap, err := New(repo)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if options.MaxConcurrency == 0 {
+		options.MaxConcurrency = 4
+	}
+	if err := snap.BackupImporter(wrapper, options); err == nil || repo.Context().Err() == nil {
+		t.Fatalf("Expected the backup to be interrupted, got %v", err)
+	}
+	return snap
+}
+
+// checkpointOf returns the checkpoint of the backups of dir and its state.
+func checkpointOf(t *testing.T, snap *Snapshot, dir string) (*checkpoint, *state.State) {
+	t.Helper()
+
+	imp, err := importer.NewImporter(dir)
+	if err != nil {
+		t.Fatalf("Failed to create importer: %v", err)
+	}
+	defer imp.Close()
+
+	cp, err := snap.getCheckpoint(checkpointSource(imp, dir))
+	if err != nil {
+		t.Fatalf("Failed to get checkpoint: %v", err)
+	}
+	if cp == nil {
+		return nil, nil
+	}
+
+	return cp, loadState(t, snap.repository, cp.StateID)
+}
+
+// loadState returns the state stateID of repo.
+func loadState(t *testing.T, repo *repository.Repository, stateID objects.Checksum) *state.State {
+	t.Helper()
+
+	rd, err := repo.GetState(stateID)
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	delta, err := state.DeserializeStream(rd)
+	if err != nil {
+		t.Fatalf("Failed to deserialize state: %v", err)
+	}
+	st := state.New()
+	st.Merge(stateID, delta)
+	return st
+}
+
+// chunkPackfile returns the packfile holding chunk in st.
+func chunkPackfile(t *testing.T, st *state.State, chunk objects.Checksum) objects.Checksum {
+	t.Helper()
+
+	packfileChecksum, _, _, exists := st.GetSubpartForBlob(packfile.TYPE_CHUNK, chunk)
+	if !exists {
+		t.Fatalf("Expected chunk %x to be in the state", chunk[:4])
+	}
+	return packfileChecksum
+}
+
+func TestCheckpointResume(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "uploaded before the interrupt", "b.txt": "interrupted"})
+	chunk := repo.Checksum([]byte("uploaded before the interrupt"))
+
+	interrupted := interruptBackup(t, repo, dir, filepath.Join(dir, "b.txt"), &BackupOptions{CheckpointInterval: time.Hour})
+	cp, cpState := checkpointOf(t, interrupted, dir)
+	if cp == nil {
+		t.Fatalf("Expected the interrupted backup to leave a checkpoint")
+	}
+	if cp.SnapshotID != interrupted.Header.Identifier {
+		t.Errorf("Expected the checkpoint of snapshot %x, got %x", interrupted.Header.Identifier[:4], cp.SnapshotID[:4])
+	}
+	uploaded := chunkPackfile(t, cpState, chunk)
+
+	repo = testutil.ReopenRepository(t, repo)
+	snap, err := New(repo)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if err := snap.BackupSources([]string{dir}, &BackupOptions{MaxConcurrency: 4, CheckpointInterval: time.Hour}); err != nil {
+		t.Fatalf("Failed to back up %s: %v", dir, err)
+	}
+	if packfileChecksum := chunkPackfile(t, loadState(t, repo, snap.Header.Identifier), chunk); packfileChecksum != uploaded {
+		t.Errorf("Expected the chunk uploaded before the interrupt to be reused, found it in %x instead of %x", packfileChecksum[:4], uploaded[:4])
+	}
+	if cp, _ := checkpointOf(t, snap, dir); cp != nil {
+		t.Errorf("Expected the checkpoint to be cleared once the backup is committed")
+	}
+
+	loaded, err := Load(repo, snap.Header.Identifier)
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	dst := t.TempDir()
+	restoreTree(t, loaded, dst, dir, &RestoreOptions{Rebase: true})
+	for name, expected := range map[string]string{"a.txt": "uploaded before the interrupt", "b.txt": "interrupted"} {
+		if content := readFile(t, filepath.Join(dst, name)); content != expected {
+			t.Errorf("Expected %s to hold %q, got %q", name, expected, content)
+		}
+	}
+}
+
+func TestCheckpointDiscard(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "uploaded before the interrupt", "b.txt": "interrupted"})
+	chunk := repo.Checksum([]byte("uploaded before the interrupt"))
+
+	interrupted := interruptBackup(t, repo, dir, filepath.Join(dir, "b.txt"), &BackupOptions{CheckpointInterval: time.Hour})
+	cp, cpState := checkpointOf(t, interrupted, dir)
+	if cp == nil {
+		t.Fatalf("Expected the interrupted backup to leave a checkpoint")
+	}
+	uploaded := chunkPackfile(t, cpState, chunk)
+
+	repo = testutil.ReopenRepository(t, repo)
+	snap, err := New(repo)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if err := snap.BackupSources([]string{dir}, &BackupOptions{MaxConcurrency: 4, DiscardCheckpoint: true}); err != nil {
+		t.Fatalf("Failed to back up %s: %v", dir, err)
+	}
+	if packfileChecksum := chunkPackfile(t, loadState(t, repo, snap.Header.Identifier), chunk); packfileChecksum == uploaded {
+		t.Errorf("Expected the chunk of the discarded checkpoint to be uploaded again")
+	}
+	if cp, _ := checkpointOf(t, snap, dir); cp != nil {
+		t.Errorf("Expected the checkpoint to be discarded")
+	}
+	if _, err := repo.GetState(cp.StateID); err == nil {
+		t.Errorf("Expected the state of the discarded checkpoint to be deleted")
+	}
+}
+
+func TestCheckpointNotWritten(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "uploaded before the interrupt", "b.txt": "interrupted"})
+
+	interrupted := interruptBackup(t, repo, dir, filepath.Join(dir, "b.txt"), &BackupOptions{})
+	if cp, _ := checkpointOf(t, interrupted, dir); cp != nil {
+		t.Errorf("Expected no checkpoint without a checkpoint interval")
+	}
+}
+
+func TestObjectExists(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "hello"})
+	snap := backupTree(t, repo, dir, nil)
+
+	object := fileObject(t, snap, filepath.Join(dir, "a.txt"))
+	if !snap.objectExists(object) {
+		t.Fatalf("Expected the object of a backed up file to exist")
+	}
+
+	missing := repo.Checksum([]byte("never uploaded"))
+	for _, test := range []struct {
+		name     string
+		change   func(*objects.Object)
+		expected bool
+	}{
+		{"missing chunk", func(object *objects.Object) {
+			object.Chunks = append(object.Chunks, objects.Chunk{Checksum: missing, Length: 14})
+		}, false},
+		{"hole", func(object *objects.Object) {
+			object.Chunks = append(object.Chunks, objects.Chunk{Checksum: missing, Length: 14, Flags: objects.CHUNK_FLAG_HOLE})
+		}, true},
+		{"missing object", func(object *objects.Object) {
+			object.Checksum = missing
+		}, false},
+	} {
+		changed := *object
+		changed.Chunks = append([]objects.Chunk(nil), object.Chunks...)
+		test.change(&changed)
+		if exists := snap.objectExists(&changed); exists != test.expected {
+			t.Errorf("Expected objectExists to be %v with a %s, got %v", test.expected, test.name, exists)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package snapshot
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+)
+
+// reported returns the reason given for pathname in entries.
+func reported(entries []RestoreEntry, pathname string) (string, bool) {
+	for _, entry := range entries {
+		if entry.Pathname == pathname {
+			return entry.Reason, true
+		}
+	}
+	return "", false
+}
+
+func readFile(t *testing.T, pathname string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(pathname)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", pathname, err)
+	}
+	return string(content)
+}
+
+func TestRestoreConflictSkipNewer(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "snapshot a", "b.txt": "snapshot b"})
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "b.txt"), past, past); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+	snap := backupTree(t, repo, src, nil)
+
+	dst := t.TempDir()
+	writeTree(t, dst, map[string]string{"a.txt": "local a", "b.txt": "local b"})
+	report := restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true, Conflict: ConflictSkip})
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if content := readFile(t, filepath.Join(dst, name)); content != "local "+name[:1] {
+			t.Errorf("Expected %s to be left alone, got %q", name, content)
+		}
+		if _, ok := reported(report.Skipped, filepath.Join(src, name)); !ok {
+			t.Errorf("Expected %s to be reported as skipped", name)
+		}
+	}
+
+	// a.txt in the snapshot is newer than the local copy, b.txt is older
+	older := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dst, "a.txt"), older, older); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+	report = restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true, Conflict: ConflictNewer})
+	if content := readFile(t, filepath.Join(dst, "a.txt")); content != "snapshot a" {
+		t.Errorf("Expected the older a.txt to be replaced, got %q", content)
+	}
+	if content := readFile(t, filepath.Join(dst, "b.txt")); content != "local b" {
+		t.Errorf("Expected the newer b.txt to be left alone, got %q", content)
+	}
+	if _, ok := reported(report.Skipped, filepath.Join(src, "b.txt")); !ok {
+		t.Errorf("Expected b.txt to be reported as skipped")
+	}
+}
+
+func TestRestoreConflictOverwrite(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "snapshot a", "b.txt": "snapshot b"})
+	snap := backupTree(t, repo, src, nil)
+
+	dst := t.TempDir()
+	writeTree(t, dst, map[string]string{"a.txt": "local a", "b.txt/keep": "precious"})
+	report := restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true})
+	if content := readFile(t, filepath.Join(dst, "a.txt")); content != "snapshot a" {
+		t.Errorf("Expected a.txt to be overwritten, got %q", content)
+	}
+	if reason, ok := reported(report.Failed, filepath.Join(src, "b.txt")); !ok || reason != errRestoreDirectory.Error() {
+		t.Errorf("Expected b.txt to fail on the directory in the way, got %q", reason)
+	}
+	if content := readFile(t, filepath.Join(dst, "b.txt/keep")); content != "precious" {
+		t.Errorf("Expected the directory in the way to be kept, got %q", content)
+	}
+
+	report = restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true, ReplaceDirectories: true})
+	if len(report.Failed) != 0 {
+		t.Fatalf("Expected no failures, got %v", report.Failed)
+	}
+	if content := readFile(t, filepath.Join(dst, "b.txt")); content != "snapshot b" {
+		t.Errorf("Expected the directory to be replaced by b.txt, got %q", content)
+	}
+}
+
+func TestRestoreConflictSync(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"same.txt":    "unchanged content",
+		"changed.txt": "snapshot content",
+		"linked.txt":  "snapshot linked",
+	})
+	snap := backupTree(t, repo, src, nil)
+
+	dst := t.TempDir()
+	restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true})
+
+	// same size, other content and mtime
+	writeTree(t, dst, map[string]string{
+		"changed.txt": "locally modified",
+		"linked.txt":  "locally  linked",
+		"extra.txt":   "not in snapshot",
+	})
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	if err := os.Link(filepath.Join(dst, "linked.txt"), outside); err != nil {
+		t.Fatalf("Failed to create hardlink: %v", err)
+	}
+
+	report := restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true, Conflict: ConflictSync})
+	if len(report.Failed) != 0 {
+		t.Fatalf("Expected no failures, got %v", report.Failed)
+	}
+	for name, content := range map[string]string{
+		"same.txt":    "unchanged content",
+		"changed.txt": "snapshot content",
+		"linked.txt":  "snapshot linked",
+	} {
+		if got := readFile(t, filepath.Join(dst, name)); got != content {
+			t.Errorf("Expected %s to hold %q, got %q", name, content, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "extra.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected extra.txt to be removed")
+	}
+	if got := readFile(t, outside); got != "locally  linked" {
+		t.Errorf("Expected the other link of linked.txt to be left alone, got %q", got)
+	}
+
+	if reason, ok := reported(report.Skipped, filepath.Join(src, "same.txt")); !ok || reason != "unchanged" {
+		t.Errorf("Expected same.txt to be skipped as unchanged, got %q", reason)
+	}
+	for _, name := range []string{"changed.txt", "linked.txt"} {
+		if _, ok := reported(report.Restored, filepath.Join(src, name)); !ok {
+			t.Errorf("Expected %s to be reported as restored", name)
+		}
+	}
+}
+
+// storeExporter is an exporter unable to update files in place, it counts
+// the files it stores.
+type storeExporter struct {
+	exporter.Exporter
+	stored int
+}
+
+func (p *storeExporter) OpenFile(pathname string) (exporter.File, error) {
+	return nil, exporter.ErrNotSupported
+}
+
+func (p *storeExporter) StoreFile(pathname string, fp io.Reader) error {
+	p.stored++
+	return p.Exporter.StoreFile(pathname, fp)
+}
+
+func TestRestoreConflictSyncStore(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"same.txt": "unchanged content", "changed.txt": "snapshot content"})
+	snap := backupTree(t, repo, src, nil)
+
+	dst := t.TempDir()
+	restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true})
+	writeTree(t, dst, map[string]string{"changed.txt": "locally modified"})
+	// stores do not keep mtimes, only the checksum tells the files apart
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(dst, "same.txt"), now, now); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+
+	fsExp, err := exporter.NewExporter(dst)
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+	defer fsExp.Close()
+	exp := &storeExporter{Exporter: fsExp}
+
+	// MaxConcurrency 1 keeps the counter to a single goroutine
+	report, err := snap.Restore(exp, exp.Root(), src, &RestoreOptions{Rebase: true, Conflict: ConflictSync, MaxConcurrency: 1})
+	if err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if exp.stored != 1 {
+		t.Errorf("Expected only changed.txt to be stored, %d files were", exp.stored)
+	}
+	if content := readFile(t, filepath.Join(dst, "changed.txt")); content != "snapshot content" {
+		t.Errorf("Expected changed.txt to be restored, got %q", content)
+	}
+	if reason, ok := reported(report.Skipped, filepath.Join(src, "same.txt")); !ok || reason != "unchanged" {
+		t.Errorf("Expected same.txt to be skipped as unchanged, got %q", reason)
+	}
+}
@@ -0,0 +1,229 @@
+package snapshot
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/packfile"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+	"github.com/gobwas/glob"
+)
+
+type DryRunEntry struct {
+	Pathname string `json:"pathname"`
+	NewSize  uint64 `json:"new_size"`
+}
+
+// DryRunReport estimates what a backup would add to the repository, sizes
+// are in bytes and new sizes only count chunks not already stored nor seen
+// earlier in the same run.
+type DryRunReport struct {
+	Files              uint64        `json:"files"`
+	Directories        uint64        `json:"directories"`
+	Errors             uint64        `json:"errors"`
+	Size               uint64        `json:"size"`
+	Chunks             uint64        `json:"chunks"`
+	NewChunks          uint64        `json:"new_chunks"`
+	NewSize            uint64        `json:"new_size"`
+	EncodedSize        uint64        `json:"encoded_size"`
+	LargestFiles       []DryRunEntry `json:"largest_files"`
+	LargestDirectories []DryRunEntry `json:"largest_directories"`
+}
+
+type dryRunContext struct {
+	mu          sync.Mutex
+	report      *DryRunReport
+	seen        map[objects.Checksum]struct{}
+	files       []DryRunEntry
+	directories map[string]uint64
+}
+
+// newChunk accounts for a chunk and reports whether it would be uploaded.
+func (dc *dryRunContext) newChunk(snap *Snapshot, checksum objects.Checksum) bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.report.Chunks++
+	if _, exists := dc.seen[checksum]; exists {
+		return false
+	}
+	dc.seen[checksum] = struct{}{}
+	if snap.repository.BlobExists(packfile.TYPE_CHUNK, checksum) {
+		return false
+	}
+	dc.report.NewChunks++
+	return true
+}
+
+// DryRun scans and chunks scanDirs as Backup would, checking every chunk
+// against the repository without writing anything. The top largest new
+// files and directories are reported.
+func (snap *Snapshot) DryRun(scanDirs []string, options *BackupOptions, top int) (*DryRunReport, error) {
+	// nothing is packed, the packer started with the snapshot is stopped
+	defer snap.closePacker()
+
+	excludes, err := compileExcludes(options.Excludes)
+	if err != nil {
+		return nil, err
+	}
+
+	maxConcurrency := options.MaxConcurrency
+	if maxConcurrency == 0 {
+		maxConcurrency = uint64(snap.Context().GetMaxConcurrency())
+	}
+	concurrency := make(chan bool, maxConcurrency)
+
+	dc := &dryRunContext{
+		report:      &DryRunReport{},
+		seen:        make(map[objects.Checksum]struct{}),
+		directories: make(map[string]uint64),
+	}
+
+	for _, scanDir := range scanDirs {
+		if err := snap.dryRun(scanDir, options, excludes, concurrency, dc); err != nil {
+			return nil, err
+		}
+	}
+
+	directories := make([]DryRunEntry, 0, len(dc.directories))
+	for pathname, newSize := range dc.directories {
+		directories = append(directories, DryRunEntry{Pathname: pathname, NewSize: newSize})
+	}
+	dc.report.LargestFiles = largestEntries(dc.files, top)
+	dc.report.LargestDirectories = largestEntries(directories, top)
+
+	return dc.report, nil
+}
+
+func (snap *Snapshot) dryRun(scanDir string, options *BackupOptions, excludes []glob.Glob, concurrency chan bool, dc *dryRunContext) error {
+	imp, err := importer.NewImporter(scanDir)
+	if err != nil {
+		return err
+	}
+	defer imp.Close()
+
+	scanner, err := imp.Scan(snap.Context(), options.scanOptions())
+	if err != nil {
+		return err
+	}
+
+	root := imp.Root()
+	prefix := root
+	if root != "/" {
+		prefix += "/"
+	}
+
+	wg := sync.WaitGroup{}
+	for _record := range scanner {
+		if snap.Context().Err() != nil || snap.skipExcludedPathname(excludes, _record) || options.skipFilteredRecord(_record) {
+			continue
+		}
+
+		switch record := _record.(type) {
+		case importer.ScanError:
+			dc.mu.Lock()
+			dc.report.Errors++
+			dc.mu.Unlock()
+			snap.Logger().Warn("%s: %s", record.Pathname, record.Err)
+
+		case importer.ScanRecord:
+			if record.FileInfo.Mode().IsDir() {
+				// parents of the scanned directory are not accounted for
+				if record.Pathname != root && !strings.HasPrefix(record.Pathname, prefix) {
+					continue
+				}
+				dc.mu.Lock()
+				dc.report.Directories++
+				dc.mu.Unlock()
+				continue
+			}
+			if !record.FileInfo.Mode().IsRegular() {
+				continue
+			}
+
+			concurrency <- true
+			wg.Add(1)
+			go func(record importer.ScanRecord) {
+				defer func() {
+					<-concurrency
+					wg.Done()
+				}()
+
+				size, newSize, encodedSize, err := snap.dryRunFile(imp, dc, record)
+				dc.mu.Lock()
+				defer dc.mu.Unlock()
+				if err != nil {
+					dc.report.Errors++
+					snap.Logger().Warn("%s: %s", record.Pathname, err)
+					return
+				}
+
+				dc.report.Files++
+				dc.report.Size += size
+				dc.report.NewSize += newSize
+				dc.report.EncodedSize += encodedSize
+				if newSize == 0 {
+					return
+				}
+				dc.files = append(dc.files, DryRunEntry{Pathname: record.Pathname, NewSize: newSize})
+				for dir := path.Dir(record.Pathname); ; dir = path.Dir(dir) {
+					dc.directories[dir] += newSize
+					if dir == root || dir == "/" {
+						break
+					}
+				}
+			}(record)
+		}
+	}
+	wg.Wait()
+
+	return snap.Context().Err()
+}
+
+// dryRunFile chunks a file and returns its size and the size of its new
+// chunks, raw and as they would be encoded in a packfile.
+func (snap *Snapshot) dryRunFile(imp importer.Importer, dc *dryRunContext, record importer.ScanRecord) (uint64, uint64, uint64, error) {
+	rd, err := imp.NewReader(record.Pathname)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer rd.Close()
+
+	var size, newSize, encodedSize uint64
+	err = snap.splitSparseChunks(rd, record.FileInfo.Size(), func(data []byte) error {
+		if err := snap.Context().Err(); err != nil {
+			return err
+		}
+		size += uint64(len(data))
+		if !dc.newChunk(snap, snap.repository.Checksum(data)) {
+			return nil
+		}
+		encoded, _, err := snap.repository.EncodeBlob(data)
+		if err != nil {
+			return err
+		}
+		newSize += uint64(len(data))
+		encodedSize += uint64(len(encoded))
+		return nil
+	}, func(length uint32) error {
+		size += uint64(length)
+		return nil
+	})
+	return size, newSize, encodedSize, err
+}
+
+func largestEntries(entries []DryRunEntry, top int) []DryRunEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].NewSize != entries[j].NewSize {
+			return entries[i].NewSize > entries[j].NewSize
+		}
+		return entries[i].Pathname < entries[j].Pathname
+	})
+	if top > 0 && len(entries) > top {
+		entries = entries[:top]
+	}
+	return entries
+}
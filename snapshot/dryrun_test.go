@@ -0,0 +1,71 @@
+package snapshot
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/repository"
+)
+
+// dryRun dry-runs a backup of dir with a new snapshot of repo.
+func dryRun(t *testing.T, repo *repository.Repository, dir string, top int) *DryRunReport {
+	t.Helper()
+
+	snap, err := New(repo)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	report, err := snap.DryRun([]string{dir}, &BackupOptions{MaxConcurrency: 2}, top)
+	if err != nil {
+		t.Fatalf("Failed to dry-run backup: %v", err)
+	}
+
+	select {
+	case <-snap.packerChanDone:
+	default:
+		t.Errorf("Expected the packer to be stopped after a dry run")
+	}
+	return report
+}
+
+func TestDryRun(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "hello", "sub/b.txt": "hello world!"})
+
+	report := dryRun(t, repo, dir, 1)
+	if report.Files != 2 || report.Size != 17 {
+		t.Errorf("Expected 2 files of 17 bytes, got %d of %d bytes", report.Files, report.Size)
+	}
+	if report.Chunks != 2 || report.NewChunks != 2 {
+		t.Errorf("Expected 2 new chunks out of 2, got %d out of %d", report.NewChunks, report.Chunks)
+	}
+	if report.NewSize != 17 {
+		t.Errorf("Expected 17 new bytes, got %d", report.NewSize)
+	}
+	if report.EncodedSize == 0 {
+		t.Errorf("Expected the new chunks to have an encoded size")
+	}
+	largest := filepath.Join(dir, "sub/b.txt")
+	if len(report.LargestFiles) != 1 || report.LargestFiles[0] != (DryRunEntry{Pathname: largest, NewSize: 12}) {
+		t.Errorf("Expected %s to be the largest new file, got %v", largest, report.LargestFiles)
+	}
+	if len(report.LargestDirectories) != 1 || report.LargestDirectories[0] != (DryRunEntry{Pathname: dir, NewSize: 17}) {
+		t.Errorf("Expected %s to be the largest new directory, got %v", dir, report.LargestDirectories)
+	}
+
+	// nothing is new once the tree is backed up
+	backupTree(t, repo, dir, nil)
+	report = dryRun(t, repo, dir, 1)
+	if report.Files != 2 || report.Size != 17 || report.Chunks != 2 {
+		t.Errorf("Expected 2 files of 17 bytes in 2 chunks, got %d of %d bytes in %d chunks", report.Files, report.Size, report.Chunks)
+	}
+	if report.NewChunks != 0 || report.NewSize != 0 || report.EncodedSize != 0 {
+		t.Errorf("Expected nothing new, got %d chunks of %d bytes, %d encoded", report.NewChunks, report.NewSize, report.EncodedSize)
+	}
+	if len(report.LargestFiles) != 0 || len(report.LargestDirectories) != 0 {
+		t.Errorf("Expected no largest entries, got %v and %v", report.LargestFiles, report.LargestDirectories)
+	}
+}
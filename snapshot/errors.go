@@ -56,3 +56,43 @@ func (snapshot *Snapshot) Errors(beneath string) (<-chan ErrorItem, error) {
 
 	return c, nil
 }
+
+// VisitErrors calls fn with the checksum of every blob holding the errors
+// index, it stops at the first error.
+func (snapshot *Snapshot) VisitErrors(fn func(objects.Checksum) error) error {
+	bytes, err := snapshot.GetBlob(packfile.TYPE_ERROR, snapshot.Header.Errors)
+	if err != nil {
+		return err
+	}
+	if err := fn(snapshot.Header.Errors); err != nil {
+		return err
+	}
+
+	var root btree.BTree[string, objects.Checksum, ErrorItem]
+	if err := msgpack.Unmarshal(bytes, &root); err != nil {
+		return err
+	}
+	if err := fn(root.Root); err != nil {
+		return err
+	}
+
+	storage := SnapshotStore[string, ErrorItem]{
+		blobtype: packfile.TYPE_ERROR,
+		snap:     snapshot,
+	}
+	tree := btree.FromStorage(root.Root, &storage, strings.Compare, root.Order)
+
+	var fnErr error
+	err = tree.VisitLevelOrder(func(node btree.Node[string, objects.Checksum, ErrorItem]) bool {
+		for _, ptr := range node.Pointers {
+			if fnErr = fn(ptr); fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
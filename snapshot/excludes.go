@@ -0,0 +1,125 @@
+package snapshot
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/snapshot/header"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+	"github.com/gobwas/glob"
+)
+
+func compileExcludes(patterns []string) ([]glob.Glob, error) {
+	excludes := make([]glob.Glob, 0, len(patterns))
+	for _, pattern := range patterns {
+		exclude, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		excludes = append(excludes, exclude)
+	}
+	return excludes, nil
+}
+
+// headerExcludes lists the command line patterns followed by the rules of
+// the ignore files, parents first so that the rules can be evaluated again.
+func headerExcludes(patterns []string, rules []*importer.IgnoreRule) []header.Exclude {
+	sort.SliceStable(rules, func(i, j int) bool {
+		idir, jdir := path.Dir(rules[i].Source), path.Dir(rules[j].Source)
+		if idepth, jdepth := strings.Count(idir, "/"), strings.Count(jdir, "/"); idepth != jdepth {
+			return idepth < jdepth
+		}
+		return idir < jdir
+	})
+
+	excludes := make([]header.Exclude, 0, len(patterns)+len(rules))
+	for _, pattern := range patterns {
+		excludes = append(excludes, header.Exclude{Pattern: pattern})
+	}
+	for _, rule := range rules {
+		excludes = append(excludes, header.Exclude{Source: rule.Source, Pattern: rule.Pattern})
+	}
+	return excludes
+}
+
+// ExcludedBy returns the exclusion of the snapshot that skipped pathname
+// during the backup, or nil if it was not excluded.
+func (snap *Snapshot) ExcludedBy(pathname string) *header.Exclude {
+	pathname = path.Clean(pathname)
+
+	sources := snap.Header.GetSources()
+	for i := range sources {
+		if exclude := excludedBy(sources[i].Excludes, pathname); exclude != nil {
+			return exclude
+		}
+	}
+	return nil
+}
+
+func excludedBy(excludes []header.Exclude, pathname string) *header.Exclude {
+	var ignore *importer.Ignore
+	ignored := make(map[*importer.IgnoreRule]*header.Exclude)
+	for i, exclude := range excludes {
+		if exclude.Source == "" {
+			if g, err := glob.Compile(exclude.Pattern); err == nil && g.Match(pathname) {
+				return &excludes[i]
+			}
+			continue
+		}
+		rule, err := importer.NewIgnoreRule(exclude.Source, exclude.Pattern)
+		if err != nil || rule == nil {
+			continue
+		}
+		ignore = ignore.With([]*importer.IgnoreRule{rule})
+		ignored[rule] = &excludes[i]
+	}
+
+	// the type of a missing pathname is unknown, try both
+	for _, isDir := range []bool{false, true} {
+		if rule := ignore.MatchRule(pathname, isDir); rule != nil {
+			return ignored[rule]
+		}
+	}
+	return nil
+}
+
+func (options *BackupOptions) scanOptions() *importer.ScanOptions {
+	return &importer.ScanOptions{
+		OneFileSystem:  options.OneFileSystem,
+		ExcludeMarkers: options.ExcludeMarkers,
+	}
+}
+
+// skipFilteredRecord reports whether record is excluded by the size, age or
+// type filters, which never exclude directories.
+func (options *BackupOptions) skipFilteredRecord(result importer.ScanResult) bool {
+	record, isRecord := result.(importer.ScanRecord)
+	if !isRecord || record.FileInfo.Mode().IsDir() {
+		return false
+	}
+
+	for _, recordType := range options.ExcludeTypes {
+		if record.Type == recordType {
+			return true
+		}
+	}
+
+	if !record.FileInfo.Mode().IsRegular() {
+		return false
+	}
+	// streamed files have no known size until they are read
+	if record.FileInfo.Size() >= 0 && options.MinSize != 0 && record.FileInfo.Size() < options.MinSize {
+		return true
+	}
+	if record.FileInfo.Size() >= 0 && options.MaxSize != 0 && record.FileInfo.Size() > options.MaxSize {
+		return true
+	}
+	if !options.NewerThan.IsZero() && !record.FileInfo.ModTime().After(options.NewerThan) {
+		return true
+	}
+	if !options.OlderThan.IsZero() && !record.FileInfo.ModTime().Before(options.OlderThan) {
+		return true
+	}
+	return false
+}
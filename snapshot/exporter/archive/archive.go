@@ -0,0 +1,343 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/PlakarKorp/plakar/snapshot/exporter/staging"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+	"github.com/klauspost/compress/zstd"
+)
+
+type Format int
+
+const (
+	FormatTar Format = iota
+	FormatTarGzip
+	FormatTarZstd
+	FormatZip
+)
+
+// ParseFormat returns the format of the given name, as accepted by the
+// archive command.
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "tar":
+		return FormatTar, nil
+	case "tarball", "tar.gz":
+		return FormatTarGzip, nil
+	case "tar.zst":
+		return FormatTarZstd, nil
+	case "zip":
+		return FormatZip, nil
+	}
+	return FormatTar, fmt.Errorf("unsupported format %s", name)
+}
+
+func (format Format) Extension() string {
+	switch format {
+	case FormatTarGzip:
+		return ".tar.gz"
+	case FormatTarZstd:
+		return ".tar.zst"
+	case FormatZip:
+		return ".zip"
+	}
+	return ".tar"
+}
+
+// tarFormat returns the compression of a tar archive from its name.
+func tarFormat(pathname string) Format {
+	switch {
+	case strings.HasSuffix(pathname, ".tar.gz"), strings.HasSuffix(pathname, ".tgz"):
+		return FormatTarGzip
+	case strings.HasSuffix(pathname, ".tar.zst"), strings.HasSuffix(pathname, ".tzst"):
+		return FormatTarZstd
+	}
+	return FormatTar
+}
+
+// Writer writes entries to an archive in the order they are given, so that
+// an archive can be streamed as the entries are read.
+type Writer struct {
+	compressor io.WriteCloser
+	tarWriter  *tar.Writer
+	zipWriter  *zip.Writer
+	links      map[inode]string
+}
+
+// inode identifies the file shared by hard links.
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// NewWriter returns a Writer of an archive to w, which the caller remains
+// responsible for closing.
+func NewWriter(w io.Writer, format Format) (*Writer, error) {
+	aw := &Writer{links: make(map[inode]string)}
+
+	switch format {
+	case FormatZip:
+		aw.zipWriter = zip.NewWriter(w)
+		return aw, nil
+	case FormatTarGzip:
+		aw.compressor = gzip.NewWriter(w)
+	case FormatTarZstd:
+		zstdWriter, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		aw.compressor = zstdWriter
+	}
+	if aw.compressor != nil {
+		w = aw.compressor
+	}
+	aw.tarWriter = tar.NewWriter(w)
+	return aw, nil
+}
+
+// Links reports which of records, written in turn by a Writer of format,
+// are written as hard links to an earlier entry, their content is not read
+// and need not be fetched.
+func Links(format Format, records []*importer.ScanRecord) []bool {
+	linked := make([]bool, len(records))
+	if format == FormatZip {
+		return linked
+	}
+	seen := make(map[inode]struct{})
+	for i, record := range records {
+		if key, ok := linkKey(record); ok {
+			_, linked[i] = seen[key]
+			seen[key] = struct{}{}
+		}
+	}
+	return linked
+}
+
+// linkKey returns the inode of record when it is a regular file that may
+// have other names.
+func linkKey(record *importer.ScanRecord) (inode, bool) {
+	fileinfo := record.FileInfo
+	if record.Pathname == "/" || !fileinfo.Mode().IsRegular() || fileinfo.Nlink() <= 1 {
+		return inode{}, false
+	}
+	return inode{fileinfo.Dev(), fileinfo.Ino()}, true
+}
+
+// WriteEntry adds record to the archive, with the content of regular files
+// read from content unless written as a hard link.  The root directory has
+// no entry of its own.
+func (aw *Writer) WriteEntry(record *importer.ScanRecord, content io.Reader) error {
+	if record.Pathname == "/" {
+		return nil
+	}
+	if aw.zipWriter != nil {
+		return aw.writeZip(record, content)
+	}
+	return aw.writeTar(record, content)
+}
+
+func (aw *Writer) Close() error {
+	if aw.zipWriter != nil {
+		return aw.zipWriter.Close()
+	}
+	if err := aw.tarWriter.Close(); err != nil {
+		if aw.compressor != nil {
+			aw.compressor.Close()
+		}
+		return err
+	}
+	if aw.compressor != nil {
+		return aw.compressor.Close()
+	}
+	return nil
+}
+
+// Create calls fn with a Writer of an archive at pathname, which is only
+// replaced once fn returns and the archive is complete.
+func Create(pathname string, format Format, fn func(aw *Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(pathname), ".plakar-archive-")
+	if err != nil {
+		return err
+	}
+	if err := write(tmp, format, fn); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), pathname)
+}
+
+// write calls fn with a Writer of an archive to w and completes it.
+func write(w io.Writer, format Format, fn func(aw *Writer) error) error {
+	aw, err := NewWriter(w, format)
+	if err != nil {
+		return err
+	}
+	if err := fn(aw); err != nil {
+		aw.Close()
+		return err
+	}
+	return aw.Close()
+}
+
+// ArchiveExporter writes what is exported to it as an archive, once the
+// tree is complete on Close.  Entries are written in pathname order with
+// the content of files read from the repository at that time, through a
+// single planned stream per snapshot, so nothing but the tree is held
+// until then.
+type ArchiveExporter struct {
+	*staging.Staging
+
+	format   Format
+	pathname string
+	output   io.Writer
+}
+
+func init() {
+	exporter.Register("tar", NewArchiveExporter)
+	exporter.Register("zip", NewArchiveExporter)
+}
+
+// NewArchiveExporter handles tar://pathname and zip://pathname locations, a
+// pathname of - or a location of - alone writes a tar archive to the
+// standard output.
+func NewArchiveExporter(location string) (exporter.Exporter, error) {
+	scheme, pathname := "tar", location
+	if before, after, found := strings.Cut(location, "://"); found {
+		scheme, pathname = before, after
+	}
+	if pathname == "" {
+		return nil, fmt.Errorf("%s: missing archive pathname", location)
+	}
+
+	var format Format
+	switch scheme {
+	case "tar":
+		format = tarFormat(pathname)
+	case "zip":
+		format = FormatZip
+	default:
+		return nil, fmt.Errorf("%s: unsupported archive format", location)
+	}
+
+	if pathname == "-" {
+		return NewStreamExporter(os.Stdout, format), nil
+	}
+	return NewFileExporter(pathname, format), nil
+}
+
+// NewFileExporter returns an exporter writing an archive to pathname, which
+// is only replaced once the archive is complete.
+func NewFileExporter(pathname string, format Format) *ArchiveExporter {
+	return &ArchiveExporter{
+		Staging:  staging.New(),
+		format:   format,
+		pathname: pathname,
+	}
+}
+
+// NewStreamExporter returns an exporter writing an archive to w, which the
+// caller remains responsible for closing.
+func NewStreamExporter(w io.Writer, format Format) *ArchiveExporter {
+	return &ArchiveExporter{
+		Staging: staging.New(),
+		format:  format,
+		output:  w,
+	}
+}
+
+// CreateSpecial is not supported for sockets, nor for any special file in
+// zip archives.
+func (a *ArchiveExporter) CreateSpecial(pathname string, fileinfo *objects.FileInfo) error {
+	if a.format == FormatZip || fileinfo.Mode()&os.ModeSocket != 0 {
+		return exporter.ErrNotSupported
+	}
+	return a.Staging.CreateSpecial(pathname, fileinfo)
+}
+
+func (a *ArchiveExporter) Close() error {
+	defer a.Cleanup()
+
+	if a.output != nil {
+		return write(a.output, a.format, a.writeEntries)
+	}
+	return Create(a.pathname, a.format, a.writeEntries)
+}
+
+func (a *ArchiveExporter) writeEntries(aw *Writer) error {
+	entries := a.Entries()
+	records := make([]*importer.ScanRecord, 0, len(entries))
+	for _, e := range entries {
+		records = append(records, &e.Record)
+	}
+
+	// hard links to an entry already written are not read
+	linked := Links(a.format, records)
+	planned := make([]*staging.Entry, 0)
+	for i, e := range entries {
+		if e.Record.FileInfo.Mode().IsRegular() && !linked[i] {
+			planned = append(planned, e)
+		}
+	}
+	contents := staging.NewContents(planned)
+	defer contents.Close()
+
+	for i, e := range entries {
+		var content io.ReadCloser
+		if e.Record.FileInfo.Mode().IsRegular() && !linked[i] {
+			rd, err := contents.Open(e)
+			if err != nil {
+				return fmt.Errorf("%s: %w", e.Record.Pathname, err)
+			}
+			content = rd
+		}
+		err := aw.WriteEntry(&e.Record, content)
+		if content != nil {
+			content.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryName returns the name of an entry in the archive, relative to the
+// root of the archive.
+func entryName(record *importer.ScanRecord) string {
+	name := strings.TrimPrefix(record.Pathname, "/")
+	if record.FileInfo.Mode().IsDir() {
+		name += "/"
+	}
+	return name
+}
@@ -0,0 +1,325 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+	"github.com/klauspost/compress/zstd"
+
+	_ "github.com/PlakarKorp/plakar/snapshot/importer/fs"
+)
+
+var mtime = time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+// populate exports a small tree with a file, a hard link to it and a
+// symlink, as restore would.
+func populate(t *testing.T, exp *ArchiveExporter) {
+	if err := exp.CreateDirectory("/data"); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := exp.StoreFile("/data/file.txt", strings.NewReader("hello world")); err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+	fileinfo := objects.NewFileInfo("file.txt", 11, 0640|os.ModeSetgid, mtime, 0, 0, 1000, 100, 2)
+	fileinfo.Lusername = "alice"
+	fileinfo.Lgroupname = "users"
+	if err := exp.SetOwner("/data/file.txt", &fileinfo, false); err != nil {
+		t.Fatalf("Failed to set owner: %v", err)
+	}
+	if err := exp.SetPermissions("/data/file.txt", &fileinfo); err != nil {
+		t.Fatalf("Failed to set permissions: %v", err)
+	}
+	if err := exp.SetExtendedAttribute("/data/file.txt", "user.comment", []byte("\x00\x01binary")); err != nil {
+		t.Fatalf("Failed to set extended attribute: %v", err)
+	}
+	if err := exp.SetTimes("/data/file.txt", &fileinfo); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+	if err := exp.CreateLink("/data/file.txt", "/data/link.txt"); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+	if err := exp.CreateSymlink("/data/symlink", "file.txt"); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+}
+
+func readTar(t *testing.T, rd io.Reader) map[string]*tar.Header {
+	headers := make(map[string]*tar.Header)
+	tarReader := tar.NewReader(rd)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		content, err := io.ReadAll(tarReader)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", header.Name, err)
+		}
+		if header.Typeflag == tar.TypeReg && string(content) != "hello world" {
+			t.Errorf("Expected content %q for %s, got %q", "hello world", header.Name, content)
+		}
+		headers[header.Name] = header
+	}
+	return headers
+}
+
+func TestTarExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exp := NewStreamExporter(&buf, FormatTar)
+	populate(t, exp)
+	if err := exp.Close(); err != nil {
+		t.Fatalf("Failed to close exporter: %v", err)
+	}
+
+	headers := readTar(t, &buf)
+	if len(headers) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(headers))
+	}
+
+	if header := headers["data/"]; header == nil || header.Typeflag != tar.TypeDir {
+		t.Errorf("Expected a directory entry for data/")
+	}
+
+	header := headers["data/file.txt"]
+	if header == nil || header.Typeflag != tar.TypeReg {
+		t.Fatalf("Expected a regular file entry for data/file.txt")
+	}
+	if header.Mode != 02640 {
+		t.Errorf("Expected mode 02640, got %o", header.Mode)
+	}
+	if header.Uid != 1000 || header.Gid != 100 || header.Uname != "alice" || header.Gname != "users" {
+		t.Errorf("Unexpected owner %d:%d %s:%s", header.Uid, header.Gid, header.Uname, header.Gname)
+	}
+	if !header.ModTime.Equal(mtime) {
+		t.Errorf("Expected mtime %v, got %v", mtime, header.ModTime)
+	}
+	if value := header.PAXRecords["SCHILY.xattr.user.comment"]; value != "\x00\x01binary" {
+		t.Errorf("Expected extended attribute to be preserved, got %q", value)
+	}
+
+	if header := headers["data/link.txt"]; header == nil || header.Typeflag != tar.TypeLink || header.Linkname != "data/file.txt" {
+		t.Errorf("Expected data/link.txt to be a hard link to data/file.txt")
+	}
+	if header := headers["data/symlink"]; header == nil || header.Typeflag != tar.TypeSymlink || header.Linkname != "file.txt" {
+		t.Errorf("Expected data/symlink to be a symlink to file.txt")
+	}
+}
+
+func TestTarZstdExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exp := NewStreamExporter(&buf, FormatTarZstd)
+	populate(t, exp)
+	if err := exp.Close(); err != nil {
+		t.Fatalf("Failed to close exporter: %v", err)
+	}
+
+	rd, err := zstd.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("Failed to create zstd reader: %v", err)
+	}
+	defer rd.Close()
+	if headers := readTar(t, rd); len(headers) != 4 {
+		t.Errorf("Expected 4 entries, got %d", len(headers))
+	}
+}
+
+func TestZipExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exp := NewStreamExporter(&buf, FormatZip)
+	populate(t, exp)
+
+	fileinfo := objects.NewFileInfo("fifo", 0, os.ModeNamedPipe|0644, mtime, 0, 0, 0, 0, 1)
+	if err := exp.CreateSpecial("/data/fifo", &fileinfo); err != exporter.ErrNotSupported {
+		t.Errorf("Expected special files not to be supported, got %v", err)
+	}
+	if err := exp.Close(); err != nil {
+		t.Fatalf("Failed to close exporter: %v", err)
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	files := make(map[string]string)
+	for _, file := range zipReader.File {
+		rd, err := file.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", file.Name, err)
+		}
+		content, _ := io.ReadAll(rd)
+		rd.Close()
+		files[file.Name] = string(content)
+	}
+
+	if files["data/file.txt"] != "hello world" || files["data/link.txt"] != "hello world" {
+		t.Errorf("Expected both names of the file to hold its content, got %q", files)
+	}
+	if files["data/symlink"] != "file.txt" {
+		t.Errorf("Expected symlink to hold its target, got %q", files["data/symlink"])
+	}
+}
+
+func TestNewArchiveExporter(t *testing.T) {
+	tests := []struct {
+		location string
+		format   Format
+		pathname string
+	}{
+		{"-", FormatTar, ""},
+		{"tar://-", FormatTar, ""},
+		{"tar:///tmp/backup.tar", FormatTar, "/tmp/backup.tar"},
+		{"tar:///tmp/backup.tgz", FormatTarGzip, "/tmp/backup.tgz"},
+		{"tar:///tmp/backup.tar.zst", FormatTarZstd, "/tmp/backup.tar.zst"},
+		{"zip://backup.zip", FormatZip, "backup.zip"},
+	}
+	for _, test := range tests {
+		exp, err := NewArchiveExporter(test.location)
+		if err != nil {
+			t.Fatalf("Failed to create exporter for %s: %v", test.location, err)
+		}
+		archiveExporter := exp.(*ArchiveExporter)
+		if archiveExporter.format != test.format || archiveExporter.pathname != test.pathname {
+			t.Errorf("Expected format %d to %q for %s, got %d to %q", test.format, test.pathname,
+				test.location, archiveExporter.format, archiveExporter.pathname)
+		}
+	}
+
+	if _, err := NewArchiveExporter("tar://"); err == nil {
+		t.Errorf("Expected an error for a missing pathname")
+	}
+}
+
+func TestWriterLinks(t *testing.T) {
+	fileinfo := objects.NewFileInfo("file.txt", 11, 0644, mtime, 1, 42, 0, 0, 2)
+	fileinfo.Lnlink = 2
+	records := []*importer.ScanRecord{
+		{Type: importer.RecordTypeFile, Pathname: "/file.txt", FileInfo: fileinfo},
+		{Type: importer.RecordTypeFile, Pathname: "/link.txt", FileInfo: fileinfo},
+	}
+	if linked := Links(FormatTar, records); linked[0] || !linked[1] {
+		t.Errorf("Expected only the second name to be linked, got %v", linked)
+	}
+	if linked := Links(FormatZip, records); linked[0] || linked[1] {
+		t.Errorf("Expected no links in zip archives, got %v", linked)
+	}
+
+	// the content of a link is not read
+	var buf bytes.Buffer
+	aw, err := NewWriter(&buf, FormatTar)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := aw.WriteEntry(records[0], strings.NewReader("hello world")); err != nil {
+		t.Fatalf("Failed to write entry: %v", err)
+	}
+	if err := aw.WriteEntry(records[1], nil); err != nil {
+		t.Fatalf("Failed to write link: %v", err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	headers := readTar(t, &buf)
+	if header := headers["link.txt"]; header == nil || header.Typeflag != tar.TypeLink || header.Linkname != "file.txt" {
+		t.Errorf("Expected link.txt to be a hard link to file.txt")
+	}
+}
+
+// restoreTree backs up a tree of files holding "hello world", one of them
+// twice linked, and restores it to exp.
+func restoreTree(t *testing.T, exp *ArchiveExporter) {
+	t.Helper()
+
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte("hello world"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := os.Link(filepath.Join(src, "a.txt"), filepath.Join(src, "link.txt")); err != nil {
+		t.Fatalf("Failed to create hardlink: %v", err)
+	}
+
+	snap, err := snapshot.New(testutil.NewRepository(t, nil))
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if err := snap.Backup(src, &snapshot.BackupOptions{MaxConcurrency: 4}); err != nil {
+		t.Fatalf("Failed to back up %s: %v", src, err)
+	}
+	report, err := snap.Restore(exp, exp.Root(), src, &snapshot.RestoreOptions{MaxConcurrency: 4, Rebase: true})
+	if err != nil {
+		t.Fatalf("Failed to restore %s: %v", src, err)
+	}
+	if len(report.Failed) != 0 {
+		t.Fatalf("Expected no failures, got %v", report.Failed)
+	}
+}
+
+func TestSnapshotExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exp := NewStreamExporter(&buf, FormatTar)
+	restoreTree(t, exp)
+	if err := exp.Close(); err != nil {
+		t.Fatalf("Failed to close exporter: %v", err)
+	}
+
+	headers := readTar(t, &buf)
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if header := headers[name]; header == nil || header.Typeflag != tar.TypeReg || header.Size != 11 {
+			t.Errorf("Expected a regular file entry of 11 bytes for %s", name)
+		}
+	}
+	if header := headers["link.txt"]; header == nil || header.Typeflag != tar.TypeLink || header.Linkname != "a.txt" {
+		t.Errorf("Expected link.txt to be a hard link to a.txt")
+	}
+
+	// zip archives have no links, the content is read for each name
+	buf.Reset()
+	exp = NewStreamExporter(&buf, FormatZip)
+	restoreTree(t, exp)
+	if err := exp.Close(); err != nil {
+		t.Fatalf("Failed to close exporter: %v", err)
+	}
+	zipReader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	files := 0
+	for _, file := range zipReader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		rd, err := file.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", file.Name, err)
+		}
+		content, _ := io.ReadAll(rd)
+		rd.Close()
+		if string(content) != "hello world" {
+			t.Errorf("Expected %s to hold %q, got %q", file.Name, "hello world", content)
+		}
+		files++
+	}
+	if files != 3 {
+		t.Errorf("Expected 3 files, got %d", files)
+	}
+}
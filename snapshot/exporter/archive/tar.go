@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+	"golang.org/x/sys/unix"
+)
+
+// writeTar writes record as a PAX entry, which keeps the owners by id and
+// name, the mtimes to the nanosecond and the extended attributes.  The
+// first entry of an inode holds the content, the others link to it.
+func (aw *Writer) writeTar(record *importer.ScanRecord, content io.Reader) error {
+	header, err := tarHeader(record, aw.links)
+	if err != nil {
+		return err
+	}
+	if err := aw.tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("%s: %w", record.Pathname, err)
+	}
+	if header.Typeflag != tar.TypeReg || header.Size == 0 {
+		return nil
+	}
+	if content == nil {
+		return fmt.Errorf("%s: missing content", record.Pathname)
+	}
+	if _, err := io.Copy(aw.tarWriter, content); err != nil {
+		return fmt.Errorf("%s: %w", record.Pathname, err)
+	}
+	return nil
+}
+
+func tarHeader(record *importer.ScanRecord, links map[inode]string) (*tar.Header, error) {
+	fileinfo := record.FileInfo
+	mode := fileinfo.Mode()
+
+	header := &tar.Header{
+		Name:    entryName(record),
+		Mode:    int64(mode.Perm()),
+		Uid:     int(fileinfo.Uid()),
+		Gid:     int(fileinfo.Gid()),
+		Uname:   fileinfo.Username(),
+		Gname:   fileinfo.Groupname(),
+		ModTime: fileinfo.ModTime(),
+		Format:  tar.FormatPAX,
+	}
+	if mode&os.ModeSetuid != 0 {
+		header.Mode |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		header.Mode |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		header.Mode |= 01000
+	}
+	for name, value := range record.ExtendedAttributes {
+		if header.PAXRecords == nil {
+			header.PAXRecords = make(map[string]string)
+		}
+		header.PAXRecords["SCHILY.xattr."+name] = string(value)
+	}
+
+	switch {
+	case mode.IsDir():
+		header.Typeflag = tar.TypeDir
+	case mode&os.ModeSymlink != 0:
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = record.Target
+	case mode&os.ModeNamedPipe != 0:
+		header.Typeflag = tar.TypeFifo
+	case mode&os.ModeDevice != 0:
+		header.Typeflag = tar.TypeBlock
+		if mode&os.ModeCharDevice != 0 {
+			header.Typeflag = tar.TypeChar
+		}
+		header.Devmajor = int64(unix.Major(fileinfo.Rdev()))
+		header.Devminor = int64(unix.Minor(fileinfo.Rdev()))
+	case mode.IsRegular():
+		if key, ok := linkKey(record); ok {
+			if first, exists := links[key]; exists {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first
+				return header, nil
+			}
+			links[key] = header.Name
+		}
+		header.Typeflag = tar.TypeReg
+		header.Size = fileinfo.Size()
+	default:
+		return nil, fmt.Errorf("%s: unexpected file mode %s", record.Pathname, mode)
+	}
+	return header, nil
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package archive
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+)
+
+// writeZip writes record as a zip entry.  Zip has no owners, extended
+// attributes nor hard links, every name of an inode gets its own copy of
+// the content.
+func (aw *Writer) writeZip(record *importer.ScanRecord, content io.Reader) error {
+	header, err := zip.FileInfoHeader(record.FileInfo)
+	if err != nil {
+		return fmt.Errorf("%s: %w", record.Pathname, err)
+	}
+	header.Name = entryName(record)
+
+	mode := record.FileInfo.Mode()
+	if mode.IsRegular() {
+		header.Method = zip.Deflate
+	} else {
+		header.Method = zip.Store
+	}
+
+	writer, err := aw.zipWriter.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("%s: %w", record.Pathname, err)
+	}
+
+	switch {
+	case mode&os.ModeSymlink != 0:
+		_, err = io.WriteString(writer, record.Target)
+	case mode.IsRegular() && content != nil:
+		_, err = io.Copy(writer, content)
+	case mode.IsRegular() && record.FileInfo.Size() != 0:
+		err = fmt.Errorf("missing content")
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", record.Pathname, err)
+	}
+	return nil
+}
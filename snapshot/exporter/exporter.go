@@ -1,6 +1,7 @@
 package exporter
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,13 +10,58 @@ import (
 	"sync"
 
 	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/repository"
 )
 
+// ErrNotSupported is returned by exporters that cannot represent an entry,
+// such as a device on an object store, the entry is skipped.
+var ErrNotSupported = errors.New("not supported by exporter")
+
+// File is an existing file updated in place when restoring over it.
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+	Close() error
+}
+
+// SparseReader is implemented by the readers passed to StoreFile when the
+// content has holes.  Reads never span a hole, exporters able to create
+// sparse files call SkipHole between reads to skip them instead of writing
+// zeros.
+type SparseReader interface {
+	io.Reader
+	// SkipHole skips the hole at the current offset and returns its
+	// length, or 0 if there is none.
+	SkipHole() int64
+}
+
+// ObjectReader is implemented by the readers passed to StoreFile for the
+// content of a snapshot.  Exporters writing to a repository use it to copy
+// the chunks of the object rather than chunking the content again.
+type ObjectReader interface {
+	io.Reader
+	Repository() *repository.Repository
+	// Object returns the object being read, nil for an empty file.
+	Object() *objects.Object
+}
+
 type Exporter interface {
 	Root() string
+	Stat(pathname string) (*objects.FileInfo, error)
+	ReadDir(pathname string) ([]string, error)
+	Remove(pathname string) error
+	Open(pathname string) (io.ReadCloser, error)
+	OpenFile(pathname string) (File, error)
 	CreateDirectory(pathname string) error
 	StoreFile(pathname string, fp io.Reader) error
+	CreateSymlink(pathname string, target string) error
+	CreateLink(oldname string, newname string) error
+	CreateSpecial(pathname string, fileinfo *objects.FileInfo) error
 	SetPermissions(pathname string, fileinfo *objects.FileInfo) error
+	SetOwner(pathname string, fileinfo *objects.FileInfo, numeric bool) error
+	SetTimes(pathname string, fileinfo *objects.FileInfo) error
+	SetExtendedAttribute(pathname string, name string, value []byte) error
 	Close() error
 }
 
@@ -51,11 +97,21 @@ func NewExporter(location string) (Exporter, error) {
 	defer muBackends.Unlock()
 
 	var backendName string
-	if !strings.HasPrefix(location, "/") {
+	if location == "-" {
+		backendName = "tar"
+	} else if !strings.HasPrefix(location, "/") {
 		if strings.HasPrefix(location, "s3://") {
 			backendName = "s3"
 		} else if strings.HasPrefix(location, "fs://") {
 			backendName = "fs"
+		} else if strings.HasPrefix(location, "ftp://") {
+			backendName = "ftp"
+		} else if strings.HasPrefix(location, "sftp://") {
+			backendName = "sftp"
+		} else if strings.HasPrefix(location, "tar://") {
+			backendName = "tar"
+		} else if strings.HasPrefix(location, "zip://") {
+			backendName = "zip"
 		} else {
 			if strings.Contains(location, "://") {
 				return nil, fmt.Errorf("unsupported importer protocol")
@@ -17,16 +17,27 @@
 package fs
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"os/user"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/pkg/xattr"
+	"golang.org/x/sys/unix"
 )
 
 type FSExporter struct {
 	rootDir string
+
+	muIds  sync.Mutex
+	users  map[string]int
+	groups map[string]int
 }
 
 func init() {
@@ -40,6 +51,8 @@ func NewFSExporter(location string) (exporter.Exporter, error) {
 
 	return &FSExporter{
 		rootDir: location,
+		users:   make(map[string]int),
+		groups:  make(map[string]int),
 	}, nil
 }
 
@@ -55,6 +68,39 @@ func (p *FSExporter) Root() string {
 	return p.rootDir
 }
 
+func (p *FSExporter) Stat(pathname string) (*objects.FileInfo, error) {
+	info, err := os.Lstat(pathname)
+	if err != nil {
+		return nil, err
+	}
+	fileinfo := objects.FileInfoFromStat(info)
+	return &fileinfo, nil
+}
+
+func (p *FSExporter) ReadDir(pathname string) ([]string, error) {
+	entries, err := os.ReadDir(pathname)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func (p *FSExporter) Remove(pathname string) error {
+	return os.RemoveAll(pathname)
+}
+
+func (p *FSExporter) Open(pathname string) (io.ReadCloser, error) {
+	return os.Open(pathname)
+}
+
+func (p *FSExporter) OpenFile(pathname string) (exporter.File, error) {
+	return os.OpenFile(pathname, os.O_RDWR, 0)
+}
+
 func (p *FSExporter) CreateDirectory(pathname string) error {
 	return os.MkdirAll(pathname, 0700)
 }
@@ -65,7 +111,12 @@ func (p *FSExporter) StoreFile(pathname string, fp io.Reader) error {
 		return err
 	}
 
-	if _, err := io.Copy(f, fp); err != nil {
+	if sparse, isSparse := fp.(exporter.SparseReader); isSparse {
+		err = exporter.StoreSparse(f, sparse)
+	} else {
+		_, err = io.Copy(f, fp)
+	}
+	if err != nil {
 		//logging.Warn("copy failure: %s: %s", pathname, err)
 		f.Close()
 		return err
@@ -79,16 +130,110 @@ func (p *FSExporter) StoreFile(pathname string, fp io.Reader) error {
 	return nil
 }
 
+func (p *FSExporter) CreateSymlink(pathname string, target string) error {
+	return os.Symlink(target, pathname)
+}
+
+func (p *FSExporter) CreateLink(oldname string, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (p *FSExporter) CreateSpecial(pathname string, fileinfo *objects.FileInfo) error {
+	mode := uint32(fileinfo.Mode().Perm())
+	switch {
+	case fileinfo.Mode()&os.ModeNamedPipe != 0:
+		mode |= syscall.S_IFIFO
+	case fileinfo.Mode()&os.ModeSocket != 0:
+		mode |= syscall.S_IFSOCK
+	case fileinfo.Mode()&os.ModeCharDevice != 0:
+		mode |= syscall.S_IFCHR
+	case fileinfo.Mode()&os.ModeDevice != 0:
+		mode |= syscall.S_IFBLK
+	default:
+		return fmt.Errorf("%s: not a special file", pathname)
+	}
+	return mknod(pathname, mode, fileinfo.Rdev())
+}
+
 func (p *FSExporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
-	if err := os.Chmod(pathname, fileinfo.Mode()); err != nil {
-		return err
+	// symlink permissions are not meaningful and chmod would follow the link
+	if fileinfo.Mode()&os.ModeSymlink != 0 {
+		return nil
 	}
-	if os.Getuid() == 0 {
-		if err := os.Chown(pathname, int(fileinfo.Uid()), int(fileinfo.Gid())); err != nil {
-			return err
+	return os.Chmod(pathname, fileinfo.Mode())
+}
+
+// SetOwner restores the owner of pathname, by user and group names when they
+// exist on this system unless numeric is set, by uid and gid otherwise.
+// Only the superuser may give files away, others keep their ownership.
+func (p *FSExporter) SetOwner(pathname string, fileinfo *objects.FileInfo, numeric bool) error {
+	if os.Getuid() != 0 {
+		return nil
+	}
+
+	uid, gid := int(fileinfo.Uid()), int(fileinfo.Gid())
+	if !numeric {
+		if id, ok := p.lookupUser(fileinfo.Username()); ok {
+			uid = id
+		}
+		if id, ok := p.lookupGroup(fileinfo.Groupname()); ok {
+			gid = id
 		}
 	}
-	return nil
+	return os.Lchown(pathname, uid, gid)
+}
+
+func (p *FSExporter) lookupUser(name string) (int, bool) {
+	if name == "" {
+		return 0, false
+	}
+
+	p.muIds.Lock()
+	defer p.muIds.Unlock()
+	if id, ok := p.users[name]; ok {
+		return id, id >= 0
+	}
+
+	id := -1
+	if u, err := user.Lookup(name); err == nil {
+		if uid, err := strconv.Atoi(u.Uid); err == nil {
+			id = uid
+		}
+	}
+	p.users[name] = id
+	return id, id >= 0
+}
+
+func (p *FSExporter) lookupGroup(name string) (int, bool) {
+	if name == "" {
+		return 0, false
+	}
+
+	p.muIds.Lock()
+	defer p.muIds.Unlock()
+	if id, ok := p.groups[name]; ok {
+		return id, id >= 0
+	}
+
+	id := -1
+	if g, err := user.LookupGroup(name); err == nil {
+		if gid, err := strconv.Atoi(g.Gid); err == nil {
+			id = gid
+		}
+	}
+	p.groups[name] = id
+	return id, id >= 0
+}
+
+// SetTimes sets both the access and modification times to the modification
+// time of the snapshot, the only one recorded.
+func (p *FSExporter) SetTimes(pathname string, fileinfo *objects.FileInfo) error {
+	ts := unix.NsecToTimespec(fileinfo.ModTime().UnixNano())
+	return unix.UtimesNanoAt(unix.AT_FDCWD, pathname, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+func (p *FSExporter) SetExtendedAttribute(pathname string, name string, value []byte) error {
+	return xattr.LSet(pathname, name, value)
 }
 
 func (p *FSExporter) Close() error {
@@ -0,0 +1,10 @@
+package fs
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// mknod creates a special file, FreeBSD takes 64-bit device numbers.
+func mknod(pathname string, mode uint32, rdev uint64) error {
+	return unix.Mknod(pathname, mode, rdev)
+}
@@ -0,0 +1,13 @@
+//go:build !freebsd
+// +build !freebsd
+
+package fs
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// mknod creates a special file, the device number is an int here.
+func mknod(pathname string, mode uint32, rdev uint64) error {
+	return unix.Mknod(pathname, mode, int(rdev))
+}
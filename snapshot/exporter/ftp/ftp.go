@@ -0,0 +1,365 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/secsy/goftp"
+)
+
+type FTPExporter struct {
+	rootDir string
+	client  *goftp.Client
+
+	// commands go through a single control connection, opened on demand
+	muConn sync.Mutex
+	conn   goftp.RawConn
+
+	muListings sync.Mutex
+	listings   map[string]*listing
+}
+
+// listing caches the entries of a directory.  Names written to since it was
+// read are stale, their parent is listed again when they are looked up.
+type listing struct {
+	entries map[string]os.FileInfo
+	stale   map[string]struct{}
+}
+
+func init() {
+	exporter.Register("ftp", NewFTPExporter)
+}
+
+func connectToFTP(location *url.URL) (*goftp.Client, error) {
+	host := location.Host
+	if location.Port() == "" {
+		host = net.JoinHostPort(location.Hostname(), "21")
+	}
+
+	config := goftp.Config{
+		Timeout: 10 * time.Second,
+	}
+	if location.User != nil {
+		config.User = location.User.Username()
+		config.Password, _ = location.User.Password()
+	}
+	return goftp.DialConfig(config, host)
+}
+
+func NewFTPExporter(location string) (exporter.Exporter, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := connectToFTP(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	rootDir := path.Clean("/" + parsed.Path)
+	return &FTPExporter{
+		rootDir:  rootDir,
+		client:   client,
+		listings: make(map[string]*listing),
+	}, nil
+}
+
+func (p *FTPExporter) Root() string {
+	return p.rootDir
+}
+
+// isNotExist reports whether err is the reply of an FTP server to an
+// operation on a file that does not exist.
+func isNotExist(err error) bool {
+	var ftpErr goftp.Error
+	return errors.As(err, &ftpErr) && ftpErr.Code() == 550
+}
+
+// list reads the entries of dir and caches them.
+func (p *FTPExporter) list(dir string) ([]os.FileInfo, error) {
+	entries, err := p.client.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &listing{
+		entries: make(map[string]os.FileInfo, len(entries)),
+		stale:   make(map[string]struct{}),
+	}
+	for _, entry := range entries {
+		l.entries[entry.Name()] = entry
+	}
+	p.muListings.Lock()
+	p.listings[dir] = l
+	p.muListings.Unlock()
+	return entries, nil
+}
+
+// lookup returns the cached entry of pathname, ok is false when its parent
+// must be listed again.
+func (p *FTPExporter) lookup(pathname string) (entry os.FileInfo, ok bool) {
+	p.muListings.Lock()
+	defer p.muListings.Unlock()
+
+	l, exists := p.listings[path.Dir(pathname)]
+	if !exists {
+		return nil, false
+	}
+	if _, stale := l.stale[path.Base(pathname)]; stale {
+		return nil, false
+	}
+	return l.entries[path.Base(pathname)], true
+}
+
+// changed marks pathname as stale in the listing of its parent and forgets
+// the listings of the directories below it.
+func (p *FTPExporter) changed(pathname string) {
+	p.muListings.Lock()
+	defer p.muListings.Unlock()
+
+	if l, exists := p.listings[path.Dir(pathname)]; exists {
+		l.stale[path.Base(pathname)] = struct{}{}
+	}
+	for dir := range p.listings {
+		if dir == pathname || strings.HasPrefix(dir, pathname+"/") {
+			delete(p.listings, dir)
+		}
+	}
+}
+
+// Stat looks pathname up in its parent directory, as not all servers
+// support MLST and LIST does not describe directories themselves.  The
+// listing is cached so that the entries of a directory are looked up with
+// a single LIST.
+func (p *FTPExporter) Stat(pathname string) (*objects.FileInfo, error) {
+	if pathname == "/" {
+		fileinfo := objects.NewFileInfo("/", 0, os.ModeDir|0755, time.Time{}, 0, 0, 0, 0, 1)
+		return &fileinfo, nil
+	}
+
+	entry, ok := p.lookup(pathname)
+	if !ok {
+		entries, err := p.list(path.Dir(pathname))
+		if err != nil {
+			if isNotExist(err) {
+				return nil, os.ErrNotExist
+			}
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Name() == path.Base(pathname) {
+				entry = e
+				break
+			}
+		}
+	}
+	if entry == nil {
+		return nil, os.ErrNotExist
+	}
+	fileinfo := objects.FileInfoFromStat(entry)
+	return &fileinfo, nil
+}
+
+func (p *FTPExporter) ReadDir(pathname string) ([]string, error) {
+	entries, err := p.list(pathname)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// Remove removes pathname and, for directories, all of their content as
+// FTP servers only remove empty directories.
+func (p *FTPExporter) Remove(pathname string) error {
+	info, err := p.Stat(pathname)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer p.changed(pathname)
+	if !info.Mode().IsDir() {
+		return p.client.Delete(pathname)
+	}
+
+	names, err := p.ReadDir(pathname)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := p.Remove(path.Join(pathname, name)); err != nil {
+			return err
+		}
+	}
+	return p.client.Rmdir(pathname)
+}
+
+// Open streams the content of pathname as it is retrieved.
+func (p *FTPExporter) Open(pathname string) (io.ReadCloser, error) {
+	rd, wr := io.Pipe()
+	go func() {
+		wr.CloseWithError(p.client.Retrieve(pathname, wr))
+	}()
+	return rd, nil
+}
+
+// OpenFile is not supported, FTP has no way to write at an offset other
+// than by resuming an upload.
+func (p *FTPExporter) OpenFile(pathname string) (exporter.File, error) {
+	return nil, exporter.ErrNotSupported
+}
+
+func (p *FTPExporter) CreateDirectory(pathname string) error {
+	if pathname == "/" {
+		return nil
+	}
+	if info, err := p.Stat(pathname); err == nil {
+		if !info.Mode().IsDir() {
+			return fmt.Errorf("%s: not a directory", pathname)
+		}
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := p.CreateDirectory(path.Dir(pathname)); err != nil {
+		return err
+	}
+	// the reply is not parsed, some servers omit the quoted pathname
+	defer p.changed(pathname)
+	code, msg, err := p.command("MKD %s", pathname)
+	if err != nil {
+		return err
+	}
+	if code != 257 {
+		return fmt.Errorf("%s: %d %s", pathname, code, msg)
+	}
+	return nil
+}
+
+func (p *FTPExporter) StoreFile(pathname string, fp io.Reader) error {
+	defer p.changed(pathname)
+	return p.client.Store(pathname, fp)
+}
+
+// CreateSymlink is not supported, FTP has no notion of links.
+func (p *FTPExporter) CreateSymlink(pathname string, target string) error {
+	return exporter.ErrNotSupported
+}
+
+// CreateLink emulates hard links by uploading the file already restored
+// again.
+func (p *FTPExporter) CreateLink(oldname string, newname string) error {
+	rd, err := p.Open(oldname)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	return p.StoreFile(newname, rd)
+}
+
+func (p *FTPExporter) CreateSpecial(pathname string, fileinfo *objects.FileInfo) error {
+	return exporter.ErrNotSupported
+}
+
+// command sends a command to the server, for those the client does not
+// wrap or whose replies it fails to parse.  They go through a control
+// connection of their own, kept open across commands and opened again if
+// it breaks.
+func (p *FTPExporter) command(format string, args ...interface{}) (int, string, error) {
+	p.muConn.Lock()
+	defer p.muConn.Unlock()
+
+	if p.conn == nil {
+		conn, err := p.client.OpenRawConn()
+		if err != nil {
+			return 0, "", err
+		}
+		p.conn = conn
+	}
+	code, msg, err := p.conn.SendCommand(format, args...)
+	if err != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return code, msg, err
+}
+
+// optionalCommand sends an extension command to the server, those it does
+// not implement are ignored.
+func (p *FTPExporter) optionalCommand(format string, args ...interface{}) error {
+	code, msg, err := p.command(format, args...)
+	if err != nil {
+		return err
+	}
+	switch {
+	case code >= 200 && code < 300:
+		return nil
+	case code == 500 || code == 502 || code == 504:
+		return nil
+	default:
+		return fmt.Errorf("%d %s", code, msg)
+	}
+}
+
+// SetPermissions relies on the SITE CHMOD extension, when available.
+func (p *FTPExporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
+	defer p.changed(pathname)
+	return p.optionalCommand("SITE CHMOD %04o %s", fileinfo.Mode().Perm(), pathname)
+}
+
+func (p *FTPExporter) SetOwner(pathname string, fileinfo *objects.FileInfo, numeric bool) error {
+	return nil
+}
+
+// SetTimes relies on the MFMT extension, when available.
+func (p *FTPExporter) SetTimes(pathname string, fileinfo *objects.FileInfo) error {
+	defer p.changed(pathname)
+	return p.optionalCommand("MFMT %s %s", fileinfo.ModTime().UTC().Format("20060102150405"), pathname)
+}
+
+func (p *FTPExporter) SetExtendedAttribute(pathname string, name string, value []byte) error {
+	return nil
+}
+
+func (p *FTPExporter) Close() error {
+	p.muConn.Lock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	p.muConn.Unlock()
+	return p.client.Close()
+}
@@ -0,0 +1,149 @@
+package ftp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"goftp.io/server/v2"
+	"goftp.io/server/v2/driver/file"
+)
+
+// newTestExporter connects an exporter to an in-process server serving a
+// temporary directory.
+func newTestExporter(t *testing.T) (exporter.Exporter, string) {
+	root := t.TempDir()
+	driver, err := file.NewDriver(root)
+	if err != nil {
+		t.Fatalf("Failed to create driver: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	srv, err := server.NewServer(&server.Options{
+		Driver:   driver,
+		Auth:     &server.SimpleAuth{Name: "user", Password: "secret"},
+		Perm:     server.NewSimplePerm("user", "user"),
+		PublicIP: "127.0.0.1",
+		Logger:   &server.DiscardLogger{},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	go srv.Serve(listener)
+
+	exp, err := NewFTPExporter(fmt.Sprintf("ftp://user:secret@%s/restore", listener.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+	t.Cleanup(func() {
+		exp.Close()
+		srv.Shutdown()
+	})
+	return exp, root
+}
+
+func TestFTPExporter(t *testing.T) {
+	exp, root := newTestExporter(t)
+
+	if exp.Root() != "/restore" {
+		t.Errorf("Expected root /restore, got %q", exp.Root())
+	}
+
+	dir := exp.Root() + "/a/b"
+	if err := exp.CreateDirectory(dir); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := exp.CreateDirectory(dir); err != nil {
+		t.Fatalf("Failed to create existing directory: %v", err)
+	}
+
+	pathname := dir + "/file.txt"
+	if err := exp.StoreFile(pathname, strings.NewReader("hello world")); err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(root, pathname))
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != "hello world" {
+		t.Errorf("Expected content %q, got %q", "hello world", content)
+	}
+
+	// the server implements neither SITE CHMOD nor MFMT
+	fileinfo := objects.NewFileInfo("file.txt", 11, 0600, time.Now(), 0, 0, 0, 0, 1)
+	if err := exp.SetPermissions(pathname, &fileinfo); err != nil {
+		t.Errorf("Failed to set permissions: %v", err)
+	}
+	if err := exp.SetTimes(pathname, &fileinfo); err != nil {
+		t.Errorf("Failed to set times: %v", err)
+	}
+
+	info, err := exp.Stat(pathname)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if !info.Mode().IsRegular() || info.Size() != 11 {
+		t.Errorf("Expected a regular file of 11 bytes, got %v %d", info.Mode(), info.Size())
+	}
+	if info, err := exp.Stat(dir); err != nil || !info.Mode().IsDir() {
+		t.Errorf("Expected a directory, got %v", err)
+	}
+	if _, err := exp.Stat(dir + "/missing"); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing file, got %v", err)
+	}
+
+	// listings are cached, files stored since are looked up again
+	if err := exp.StoreFile(pathname, strings.NewReader("hello")); err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+	if info, err := exp.Stat(pathname); err != nil || info.Size() != 5 {
+		t.Errorf("Expected the file stored again to be 5 bytes, got %v", err)
+	}
+	if err := exp.StoreFile(pathname, strings.NewReader("hello world")); err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+
+	if err := exp.CreateLink(pathname, dir+"/copy.txt"); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+	rd, err := exp.Open(dir + "/copy.txt")
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	var buf bytes.Buffer
+	io.Copy(&buf, rd)
+	rd.Close()
+	if buf.String() != "hello world" {
+		t.Errorf("Expected content %q, got %q", "hello world", buf.String())
+	}
+
+	names, err := exp.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("Expected 2 entries, got %v", names)
+	}
+
+	if _, err := exp.OpenFile(pathname); err != exporter.ErrNotSupported {
+		t.Errorf("Expected OpenFile not to be supported, got %v", err)
+	}
+
+	if err := exp.Remove(exp.Root() + "/a"); err != nil {
+		t.Fatalf("Failed to remove directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "restore", "a")); !os.IsNotExist(err) {
+		t.Errorf("Expected directory to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package plakar
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/exporter/staging"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+)
+
+// PlakarExporter writes what is exported to it as a new snapshot of a
+// repository, which is committed on Close.  The content of files coming
+// from a repository is copied or chunked again at that time.
+type PlakarExporter struct {
+	*staging.Staging
+
+	repository *repository.Repository
+	options    *snapshot.BackupOptions
+
+	snapshotID objects.Checksum
+	aborted    bool
+}
+
+// NewPlakarExporter returns an exporter to a new snapshot of repo, which the
+// caller opens and remains responsible for closing.
+func NewPlakarExporter(repo *repository.Repository, options *snapshot.BackupOptions) *PlakarExporter {
+	return &PlakarExporter{
+		Staging:    staging.New(),
+		repository: repo,
+		options:    options,
+	}
+}
+
+// SnapshotID returns the identifier of the snapshot once committed.
+func (p *PlakarExporter) SnapshotID() objects.Checksum {
+	return p.snapshotID
+}
+
+// Abort discards what was exported, Close then commits nothing.  It is
+// used when some files could not be exported, so that a snapshot missing
+// them is not mistaken for a complete copy.
+func (p *PlakarExporter) Abort() {
+	p.aborted = true
+}
+
+// Close commits the snapshot, unless nothing was exported to it or the
+// export was aborted.
+func (p *PlakarExporter) Close() error {
+	defer p.Cleanup()
+
+	if p.aborted || p.Empty() {
+		return nil
+	}
+
+	snap, err := snapshot.New(p.repository)
+	if err != nil {
+		return err
+	}
+	imp := &entriesImporter{exporter: p, entries: p.Entries()}
+	if err := snap.BackupImporter(imp, p.options); err != nil {
+		return err
+	}
+	p.snapshotID = snap.Header.Identifier
+	return nil
+}
+
+// entriesImporter feeds the entries of the exporter to the backup.
+type entriesImporter struct {
+	exporter *PlakarExporter
+	entries  []*staging.Entry
+}
+
+func (imp *entriesImporter) Origin() string {
+	return imp.exporter.repository.Context().GetHostname()
+}
+
+func (imp *entriesImporter) Type() string {
+	return "plakar"
+}
+
+func (imp *entriesImporter) Root() string {
+	return "/"
+}
+
+func (imp *entriesImporter) Scan(ctx context.Context, options *importer.ScanOptions) (<-chan importer.ScanResult, error) {
+	c := make(chan importer.ScanResult, 1000)
+	go func() {
+		defer close(c)
+		for _, e := range imp.entries {
+			if ctx.Err() != nil {
+				break
+			}
+			c <- e.Record
+		}
+	}()
+	return c, nil
+}
+
+func (imp *entriesImporter) IgnoreRules() []*importer.IgnoreRule {
+	return nil
+}
+
+func (imp *entriesImporter) NewReader(pathname string) (io.ReadCloser, error) {
+	e, err := imp.exporter.Lookup(pathname)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return e.Open()
+}
+
+func (imp *entriesImporter) Close() error {
+	return nil
+}
@@ -0,0 +1,141 @@
+package plakar
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+
+	_ "github.com/PlakarKorp/plakar/snapshot/exporter/fs"
+	_ "github.com/PlakarKorp/plakar/snapshot/importer/fs"
+)
+
+// exportTree backs up a small tree to a new repository and restores it to
+// exp, it returns the directory that was backed up.
+func exportTree(t *testing.T, exp exporter.Exporter) string {
+	t.Helper()
+
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for name, content := range map[string]string{"a.txt": "hello", "sub/b.txt": "world"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := os.Link(filepath.Join(src, "a.txt"), filepath.Join(src, "link.txt")); err != nil {
+		t.Fatalf("Failed to create hardlink: %v", err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(src, "symlink")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	snap, err := snapshot.New(testutil.NewRepository(t, nil))
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if err := snap.Backup(src, &snapshot.BackupOptions{MaxConcurrency: 4}); err != nil {
+		t.Fatalf("Failed to back up %s: %v", src, err)
+	}
+	report, err := snap.Restore(exp, exp.Root(), src, &snapshot.RestoreOptions{MaxConcurrency: 4, Rebase: true})
+	if err != nil {
+		t.Fatalf("Failed to restore %s: %v", src, err)
+	}
+	if len(report.Failed) != 0 {
+		t.Fatalf("Expected no failures, got %v", report.Failed)
+	}
+	return src
+}
+
+func TestPlakarExporter(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+	exp := NewPlakarExporter(repo, &snapshot.BackupOptions{MaxConcurrency: 4})
+	exportTree(t, exp)
+	if err := exp.Close(); err != nil {
+		t.Fatalf("Failed to commit snapshot: %v", err)
+	}
+
+	snapshotID := exp.SnapshotID()
+	if snapshotID == (objects.Checksum{}) {
+		t.Fatalf("Expected a snapshot to be committed")
+	}
+	snap, err := snapshot.Load(repo, snapshotID)
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+
+	dst := t.TempDir()
+	fsExp, err := exporter.NewExporter(dst)
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+	defer fsExp.Close()
+	report, err := snap.Restore(fsExp, fsExp.Root(), "/", &snapshot.RestoreOptions{MaxConcurrency: 4, Rebase: true})
+	if err != nil {
+		t.Fatalf("Failed to restore snapshot: %v", err)
+	}
+	if len(report.Failed) != 0 {
+		t.Fatalf("Expected no failures, got %v", report.Failed)
+	}
+
+	for name, expected := range map[string]string{"a.txt": "hello", "sub/b.txt": "world", "link.txt": "hello"} {
+		content, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(content) != expected {
+			t.Errorf("Expected %s to hold %q, got %q", name, expected, content)
+		}
+	}
+	a, err := os.Stat(filepath.Join(dst, "a.txt"))
+	if err != nil {
+		t.Fatalf("Failed to stat a.txt: %v", err)
+	}
+	link, err := os.Stat(filepath.Join(dst, "link.txt"))
+	if err != nil {
+		t.Fatalf("Failed to stat link.txt: %v", err)
+	}
+	if !os.SameFile(a, link) {
+		t.Errorf("Expected link.txt to be a hard link to a.txt")
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "symlink")); err != nil || target != "a.txt" {
+		t.Errorf("Expected symlink to point to a.txt, got %q %v", target, err)
+	}
+}
+
+func TestPlakarExporterEmpty(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+	exp := NewPlakarExporter(repo, &snapshot.BackupOptions{MaxConcurrency: 4})
+	if err := exp.Close(); err != nil {
+		t.Fatalf("Failed to close exporter: %v", err)
+	}
+	if exp.SnapshotID() != (objects.Checksum{}) {
+		t.Errorf("Expected no snapshot to be committed when nothing was exported")
+	}
+}
+
+func TestPlakarExporterAbort(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+	exp := NewPlakarExporter(repo, &snapshot.BackupOptions{MaxConcurrency: 4})
+	exportTree(t, exp)
+	exp.Abort()
+	if err := exp.Close(); err != nil {
+		t.Fatalf("Failed to close exporter: %v", err)
+	}
+
+	if exp.SnapshotID() != (objects.Checksum{}) {
+		t.Errorf("Expected no snapshot to be committed once aborted")
+	}
+	snapshots, err := repo.GetSnapshots()
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 0 {
+		t.Errorf("Expected no snapshot in the repository, got %d", len(snapshots))
+	}
+}
@@ -19,7 +19,9 @@ package s3
 import (
 	"context"
 	"io"
+	"io/fs"
 	"net/url"
+	"path"
 	"strings"
 
 	"github.com/PlakarKorp/plakar/objects"
@@ -78,6 +80,73 @@ func (p *S3Exporter) Root() string {
 	return p.rootDir
 }
 
+func (p *S3Exporter) key(pathname string) string {
+	return strings.TrimPrefix(pathname, p.rootDir+"/")
+}
+
+func (p *S3Exporter) Stat(pathname string) (*objects.FileInfo, error) {
+	info, err := p.minioClient.StatObject(context.Background(),
+		strings.TrimPrefix(p.rootDir, "/"), p.key(pathname), minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+	fileinfo := objects.NewFileInfo(path.Base(pathname), info.Size, 0644, info.LastModified, 0, 0, 0, 0, 1)
+	return &fileinfo, nil
+}
+
+func (p *S3Exporter) ReadDir(pathname string) ([]string, error) {
+	prefix := p.key(pathname) + "/"
+	if pathname == p.rootDir {
+		prefix = ""
+	}
+
+	names := make([]string, 0)
+	for object := range p.minioClient.ListObjects(context.Background(),
+		strings.TrimPrefix(p.rootDir, "/"), minio.ListObjectsOptions{Prefix: prefix}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(object.Key, prefix), "/"))
+	}
+	return names, nil
+}
+
+// Remove removes the object at pathname and those below it, as directories
+// only exist as key prefixes.
+func (p *S3Exporter) Remove(pathname string) error {
+	bucket := strings.TrimPrefix(p.rootDir, "/")
+	key := p.key(pathname)
+
+	if err := p.minioClient.RemoveObject(context.Background(), bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			return err
+		}
+	}
+	for object := range p.minioClient.ListObjects(context.Background(), bucket,
+		minio.ListObjectsOptions{Prefix: key + "/", Recursive: true}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		if err := p.minioClient.RemoveObject(context.Background(), bucket, object.Key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *S3Exporter) Open(pathname string) (io.ReadCloser, error) {
+	return p.minioClient.GetObject(context.Background(),
+		strings.TrimPrefix(p.rootDir, "/"), p.key(pathname), minio.GetObjectOptions{})
+}
+
+// OpenFile is not supported, objects cannot be updated in place.
+func (p *S3Exporter) OpenFile(pathname string) (exporter.File, error) {
+	return nil, exporter.ErrNotSupported
+}
+
 func (p *S3Exporter) CreateDirectory(pathname string) error {
 	return nil
 }
@@ -85,15 +154,51 @@ func (p *S3Exporter) CreateDirectory(pathname string) error {
 func (p *S3Exporter) StoreFile(pathname string, fp io.Reader) error {
 	_, err := p.minioClient.PutObject(context.Background(),
 		strings.TrimPrefix(p.rootDir, "/"),
-		strings.TrimPrefix(pathname, p.rootDir+"/"),
+		p.key(pathname),
 		fp, -1, minio.PutObjectOptions{})
 	return err
 }
 
+// CreateSymlink is not supported, object stores have no notion of links.
+func (p *S3Exporter) CreateSymlink(pathname string, target string) error {
+	return exporter.ErrNotSupported
+}
+
+// CreateLink emulates hard links by copying the object already restored.
+func (p *S3Exporter) CreateLink(oldname string, newname string) error {
+	bucket := strings.TrimPrefix(p.rootDir, "/")
+	_, err := p.minioClient.CopyObject(context.Background(),
+		minio.CopyDestOptions{
+			Bucket: bucket,
+			Object: p.key(newname),
+		},
+		minio.CopySrcOptions{
+			Bucket: bucket,
+			Object: p.key(oldname),
+		})
+	return err
+}
+
+func (p *S3Exporter) CreateSpecial(pathname string, fileinfo *objects.FileInfo) error {
+	return exporter.ErrNotSupported
+}
+
 func (p *S3Exporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
 	return nil
 }
 
+func (p *S3Exporter) SetOwner(pathname string, fileinfo *objects.FileInfo, numeric bool) error {
+	return nil
+}
+
+func (p *S3Exporter) SetTimes(pathname string, fileinfo *objects.FileInfo) error {
+	return nil
+}
+
+func (p *S3Exporter) SetExtendedAttribute(pathname string, name string, value []byte) error {
+	return nil
+}
+
 func (p *S3Exporter) Close() error {
 	return nil
 }
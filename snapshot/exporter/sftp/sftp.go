@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/pkg/sftp"
+)
+
+type SFTPExporter struct {
+	rootDir string
+	client  *sftp.Client
+	cmd     *exec.Cmd
+}
+
+func init() {
+	exporter.Register("sftp", NewSFTPExporter)
+}
+
+// sshArgs returns the arguments of the ssh client to run the sftp
+// subsystem on the host of location.  The host ends the options so that
+// it cannot be taken for one.
+func sshArgs(location *url.URL) ([]string, error) {
+	host := location.Hostname()
+	if host == "" {
+		return nil, fmt.Errorf("missing host in %s", location)
+	}
+	if strings.HasPrefix(host, "-") {
+		return nil, fmt.Errorf("invalid host %q", host)
+	}
+
+	args := []string{"-s"}
+	if location.Port() != "" {
+		args = append(args, "-p", location.Port())
+	}
+	if location.User != nil {
+		args = append(args, "-l", location.User.Username())
+	}
+	return append(args, "--", host, "sftp"), nil
+}
+
+// connectToSFTP runs the sftp subsystem over the ssh client, which takes
+// care of host keys, agents and the user configuration.
+func connectToSFTP(location *url.URL) (*sftp.Client, *exec.Cmd, error) {
+	args, err := sshArgs(location)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = os.Stderr
+	wr, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	rd, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	client, err := sftp.NewClientPipe(rd, wr)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, nil, err
+	}
+	return client, cmd, nil
+}
+
+func NewSFTPExporter(location string) (exporter.Exporter, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	client, cmd, err := connectToSFTP(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	exp := newSFTPExporter(client, path.Clean("/"+parsed.Path))
+	exp.cmd = cmd
+	return exp, nil
+}
+
+func newSFTPExporter(client *sftp.Client, rootDir string) *SFTPExporter {
+	return &SFTPExporter{
+		rootDir: rootDir,
+		client:  client,
+	}
+}
+
+func (p *SFTPExporter) Root() string {
+	return p.rootDir
+}
+
+func (p *SFTPExporter) Stat(pathname string) (*objects.FileInfo, error) {
+	info, err := p.client.Lstat(pathname)
+	if err != nil {
+		return nil, err
+	}
+	fileinfo := objects.FileInfoFromStat(info)
+	return &fileinfo, nil
+}
+
+func (p *SFTPExporter) ReadDir(pathname string) ([]string, error) {
+	entries, err := p.client.ReadDir(pathname)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func (p *SFTPExporter) Remove(pathname string) error {
+	err := p.client.RemoveAll(pathname)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (p *SFTPExporter) Open(pathname string) (io.ReadCloser, error) {
+	return p.client.Open(pathname)
+}
+
+func (p *SFTPExporter) OpenFile(pathname string) (exporter.File, error) {
+	return p.client.OpenFile(pathname, os.O_RDWR)
+}
+
+func (p *SFTPExporter) CreateDirectory(pathname string) error {
+	return p.client.MkdirAll(pathname)
+}
+
+func (p *SFTPExporter) StoreFile(pathname string, fp io.Reader) error {
+	f, err := p.client.Create(pathname)
+	if err != nil {
+		return err
+	}
+
+	if sparse, isSparse := fp.(exporter.SparseReader); isSparse {
+		err = exporter.StoreSparse(f, sparse)
+	} else {
+		_, err = f.ReadFrom(fp)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (p *SFTPExporter) CreateSymlink(pathname string, target string) error {
+	return p.client.Symlink(target, pathname)
+}
+
+func (p *SFTPExporter) CreateLink(oldname string, newname string) error {
+	return p.client.Link(oldname, newname)
+}
+
+// CreateSpecial is not supported, the protocol cannot create devices,
+// pipes or sockets.
+func (p *SFTPExporter) CreateSpecial(pathname string, fileinfo *objects.FileInfo) error {
+	return exporter.ErrNotSupported
+}
+
+func (p *SFTPExporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
+	// symlink permissions are not meaningful and chmod would follow the link
+	if fileinfo.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return p.client.Chmod(pathname, fileinfo.Mode())
+}
+
+// SetOwner restores the numeric owner of pathname, names cannot be resolved
+// on the server.  Unless logged in as the superuser, the files keep the
+// ownership of the remote user.
+func (p *SFTPExporter) SetOwner(pathname string, fileinfo *objects.FileInfo, numeric bool) error {
+	if fileinfo.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	err := p.client.Chown(pathname, int(fileinfo.Uid()), int(fileinfo.Gid()))
+	if errors.Is(err, os.ErrPermission) {
+		return nil
+	}
+	return err
+}
+
+// SetTimes sets both the access and modification times to the modification
+// time of the snapshot, the only one recorded.
+func (p *SFTPExporter) SetTimes(pathname string, fileinfo *objects.FileInfo) error {
+	// the protocol has no way to set the times of a symlink itself
+	if fileinfo.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return p.client.Chtimes(pathname, fileinfo.ModTime(), fileinfo.ModTime())
+}
+
+func (p *SFTPExporter) SetExtendedAttribute(pathname string, name string, value []byte) error {
+	return nil
+}
+
+func (p *SFTPExporter) Close() error {
+	err := p.client.Close()
+	if p.cmd != nil {
+		p.cmd.Wait()
+	}
+	return err
+}
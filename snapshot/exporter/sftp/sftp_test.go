@@ -0,0 +1,165 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/pkg/sftp"
+)
+
+type pipe struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// newTestExporter connects an exporter to an in-process server exporting
+// the local filesystem, rooted at a temporary directory.
+func newTestExporter(t *testing.T) (*SFTPExporter, string) {
+	c2s, clientWr := io.Pipe()
+	clientRd, s2c := io.Pipe()
+
+	server, err := sftp.NewServer(pipe{c2s, s2c})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientRd, clientWr)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	root := t.TempDir()
+	exp := newSFTPExporter(client, root)
+	t.Cleanup(func() {
+		server.Close()
+		exp.Close()
+	})
+	return exp, root
+}
+
+func TestSFTPExporter(t *testing.T) {
+	exp, root := newTestExporter(t)
+
+	dir := filepath.Join(root, "a", "b")
+	if err := exp.CreateDirectory(dir); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	pathname := filepath.Join(dir, "file.txt")
+	if err := exp.StoreFile(pathname, strings.NewReader("hello world")); err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+	content, err := os.ReadFile(pathname)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != "hello world" {
+		t.Errorf("Expected content %q, got %q", "hello world", content)
+	}
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fileinfo := objects.NewFileInfo("file.txt", 11, 0600, mtime, 0, 0, 0, 0, 1)
+	if err := exp.SetPermissions(pathname, &fileinfo); err != nil {
+		t.Fatalf("Failed to set permissions: %v", err)
+	}
+	if err := exp.SetTimes(pathname, &fileinfo); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+	info, err := exp.Stat(pathname)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %o", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("Expected mtime %v, got %v", mtime, info.ModTime())
+	}
+
+	if err := exp.CreateSymlink(filepath.Join(dir, "link"), "file.txt"); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "link")); err != nil || target != "file.txt" {
+		t.Errorf("Expected symlink to file.txt, got %q (%v)", target, err)
+	}
+
+	names, err := exp.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("Expected 2 entries, got %v", names)
+	}
+
+	fp, err := exp.OpenFile(pathname)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	if _, err := fp.WriteAt([]byte("HELLO"), 0); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := fp.Truncate(5); err != nil {
+		t.Fatalf("Failed to truncate file: %v", err)
+	}
+	fp.Close()
+
+	rd, err := exp.Open(pathname)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	var buf bytes.Buffer
+	io.Copy(&buf, rd)
+	rd.Close()
+	if buf.String() != "HELLO" {
+		t.Errorf("Expected content %q, got %q", "HELLO", buf.String())
+	}
+
+	if err := exp.Remove(filepath.Join(root, "a")); err != nil {
+		t.Fatalf("Failed to remove directory: %v", err)
+	}
+	if _, err := exp.Stat(filepath.Join(root, "a")); !os.IsNotExist(err) {
+		t.Errorf("Expected directory to be removed, got %v", err)
+	}
+}
+
+func TestSSHArgs(t *testing.T) {
+	tests := []struct {
+		location string
+		expected []string
+	}{
+		{"sftp://example.com/srv", []string{"-s", "--", "example.com", "sftp"}},
+		{"sftp://backup@example.com:2222/srv", []string{"-s", "-p", "2222", "-l", "backup", "--", "example.com", "sftp"}},
+		{"sftp://-oProxyCommand=id@example.com/srv", []string{"-s", "-l", "-oProxyCommand=id", "--", "example.com", "sftp"}},
+	}
+	for _, test := range tests {
+		location, err := url.Parse(test.location)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", test.location, err)
+		}
+		args, err := sshArgs(location)
+		if err != nil {
+			t.Fatalf("Failed to build arguments for %s: %v", test.location, err)
+		}
+		if strings.Join(args, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("Expected %v for %s, got %v", test.expected, test.location, args)
+		}
+	}
+
+	for _, location := range []string{"sftp://-oProxyCommand=id/tmp", "sftp:///tmp"} {
+		parsed, err := url.Parse(location)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", location, err)
+		}
+		if args, err := sshArgs(parsed); err == nil {
+			t.Errorf("Expected %s to be rejected, got %v", location, args)
+		}
+	}
+}
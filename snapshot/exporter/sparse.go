@@ -0,0 +1,45 @@
+package exporter
+
+import (
+	"io"
+)
+
+// SparseFile is a file written from start to end that can seek over the
+// holes of its content.
+type SparseFile interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// StoreSparse writes the content of rd to f, seeking over its holes so that
+// they take no space where the destination supports it.
+func StoreSparse(f SparseFile, rd SparseReader) error {
+	buf := make([]byte, 256*1024)
+	var size int64
+	for {
+		if hole := rd.SkipHole(); hole > 0 {
+			if _, err := f.Seek(hole, io.SeekCurrent); err != nil {
+				return err
+			}
+			size += hole
+			continue
+		}
+
+		n, err := rd.Read(buf)
+		if n > 0 {
+			if _, err := f.Write(buf[:n]); err != nil {
+				return err
+			}
+			size += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// a trailing hole is only materialized by setting the size
+	return f.Truncate(size)
+}
@@ -0,0 +1,526 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package staging keeps what is exported to it as a tree of entries, for
+// exporters that can only write their destination once the tree is
+// complete.  The content of files is not copied when it comes from a
+// repository, it is read from there when the entry is opened.
+package staging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/packfile"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+)
+
+// Entry is an entry of the tree.  The content of files is either an object
+// of a repository, along with the snapshot it was read from if known, or
+// spooled to a temporary file.
+type Entry struct {
+	Record     importer.ScanRecord
+	repository *repository.Repository
+	snapshot   *snapshot.Snapshot
+	object     *objects.Object
+	spool      string
+}
+
+// snapshotReader is implemented by the readers of the content of a
+// snapshot.
+type snapshotReader interface {
+	Snapshot() *snapshot.Snapshot
+}
+
+// Staging implements every method of exporter.Exporter but Close, which is
+// left to the exporters embedding it.
+type Staging struct {
+	mu      sync.Mutex
+	entries map[string]*Entry
+	spools  []string
+	inode   uint64
+}
+
+func New() *Staging {
+	s := &Staging{
+		entries: make(map[string]*Entry),
+	}
+	s.entries["/"] = s.newEntry("/", importer.RecordTypeDirectory, os.ModeDir|0755)
+	return s
+}
+
+func (s *Staging) Root() string {
+	return "/"
+}
+
+// Empty reports whether nothing was exported.
+func (s *Staging) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries) == 1
+}
+
+// Entries returns the entries sorted by pathname, with the link count of
+// each set to the number of entries sharing its inode.
+func (s *Staging) Entries() []*Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nlinks := make(map[uint64]uint16)
+	for _, e := range s.entries {
+		nlinks[e.Record.FileInfo.Ino()]++
+	}
+
+	entries := make([]*Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		e.Record.FileInfo.Lnlink = nlinks[e.Record.FileInfo.Ino()]
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Record.Pathname < entries[j].Record.Pathname
+	})
+	return entries
+}
+
+// Lookup returns the entry at pathname.
+func (s *Staging) Lookup(pathname string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookup(pathname)
+}
+
+// Cleanup removes the spooled contents.
+func (s *Staging) Cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, spool := range s.spools {
+		os.Remove(spool)
+	}
+	s.spools = nil
+}
+
+func (s *Staging) newEntry(pathname string, recordType importer.RecordType, mode os.FileMode) *Entry {
+	s.inode++
+	fileinfo := objects.NewFileInfo(path.Base(pathname), 0, mode, time.Now(), 0, s.inode,
+		uint64(os.Getuid()), uint64(os.Getgid()), 1)
+	return &Entry{
+		Record: importer.ScanRecord{
+			Type:               recordType,
+			Pathname:           pathname,
+			FileInfo:           fileinfo,
+			ExtendedAttributes: make(map[string][]byte),
+		},
+	}
+}
+
+// put adds e, creating the directories above it as needed.  It must be
+// called with the lock held.
+func (s *Staging) put(e *Entry) error {
+	if err := s.mkdirAll(path.Dir(e.Record.Pathname)); err != nil {
+		return err
+	}
+	s.entries[e.Record.Pathname] = e
+	return nil
+}
+
+func (s *Staging) mkdirAll(pathname string) error {
+	if e, exists := s.entries[pathname]; exists {
+		if !e.Record.FileInfo.Mode().IsDir() {
+			return fmt.Errorf("%s: not a directory", pathname)
+		}
+		return nil
+	}
+	return s.put(s.newEntry(pathname, importer.RecordTypeDirectory, os.ModeDir|0755))
+}
+
+func (s *Staging) lookup(pathname string) (*Entry, error) {
+	e, exists := s.entries[path.Clean(pathname)]
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	return e, nil
+}
+
+func (s *Staging) Stat(pathname string) (*objects.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.lookup(pathname)
+	if err != nil {
+		return nil, err
+	}
+	fileinfo := e.Record.FileInfo
+	return &fileinfo, nil
+}
+
+func (s *Staging) ReadDir(pathname string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pathname = path.Clean(pathname)
+	names := make([]string, 0)
+	for name := range s.entries {
+		if name != "/" && path.Dir(name) == pathname {
+			names = append(names, path.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Staging) Remove(pathname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pathname = path.Clean(pathname)
+	for name := range s.entries {
+		if name == pathname || strings.HasPrefix(name, pathname+"/") {
+			delete(s.entries, name)
+		}
+	}
+	return nil
+}
+
+func (s *Staging) Open(pathname string) (io.ReadCloser, error) {
+	e, err := s.Lookup(pathname)
+	if err != nil {
+		return nil, err
+	}
+	return e.Open()
+}
+
+// Open returns a reader over the content of a file.  Content coming from a
+// repository is read through a reader implementing exporter.ObjectReader
+// and importer.SparseReader.
+func (e *Entry) Open() (io.ReadCloser, error) {
+	switch {
+	case e.Record.FileInfo.Mode().IsDir():
+		return nil, fmt.Errorf("%s: is a directory", e.Record.Pathname)
+	case e.spool != "":
+		return os.Open(e.spool)
+	default:
+		return &objectReader{repository: e.repository, object: e.object}, nil
+	}
+}
+
+// Contents reads the contents of entries in a planned order.  The objects
+// of the entries read from a snapshot are fetched through a single stream
+// per snapshot, so that reads are coalesced across files.
+type Contents struct {
+	readers map[*snapshot.Snapshot]*snapshot.ObjectsReader
+}
+
+// NewContents plans the reading of the contents of entries, which must then
+// all be opened in that order.
+func NewContents(entries []*Entry) *Contents {
+	planned := make(map[*snapshot.Snapshot][]*objects.Object)
+	for _, e := range entries {
+		if e.snapshot != nil && e.spool == "" {
+			planned[e.snapshot] = append(planned[e.snapshot], e.object)
+		}
+	}
+
+	c := &Contents{readers: make(map[*snapshot.Snapshot]*snapshot.ObjectsReader)}
+	for snap, objs := range planned {
+		c.readers[snap] = snap.NewObjectsReader(objs)
+	}
+	return c
+}
+
+// Open returns a reader over the content of e, the next planned entry.  It
+// is only valid until the next call.
+func (c *Contents) Open(e *Entry) (io.ReadCloser, error) {
+	if e.snapshot == nil || e.spool != "" {
+		return e.Open()
+	}
+	rd, err := c.readers[e.snapshot].NextObject()
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(rd), nil
+}
+
+func (c *Contents) Close() error {
+	var err error
+	for _, rd := range c.readers {
+		if closeErr := rd.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// OpenFile is not supported, the tree is written from scratch.
+func (s *Staging) OpenFile(pathname string) (exporter.File, error) {
+	return nil, exporter.ErrNotSupported
+}
+
+func (s *Staging) CreateDirectory(pathname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mkdirAll(path.Clean(pathname))
+}
+
+// StoreFile records the object read by fp when it comes from a repository.
+// Any other content is spooled to a temporary file.
+func (s *Staging) StoreFile(pathname string, fp io.Reader) error {
+	pathname = path.Clean(pathname)
+
+	s.mu.Lock()
+	e := s.newEntry(pathname, importer.RecordTypeFile, 0644)
+	s.mu.Unlock()
+
+	if src, ok := fp.(exporter.ObjectReader); ok {
+		e.repository, e.object = src.Repository(), src.Object()
+		if src, ok := fp.(snapshotReader); ok {
+			e.snapshot = src.Snapshot()
+		}
+		if e.object != nil {
+			for _, chunk := range e.object.Chunks {
+				e.Record.FileInfo.Lsize += int64(chunk.Length)
+			}
+		}
+	} else {
+		spool, err := os.CreateTemp("", "plakar-export-")
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.spools = append(s.spools, spool.Name())
+		s.mu.Unlock()
+
+		e.spool = spool.Name()
+		e.Record.FileInfo.Lsize, err = io.Copy(spool, fp)
+		if err != nil {
+			spool.Close()
+			return err
+		}
+		if err := spool.Close(); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(e)
+}
+
+func (s *Staging) CreateSymlink(pathname string, target string) error {
+	pathname = path.Clean(pathname)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.newEntry(pathname, importer.RecordTypeSymlink, os.ModeSymlink|0777)
+	e.Record.Target = target
+	e.Record.FileInfo.Lsize = int64(len(target))
+	return s.put(e)
+}
+
+// CreateLink adds newname as another name of the inode of oldname.
+func (s *Staging) CreateLink(oldname string, newname string) error {
+	newname = path.Clean(newname)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.lookup(oldname)
+	if err != nil {
+		return err
+	}
+	e := *old
+	e.Record.Pathname = newname
+	e.Record.FileInfo.Lname = path.Base(newname)
+	e.Record.ExtendedAttributes = make(map[string][]byte)
+	for name, value := range old.Record.ExtendedAttributes {
+		e.Record.ExtendedAttributes[name] = value
+	}
+	return s.put(&e)
+}
+
+func (s *Staging) CreateSpecial(pathname string, fileinfo *objects.FileInfo) error {
+	pathname = path.Clean(pathname)
+
+	var recordType importer.RecordType
+	switch mode := fileinfo.Mode(); {
+	case mode&os.ModeNamedPipe != 0:
+		recordType = importer.RecordTypePipe
+	case mode&os.ModeSocket != 0:
+		recordType = importer.RecordTypeSocket
+	case mode&os.ModeDevice != 0:
+		recordType = importer.RecordTypeDevice
+	default:
+		return fmt.Errorf("%s: not a special file", pathname)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.newEntry(pathname, recordType, fileinfo.Mode())
+	e.Record.FileInfo.Lrdev = fileinfo.Rdev()
+	return s.put(e)
+}
+
+// update calls fn on the entry at pathname with the lock held.
+func (s *Staging) update(pathname string, fn func(e *Entry)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.lookup(pathname)
+	if err != nil {
+		return err
+	}
+	fn(e)
+	return nil
+}
+
+func (s *Staging) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
+	const perm = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	return s.update(pathname, func(e *Entry) {
+		e.Record.FileInfo.Lmode = e.Record.FileInfo.Lmode&^perm | fileinfo.Mode()&perm
+	})
+}
+
+// SetOwner records both the ids and the names, it is up to the reader of
+// the tree to pick one.
+func (s *Staging) SetOwner(pathname string, fileinfo *objects.FileInfo, numeric bool) error {
+	return s.update(pathname, func(e *Entry) {
+		e.Record.FileInfo.Luid = fileinfo.Uid()
+		e.Record.FileInfo.Lgid = fileinfo.Gid()
+		e.Record.FileInfo.Lusername = fileinfo.Username()
+		e.Record.FileInfo.Lgroupname = fileinfo.Groupname()
+	})
+}
+
+func (s *Staging) SetTimes(pathname string, fileinfo *objects.FileInfo) error {
+	return s.update(pathname, func(e *Entry) {
+		e.Record.FileInfo.LmodTime = fileinfo.ModTime()
+	})
+}
+
+func (s *Staging) SetExtendedAttribute(pathname string, name string, value []byte) error {
+	return s.update(pathname, func(e *Entry) {
+		e.Record.ExtendedAttributes[name] = value
+	})
+}
+
+// objectReader reads an object chunk by chunk from the repository holding
+// it, a nil object reads as empty.  It implements exporter.ObjectReader so
+// that a backup copies the chunks when it can instead of reading them, and
+// importer.SparseReader so that holes are kept when it can't.
+type objectReader struct {
+	repository *repository.Repository
+	object     *objects.Object
+	chunk      int
+	current    io.Reader
+	offset     int64
+}
+
+func (rd *objectReader) Repository() *repository.Repository {
+	return rd.repository
+}
+
+func (rd *objectReader) Object() *objects.Object {
+	return rd.object
+}
+
+func (rd *objectReader) Read(p []byte) (int, error) {
+	for {
+		if rd.current != nil {
+			n, err := rd.current.Read(p)
+			rd.offset += int64(n)
+			if err == io.EOF {
+				rd.current = nil
+				if n == 0 {
+					continue
+				}
+				err = nil
+			}
+			return n, err
+		}
+
+		if rd.object == nil || rd.chunk == len(rd.object.Chunks) {
+			return 0, io.EOF
+		}
+		chunk := rd.object.Chunks[rd.chunk]
+		rd.chunk++
+
+		if chunk.IsHole() {
+			rd.current = io.LimitReader(snapshot.ZeroReader{}, int64(chunk.Length))
+			continue
+		}
+		blob, err := rd.repository.GetBlob(packfile.TYPE_CHUNK, chunk.Checksum)
+		if err != nil {
+			return 0, err
+		}
+		rd.current = blob
+	}
+}
+
+// Holes returns the runs of hole chunks of the object.
+func (rd *objectReader) Holes() ([]importer.Hole, error) {
+	if rd.object == nil {
+		return nil, nil
+	}
+	return snapshot.ObjectHoles(rd.object), nil
+}
+
+// Seek moves to the chunk holding the offset, skipping the start of that
+// chunk if the offset is not on a chunk boundary.
+func (rd *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rd.offset
+	default:
+		return 0, fmt.Errorf("seek: unsupported whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek: negative offset %d", offset)
+	}
+
+	rd.chunk, rd.current, rd.offset = 0, nil, 0
+	if rd.object != nil {
+		for rd.chunk < len(rd.object.Chunks) {
+			length := int64(rd.object.Chunks[rd.chunk].Length)
+			if rd.offset+length > offset {
+				break
+			}
+			rd.offset += length
+			rd.chunk++
+		}
+	}
+	if skip := offset - rd.offset; skip > 0 {
+		if _, err := io.CopyN(io.Discard, rd, skip); err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+	return offset, nil
+}
+
+func (rd *objectReader) Close() error {
+	return nil
+}
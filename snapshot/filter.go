@@ -0,0 +1,137 @@
+package snapshot
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/search"
+	"github.com/PlakarKorp/plakar/snapshot/vfs"
+	"github.com/gobwas/glob"
+)
+
+// PathMapping relocates the entries below Source, a pathname of the
+// snapshot, to Destination.
+type PathMapping struct {
+	Source      string
+	Destination string
+}
+
+// ParsePathMapping parses a src=dst mapping.
+func ParsePathMapping(mapping string) (PathMapping, error) {
+	src, dst, found := strings.Cut(mapping, "=")
+	if !found || src == "" || dst == "" {
+		return PathMapping{}, fmt.Errorf("invalid path mapping %q, must be src=dst", mapping)
+	}
+	return PathMapping{Source: path.Clean("/" + src), Destination: path.Clean("/" + dst)}, nil
+}
+
+// PathFilter selects the entries of a snapshot to restore or export and
+// where they land.  Include and exclude globs are matched against the full
+// pathname, an excluded directory is skipped with all its content.  The
+// search query only applies to files.
+type PathFilter struct {
+	includes []glob.Glob
+	excludes []glob.Glob
+	query    *search.Query
+	mappings []PathMapping
+}
+
+func NewPathFilter(includes []string, excludes []string, query string, mappings []PathMapping) (*PathFilter, error) {
+	filter := &PathFilter{mappings: mappings}
+
+	var err error
+	if filter.includes, err = compileExcludes(includes); err != nil {
+		return nil, err
+	}
+	if filter.excludes, err = compileExcludes(excludes); err != nil {
+		return nil, err
+	}
+	if query != "" {
+		if filter.query, err = search.Parse(query); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+// Selective reports whether the filter only keeps some of the files, in
+// which case directories are only worth creating for the files they hold.
+func (filter *PathFilter) Selective() bool {
+	return filter != nil && (len(filter.includes) != 0 || filter.query != nil)
+}
+
+// Excluded reports whether pathname or one of its parents is excluded.
+func (filter *PathFilter) Excluded(pathname string) bool {
+	if filter == nil {
+		return false
+	}
+	for {
+		for _, exclude := range filter.excludes {
+			if exclude.Match(pathname) {
+				return true
+			}
+		}
+		if pathname == "/" || pathname == "." {
+			return false
+		}
+		pathname = path.Dir(pathname)
+	}
+}
+
+// Match reports whether entry is selected by the filter.  A directory
+// matches when it is not excluded and, for selective filters, when an
+// include glob matches it.
+func (filter *PathFilter) Match(snap *Snapshot, pathname string, entry vfs.FSEntry) (bool, error) {
+	if filter == nil {
+		return true, nil
+	}
+	if filter.Excluded(pathname) {
+		return false, nil
+	}
+
+	if len(filter.includes) != 0 {
+		included := false
+		for _, include := range filter.includes {
+			if include.Match(pathname) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false, nil
+		}
+	}
+
+	if filter.query == nil {
+		return true, nil
+	}
+	fileEntry, isFile := entry.(*vfs.FileEntry)
+	if !isFile {
+		return len(filter.includes) != 0, nil
+	}
+	return snap.searchMatch(fileEntry, *filter.query)
+}
+
+// Map returns the pathname an entry lands at once the mappings are
+// applied, the longest matching source wins.  The boolean is false when no
+// mapping applies.
+func (filter *PathFilter) Map(pathname string) (string, bool) {
+	if filter == nil {
+		return pathname, false
+	}
+
+	var best *PathMapping
+	for i, mapping := range filter.mappings {
+		if pathname != mapping.Source && !isBelow(pathname, mapping.Source) {
+			continue
+		}
+		if best == nil || len(mapping.Source) > len(best.Source) {
+			best = &filter.mappings[i]
+		}
+	}
+	if best == nil {
+		return pathname, false
+	}
+	return path.Join(best.Destination, strings.TrimPrefix(pathname, best.Source)), true
+}
@@ -0,0 +1,140 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+)
+
+// restoredFiles lists the files and directories below dir.
+func restoredFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	names := make([]string, 0)
+	err := filepath.Walk(dir, func(pathname string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if pathname != dir {
+			name, _ := filepath.Rel(dir, pathname)
+			if info.IsDir() {
+				name += "/"
+			}
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk %s: %v", dir, err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRestoreFilter(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"a.go":       "package a",
+		"b.txt":      "some text",
+		"sub/c.go":   "package sub, a little longer",
+		"sub/d.txt":  "more text",
+		"skip/e.go":  "package skip",
+		"empty/f.md": "nothing",
+	})
+	snap := backupTree(t, repo, src, nil)
+
+	for _, test := range []struct {
+		name     string
+		includes []string
+		excludes []string
+		query    string
+		mappings []string
+		expected []string
+	}{
+		{
+			name:     "include",
+			includes: []string{"**.go"},
+			expected: []string{"a.go", "skip/", "skip/e.go", "sub/", "sub/c.go"},
+		},
+		{
+			name:     "exclude",
+			excludes: []string{"**.txt", src + "/skip"},
+			expected: []string{"a.go", "empty/", "empty/f.md", "sub/", "sub/c.go"},
+		},
+		{
+			name:     "include and exclude",
+			includes: []string{"**.go"},
+			excludes: []string{src + "/skip"},
+			expected: []string{"a.go", "sub/", "sub/c.go"},
+		},
+		{
+			name:     "query",
+			query:    "size>12",
+			expected: []string{"sub/", "sub/c.go"},
+		},
+		{
+			name:     "map",
+			includes: []string{src + "/sub/*"},
+			mappings: []string{src + "/sub=/moved"},
+			expected: []string{"moved/", "moved/c.go", "moved/d.txt"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mappings := make([]PathMapping, 0)
+			for _, mapping := range test.mappings {
+				parsed, err := ParsePathMapping(mapping)
+				if err != nil {
+					t.Fatalf("Failed to parse mapping: %v", err)
+				}
+				mappings = append(mappings, parsed)
+			}
+			filter, err := NewPathFilter(test.includes, test.excludes, test.query, mappings)
+			if err != nil {
+				t.Fatalf("Failed to create filter: %v", err)
+			}
+
+			dst := t.TempDir()
+			report := restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true, Filter: filter})
+			if len(report.Failed) != 0 {
+				t.Fatalf("Expected no failures, got %v", report.Failed)
+			}
+			if got := restoredFiles(t, dst); strings.Join(got, " ") != strings.Join(test.expected, " ") {
+				t.Errorf("Expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestPathFilterExcluded(t *testing.T) {
+	filter, err := NewPathFilter(nil, []string{"/data/cache", "**.tmp"}, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	for pathname, expected := range map[string]bool{
+		"/data":                false,
+		"/data/cache":          true,
+		"/data/cache/file":     true,
+		"/data/cache/sub/file": true,
+		"/data/cached":         false,
+		"/data/file.tmp":       true,
+		"/data/file.tmp/child": true,
+	} {
+		if excluded := filter.Excluded(pathname); excluded != expected {
+			t.Errorf("Expected Excluded(%s) to be %v, got %v", pathname, expected, excluded)
+		}
+	}
+
+	var none *PathFilter
+	if none.Excluded("/data") || none.Selective() {
+		t.Errorf("Expected a nil filter to keep everything")
+	}
+	if _, err := ParsePathMapping("/src"); err == nil {
+		t.Errorf("Expected a mapping without destination to be rejected")
+	}
+}
@@ -0,0 +1,101 @@
+package header
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Filter selects snapshots on their classification, empty fields match any
+// snapshot and Meta entries must all be present with the same value.
+type Filter struct {
+	Name        string
+	Category    string
+	Environment string
+	Perimeter   string
+	Tag         string
+	Meta        []KeyValue
+}
+
+// ParseKeyValue parses a key=value pair as given to -meta.
+func ParseKeyValue(s string) (KeyValue, error) {
+	key, value, found := strings.Cut(s, "=")
+	if !found || key == "" {
+		return KeyValue{}, fmt.Errorf("invalid metadata %q, must be key=value", s)
+	}
+	return KeyValue{Key: key, Value: value}, nil
+}
+
+func (f *Filter) IsEmpty() bool {
+	return f.Name == "" && f.Category == "" && f.Environment == "" &&
+		f.Perimeter == "" && f.Tag == "" && len(f.Meta) == 0
+}
+
+func (f *Filter) Match(h *Header) bool {
+	if f.Name != "" && h.Name != f.Name {
+		return false
+	}
+	if f.Category != "" && h.Category != f.Category {
+		return false
+	}
+	if f.Environment != "" && h.Environment != f.Environment {
+		return false
+	}
+	if f.Perimeter != "" && h.Perimeter != f.Perimeter {
+		return false
+	}
+	if f.Tag != "" {
+		found := false
+		for _, tag := range h.Tags {
+			if tag == f.Tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, kv := range f.Meta {
+		if !h.hasContext(KeyValue{Key: MetaPrefix + kv.Key, Value: kv.Value}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Header) hasContext(kv KeyValue) bool {
+	for _, entry := range h.Context {
+		if entry == kv {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseGroupBy checks that snapshots can be grouped on field, one of name,
+// category, environment, perimeter or meta.<key> for a metadata entry.
+func ParseGroupBy(field string) (string, error) {
+	switch field {
+	case "name", "category", "environment", "perimeter":
+		return field, nil
+	}
+	if key, found := strings.CutPrefix(field, "meta."); found && key != "" {
+		return field, nil
+	}
+	return "", fmt.Errorf("invalid group %q, must be name, category, environment, perimeter or meta.<key>", field)
+}
+
+// GetField returns the value of a field accepted by ParseGroupBy.
+func (h *Header) GetField(field string) string {
+	switch field {
+	case "name":
+		return h.Name
+	case "category":
+		return h.Category
+	case "environment":
+		return h.Environment
+	case "perimeter":
+		return h.Perimeter
+	}
+	return h.GetMeta(strings.TrimPrefix(field, "meta."))
+}
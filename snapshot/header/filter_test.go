@@ -0,0 +1,76 @@
+package header
+
+import (
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	hdr := NewHeader("db", [32]byte{0x1})
+	hdr.Environment = "prod"
+	hdr.Tags = []string{"daily"}
+	hdr.SetMeta("owner", "team-a")
+
+	tests := []struct {
+		filter Filter
+		match  bool
+	}{
+		{Filter{}, true},
+		{Filter{Name: "db", Environment: "prod"}, true},
+		{Filter{Environment: "staging"}, false},
+		{Filter{Category: "default", Perimeter: "default"}, true},
+		{Filter{Tag: "daily"}, true},
+		{Filter{Tag: "weekly"}, false},
+		{Filter{Meta: []KeyValue{{Key: "owner", Value: "team-a"}}}, true},
+		{Filter{Meta: []KeyValue{{Key: "Meta.owner", Value: "team-a"}}}, false},
+		{Filter{Meta: []KeyValue{{Key: "owner", Value: "team-b"}}}, false},
+	}
+	for i, test := range tests {
+		if match := test.filter.Match(hdr); match != test.match {
+			t.Errorf("Test %d failed: expected %v, got %v", i, test.match, match)
+		}
+	}
+}
+
+func TestParseKeyValue(t *testing.T) {
+	kv, err := ParseKeyValue("ticket=OPS-1=2")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if kv.Key != "ticket" || kv.Value != "OPS-1=2" {
+		t.Errorf("Unexpected key/value: %+v", kv)
+	}
+	for _, invalid := range []string{"ticket", "=value"} {
+		if _, err := ParseKeyValue(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestGetField(t *testing.T) {
+	hdr := NewHeader("db", [32]byte{0x1})
+	hdr.Environment = "prod"
+	hdr.SetMeta("owner", "team-a")
+
+	for field, expected := range map[string]string{
+		"name":        "db",
+		"environment": "prod",
+		"category":    "default",
+		"meta.owner":  "team-a",
+		"meta.site":   "",
+	} {
+		if _, err := ParseGroupBy(field); err != nil {
+			t.Errorf("Unexpected error for %q: %v", field, err)
+		}
+		if value := hdr.GetField(field); value != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, field, value)
+		}
+	}
+	if metas := hdr.GetMetas(); len(metas) != 1 || metas[0] != (KeyValue{Key: "owner", Value: "team-a"}) {
+		t.Errorf("Unexpected metadata: %v", metas)
+	}
+	for _, invalid := range []string{"tags", "meta."} {
+		if _, err := ParseGroupBy(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package header
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"sort"
 	"strings"
@@ -14,10 +15,28 @@ import (
 	"github.com/vmihailenco/msgpack/v5"
 )
 
+// Exclude is an exclusion in effect during the backup, Source is the
+// pathname of the ignore file holding Pattern or empty for patterns given
+// on the command line.
+type Exclude struct {
+	Source  string `msgpack:"source" json:"source"`
+	Pattern string `msgpack:"pattern" json:"pattern"`
+}
+
+func (e Exclude) String() string {
+	if e.Source == "" {
+		return fmt.Sprintf("pattern %q", e.Pattern)
+	}
+	return fmt.Sprintf("pattern %q of %s", e.Pattern, e.Source)
+}
+
 type Importer struct {
-	Type      string `msgpack:"type" json:"type"`
-	Origin    string `msgpack:"origin" json:"origin"`
-	Directory string `msgpack:"directory" json:"directory"`
+	Type       string    `msgpack:"type" json:"type"`
+	Origin     string    `msgpack:"origin" json:"origin"`
+	Directory  string    `msgpack:"directory" json:"directory"`
+	Excludes   []Exclude `msgpack:"excludes" json:"excludes"`
+	Command    string    `msgpack:"command" json:"command"`
+	ExitStatus int       `msgpack:"exit_status" json:"exit_status"`
 }
 
 type Identity struct {
@@ -54,12 +73,17 @@ type Header struct {
 	Tags            []string         `msgpack:"tags" json:"tags"`
 	Context         []KeyValue       `msgpack:"context" json:"context"`
 	Importer        Importer         `msgpack:"importer" json:"importer"`
+	Sources         []Importer       `msgpack:"sources" json:"sources"`
 	Root            objects.Checksum `msgpack:"root" json:"root"`
 	Errors          objects.Checksum `msgpack:"errors" json:"errors"`
 	Index           objects.Checksum `msgpack:"index" json:"index"`
 	Metadata        objects.Checksum `msgpack:"metadata" json:"metadata"`
 	Statistics      objects.Checksum `msgpack:"statistics" json:"statistics"`
 	Summary         vfs.Summary      `msgpack:"summary" json:"summary"`
+
+	// set from the annotations of the snapshot, never serialized
+	Note   string `msgpack:"-" json:"note"`
+	Pinned bool   `msgpack:"-" json:"pinned"`
 }
 
 func NewHeader(name string, identifier objects.Checksum) *Header {
@@ -117,6 +141,38 @@ func (h *Header) GetContext(key string) string {
 	return ""
 }
 
+// MetaPrefix namespaces the user metadata in the context, apart from the
+// entries recorded by plakar itself.
+const MetaPrefix = "Meta."
+
+func (h *Header) SetMeta(key, value string) {
+	h.SetContext(MetaPrefix+key, value)
+}
+
+func (h *Header) GetMeta(key string) string {
+	return h.GetContext(MetaPrefix + key)
+}
+
+// GetMetas returns the user metadata, without their prefix.
+func (h *Header) GetMetas() []KeyValue {
+	metas := make([]KeyValue, 0)
+	for _, kv := range h.Context {
+		if key, found := strings.CutPrefix(kv.Key, MetaPrefix); found {
+			metas = append(metas, KeyValue{Key: key, Value: kv.Value})
+		}
+	}
+	return metas
+}
+
+// GetSources returns the importers of the snapshot, Importer is their
+// summary when a snapshot has several roots.
+func (h *Header) GetSources() []Importer {
+	if len(h.Sources) == 0 {
+		return []Importer{h.Importer}
+	}
+	return h.Sources
+}
+
 func (h *Header) GetIndexID() [32]byte {
 	return h.Identifier
 }
@@ -193,6 +249,39 @@ func SortHeaders(headers []Header, sortKeys []string) error {
 					return headers[i].Version > headers[j].Version
 				}
 
+			case "Name":
+				if headers[i].Name != headers[j].Name {
+					return headers[i].Name < headers[j].Name
+				}
+			case "-Name":
+				if headers[i].Name != headers[j].Name {
+					return headers[i].Name > headers[j].Name
+				}
+			case "Category":
+				if headers[i].Category != headers[j].Category {
+					return headers[i].Category < headers[j].Category
+				}
+			case "-Category":
+				if headers[i].Category != headers[j].Category {
+					return headers[i].Category > headers[j].Category
+				}
+			case "Environment":
+				if headers[i].Environment != headers[j].Environment {
+					return headers[i].Environment < headers[j].Environment
+				}
+			case "-Environment":
+				if headers[i].Environment != headers[j].Environment {
+					return headers[i].Environment > headers[j].Environment
+				}
+			case "Perimeter":
+				if headers[i].Perimeter != headers[j].Perimeter {
+					return headers[i].Perimeter < headers[j].Perimeter
+				}
+			case "-Perimeter":
+				if headers[i].Perimeter != headers[j].Perimeter {
+					return headers[i].Perimeter > headers[j].Perimeter
+				}
+
 			case "Tags":
 				// Compare Tags lexicographically, element by element
 				for k := 0; k < len(headers[i].Tags) && k < len(headers[j].Tags); k++ {
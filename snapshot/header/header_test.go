@@ -86,3 +86,41 @@ func TestSortHeaders(t *testing.T) {
 		t.Errorf("Test 10 failed: expected %v, got %v", expected10, headers)
 	}
 }
+
+func TestGetSources(t *testing.T) {
+	header := NewHeader("test", [32]byte{0x1})
+	header.Importer = Importer{Type: "fs", Directory: "/etc"}
+
+	sources := header.GetSources()
+	if len(sources) != 1 || sources[0].Directory != "/etc" {
+		t.Errorf("Expected the importer as single source, got %v", sources)
+	}
+
+	header.Importer.Directory = "/"
+	header.Sources = []Importer{{Type: "fs", Directory: "/etc"}, {Type: "fs", Directory: "/home"}}
+	sources = header.GetSources()
+	if len(sources) != 2 || sources[0].Directory != "/etc" || sources[1].Directory != "/home" {
+		t.Errorf("Expected the sources of the header, got %v", sources)
+	}
+}
+
+func TestSortHeadersByEnvironment(t *testing.T) {
+	headers := []Header{
+		{Environment: "staging", Identifier: [32]byte{0x1}},
+		{Environment: "prod", Identifier: [32]byte{0x2}},
+		{Environment: "staging", Identifier: [32]byte{0x0}},
+	}
+	sortKeys, err := ParseSortKeys("-Environment,Identifier")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := SortHeaders(headers, sortKeys); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := [][32]byte{{0x0}, {0x1}, {0x2}}
+	for i, hdr := range headers {
+		if hdr.Identifier != expected[i] {
+			t.Errorf("Expected %x at %d, got %x", expected[i][:1], i, hdr.Identifier[:1])
+		}
+	}
+}
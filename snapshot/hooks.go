@@ -0,0 +1,153 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	HookPreScan    = "pre-scan"
+	HookPostScan   = "post-scan"
+	HookPreCommit  = "pre-commit"
+	HookPostCommit = "post-commit"
+)
+
+// HookStages lists the stages of a backup running hooks, in order.
+var HookStages = []string{HookPreScan, HookPostScan, HookPreCommit, HookPostCommit}
+
+type HookPolicy int
+
+const (
+	// HookAbort fails the backup when the hook fails
+	HookAbort HookPolicy = iota
+	// HookWarn only logs a warning when the hook fails
+	HookWarn
+)
+
+// hookOutputMax bounds the output of a hook kept in the header.
+const hookOutputMax = 4096
+
+// Hook is a shell command run at a stage of a backup.
+type Hook struct {
+	Stage   string
+	Command string
+	Policy  HookPolicy
+}
+
+func ParseHookPolicy(policy string) (HookPolicy, error) {
+	switch policy {
+	case "abort":
+		return HookAbort, nil
+	case "warn":
+		return HookWarn, nil
+	default:
+		return HookAbort, fmt.Errorf("invalid hook policy %q, must be abort or warn", policy)
+	}
+}
+
+func (snap *Snapshot) hookEnv(stage string, status error) []string {
+	env := append(os.Environ(),
+		"PLAKAR_HOOK="+stage,
+		fmt.Sprintf("PLAKAR_SNAPSHOT_ID=%x", snap.Header.Identifier),
+		"PLAKAR_SNAPSHOT_NAME="+snap.Header.Name,
+		"PLAKAR_REPOSITORY="+snap.repository.Location(),
+	)
+
+	directories := make([]string, 0)
+	for _, source := range snap.Header.GetSources() {
+		directories = append(directories, source.Directory)
+	}
+	env = append(env, "PLAKAR_SOURCES="+strings.Join(directories, ":"))
+
+	if stage == HookPreScan {
+		return env
+	}
+	if status != nil {
+		return append(env, "PLAKAR_STATUS=failed", "PLAKAR_ERROR="+status.Error())
+	}
+
+	summary := snap.Header.Summary
+	return append(env,
+		"PLAKAR_STATUS=ok",
+		fmt.Sprintf("PLAKAR_ROOT=%x", snap.Header.Root),
+		fmt.Sprintf("PLAKAR_FILES=%d", summary.Directory.Files+summary.Below.Files),
+		fmt.Sprintf("PLAKAR_DIRECTORIES=%d", summary.Directory.Directories+summary.Below.Directories),
+		fmt.Sprintf("PLAKAR_SIZE=%d", summary.Directory.Size+summary.Below.Size),
+		fmt.Sprintf("PLAKAR_ERRORS=%d", summary.Directory.Errors+summary.Below.Errors),
+	)
+}
+
+// runHooks runs the hooks of stage in order, status is the error of the
+// backup so far.  Their command, exit status and output are recorded in the
+// header context, indexed when the stage has several hooks, the first
+// failing hook with the abort policy stops the stage and its error is
+// returned.
+//
+// Post-scan hooks undo what pre-scan hooks did and post-commit hooks follow
+// a backup that is already done, both run even if the backup was
+// interrupted.  Post-commit hooks are not recorded since the header is
+// committed, and their failure is only logged.
+func (snap *Snapshot) runHooks(hooks []Hook, stage string, status error) error {
+	var ctx context.Context = snap.Context()
+	if stage == HookPostScan || stage == HookPostCommit {
+		ctx = context.WithoutCancel(ctx)
+	}
+
+	stageHooks := make([]Hook, 0)
+	for _, hook := range hooks {
+		if hook.Stage == stage {
+			stageHooks = append(stageHooks, hook)
+		}
+	}
+
+	for i, hook := range stageHooks {
+		var output bytes.Buffer
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", hook.Command)
+		cmd.Env = snap.hookEnv(stage, status)
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		err := cmd.Run()
+
+		exitStatus := 0
+		if cmd.ProcessState != nil {
+			exitStatus = cmd.ProcessState.ExitCode()
+		}
+		out := output.Bytes()
+		if len(out) > hookOutputMax {
+			out = out[:hookOutputMax]
+		}
+
+		if stage != HookPostCommit {
+			key := "Hook." + stage
+			if len(stageHooks) > 1 {
+				key = fmt.Sprintf("%s.%d", key, i)
+			}
+			snap.Header.SetContext(key+".Command", hook.Command)
+			snap.Header.SetContext(key+".ExitStatus", fmt.Sprintf("%d", exitStatus))
+			snap.Header.SetContext(key+".Output", string(out))
+		}
+
+		if err == nil {
+			snap.Logger().Info("%s hook %q succeeded", stage, hook.Command)
+			continue
+		}
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			err = fmt.Errorf("%s hook %q exited with status %d", stage, hook.Command, exitStatus)
+		} else {
+			err = fmt.Errorf("%s hook %q: %w", stage, hook.Command, err)
+		}
+		if hook.Policy == HookWarn || stage == HookPostCommit {
+			snap.Logger().Warn("%s", err)
+			continue
+		}
+		return err
+	}
+	return nil
+}
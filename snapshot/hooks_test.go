@@ -0,0 +1,119 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+)
+
+func TestHooksContext(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "hello"})
+	snap := backupTree(t, repo, src, &BackupOptions{
+		Hooks: []Hook{
+			{Stage: HookPreScan, Command: "echo first"},
+			{Stage: HookPreScan, Command: "echo second"},
+			{Stage: HookPostScan, Command: "echo $PLAKAR_STATUS $PLAKAR_FILES"},
+			{Stage: HookPostCommit, Command: "echo done"},
+		},
+	})
+
+	for key, expected := range map[string]string{
+		"Hook.pre-scan.0.Command":    "echo first",
+		"Hook.pre-scan.0.Output":     "first\n",
+		"Hook.pre-scan.1.Command":    "echo second",
+		"Hook.pre-scan.1.ExitStatus": "0",
+		"Hook.pre-scan.1.Output":     "second\n",
+		"Hook.post-scan.Output":      "ok 1\n",
+	} {
+		if value := snap.Header.GetContext(key); value != expected {
+			t.Errorf("Expected context %s to be %q, got %q", key, expected, value)
+		}
+	}
+	for _, kv := range snap.Header.Context {
+		if strings.HasPrefix(kv.Key, "Hook."+HookPostCommit) {
+			t.Errorf("Expected post-commit hooks not to be recorded, found %s", kv.Key)
+		}
+	}
+}
+
+func TestHooksPolicy(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "hello"})
+
+	snap, err := New(repo)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	err = snap.Backup(src, &BackupOptions{
+		MaxConcurrency: 2,
+		Hooks:          []Hook{{Stage: HookPreScan, Command: "exit 3", Policy: HookAbort}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "status 3") {
+		t.Fatalf("Expected the pre-scan hook to fail the backup, got %v", err)
+	}
+
+	snap = backupTree(t, repo, src, &BackupOptions{
+		Hooks: []Hook{{Stage: HookPreScan, Command: "exit 3", Policy: HookWarn}},
+	})
+	if status := snap.Header.GetContext("Hook.pre-scan.ExitStatus"); status != "3" {
+		t.Errorf("Expected the exit status of the hook to be recorded, got %q", status)
+	}
+
+	// the snapshot exists once committed, whatever its post-commit hooks do
+	snap = backupTree(t, repo, src, &BackupOptions{
+		Hooks: []Hook{{Stage: HookPostCommit, Command: "exit 1", Policy: HookAbort}},
+	})
+	if _, err := Load(repo, snap.Header.Identifier); err != nil {
+		t.Errorf("Expected the snapshot to be committed: %v", err)
+	}
+}
+
+func TestHooksInterrupted(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	snap, err := New(repo)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	snap.Context().Close()
+
+	marker := filepath.Join(t.TempDir(), "marker")
+	hooks := []Hook{
+		{Stage: HookPreScan, Command: "touch " + marker + ".pre-scan"},
+		{Stage: HookPostScan, Command: "echo $PLAKAR_STATUS > " + marker + ".post-scan"},
+		{Stage: HookPostCommit, Command: "touch " + marker + ".post-commit"},
+	}
+
+	if err := snap.runHooks(hooks, HookPreScan, nil); err == nil {
+		t.Errorf("Expected the pre-scan hook not to run once interrupted")
+	}
+	if err := snap.runHooks(hooks, HookPostScan, errors.New("interrupted")); err != nil {
+		t.Errorf("Failed to run post-scan hook: %v", err)
+	}
+	if err := snap.runHooks(hooks, HookPostCommit, nil); err != nil {
+		t.Errorf("Failed to run post-commit hook: %v", err)
+	}
+
+	if _, err := os.Stat(marker + ".pre-scan"); err == nil {
+		t.Errorf("Expected the pre-scan hook not to run once interrupted")
+	}
+	content, err := os.ReadFile(marker + ".post-scan")
+	if err != nil {
+		t.Fatalf("Expected the post-scan hook to run once interrupted: %v", err)
+	}
+	if string(content) != "failed\n" {
+		t.Errorf("Expected the post-scan hook to see a failed status, got %q", content)
+	}
+	if _, err := os.Stat(marker + ".post-commit"); err != nil {
+		t.Errorf("Expected the post-commit hook to run once interrupted: %v", err)
+	}
+}
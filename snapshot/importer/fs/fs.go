@@ -17,6 +17,7 @@
 package fs
 
 import (
+	"context"
 	"io"
 	"os"
 	"path/filepath"
@@ -26,6 +27,8 @@ import (
 )
 
 type FSImporter struct {
+	importer.IgnoreLog
+
 	rootDir string
 }
 
@@ -62,12 +65,23 @@ func (p *FSImporter) Type() string {
 	return "fs"
 }
 
-func (p *FSImporter) Scan() (<-chan importer.ScanResult, error) {
-	return walkDir_walker(p.rootDir, 256)
+func (p *FSImporter) Scan(ctx context.Context, options *importer.ScanOptions) (<-chan importer.ScanResult, error) {
+	return walkDir_walker(ctx, p.rootDir, 256, options, &p.IgnoreLog)
+}
+
+// file is a file being backed up, whose holes are skipped.
+type file struct {
+	*os.File
 }
 
+var _ importer.SparseReader = (*file)(nil)
+
 func (p *FSImporter) NewReader(pathname string) (io.ReadCloser, error) {
-	return os.Open(pathname)
+	fp, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	return &file{File: fp}, nil
 }
 
 func (p *FSImporter) Close() error {
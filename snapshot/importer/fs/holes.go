@@ -0,0 +1,57 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package fs
+
+import (
+	"errors"
+	"io"
+
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+	"golang.org/x/sys/unix"
+)
+
+// Holes locates the holes of the file with SEEK_DATA and SEEK_HOLE and
+// rewinds it.  Filesystems without support report no holes.
+func (f *file) Holes() ([]importer.Hole, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	holes := make([]importer.Hole, 0)
+	fd := int(f.Fd())
+	for offset := int64(0); offset < size; {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// no data past offset
+			data = size
+		} else if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) {
+			holes = holes[:0]
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if data > size {
+			data = size
+		}
+		if data > offset {
+			holes = append(holes, importer.Hole{Offset: offset, Length: data - offset})
+		}
+		if data == size {
+			break
+		}
+
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		offset = hole
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return holes, nil
+}
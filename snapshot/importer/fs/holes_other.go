@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package fs
+
+import (
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+)
+
+// Holes reports no holes where SEEK_DATA and SEEK_HOLE are not available.
+func (f *file) Holes() ([]importer.Hole, error) {
+	return nil, nil
+}
@@ -17,7 +17,9 @@
 package fs
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"os/user"
@@ -138,18 +140,15 @@ func walkDir_worker(rootDir string, jobs <-chan string, results chan<- importer.
 			}
 			//results <- importer.ScanRecord{Type: recordType, Pathname: filepath.ToSlash(path), FileInfo: fileinfo, ExtendedAttributes: extendedAttributes, Children: children}
 			results <- importer.ScanRecord{Type: recordType, Pathname: filepath.ToSlash(path), FileInfo: fileinfo, ExtendedAttributes: extendedAttributes}
-		} else {
-			results <- importer.ScanRecord{Type: recordType, Pathname: filepath.ToSlash(path), FileInfo: fileinfo, ExtendedAttributes: extendedAttributes}
-		}
-
-		// Handle symlinks separately
-		if fileinfo.Mode()&os.ModeSymlink != 0 {
+		} else if fileinfo.Mode()&os.ModeSymlink != 0 {
 			originFile, err := os.Readlink(path)
 			if err != nil {
 				results <- importer.ScanError{Pathname: path, Err: err}
 				continue
 			}
 			results <- importer.ScanRecord{Type: recordType, Pathname: filepath.ToSlash(path), Target: originFile, FileInfo: fileinfo, ExtendedAttributes: extendedAttributes}
+		} else {
+			results <- importer.ScanRecord{Type: recordType, Pathname: filepath.ToSlash(path), FileInfo: fileinfo, ExtendedAttributes: extendedAttributes}
 		}
 	}
 }
@@ -177,7 +176,52 @@ func walkDir_addPrefixDirectories(rootDir string, jobs chan<- string, results ch
 	}
 }
 
-func walkDir_walker(rootDir string, numWorkers int) (<-chan importer.ScanResult, error) {
+func walkDir_loadIgnore(dir string) ([]*importer.IgnoreRule, error) {
+	pathname := filepath.Join(dir, importer.IgnoreFile)
+	fp, err := os.Open(pathname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fp.Close()
+	return importer.ParseIgnore(filepath.ToSlash(pathname), fp)
+}
+
+// walkDir_pruned reports whether the content of directory path is excluded
+// by the scan options.
+func walkDir_pruned(path string, d fs.DirEntry, rootDev uint64, options *importer.ScanOptions) bool {
+	if options == nil {
+		return false
+	}
+	if options.OneFileSystem {
+		if info, err := d.Info(); err == nil && objects.FileInfoFromStat(info).Dev() != rootDev {
+			return true
+		}
+	}
+	for _, marker := range options.ExcludeMarkers {
+		pathname := filepath.Join(path, marker)
+		if _, err := os.Lstat(pathname); err != nil {
+			continue
+		}
+		if options.IsMarker(marker, func() (io.ReadCloser, error) { return os.Open(pathname) }) {
+			return true
+		}
+	}
+	return false
+}
+
+func walkDir_walker(ctx context.Context, rootDir string, numWorkers int, options *importer.ScanOptions, ignoreLog *importer.IgnoreLog) (<-chan importer.ScanResult, error) {
+	var rootDev uint64
+	if options != nil && options.OneFileSystem {
+		info, err := os.Lstat(rootDir)
+		if err != nil {
+			return nil, err
+		}
+		rootDev = objects.FileInfoFromStat(info).Dev()
+	}
+
 	results := make(chan importer.ScanResult, 1000) // Larger buffer for results
 	jobs := make(chan string, 1000)                 // Buffered channel to feed paths to workers
 	var wg sync.WaitGroup
@@ -195,17 +239,45 @@ func walkDir_walker(rootDir string, numWorkers int) (<-chan importer.ScanResult,
 		// Add prefix directories first
 		walkDir_addPrefixDirectories(rootDir, jobs, results)
 
+		// WalkDir visits a directory before its content, so the rules of
+		// the ignore files of its parents are known when reaching a path
+		ignores := make(map[string]*importer.Ignore)
+
 		err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			if err != nil {
 				results <- importer.ScanError{Pathname: path, Err: err}
 				return nil
 			}
 
+			ignore := ignores[filepath.Dir(path)]
+			if ignore.Match(filepath.ToSlash(path), d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				if walkDir_pruned(path, d, rootDev, options) {
+					jobs <- path
+					return filepath.SkipDir
+				}
+
+				rules, err := walkDir_loadIgnore(path)
+				if err != nil {
+					results <- importer.ScanError{Pathname: filepath.Join(path, importer.IgnoreFile), Err: err}
+				}
+				ignoreLog.Add(rules)
+				ignores[path] = ignore.With(rules)
+			}
+
 			// If d is a directory, send its path directly to the job queue
 			jobs <- path
 			return nil
 		})
-		if err != nil {
+		if err != nil && ctx.Err() == nil {
 			results <- importer.ScanError{Pathname: rootDir, Err: err}
 		}
 	}()
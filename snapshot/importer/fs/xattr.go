@@ -25,15 +25,15 @@ import (
 func getExtendedAttributes(path string) (map[string][]byte, error) {
 	attrs := make(map[string][]byte)
 
-	// Get the list of attribute names
-	attributes, err := xattr.List(path)
+	// Get the list of attribute names, without following symlinks
+	attributes, err := xattr.LList(path)
 	if err != nil {
 		return nil, err
 	}
 
 	// Iterate over each attribute and retrieve its value
 	for _, attr := range attributes {
-		value, err := xattr.Get(path, attr)
+		value, err := xattr.LGet(path, attr)
 		if err != nil {
 			// Log the error and continue instead of failing
 			if os.IsPermission(err) {
@@ -17,6 +17,8 @@
 package ftp
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -33,6 +35,8 @@ import (
 )
 
 type FTPImporter struct {
+	importer.IgnoreLog
+
 	host    string
 	rootDir string
 	client  *goftp.Client
@@ -136,17 +140,62 @@ func (p *FTPImporter) ftpWalker_addPrefixDirectories(jobs chan<- string, results
 	}
 }
 
-func (p *FTPImporter) walkDir(root string, results chan<- string, wg *sync.WaitGroup) {
+func (p *FTPImporter) loadIgnore(dir string) ([]*importer.IgnoreRule, error) {
+	pathname := filepath.Join(dir, importer.IgnoreFile)
+	var buffer bytes.Buffer
+	if err := p.client.Retrieve(pathname, &buffer); err != nil {
+		return nil, err
+	}
+	return importer.ParseIgnore(filepath.ToSlash(pathname), &buffer)
+}
+
+func (p *FTPImporter) walkDir(ctx context.Context, root string, options *importer.ScanOptions, ignore *importer.Ignore, results chan<- string, wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	if ctx.Err() != nil {
+		return
+	}
+
 	entries, err := p.client.ReadDir(root)
 	if err != nil {
 		log.Printf("Error reading directory %s: %v", root, err)
 		return
 	}
 
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		pathname := filepath.Join(root, entry.Name())
+		if options.IsMarker(entry.Name(), func() (io.ReadCloser, error) {
+			var buffer bytes.Buffer
+			if err := p.client.Retrieve(pathname, &buffer); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(&buffer), nil
+		}) {
+			return
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == importer.IgnoreFile && !entry.IsDir() {
+			rules, err := p.loadIgnore(root)
+			if err != nil {
+				log.Printf("Error reading ignore file of %s: %v", root, err)
+				break
+			}
+			p.IgnoreLog.Add(rules)
+			ignore = ignore.With(rules)
+			break
+		}
+	}
+
 	for _, entry := range entries {
 		entryPath := filepath.Join(root, entry.Name())
+		if ignore.Match(filepath.ToSlash(entryPath), entry.IsDir()) {
+			continue
+		}
 
 		// Send the current entry to the results channel
 		results <- entryPath
@@ -154,12 +203,12 @@ func (p *FTPImporter) walkDir(root string, results chan<- string, wg *sync.WaitG
 		// If the entry is a directory, traverse it recursively
 		if entry.IsDir() {
 			wg.Add(1)
-			go p.walkDir(entryPath, results, wg)
+			go p.walkDir(ctx, entryPath, options, ignore, results, wg)
 		}
 	}
 }
 
-func (p *FTPImporter) Scan() (<-chan importer.ScanResult, error) {
+func (p *FTPImporter) Scan(ctx context.Context, options *importer.ScanOptions) (<-chan importer.ScanResult, error) {
 	client, err := connectToFTP(p.host, "", "")
 	if err != nil {
 		fmt.Println(err)
@@ -181,7 +230,7 @@ func (p *FTPImporter) Scan() (<-chan importer.ScanResult, error) {
 		defer close(jobs)
 		p.ftpWalker_addPrefixDirectories(jobs, results)
 		wg.Add(1)
-		p.walkDir(p.rootDir, jobs, &wg)
+		p.walkDir(ctx, p.rootDir, options, nil, jobs, &wg)
 	}()
 
 	go func() {
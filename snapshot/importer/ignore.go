@@ -0,0 +1,236 @@
+package importer
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// IgnoreFile is the name of the per-directory files listing, in the
+// gitignore syntax, the pathnames to exclude from backups.
+const IgnoreFile = ".plakarignore"
+
+// IgnoreRule is a pattern of an ignore file, it applies to pathnames below
+// the directory holding the file.
+type IgnoreRule struct {
+	Source  string
+	Pattern string
+
+	base    string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// NewIgnoreRule parses a line of the ignore file source, it returns nil for
+// blank lines and comments.
+func NewIgnoreRule(source string, line string) (*IgnoreRule, error) {
+	pattern := strings.TrimSuffix(line, "\r")
+	for strings.HasSuffix(pattern, " ") && !strings.HasSuffix(pattern, "\\ ") {
+		pattern = pattern[:len(pattern)-1]
+	}
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil, nil
+	}
+
+	rule := &IgnoreRule{
+		Source:  source,
+		Pattern: pattern,
+		base:    path.Dir(source),
+	}
+
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, "\\!") || strings.HasPrefix(pattern, "\\#") {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return nil, nil
+	}
+
+	// a slash anywhere but at the end anchors the pattern to the directory
+	// of the ignore file, otherwise it matches at any depth
+	if strings.HasPrefix(pattern, "/") {
+		pattern = pattern[1:]
+	} else if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		if segment == "**" {
+			if last {
+				sb.WriteString(".+")
+			} else {
+				sb.WriteString("(?:.+/)?")
+			}
+			continue
+		}
+		sb.WriteString(globToRegexp(segment))
+		if !last {
+			sb.WriteString("/")
+		}
+	}
+	sb.WriteString("$")
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, err
+	}
+	rule.re = re
+	return rule, nil
+}
+
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				sb.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// ParseIgnore reads the rules of the ignore file source.
+func ParseIgnore(source string, rd io.Reader) ([]*IgnoreRule, error) {
+	rules := make([]*IgnoreRule, 0)
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		rule, err := NewIgnoreRule(source, scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (rule *IgnoreRule) match(pathname string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	var relpath string
+	if rule.base == "/" {
+		relpath = strings.TrimPrefix(pathname, "/")
+	} else if strings.HasPrefix(pathname, rule.base+"/") {
+		relpath = pathname[len(rule.base)+1:]
+	} else {
+		return false
+	}
+	return relpath != "" && rule.re.MatchString(relpath)
+}
+
+// Ignore holds the rules applying below a directory, the rules of nested
+// ignore files come after those of their parents and the last matching rule
+// decides. A nil Ignore excludes nothing.
+type Ignore struct {
+	rules []*IgnoreRule
+}
+
+// With returns the rules of ign followed by rules.
+func (ign *Ignore) With(rules []*IgnoreRule) *Ignore {
+	if len(rules) == 0 {
+		return ign
+	}
+	child := &Ignore{}
+	if ign != nil {
+		child.rules = append(child.rules, ign.rules...)
+	}
+	child.rules = append(child.rules, rules...)
+	return child
+}
+
+func (ign *Ignore) decide(pathname string, isDir bool) *IgnoreRule {
+	var decision *IgnoreRule
+	for _, rule := range ign.rules {
+		if rule.match(pathname, isDir) {
+			decision = rule
+		}
+	}
+	if decision != nil && decision.negate {
+		return nil
+	}
+	return decision
+}
+
+// Match reports whether pathname is excluded, ignoring its parents which
+// importers do not descend into once excluded.
+func (ign *Ignore) Match(pathname string, isDir bool) bool {
+	if ign == nil {
+		return false
+	}
+	return ign.decide(pathname, isDir) != nil
+}
+
+// MatchRule returns the rule excluding pathname or one of its parents, or
+// nil if it is not excluded.
+func (ign *Ignore) MatchRule(pathname string, isDir bool) *IgnoreRule {
+	if ign == nil {
+		return nil
+	}
+	pathname = path.Clean(pathname)
+	atoms := strings.Split(strings.TrimPrefix(pathname, "/"), "/")
+	for i := 1; i < len(atoms); i++ {
+		if rule := ign.decide("/"+path.Join(atoms[:i]...), true); rule != nil {
+			return rule
+		}
+	}
+	return ign.decide(pathname, isDir)
+}
+
+// IgnoreLog records the rules of the ignore files loaded during a scan, it
+// is meant to be embedded by importers.
+type IgnoreLog struct {
+	mu    sync.Mutex
+	rules []*IgnoreRule
+}
+
+func (l *IgnoreLog) Add(rules []*IgnoreRule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = append(l.rules, rules...)
+}
+
+func (l *IgnoreLog) IgnoreRules() []*IgnoreRule {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*IgnoreRule{}, l.rules...)
+}
@@ -0,0 +1,94 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIgnoreMatch(t *testing.T) {
+	rules, err := ParseIgnore("/data/.plakarignore", strings.NewReader(strings.Join([]string{
+		"# comment",
+		"",
+		"*.log",
+		"!keep.log",
+		"/build",
+		"cache/",
+		"docs/**/*.tmp",
+		"vendor/**",
+		"\\#hash",
+	}, "\n")))
+	if err != nil {
+		t.Fatalf("Failed to parse ignore file: %v", err)
+	}
+	if len(rules) != 7 {
+		t.Fatalf("Expected 7 rules, got %d", len(rules))
+	}
+	ignore := (*Ignore)(nil).With(rules)
+
+	tests := []struct {
+		pathname string
+		isDir    bool
+		expected bool
+	}{
+		{"/data/app.log", false, true},
+		{"/data/sub/app.log", false, true},
+		{"/data/keep.log", false, false},
+		{"/data/build", true, true},
+		{"/data/sub/build", true, false},
+		{"/data/cache", true, true},
+		{"/data/cache", false, false},
+		{"/data/sub/cache", true, true},
+		{"/data/docs/a.tmp", false, true},
+		{"/data/docs/x/y/a.tmp", false, true},
+		{"/data/a.tmp", false, false},
+		{"/data/vendor", true, false},
+		{"/data/vendor/lib/file", false, true},
+		{"/data/#hash", false, true},
+		{"/other/app.log", false, false},
+	}
+	for _, test := range tests {
+		if got := ignore.Match(test.pathname, test.isDir); got != test.expected {
+			t.Errorf("Match(%q, %v) = %v, expected %v", test.pathname, test.isDir, got, test.expected)
+		}
+	}
+}
+
+func TestIgnoreNested(t *testing.T) {
+	parent, err := ParseIgnore("/.plakarignore", strings.NewReader("*.bin\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse ignore file: %v", err)
+	}
+	child, err := ParseIgnore("/sub/.plakarignore", strings.NewReader("!*.bin\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse ignore file: %v", err)
+	}
+
+	ignore := (*Ignore)(nil).With(parent)
+	nested := ignore.With(child)
+
+	if !ignore.Match("/sub/a.bin", false) {
+		t.Errorf("Expected /sub/a.bin to be excluded by the parent rules")
+	}
+	if nested.Match("/sub/a.bin", false) {
+		t.Errorf("Expected /sub/a.bin to be included by the nested rules")
+	}
+	if !nested.Match("/a.bin", false) {
+		t.Errorf("Expected /a.bin to be excluded by the parent rules")
+	}
+}
+
+func TestIgnoreMatchRule(t *testing.T) {
+	rules, err := ParseIgnore("/.plakarignore", strings.NewReader("tmp/\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse ignore file: %v", err)
+	}
+	ignore := (*Ignore)(nil).With(rules)
+
+	if ignore.Match("/tmp/file", false) {
+		t.Errorf("Expected Match to ignore parent directories")
+	}
+	rule := ignore.MatchRule("/tmp/file", false)
+	if rule == nil || rule.Pattern != "tmp/" {
+		t.Errorf("Expected MatchRule to return the rule excluding the parent directory, got %v", rule)
+	}
+}
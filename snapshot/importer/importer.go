@@ -17,6 +17,7 @@
 package importer
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -64,15 +65,43 @@ type ScanError struct {
 
 func (r ScanError) scanResult() {}
 
+// Importer is the interface implemented by backup sources. Scan stops
+// producing results once ctx is done but callers must still drain the
+// returned channel until it is closed. Pathnames excluded by ignore files
+// or by options are not produced and IgnoreRules returns the rules that
+// were loaded.
 type Importer interface {
 	Origin() string
 	Type() string
 	Root() string
-	Scan() (<-chan ScanResult, error)
+	Scan(ctx context.Context, options *ScanOptions) (<-chan ScanResult, error)
+	IgnoreRules() []*IgnoreRule
 	NewReader(string) (io.ReadCloser, error)
 	Close() error
 }
 
+// Commander is implemented by importers reading the output of a command,
+// Wait returns its exit status once the output is consumed and an error if
+// the command failed.
+type Commander interface {
+	Command() string
+	Wait() (int, error)
+}
+
+// Hole is a run of zeros of a sparse file with no data on disk.
+type Hole struct {
+	Offset int64
+	Length int64
+}
+
+// SparseReader is implemented by the readers of importers able to locate
+// the holes of a file, in increasing offsets, so that they are skipped
+// rather than read.
+type SparseReader interface {
+	io.ReadSeeker
+	Holes() ([]Hole, error)
+}
+
 var muBackends sync.Mutex
 var backends map[string]func(config string) (Importer, error) = make(map[string]func(config string) (Importer, error))
 
@@ -112,6 +141,8 @@ func NewImporter(location string) (Importer, error) {
 			backendName = "fs"
 		} else if strings.HasPrefix(location, "ftp://") {
 			backendName = "ftp"
+		} else if strings.HasPrefix(location, "stdin://") {
+			backendName = "stdin"
 		} else {
 			if strings.Contains(location, "://") {
 				return nil, fmt.Errorf("unsupported importer protocol")
@@ -0,0 +1,52 @@
+package importer
+
+import (
+	"bytes"
+	"io"
+)
+
+// CacheDirTag marks cache directories as per the Cache Directory Tagging
+// Specification, the file must start with cacheDirTagSignature.
+const CacheDirTag = "CACHEDIR.TAG"
+
+const cacheDirTagSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+
+// ScanOptions restrict which directories a scan descends into, directories
+// excluded by them are produced but their content is not. A nil ScanOptions
+// restricts nothing.
+type ScanOptions struct {
+	// OneFileSystem skips the content of directories on another device
+	// than the scanned directory, it only applies to local filesystems.
+	OneFileSystem bool
+
+	// ExcludeMarkers skips the content of directories holding one of these
+	// files.
+	ExcludeMarkers []string
+}
+
+// IsMarker reports whether name is an exclusion marker, open is only called
+// to check the signature of CACHEDIR.TAG files.
+func (options *ScanOptions) IsMarker(name string, open func() (io.ReadCloser, error)) bool {
+	if options == nil {
+		return false
+	}
+	for _, marker := range options.ExcludeMarkers {
+		if name != marker {
+			continue
+		}
+		if marker != CacheDirTag {
+			return true
+		}
+		rd, err := open()
+		if err != nil {
+			return false
+		}
+		defer rd.Close()
+		signature := make([]byte, len(cacheDirTagSignature))
+		if _, err := io.ReadFull(rd, signature); err != nil {
+			return false
+		}
+		return bytes.Equal(signature, []byte(cacheDirTagSignature))
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package importer
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestIsMarker(t *testing.T) {
+	options := &ScanOptions{ExcludeMarkers: []string{CacheDirTag, ".nobackup"}}
+
+	open := func(content string) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(content)), nil
+		}
+	}
+
+	if !options.IsMarker(".nobackup", open("")) {
+		t.Errorf("Expected .nobackup to be a marker")
+	}
+	if options.IsMarker("other", open("")) {
+		t.Errorf("Expected other not to be a marker")
+	}
+	if !options.IsMarker(CacheDirTag, open(cacheDirTagSignature+"\n# comment\n")) {
+		t.Errorf("Expected a signed CACHEDIR.TAG to be a marker")
+	}
+	if options.IsMarker(CacheDirTag, open("not a cache\n")) {
+		t.Errorf("Expected an unsigned CACHEDIR.TAG not to be a marker")
+	}
+	if (*ScanOptions)(nil).IsMarker(".nobackup", open("")) {
+		t.Errorf("Expected nil options to have no marker")
+	}
+}
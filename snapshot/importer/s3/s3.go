@@ -35,6 +35,8 @@ import (
 )
 
 type S3Importer struct {
+	importer.IgnoreLog
+
 	minioClient *minio.Client
 	bucket      string
 	host        string
@@ -83,15 +85,65 @@ func NewS3Importer(location string) (importer.Importer, error) {
 	}, nil
 }
 
-func (p *S3Importer) scanRecursive(prefix string, result chan importer.ScanResult) {
-	for object := range p.minioClient.ListObjects(context.Background(), p.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: false}) {
+func (p *S3Importer) loadIgnore(ctx context.Context, key string) ([]*importer.IgnoreRule, error) {
+	obj, err := p.minioClient.GetObject(ctx, p.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return importer.ParseIgnore("/"+key, obj)
+}
+
+func (p *S3Importer) scanRecursive(ctx context.Context, prefix string, options *importer.ScanOptions, ignore *importer.Ignore, result chan importer.ScanResult) {
+	listing := make([]minio.ObjectInfo, 0)
+	for object := range p.minioClient.ListObjects(ctx, p.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: false}) {
+		listing = append(listing, object)
+	}
+
+	// the directory itself is still produced below
+	marked := false
+	for _, object := range listing {
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		key := object.Key
+		if options.IsMarker(strings.TrimPrefix(key, prefix), func() (io.ReadCloser, error) {
+			return p.minioClient.GetObject(ctx, p.bucket, key, minio.GetObjectOptions{})
+		}) {
+			marked = true
+			break
+		}
+	}
+	if marked {
+		listing = listing[:0]
+	}
+
+	for _, object := range listing {
+		if object.Key == prefix+importer.IgnoreFile {
+			rules, err := p.loadIgnore(ctx, object.Key)
+			if err != nil {
+				result <- importer.ScanError{Pathname: "/" + object.Key, Err: err}
+				break
+			}
+			p.IgnoreLog.Add(rules)
+			ignore = ignore.With(rules)
+			break
+		}
+	}
+
+	for _, object := range listing {
 		objectPath := "/" + object.Key
 		if !strings.HasPrefix(objectPath, p.scanDir) && !strings.HasPrefix(p.scanDir, objectPath) {
 			continue
 		}
 
-		if strings.HasSuffix(object.Key, "/") {
-			p.scanRecursive(object.Key, result)
+		isDir := strings.HasSuffix(object.Key, "/")
+		if ignore.Match(strings.TrimSuffix(objectPath, "/"), isDir) {
+			continue
+		}
+
+		if isDir {
+			p.scanRecursive(ctx, object.Key, options, ignore, result)
 		} else {
 			fi := objects.NewFileInfo(
 				filepath.Base("/"+prefix+object.Key),
@@ -128,11 +180,11 @@ func (p *S3Importer) scanRecursive(prefix string, result chan importer.ScanResul
 	)}
 }
 
-func (p *S3Importer) Scan() (<-chan importer.ScanResult, error) {
+func (p *S3Importer) Scan(ctx context.Context, options *importer.ScanOptions) (<-chan importer.ScanResult, error) {
 	c := make(chan importer.ScanResult)
 	go func() {
 		defer close(c)
-		p.scanRecursive("", c)
+		p.scanRecursive(ctx, "", options, nil, c)
 	}()
 	return c, nil
 }
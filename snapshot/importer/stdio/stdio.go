@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2024 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package stdio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+)
+
+// StdioImporter exposes a single virtual file whose content is streamed from
+// the standard input.  Its size is unknown until the stream is consumed and
+// it is reported as -1.
+type StdioImporter struct {
+	importer.IgnoreLog
+
+	pathname string
+
+	mu     sync.Mutex
+	rd     io.Reader
+	opened bool
+}
+
+// CommandImporter exposes the standard output of a command as a single
+// virtual file, the command is started when the importer is scanned.
+type CommandImporter struct {
+	StdioImporter
+
+	argv    []string
+	cmd     *exec.Cmd
+	waited  bool
+	status  int
+	waitErr error
+}
+
+func init() {
+	importer.Register("stdin", NewStdinImporter)
+}
+
+func virtualPathname(name string) (string, error) {
+	pathname := path.Clean("/" + name)
+	if pathname == "/" {
+		return "", fmt.Errorf("missing file name")
+	}
+	return pathname, nil
+}
+
+// NewStdinImporter exposes the standard input as the file named by location,
+// as in stdin://db.sql.
+func NewStdinImporter(location string) (importer.Importer, error) {
+	pathname, err := virtualPathname(strings.TrimPrefix(location, "stdin://"))
+	if err != nil {
+		return nil, err
+	}
+	return &StdioImporter{
+		pathname: pathname,
+		rd:       os.Stdin,
+	}, nil
+}
+
+// NewCommandImporter exposes the standard output of argv as the file name.
+func NewCommandImporter(name string, argv []string) (importer.Importer, error) {
+	pathname, err := virtualPathname(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("missing command")
+	}
+	return &CommandImporter{
+		StdioImporter: StdioImporter{pathname: pathname},
+		argv:          argv,
+	}, nil
+}
+
+func (p *StdioImporter) Origin() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return hostname
+}
+
+func (p *StdioImporter) Type() string {
+	return "stdin"
+}
+
+func (p *StdioImporter) Root() string {
+	return "/"
+}
+
+func (p *StdioImporter) Scan(ctx context.Context, options *importer.ScanOptions) (<-chan importer.ScanResult, error) {
+	now := time.Now()
+	uid := uint64(os.Getuid())
+	gid := uint64(os.Getgid())
+
+	c := make(chan importer.ScanResult, 2)
+	go func() {
+		defer close(c)
+
+		for dir := path.Dir(p.pathname); ; dir = path.Dir(dir) {
+			fileinfo := objects.NewFileInfo(path.Base(dir), 0, os.ModeDir|0755, now, 0, 0, uid, gid, 1)
+			c <- importer.ScanRecord{Type: importer.RecordTypeDirectory, Pathname: dir, FileInfo: fileinfo}
+			if dir == "/" {
+				break
+			}
+		}
+
+		fileinfo := objects.NewFileInfo(path.Base(p.pathname), -1, 0644, now, 0, 0, uid, gid, 1)
+		c <- importer.ScanRecord{Type: importer.RecordTypeFile, Pathname: p.pathname, FileInfo: fileinfo}
+	}()
+	return c, nil
+}
+
+func (p *StdioImporter) NewReader(pathname string) (io.ReadCloser, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pathname != p.pathname {
+		return nil, os.ErrNotExist
+	}
+	if p.rd == nil {
+		return nil, fmt.Errorf("%s: stream is not open", pathname)
+	}
+	if p.opened {
+		return nil, fmt.Errorf("%s: stream can only be read once", pathname)
+	}
+	p.opened = true
+	return io.NopCloser(p.rd), nil
+}
+
+func (p *StdioImporter) Close() error {
+	return nil
+}
+
+func (p *CommandImporter) Type() string {
+	return "command"
+}
+
+func (p *CommandImporter) Scan(ctx context.Context, options *importer.ScanOptions) (<-chan importer.ScanResult, error) {
+	cmd := exec.CommandContext(ctx, p.argv[0], p.argv[1:]...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.cmd = cmd
+	p.rd = stdout
+	p.mu.Unlock()
+
+	return p.StdioImporter.Scan(ctx, options)
+}
+
+func (p *CommandImporter) Command() string {
+	return strings.Join(p.argv, " ")
+}
+
+// Wait discards what was not read from the output of the command and waits
+// for it to exit.
+func (p *CommandImporter) Wait() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd == nil {
+		return 0, fmt.Errorf("command %q was not started", p.Command())
+	}
+	if !p.waited {
+		io.Copy(io.Discard, p.rd)
+		p.waited = true
+		err := p.cmd.Wait()
+		if p.cmd.ProcessState != nil {
+			p.status = p.cmd.ProcessState.ExitCode()
+		}
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			p.waitErr = fmt.Errorf("command %q exited with status %d", p.Command(), p.status)
+		} else if err != nil {
+			p.waitErr = err
+		}
+	}
+	return p.status, p.waitErr
+}
+
+func (p *CommandImporter) Close() error {
+	p.mu.Lock()
+	started := p.cmd != nil && !p.waited
+	p.mu.Unlock()
+
+	if started {
+		p.cmd.Process.Kill()
+		p.Wait()
+	}
+	return nil
+}
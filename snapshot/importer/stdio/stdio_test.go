@@ -0,0 +1,73 @@
+package stdio
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+)
+
+func scanCommand(t *testing.T, argv ...string) (*CommandImporter, []importer.ScanRecord) {
+	imp, err := NewCommandImporter("dumps/out.txt", argv)
+	if err != nil {
+		t.Fatalf("Failed to create importer: %v", err)
+	}
+	scanner, err := imp.Scan(context.Background(), &importer.ScanOptions{})
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	records := make([]importer.ScanRecord, 0)
+	for result := range scanner {
+		records = append(records, result.(importer.ScanRecord))
+	}
+	return imp.(*CommandImporter), records
+}
+
+func TestCommandImporter(t *testing.T) {
+	imp, records := scanCommand(t, "sh", "-c", "echo hello")
+	defer imp.Close()
+
+	expected := []string{"/dumps", "/", "/dumps/out.txt"}
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %d records, got %d", len(expected), len(records))
+	}
+	for i, record := range records {
+		if record.Pathname != expected[i] {
+			t.Errorf("Expected record %q, got %q", expected[i], record.Pathname)
+		}
+	}
+	if records[2].FileInfo.Size() != -1 {
+		t.Errorf("Expected unknown size, got %d", records[2].FileInfo.Size())
+	}
+
+	rd, err := imp.NewReader("/dumps/out.txt")
+	if err != nil {
+		t.Fatalf("Failed to open reader: %v", err)
+	}
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if string(data) != "hello\n" {
+		t.Errorf("Expected %q, got %q", "hello\n", data)
+	}
+	if _, err := imp.NewReader("/dumps/out.txt"); err == nil {
+		t.Errorf("Expected the stream to be readable only once")
+	}
+
+	status, err := imp.Wait()
+	if status != 0 || err != nil {
+		t.Errorf("Expected status 0, got %d (%v)", status, err)
+	}
+}
+
+func TestCommandImporterFailure(t *testing.T) {
+	imp, _ := scanCommand(t, "sh", "-c", "echo partial; exit 3")
+	defer imp.Close()
+
+	status, err := imp.Wait()
+	if status != 3 || err == nil {
+		t.Errorf("Expected status 3 and an error, got %d (%v)", status, err)
+	}
+}
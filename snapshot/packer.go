@@ -18,12 +18,12 @@ func NewPacker() *Packer {
 	}
 }
 
-func (packer *Packer) AddBlob(Type packfile.Type, checksum [32]byte, data []byte) {
+func (packer *Packer) AddBlob(Type packfile.Type, checksum [32]byte, data []byte, flags packfile.Flags) {
 	if _, ok := packer.Blobs[Type]; !ok {
 		packer.Blobs[Type] = make(map[[32]byte][]byte)
 	}
 	packer.Blobs[Type][checksum] = data
-	packer.Packfile.AddBlob(Type, checksum, data)
+	packer.Packfile.AddBlobWithFlags(Type, checksum, data, flags)
 }
 
 func (packer *Packer) Size() uint32 {
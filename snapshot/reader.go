@@ -1,18 +1,30 @@
 package snapshot
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"path"
 
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/packfile"
+	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/snapshot/vfs"
 )
 
-func (snapshot *Snapshot) NewReader(pathname string) (io.ReadCloser, error) {
+// Reader reads the content of a file of a snapshot, seeks and reads at an
+// offset only fetch the chunks holding the requested bytes.
+type Reader interface {
+	io.ReadCloser
+	io.ReaderAt
+	io.Seeker
+}
+
+func (snapshot *Snapshot) NewReader(pathname string) (Reader, error) {
 	return NewReader(snapshot, pathname)
 }
 
-func NewReader(snap *Snapshot, pathname string) (io.ReadCloser, error) {
+func NewReader(snap *Snapshot, pathname string) (Reader, error) {
 	pathname = path.Clean(pathname)
 
 	fs, err := vfs.NewFilesystem(snap.Repository(), snap.Header.Root)
@@ -26,5 +38,295 @@ func NewReader(snap *Snapshot, pathname string) (io.ReadCloser, error) {
 	} else if _, isDir := st.(*vfs.DirEntry); isDir {
 		return nil, os.ErrInvalid
 	}
-	return fs.Open(pathname)
+
+	fp, err := fs.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	return fp.(Reader), nil
+}
+
+// ObjectsReader reads the content of several objects in turn from a single
+// planned stream of chunks, so that reads are coalesced across objects.
+// Holes and chunks of zeros are not fetched, they read as zeros.
+type ObjectsReader struct {
+	stream  *repository.BlobStream
+	objects []*objects.Object
+	sparse  [][]bool
+	current *objectContent
+}
+
+// NewObjectsReader returns a reader over the content of objs, a nil object
+// is treated as an empty one.
+func (snap *Snapshot) NewObjectsReader(objs []*objects.Object) *ObjectsReader {
+	checksums := make([]objects.Checksum, 0)
+	sparse := make([][]bool, len(objs))
+	for i, object := range objs {
+		if object == nil {
+			continue
+		}
+		sparse[i] = snap.sparseChunks(object)
+		for j, chunk := range object.Chunks {
+			if !sparse[i][j] {
+				checksums = append(checksums, chunk.Checksum)
+			}
+		}
+	}
+
+	return &ObjectsReader{
+		stream:  snap.repository.ReadBlobs(packfile.TYPE_CHUNK, checksums, nil),
+		objects: objs,
+		sparse:  sparse,
+	}
+}
+
+// NextObject returns a reader over the content of the next object, any part
+// of the previous object left unread is skipped.  The reader implements
+// exporter.SparseReader.
+func (r *ObjectsReader) NextObject() (io.Reader, error) {
+	if r.current != nil {
+		if err := r.current.discard(); err != nil {
+			return nil, err
+		}
+		r.current = nil
+	}
+	if len(r.objects) == 0 {
+		return nil, io.EOF
+	}
+	object, sparse := r.objects[0], r.sparse[0]
+	r.objects, r.sparse = r.objects[1:], r.sparse[1:]
+
+	content := &objectContent{}
+	if object != nil {
+		for i, chunk := range object.Chunks {
+			last := len(content.segments) - 1
+			switch {
+			case sparse[i]:
+				content.segments = append(content.segments, objectSegment{length: int64(chunk.Length)})
+			case last >= 0 && content.segments[last].count != 0:
+				content.segments[last].count++
+				content.segments[last].length += int64(chunk.Length)
+			default:
+				content.segments = append(content.segments, objectSegment{count: 1, length: int64(chunk.Length)})
+			}
+		}
+		for i := range content.segments {
+			if content.segments[i].count != 0 {
+				content.segments[i].rd = r.stream.NextReader(content.segments[i].count)
+			}
+		}
+	}
+	r.current = content
+	return r.current, nil
+}
+
+func (r *ObjectsReader) Close() error {
+	return r.stream.Close()
+}
+
+// objectSegment is a run of chunks read from the stream, or a hole when it
+// has no chunks to read.
+type objectSegment struct {
+	rd     io.Reader
+	count  int
+	length int64
+}
+
+// objectContent reads the segments of an object in turn, a read never spans
+// two segments so that holes can be skipped between reads.
+type objectContent struct {
+	segments []objectSegment
+}
+
+func (c *objectContent) Read(p []byte) (int, error) {
+	for len(c.segments) != 0 {
+		segment := &c.segments[0]
+		if segment.length == 0 {
+			if err := c.next(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		if int64(len(p)) > segment.length {
+			p = p[:segment.length]
+		}
+		if segment.rd == nil {
+			clear(p)
+			segment.length -= int64(len(p))
+			return len(p), nil
+		}
+		n, err := segment.rd.Read(p)
+		segment.length -= int64(n)
+		if err == io.EOF {
+			if segment.length != 0 {
+				return n, io.ErrUnexpectedEOF
+			}
+			err = nil
+		}
+		return n, err
+	}
+	return 0, io.EOF
+}
+
+// next moves to the next segment, draining the blobs left in the current
+// one such as those of empty chunks.
+func (c *objectContent) next() error {
+	if rd := c.segments[0].rd; rd != nil {
+		if _, err := io.Copy(io.Discard, rd); err != nil {
+			return err
+		}
+	}
+	c.segments = c.segments[1:]
+	return nil
+}
+
+// SkipHole skips the hole at the current offset and returns its length, or
+// 0 if the content continues with data.
+func (c *objectContent) SkipHole() int64 {
+	for len(c.segments) != 0 && c.segments[0].rd != nil && c.segments[0].length == 0 {
+		if c.next() != nil {
+			return 0
+		}
+	}
+	if len(c.segments) == 0 || c.segments[0].rd != nil {
+		return 0
+	}
+	length := c.segments[0].length
+	c.segments = c.segments[1:]
+	return length
+}
+
+// discard skips the rest of the content.
+func (c *objectContent) discard() error {
+	for len(c.segments) != 0 {
+		if c.segments[0].rd != nil {
+			c.segments[0].length = 0
+		}
+		if err := c.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectsPlan hands out readers over the content of objects in turn.  The
+// planned stream is only started by the first read, from the object being
+// read, so that nothing is fetched for exporters recording the objects
+// without reading them.
+type objectsPlan struct {
+	snap    *Snapshot
+	objects []*objects.Object
+	reader  *ObjectsReader
+	next    int
+}
+
+func (snap *Snapshot) newObjectsPlan(objs []*objects.Object) *objectsPlan {
+	return &objectsPlan{snap: snap, objects: objs}
+}
+
+// content returns the content of the object at index, the objects before
+// it are skipped.
+func (plan *objectsPlan) content(index int) (*objectContent, error) {
+	if plan.reader == nil {
+		plan.reader = plan.snap.NewObjectsReader(plan.objects[index:])
+		plan.next = index
+	}
+	if index < plan.next {
+		return nil, fmt.Errorf("object %d already read", index)
+	}
+	for ; plan.next < index; plan.next++ {
+		if _, err := plan.reader.NextObject(); err != nil {
+			return nil, err
+		}
+	}
+	rd, err := plan.reader.NextObject()
+	if err != nil {
+		return nil, err
+	}
+	plan.next++
+	return rd.(*objectContent), nil
+}
+
+// newReader returns a reader over the object at index, it must be read
+// before the readers of the objects after it.
+func (plan *objectsPlan) newReader(index int) *objectReader {
+	return &objectReader{
+		plan:       plan,
+		index:      index,
+		repository: plan.snap.repository,
+		object:     plan.objects[index],
+	}
+}
+
+func (plan *objectsPlan) Close() error {
+	if plan.reader == nil {
+		return nil
+	}
+	return plan.reader.Close()
+}
+
+// objectReader reads the content of an object of a plan, the plan is only
+// closed with the reader when it owns it.
+type objectReader struct {
+	plan       *objectsPlan
+	index      int
+	ownsPlan   bool
+	content    *objectContent
+	err        error
+	repository *repository.Repository
+	object     *objects.Object
+}
+
+func (rd *objectReader) open() error {
+	if rd.content == nil && rd.err == nil {
+		rd.content, rd.err = rd.plan.content(rd.index)
+	}
+	return rd.err
+}
+
+func (rd *objectReader) Read(p []byte) (int, error) {
+	if err := rd.open(); err != nil {
+		return 0, err
+	}
+	return rd.content.Read(p)
+}
+
+// SkipHole implements exporter.SparseReader.
+func (rd *objectReader) SkipHole() int64 {
+	if rd.open() != nil {
+		return 0
+	}
+	return rd.content.SkipHole()
+}
+
+func (rd *objectReader) Close() error {
+	if rd.ownsPlan {
+		return rd.plan.Close()
+	}
+	return nil
+}
+
+// Repository and Object implement exporter.ObjectReader.
+func (rd *objectReader) Repository() *repository.Repository {
+	return rd.repository
+}
+
+func (rd *objectReader) Object() *objects.Object {
+	return rd.object
+}
+
+// Snapshot returns the snapshot the object is read from, so that exporters
+// keeping the object can read it again through NewObjectsReader.
+func (rd *objectReader) Snapshot() *Snapshot {
+	return rd.plan.snap
+}
+
+// NewObjectReader returns a reader over the content of object, chunks are
+// fetched through the repository read planner once it is first read. A nil
+// object reads as empty.
+func (snap *Snapshot) NewObjectReader(object *objects.Object) (io.ReadCloser, error) {
+	rd := snap.newObjectsPlan([]*objects.Object{object}).newReader(0)
+	rd.ownsPlan = true
+	return rd, nil
 }
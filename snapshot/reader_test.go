@@ -0,0 +1,203 @@
+package snapshot
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/storage"
+)
+
+// backupSparseFile backs up a file of data, a hole and data again with
+// small chunks, it returns the snapshot, the pathname of the file and its
+// expected content.
+func backupSparseFile(t *testing.T) (*Snapshot, string, []byte) {
+	t.Helper()
+
+	repo := testutil.NewRepository(t, func(configuration *storage.Configuration) {
+		configuration.Chunking.MinSize = 16 * 1024
+		configuration.Chunking.NormalSize = 32 * 1024
+		configuration.Chunking.MaxSize = 64 * 1024
+	})
+
+	rnd := rand.New(rand.NewSource(1))
+	content := make([]byte, 1<<20+200000)
+	rnd.Read(content[:300000])
+	rnd.Read(content[1<<20:])
+
+	src := t.TempDir()
+	pathname := filepath.Join(src, "sparse")
+	fp, err := os.Create(pathname)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := fp.Truncate(int64(len(content))); err != nil {
+		t.Fatalf("Failed to truncate file: %v", err)
+	}
+	for _, off := range []int{0, 1 << 20} {
+		end := off + 300000
+		if off != 0 {
+			end = len(content)
+		}
+		if _, err := fp.WriteAt(content[off:end], int64(off)); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := fp.Close(); err != nil {
+		t.Fatalf("Failed to close file: %v", err)
+	}
+
+	return backupTree(t, repo, src, nil), pathname, content
+}
+
+func fileObject(t *testing.T, snap *Snapshot, pathname string) *objects.Object {
+	t.Helper()
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		t.Fatalf("Failed to open filesystem: %v", err)
+	}
+	entry, err := fs.Stat(pathname)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", pathname, err)
+	}
+	return entry.(*vfs.FileEntry).Object
+}
+
+func TestReaderReadAt(t *testing.T) {
+	snap, pathname, content := backupSparseFile(t)
+	size := int64(len(content))
+
+	object := fileObject(t, snap, pathname)
+	if len(object.Chunks) < 10 {
+		t.Fatalf("Expected the file to be cut into many chunks, got %d", len(object.Chunks))
+	}
+	// holes are only detected where the importer supports it
+	if runtime.GOOS == "linux" && !object.IsSparse() {
+		t.Fatalf("Expected the file to be backed up with holes")
+	}
+
+	rd, err := snap.NewReader(pathname)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", pathname, err)
+	}
+	defer rd.Close()
+
+	readAt := func(off int64, length int) {
+		t.Helper()
+		buf := make([]byte, length)
+		n, err := rd.ReadAt(buf, off)
+		if n != length || err != nil {
+			t.Fatalf("Expected %d bytes at %d, got %d: %v", length, off, n, err)
+		}
+		if !bytes.Equal(buf, content[off:off+int64(length)]) {
+			t.Errorf("Unexpected content of %d bytes at %d", length, off)
+		}
+	}
+
+	// across every chunk boundary, holes included
+	var offset int64
+	for _, chunk := range object.Chunks {
+		if offset > 0 {
+			readAt(offset-5, 10)
+		}
+		offset += int64(chunk.Length)
+	}
+	readAt(0, len(content))
+	readAt(500000, 1000)
+	readAt(290000, 20000)
+	readAt(1<<20-10000, 20000)
+
+	buf := make([]byte, 10)
+	if n, err := rd.ReadAt(buf, size-3); n != 3 || err != io.EOF {
+		t.Errorf("Expected 3 bytes and io.EOF at the end, got %d: %v", n, err)
+	} else if !bytes.Equal(buf[:3], content[size-3:]) {
+		t.Errorf("Unexpected content at the end")
+	}
+	for _, off := range []int64{size, size + 100} {
+		if n, err := rd.ReadAt(buf, off); n != 0 || err != io.EOF {
+			t.Errorf("Expected io.EOF at %d, got %d: %v", off, n, err)
+		}
+	}
+	if _, err := rd.ReadAt(buf, -1); err == nil {
+		t.Errorf("Expected an error for a negative offset")
+	}
+}
+
+func TestReaderSeek(t *testing.T) {
+	snap, pathname, content := backupSparseFile(t)
+	size := int64(len(content))
+
+	rd, err := snap.NewReader(pathname)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", pathname, err)
+	}
+	defer rd.Close()
+
+	read := func(length int, expected []byte) {
+		t.Helper()
+		buf := make([]byte, length)
+		n, err := io.ReadFull(rd, buf)
+		if err != nil {
+			t.Fatalf("Failed to read %d bytes: %v", length, err)
+		}
+		if !bytes.Equal(buf[:n], expected) {
+			t.Errorf("Unexpected content of %d bytes", length)
+		}
+	}
+
+	for _, test := range []struct {
+		offset   int64
+		whence   int
+		expected int64
+	}{
+		{299990, io.SeekStart, 299990},
+		{-30, io.SeekCurrent, 299980},
+		{-100, io.SeekEnd, size - 100},
+	} {
+		pos, err := rd.Seek(test.offset, test.whence)
+		if err != nil || pos != test.expected {
+			t.Fatalf("Expected seek to %d, got %d: %v", test.expected, pos, err)
+		}
+		read(20, content[pos:pos+20])
+	}
+
+	// reads continue from the offset reached
+	pos, err := rd.Seek(0, io.SeekCurrent)
+	if err != nil || pos != size-80 {
+		t.Fatalf("Expected offset %d, got %d: %v", size-80, pos, err)
+	}
+	rest, err := io.ReadAll(rd)
+	if err != nil || !bytes.Equal(rest, content[size-80:]) {
+		t.Errorf("Expected the last 80 bytes, got %d: %v", len(rest), err)
+	}
+
+	if _, err := rd.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("Expected an error seeking to a negative offset")
+	}
+	if pos, err := rd.Seek(0, io.SeekCurrent); err != nil || pos != size {
+		t.Errorf("Expected a failed seek to keep the offset, got %d: %v", pos, err)
+	}
+
+	if _, err := rd.Seek(size+10, io.SeekStart); err != nil {
+		t.Fatalf("Failed to seek past the end: %v", err)
+	}
+	if n, err := rd.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("Expected io.EOF past the end, got %d: %v", n, err)
+	}
+
+	if _, err := rd.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Failed to seek: %v", err)
+	}
+	all, err := io.ReadAll(rd)
+	if err != nil || !bytes.Equal(all, content) {
+		t.Errorf("Expected the whole content once rewound, got %d bytes: %v", len(all), err)
+	}
+}
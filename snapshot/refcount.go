@@ -0,0 +1,265 @@
+package snapshot
+
+import (
+	"sort"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/packfile"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot/header"
+	"github.com/PlakarKorp/plakar/snapshot/vfs"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type chunkRef struct {
+	Checksum objects.Checksum `msgpack:"checksum"`
+	Length   uint32           `msgpack:"length"`
+	Count    uint32           `msgpack:"count"`
+}
+
+// chunkRefs returns the chunks referenced by the snapshot along with the
+// number of times each is referenced. Snapshots are immutable so the result
+// is kept in the repository cache.
+func (snap *Snapshot) chunkRefs() ([]chunkRef, error) {
+	cache, err := snap.Context().GetCache().Repository(snap.repository.Configuration().RepositoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := cache.GetSnapshotRefs(snap.Header.Identifier)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		var refs []chunkRef
+		if err := msgpack.Unmarshal(data, &refs); err == nil {
+			return refs, nil
+		}
+	}
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	var walkErr error
+	refs := make([]chunkRef, 0)
+	index := make(map[objects.Checksum]int)
+	for filename := range fs.Files() {
+		if walkErr != nil {
+			continue
+		}
+		fsentry, err := fs.Stat(filename)
+		if err != nil {
+			walkErr = err
+			continue
+		}
+		fileEntry, isFile := fsentry.(*vfs.FileEntry)
+		if !isFile || fileEntry.Object == nil {
+			continue
+		}
+		for _, chunk := range fileEntry.Object.Chunks {
+			if chunk.IsHole() {
+				continue
+			}
+			if offset, exists := index[chunk.Checksum]; exists {
+				refs[offset].Count++
+				continue
+			}
+			index[chunk.Checksum] = len(refs)
+			refs = append(refs, chunkRef{Checksum: chunk.Checksum, Length: chunk.Length, Count: 1})
+		}
+	}
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	data, err = msgpack.Marshal(refs)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.PutSnapshotRefs(snap.Header.Identifier, data); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+type chunkCount struct {
+	length uint32
+	stored uint32
+	refs   uint32
+}
+
+type snapshotRefs struct {
+	header *header.Header
+	chunks []chunkRef
+}
+
+// RefCounts holds, for each chunk, the number of snapshots referencing it.
+type RefCounts struct {
+	chunks    map[objects.Checksum]*chunkCount
+	snapshots []*snapshotRefs
+}
+
+// NewRefCounts computes chunk reference counts across the given snapshots,
+// which should be all the snapshots of the repository for the counts to
+// reflect what deleting a snapshot would free.
+func NewRefCounts(repo *repository.Repository, snapshotIDs []objects.Checksum) (*RefCounts, error) {
+	rc := &RefCounts{
+		chunks:    make(map[objects.Checksum]*chunkCount),
+		snapshots: make([]*snapshotRefs, 0, len(snapshotIDs)),
+	}
+
+	for _, snapshotID := range snapshotIDs {
+		snap, err := Load(repo, snapshotID)
+		if err != nil {
+			return nil, err
+		}
+		refs, err := snap.chunkRefs()
+		if err != nil {
+			return nil, err
+		}
+		rc.snapshots = append(rc.snapshots, &snapshotRefs{header: snap.Header, chunks: refs})
+
+		for _, ref := range refs {
+			count, exists := rc.chunks[ref.Checksum]
+			if !exists {
+				// missing chunks are accounted for with no stored size
+				stored, _ := repo.GetBlobSize(packfile.TYPE_CHUNK, ref.Checksum)
+				count = &chunkCount{length: ref.Length, stored: stored}
+				rc.chunks[ref.Checksum] = count
+			}
+			count.refs++
+		}
+	}
+
+	return rc, nil
+}
+
+// Refs returns the number of snapshots referencing a chunk.
+func (rc *RefCounts) Refs(checksum objects.Checksum) uint32 {
+	if count, exists := rc.chunks[checksum]; exists {
+		return count.refs
+	}
+	return 0
+}
+
+type SnapshotStats struct {
+	SnapshotID       objects.Checksum `json:"snapshot_id"`
+	Hostname         string           `json:"hostname"`
+	Timestamp        time.Time        `json:"timestamp"`
+	Chunks           uint64           `json:"chunks"`
+	LogicalSize      uint64           `json:"logical_size"`
+	StoredSize       uint64           `json:"stored_size"`
+	UniqueSize       uint64           `json:"unique_size"`
+	SharedSize       uint64           `json:"shared_size"`
+	CompressionRatio float64          `json:"compression_ratio"`
+}
+
+type HostStats struct {
+	Hostname    string  `json:"hostname"`
+	Snapshots   int     `json:"snapshots"`
+	LogicalSize uint64  `json:"logical_size"`
+	StoredSize  uint64  `json:"stored_size"`
+	DedupRatio  float64 `json:"dedup_ratio"`
+}
+
+type Stats struct {
+	Snapshots        int             `json:"snapshots"`
+	Chunks           uint64          `json:"chunks"`
+	LogicalSize      uint64          `json:"logical_size"`
+	DedupedSize      uint64          `json:"deduped_size"`
+	StoredSize       uint64          `json:"stored_size"`
+	DedupRatio       float64         `json:"dedup_ratio"`
+	CompressionRatio float64         `json:"compression_ratio"`
+	Hosts            []HostStats     `json:"hosts"`
+	SnapshotsStats   []SnapshotStats `json:"snapshots_stats"`
+}
+
+func ratio(a, b uint64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// Stats reports deduplication and compression statistics, sizes are in
+// bytes: logical sizes count every reference to a chunk, stored sizes count
+// each chunk once as encoded in packfiles. Snapshots are sorted by
+// decreasing unique size, which is what deleting them would free.
+func (rc *RefCounts) Stats() *Stats {
+	stats := &Stats{
+		Snapshots:      len(rc.snapshots),
+		Chunks:         uint64(len(rc.chunks)),
+		Hosts:          make([]HostStats, 0),
+		SnapshotsStats: make([]SnapshotStats, 0, len(rc.snapshots)),
+	}
+
+	for _, count := range rc.chunks {
+		stats.DedupedSize += uint64(count.length)
+		stats.StoredSize += uint64(count.stored)
+	}
+
+	hosts := make(map[string]*HostStats)
+	hostChunks := make(map[string]map[objects.Checksum]struct{})
+	hostDeduped := make(map[string]uint64)
+
+	for _, snapshot := range rc.snapshots {
+		snapshotStats := SnapshotStats{
+			SnapshotID: snapshot.header.Identifier,
+			Hostname:   snapshot.header.GetContext("Hostname"),
+			Timestamp:  snapshot.header.Timestamp,
+			Chunks:     uint64(len(snapshot.chunks)),
+		}
+
+		hostname := snapshotStats.Hostname
+		if _, exists := hosts[hostname]; !exists {
+			hosts[hostname] = &HostStats{Hostname: hostname}
+			hostChunks[hostname] = make(map[objects.Checksum]struct{})
+		}
+		host := hosts[hostname]
+		host.Snapshots++
+
+		dedupedSize := uint64(0)
+		for _, ref := range snapshot.chunks {
+			count := rc.chunks[ref.Checksum]
+
+			snapshotStats.LogicalSize += uint64(ref.Length) * uint64(ref.Count)
+			snapshotStats.StoredSize += uint64(count.stored)
+			dedupedSize += uint64(ref.Length)
+			if count.refs == 1 {
+				snapshotStats.UniqueSize += uint64(count.stored)
+			} else {
+				snapshotStats.SharedSize += uint64(count.stored)
+			}
+
+			if _, exists := hostChunks[hostname][ref.Checksum]; !exists {
+				hostChunks[hostname][ref.Checksum] = struct{}{}
+				hostDeduped[hostname] += uint64(ref.Length)
+				host.StoredSize += uint64(count.stored)
+			}
+		}
+		snapshotStats.CompressionRatio = ratio(dedupedSize, snapshotStats.StoredSize)
+
+		host.LogicalSize += snapshotStats.LogicalSize
+		stats.LogicalSize += snapshotStats.LogicalSize
+		stats.SnapshotsStats = append(stats.SnapshotsStats, snapshotStats)
+	}
+
+	stats.DedupRatio = ratio(stats.LogicalSize, stats.DedupedSize)
+	stats.CompressionRatio = ratio(stats.DedupedSize, stats.StoredSize)
+
+	for hostname, host := range hosts {
+		host.DedupRatio = ratio(host.LogicalSize, hostDeduped[hostname])
+		stats.Hosts = append(stats.Hosts, *host)
+	}
+	sort.Slice(stats.Hosts, func(i, j int) bool {
+		return stats.Hosts[i].Hostname < stats.Hosts[j].Hostname
+	})
+	sort.SliceStable(stats.SnapshotsStats, func(i, j int) bool {
+		return stats.SnapshotsStats[i].UniqueSize > stats.SnapshotsStats[j].UniqueSize
+	})
+
+	return stats
+}
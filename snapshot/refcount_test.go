@@ -0,0 +1,79 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/objects"
+)
+
+func TestRefCountsStats(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	shared, unique := "shared content", "unique content!!"
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": shared, "b.txt": shared})
+	first := backupTree(t, repo, dir, nil)
+	if err := os.WriteFile(filepath.Join(dir, "c.txt"), []byte(unique), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	second := backupTree(t, repo, dir, nil)
+
+	ids := []objects.Checksum{first.Header.Identifier, second.Header.Identifier}
+	rc, err := NewRefCounts(repo, ids)
+	if err != nil {
+		t.Fatalf("Failed to count references: %v", err)
+	}
+	if refs := rc.Refs(repo.Checksum([]byte(shared))); refs != 2 {
+		t.Errorf("Expected the shared chunk to be referenced by 2 snapshots, got %d", refs)
+	}
+	if refs := rc.Refs(repo.Checksum([]byte(unique))); refs != 1 {
+		t.Errorf("Expected the unique chunk to be referenced by 1 snapshot, got %d", refs)
+	}
+
+	stats := rc.Stats()
+	if stats.Snapshots != 2 || stats.Chunks != 2 {
+		t.Fatalf("Expected 2 snapshots and 2 chunks, got %d and %d", stats.Snapshots, stats.Chunks)
+	}
+	logical := uint64(4*len(shared) + len(unique))
+	deduped := uint64(len(shared) + len(unique))
+	if stats.LogicalSize != logical || stats.DedupedSize != deduped {
+		t.Errorf("Expected logical and deduped sizes %d and %d, got %d and %d", logical, deduped, stats.LogicalSize, stats.DedupedSize)
+	}
+	if stats.DedupRatio != float64(logical)/float64(deduped) {
+		t.Errorf("Expected dedup ratio %f, got %f", float64(logical)/float64(deduped), stats.DedupRatio)
+	}
+	if stats.StoredSize == 0 {
+		t.Errorf("Expected a non-zero stored size")
+	}
+
+	if len(stats.Hosts) != 1 || stats.Hosts[0].Snapshots != 2 || stats.Hosts[0].LogicalSize != logical {
+		t.Errorf("Expected a single host with both snapshots, got %+v", stats.Hosts)
+	}
+
+	// sorted by decreasing unique size
+	newest, oldest := stats.SnapshotsStats[0], stats.SnapshotsStats[1]
+	if newest.SnapshotID != second.Header.Identifier {
+		t.Fatalf("Expected the second snapshot to come first")
+	}
+	if newest.UniqueSize == 0 || oldest.UniqueSize != 0 {
+		t.Errorf("Expected only the second snapshot to hold unique data, got %d and %d", newest.UniqueSize, oldest.UniqueSize)
+	}
+	if newest.SharedSize != oldest.SharedSize || oldest.SharedSize != oldest.StoredSize {
+		t.Errorf("Expected both snapshots to share the same data, got %d and %d", newest.SharedSize, oldest.SharedSize)
+	}
+	if oldest.Chunks != 1 || oldest.LogicalSize != uint64(2*len(shared)) {
+		t.Errorf("Expected the first snapshot to reference one chunk twice, got %d chunks for %d bytes", oldest.Chunks, oldest.LogicalSize)
+	}
+
+	// the second computation reads the references from the cache
+	rc, err = NewRefCounts(repo, ids)
+	if err != nil {
+		t.Fatalf("Failed to count references: %v", err)
+	}
+	if cached := rc.Stats(); cached.LogicalSize != stats.LogicalSize || cached.StoredSize != stats.StoredSize {
+		t.Errorf("Expected cached references to give the same stats")
+	}
+}
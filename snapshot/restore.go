@@ -1,134 +1,695 @@
 package snapshot
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/PlakarKorp/plakar/events"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/packfile"
 	"github.com/PlakarKorp/plakar/snapshot/exporter"
 	"github.com/PlakarKorp/plakar/snapshot/vfs"
 )
 
+// ConflictPolicy tells restore what to do with entries already present at
+// their destination.
+type ConflictPolicy int
+
+const (
+	ConflictOverwrite ConflictPolicy = iota
+	ConflictSkip
+	ConflictNewer
+	// ConflictSync updates files in place, rewriting only the chunks that
+	// differ, and removes the entries absent from the snapshot.
+	ConflictSync
+)
+
+func ParseConflictPolicy(policy string) (ConflictPolicy, error) {
+	switch policy {
+	case "overwrite":
+		return ConflictOverwrite, nil
+	case "skip":
+		return ConflictSkip, nil
+	case "newer":
+		return ConflictNewer, nil
+	case "sync":
+		return ConflictSync, nil
+	}
+	return ConflictOverwrite, fmt.Errorf("invalid conflict policy %q, must be overwrite, skip, newer or sync", policy)
+}
+
 type RestoreOptions struct {
-	MaxConcurrency uint64
-	Rebase         bool
+	MaxConcurrency     uint64
+	Rebase             bool
+	NoOwner            bool
+	NumericOwner       bool
+	NoXattrs           bool
+	Conflict           ConflictPolicy
+	ReplaceDirectories bool
+	Verify             bool
+	Filter             *PathFilter
+}
+
+var errRestoreMismatch = errors.New("checksum mismatch")
+
+// errRestoreDirectory is returned for files of the snapshot found as
+// directories at their destination, a whole tree is only removed to make
+// room for them with ReplaceDirectories.
+var errRestoreDirectory = errors.New("a directory is in the way")
+
+type RestoreEntry struct {
+	Pathname string `json:"pathname"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// RestoreReport tells what became of each entry of a restore, sorted by
+// pathname.  Mismatched entries were restored but failed verification.
+type RestoreReport struct {
+	Snapshot   objects.Checksum `json:"snapshot"`
+	Restored   []RestoreEntry   `json:"restored"`
+	Skipped    []RestoreEntry   `json:"skipped"`
+	Failed     []RestoreEntry   `json:"failed"`
+	Mismatched []RestoreEntry   `json:"mismatched"`
+
+	mu sync.Mutex
+}
+
+func (report *RestoreReport) add(entries *[]RestoreEntry, pathname string, reason string) {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+	*entries = append(*entries, RestoreEntry{Pathname: pathname, Reason: reason})
+}
+
+func (report *RestoreReport) sort() {
+	for _, entries := range [][]RestoreEntry{report.Restored, report.Skipped, report.Failed, report.Mismatched} {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Pathname < entries[j].Pathname
+		})
+	}
+}
+
+type hardlink struct {
+	dest string
+	done chan struct{}
+	err  error
+}
+
+// restoreDirectory is created along with its parents when the first entry
+// below it is restored, so that filtered restores leave no empty trees.
+type restoreDirectory struct {
+	parent  *restoreDirectory
+	dest    string
+	once    sync.Once
+	created bool
+	err     error
+}
+
+func (dir *restoreDirectory) create(exp exporter.Exporter) error {
+	if dir == nil {
+		return nil
+	}
+	dir.once.Do(func() {
+		if dir.err = dir.parent.create(exp); dir.err == nil && dir.dest != "" {
+			dir.err = exp.CreateDirectory(dir.dest)
+		}
+		dir.created = dir.err == nil
+	})
+	return dir.err
+}
+
+// restoreBatchFiles and restoreBatchSize bound the files of a directory
+// restored together, their content is read from a single planned stream.
+const (
+	restoreBatchFiles = 128
+	restoreBatchSize  = 32 << 20
+)
+
+type restoreJob struct {
+	dest      string
+	pathname  string
+	fileEntry *vfs.FileEntry
+	parent    *restoreDirectory
+}
+
+// restoreBatch gathers the files of a directory until it is flushed, the
+// flushed batches are waited for with wg.
+type restoreBatch struct {
+	wg   *sync.WaitGroup
+	jobs []*restoreJob
+	size int64
+}
+
+func (batch *restoreBatch) full() bool {
+	return len(batch.jobs) >= restoreBatchFiles || batch.size >= restoreBatchSize
 }
 
 type restoreContext struct {
-	hardlinks      map[string]string
+	hardlinks      map[string]*hardlink
 	hardlinksMutex sync.Mutex
 	maxConcurrency chan bool
+	sources        []string
+	report         *RestoreReport
 }
 
-func snapshotRestorePath(snap *Snapshot, fs *vfs.Filesystem, exp exporter.Exporter, target string, base string, pathname string, opts *RestoreOptions, restoreContext *restoreContext, wg *sync.WaitGroup) error {
+// prunable reports whether pathname lies within a source of the snapshot,
+// directories above them only hold the part that was backed up and must
+// not be pruned when syncing.
+func (restoreContext *restoreContext) prunable(pathname string) bool {
+	for _, source := range restoreContext.sources {
+		if source == "/" || pathname == source || strings.HasPrefix(pathname, source+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreDestination returns where pathname is restored below target, a
+// path mapping takes precedence over the rebase.
+func restoreDestination(target string, base string, pathname string, opts *RestoreOptions) string {
+	if mapped, ok := opts.Filter.Map(pathname); ok {
+		return path.Join(target, mapped)
+	}
+	if opts.Rebase && strings.HasPrefix(pathname, base) {
+		return path.Join(target, pathname[len(base):])
+	}
+	return path.Join(target, pathname)
+}
+
+func snapshotRestorePath(snap *Snapshot, fs *vfs.Filesystem, exp exporter.Exporter, target string, base string, pathname string, parent *restoreDirectory, opts *RestoreOptions, restoreContext *restoreContext, batch *restoreBatch) error {
+	if err := snap.Context().Err(); err != nil {
+		return err
+	}
+
 	snap.Event(events.PathEvent(snap.Header.Identifier, pathname))
+	report := restoreContext.report
 	fsinfo, err := fs.Stat(pathname)
 	if err != nil {
 		snap.Event(events.DirectoryMissingEvent(snap.Header.Identifier, pathname))
+		report.add(&report.Failed, pathname, err.Error())
 		return err
 	}
 
-	var dest string
-	if opts.Rebase && strings.HasPrefix(pathname, base) {
-		dest = path.Join(target, pathname[len(base):])
-	} else {
-		dest = path.Join(target, pathname)
+	if opts.Filter.Excluded(pathname) {
+		return nil
 	}
+	selected, err := opts.Filter.Match(snap, pathname, fsinfo)
+	if err != nil {
+		report.add(&report.Failed, pathname, err.Error())
+		return err
+	}
+	dest := restoreDestination(target, base, pathname, opts)
 
 	if dirEntry, isDir := fsinfo.(*vfs.DirEntry); isDir {
 		snap.Event(events.DirectoryEvent(snap.Header.Identifier, pathname))
 
+		dir := &restoreDirectory{parent: parent}
+		var existing *objects.FileInfo
 		if pathname != "/" {
-			if err := exp.CreateDirectory(dest); err != nil {
+			dir.dest = dest
+			var skipped string
+			existing, skipped, err = snapshotRestoreConflict(exp, dest, dirEntry.Stat(), opts)
+			if err != nil {
 				snap.Event(events.DirectoryErrorEvent(snap.Header.Identifier, pathname, err.Error()))
+				report.add(&report.Failed, pathname, err.Error())
+				return err
+			}
+			if skipped != "" {
+				snap.Event(events.DirectoryOKEvent(snap.Header.Identifier, pathname))
+				report.add(&report.Skipped, pathname, skipped)
+				return nil
+			}
+		}
+		if selected || !opts.Filter.Selective() {
+			if err := dir.create(exp); err != nil {
+				snap.Event(events.DirectoryErrorEvent(snap.Header.Identifier, pathname, err.Error()))
+				report.add(&report.Failed, pathname, err.Error())
 				return err
 			}
 		}
 		complete := true
+		names := make(map[string]struct{})
 
 		subwg := sync.WaitGroup{}
+		subbatch := &restoreBatch{wg: &subwg}
 
 		children, err := fs.ChildrenIter(dirEntry)
 		if err != nil {
+			report.add(&report.Failed, pathname, err.Error())
 			return err
 		}
 		for child := range children {
-			err = snapshotRestorePath(snap, fs, exp, target, base, path.Join(pathname, child.Stat().Name()), opts, restoreContext, &subwg)
+			if snap.Context().Err() != nil {
+				break
+			}
+			childPathname := path.Join(pathname, child.Stat().Name())
+			// mapped entries may land outside of dest
+			if childDest := restoreDestination(target, base, childPathname, opts); path.Dir(childDest) == dest {
+				names[path.Base(childDest)] = struct{}{}
+			}
+			err = snapshotRestorePath(snap, fs, exp, target, base, childPathname, dir, opts, restoreContext, subbatch)
 			if err != nil {
 				complete = false
 			}
 		}
+		snapshotRestoreBatch(snap, exp, opts, restoreContext, subbatch)
 		subwg.Wait()
 
+		if err := snap.Context().Err(); err != nil {
+			return err
+		}
+
 		if !complete {
 			snap.Event(events.DirectoryCorruptedEvent(snap.Header.Identifier, pathname))
+			report.add(&report.Failed, pathname, "incomplete directory")
 			return err
+		} else if !dir.created {
+			// nothing below was selected by the filter
+			return nil
 		} else {
-			if pathname != "/" {
-				if err := exp.SetPermissions(dest, dirEntry.Stat()); err != nil {
+			if opts.Conflict == ConflictSync && restoreContext.prunable(pathname) {
+				if err := snapshotRestorePrune(snap, exp, dest, pathname, names); err != nil {
+					snap.Event(events.DirectoryErrorEvent(snap.Header.Identifier, pathname, err.Error()))
+					report.add(&report.Failed, pathname, err.Error())
+					return err
+				}
+			}
+			// existing directories are left as they are when skipping
+			if pathname != "/" && (existing == nil || opts.Conflict != ConflictSkip) {
+				if err := snapshotRestoreAttributes(snap, exp, dest, pathname, dirEntry.Stat(), dirEntry.ExtendedAttributes, opts); err != nil {
 					snap.Event(events.DirectoryErrorEvent(snap.Header.Identifier, pathname, err.Error()))
+					report.add(&report.Failed, pathname, err.Error())
 					return err
 				}
 			}
 			snap.Event(events.DirectoryOKEvent(snap.Header.Identifier, pathname))
+			report.add(&report.Restored, pathname, "")
+			return nil
+		}
+	} else if fileEntry, isFile := fsinfo.(*vfs.FileEntry); isFile {
+		if !selected {
 			return nil
 		}
-	} else if fileEntry, isFile := fsinfo.(*vfs.FileEntry); isFile && fileEntry.Stat().Mode().IsRegular() {
 		snap.Event(events.FileEvent(snap.Header.Identifier, pathname))
 
-		restoreContext.maxConcurrency <- true
-		wg.Add(1)
-		go func(_fileEntry *vfs.FileEntry) {
-			defer wg.Done()
-			defer func() { <-restoreContext.maxConcurrency }()
-
-			if fileEntry.Stat().Nlink() > 1 {
-				key := fmt.Sprintf("%d:%d", fileEntry.Stat().Dev(), fileEntry.Stat().Ino())
-				restoreContext.hardlinksMutex.Lock()
-				v, ok := restoreContext.hardlinks[key]
-				restoreContext.hardlinksMutex.Unlock()
-				if ok {
-					os.Link(v, dest)
-					return
-				} else {
-					restoreContext.hardlinksMutex.Lock()
-					restoreContext.hardlinks[key] = dest
-					restoreContext.hardlinksMutex.Unlock()
-				}
+		batch.jobs = append(batch.jobs, &restoreJob{dest: dest, pathname: pathname, fileEntry: fileEntry, parent: parent})
+		batch.size += fileEntry.Stat().Size()
+		if batch.full() {
+			snapshotRestoreBatch(snap, exp, opts, restoreContext, batch)
+		}
+		return nil
+	} else {
+		report.add(&report.Failed, pathname, "unexpected vfs entry type")
+		return fmt.Errorf("unexpected vfs entry type")
+	}
+}
+
+// snapshotRestoreBatch restores the files of batch in turn in a goroutine
+// and empties it.  The content of the regular files is planned as a whole,
+// reads are coalesced across files and not only within each one.
+func snapshotRestoreBatch(snap *Snapshot, exp exporter.Exporter, opts *RestoreOptions, restoreContext *restoreContext, batch *restoreBatch) {
+	if len(batch.jobs) == 0 {
+		return
+	}
+	jobs := batch.jobs
+	batch.jobs, batch.size = nil, 0
+
+	restoreContext.maxConcurrency <- true
+	batch.wg.Add(1)
+	go func() {
+		defer batch.wg.Done()
+		defer func() { <-restoreContext.maxConcurrency }()
+
+		objs := make([]*objects.Object, 0, len(jobs))
+		readers := make([]io.ReadCloser, len(jobs))
+		for _, job := range jobs {
+			if job.fileEntry.Stat().Mode().IsRegular() {
+				objs = append(objs, job.fileEntry.Object)
 			}
+		}
+		plan := snap.newObjectsPlan(objs)
+		defer plan.Close()
+		for i, index := 0, 0; i < len(jobs); i++ {
+			if jobs[i].fileEntry.Stat().Mode().IsRegular() {
+				readers[i] = plan.newReader(index)
+				index++
+			}
+		}
 
-			rd, err := snap.NewReader(pathname)
-			if err != nil {
-				snap.Event(events.FileErrorEvent(snap.Header.Identifier, pathname, err.Error()))
+		for i, job := range jobs {
+			if snap.Context().Err() != nil {
 				return
 			}
-			defer rd.Close()
+			snapshotRestoreJob(snap, exp, opts, restoreContext, job, readers[i])
+		}
+	}()
+}
+
+// snapshotRestoreJob restores a file and reports what became of it, rd
+// reads the content of regular files.
+func snapshotRestoreJob(snap *Snapshot, exp exporter.Exporter, opts *RestoreOptions, restoreContext *restoreContext, job *restoreJob, rd io.ReadCloser) {
+	report := restoreContext.report
+	pathname, fileEntry := job.pathname, job.fileEntry
+
+	var skipped string
+	err := job.parent.create(exp)
+	if err == nil {
+		skipped, err = snapshotRestoreFile(snap, exp, job.dest, pathname, fileEntry, rd, opts, restoreContext)
+	}
+	if err == nil && skipped == "" && opts.Verify && fileEntry.Stat().Mode().IsRegular() {
+		err = snapshotRestoreVerify(snap, exp, job.dest, fileEntry)
+	}
+
+	switch {
+	case errors.Is(err, exporter.ErrNotSupported):
+		snap.Logger().Warn("%s: skipped: %s", pathname, err)
+		report.add(&report.Skipped, pathname, err.Error())
+	case errors.Is(err, errRestoreMismatch):
+		snap.Event(events.FileErrorEvent(snap.Header.Identifier, pathname, err.Error()))
+		report.add(&report.Mismatched, pathname, err.Error())
+	case err != nil:
+		snap.Event(events.FileErrorEvent(snap.Header.Identifier, pathname, err.Error()))
+		report.add(&report.Failed, pathname, err.Error())
+	case skipped != "":
+		snap.Event(events.FileOKEvent(snap.Header.Identifier, pathname))
+		report.add(&report.Skipped, pathname, skipped)
+	default:
+		snap.Event(events.FileOKEvent(snap.Header.Identifier, pathname))
+		report.add(&report.Restored, pathname, "")
+	}
+}
+
+// snapshotRestoreFile restores any non-directory entry, or returns why the
+// conflict policy skipped it.  Entries sharing an inode are restored once,
+// the others wait for it and are linked to it.  The content of regular
+// files is read from rd.
+func snapshotRestoreFile(snap *Snapshot, exp exporter.Exporter, dest string, pathname string, fileEntry *vfs.FileEntry, rd io.ReadCloser, opts *RestoreOptions, restoreContext *restoreContext) (skipped string, err error) {
+	fileinfo := fileEntry.Stat()
+
+	existing, skipped, err := snapshotRestoreConflict(exp, dest, fileinfo, opts)
+	if err != nil || skipped != "" {
+		return skipped, err
+	}
+
+	if fileinfo.Nlink() > 1 {
+		key := fmt.Sprintf("%d:%d", fileinfo.Dev(), fileinfo.Ino())
+		restoreContext.hardlinksMutex.Lock()
+		link, ok := restoreContext.hardlinks[key]
+		if !ok {
+			link = &hardlink{dest: dest, done: make(chan struct{})}
+			restoreContext.hardlinks[key] = link
+		}
+		restoreContext.hardlinksMutex.Unlock()
 
-			if err := exp.StoreFile(dest, rd); err != nil {
-				snap.Event(events.FileErrorEvent(snap.Header.Identifier, pathname, err.Error()))
-			} else if err := exp.SetPermissions(dest, fileEntry.Stat()); err != nil {
-				snap.Event(events.FileErrorEvent(snap.Header.Identifier, pathname, err.Error()))
-			} else {
-				snap.Event(events.FileOKEvent(snap.Header.Identifier, pathname))
+		if ok {
+			<-link.done
+			// if the first entry failed, restore this one on its own
+			if link.err == nil {
+				if existing != nil {
+					if err := exp.Remove(dest); err != nil {
+						return "", err
+					}
+				}
+				return "", exp.CreateLink(link.dest, dest)
 			}
+		} else {
+			defer func() {
+				link.err = err
+				close(link.done)
+			}()
+		}
+	}
+
+	switch mode := fileinfo.Mode(); {
+	case mode.IsRegular():
+		if existing != nil {
+			if skipped, err = snapshotRestoreUpdate(snap, exp, dest, fileEntry, existing, rd); err != nil {
+				return "", err
+			}
+			break
+		}
+
+		if err := exp.StoreFile(dest, rd); err != nil {
+			return "", err
+		}
+	case mode&os.ModeSymlink != 0:
+		if err := exp.CreateSymlink(dest, fileEntry.SymlinkTarget); err != nil {
+			return "", err
+		}
+	case mode&(os.ModeDevice|os.ModeNamedPipe|os.ModeSocket) != 0:
+		if err := exp.CreateSpecial(dest, fileinfo); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unexpected file mode %s", mode)
+	}
+	// the attributes of unchanged files are brought in line all the same
+	return skipped, snapshotRestoreAttributes(snap, exp, dest, pathname, fileinfo, fileEntry.ExtendedAttributes, opts)
+}
+
+// snapshotRestoreConflict applies the conflict policy to the entry at dest
+// and returns why it is skipped, if it is.  It returns the entry when it is
+// kept to be updated in place, which only happens to directories and to
+// regular files when syncing, anything else is removed to be recreated.
+func snapshotRestoreConflict(exp exporter.Exporter, dest string, fileinfo *objects.FileInfo, opts *RestoreOptions) (*objects.FileInfo, string, error) {
+	existing, err := exp.Stat(dest)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+
+	if existing.IsDir() && fileinfo.IsDir() {
+		return existing, "", nil
+	}
+
+	switch opts.Conflict {
+	case ConflictSkip:
+		return existing, "already exists", nil
+	case ConflictNewer:
+		if !fileinfo.ModTime().After(existing.ModTime()) {
+			return existing, "destination is not older", nil
+		}
+	case ConflictSync:
+		if existing.Mode().IsRegular() && fileinfo.Mode().IsRegular() {
+			return existing, "", nil
+		}
+	}
+
+	if existing.IsDir() && !opts.ReplaceDirectories {
+		return nil, "", errRestoreDirectory
+	}
+	if err := exp.Remove(dest); err != nil {
+		return nil, "", err
+	}
+	return nil, "", nil
+}
 
-		}(fileEntry)
+// snapshotRestoreVerify reads back a restored file and compares it with the
+// checksum of its object.
+func snapshotRestoreVerify(snap *Snapshot, exp exporter.Exporter, dest string, fileEntry *vfs.FileEntry) error {
+	rd, err := exp.Open(dest)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	hasher := snap.repository.Hasher()
+	size, err := io.Copy(hasher, rd)
+	if err != nil {
+		return err
+	}
+
+	if fileEntry.Object == nil {
+		if size != 0 {
+			return fmt.Errorf("%w: expected an empty file, got %d bytes", errRestoreMismatch, size)
+		}
 		return nil
-	} else {
-		return fmt.Errorf("unexpected vfs entry type")
 	}
+
+	var checksum objects.Checksum
+	copy(checksum[:], hasher.Sum(nil))
+	if checksum != fileEntry.Object.Checksum {
+		return fmt.Errorf("%w: expected %x, got %x", errRestoreMismatch, fileEntry.Object.Checksum[:4], checksum[:4])
+	}
+	return nil
 }
 
-func (snap *Snapshot) Restore(exp exporter.Exporter, base string, pathname string, opts *RestoreOptions) error {
+// snapshotRestoreUpdate brings an existing file in line with the snapshot
+// and returns why it was left alone, if it was.  Files with the same size
+// and mtime are assumed intact, others are updated in place.  Exporters
+// unable to do so, as well as files sharing their inode which would change
+// along with their other links, compare the checksum of the files of the
+// right size and store the others from rd.
+func snapshotRestoreUpdate(snap *Snapshot, exp exporter.Exporter, dest string, fileEntry *vfs.FileEntry, existing *objects.FileInfo, rd io.Reader) (string, error) {
+	fileinfo := fileEntry.Stat()
+	if existing.Size() == fileinfo.Size() && existing.ModTime().Equal(fileinfo.ModTime()) {
+		return "unchanged", nil
+	}
+
+	if existing.Nlink() <= 1 {
+		fp, err := exp.OpenFile(dest)
+		if err == nil {
+			defer fp.Close()
+			return snapshotRestoreInPlace(snap, fp, fileEntry, existing)
+		}
+		if !errors.Is(err, exporter.ErrNotSupported) {
+			return "", err
+		}
+	}
+
+	if existing.Size() == fileinfo.Size() {
+		err := snapshotRestoreVerify(snap, exp, dest, fileEntry)
+		if err == nil {
+			return "unchanged", nil
+		}
+		if !errors.Is(err, errRestoreMismatch) && !errors.Is(err, exporter.ErrNotSupported) {
+			return "", err
+		}
+	}
+	if existing.Nlink() > 1 {
+		if err := exp.Remove(dest); err != nil {
+			return "", err
+		}
+	}
+	return "", exp.StoreFile(dest, rd)
+}
+
+// snapshotRestoreInPlace compares an existing file chunk by chunk with the
+// snapshot and only fetches and writes the chunks that differ.
+func snapshotRestoreInPlace(snap *Snapshot, fp exporter.File, fileEntry *vfs.FileEntry, existing *objects.FileInfo) (string, error) {
+	var offset int64
+	written := false
+	if fileEntry.Object != nil {
+		buffer := make([]byte, 0)
+		sparse := snap.sparseChunks(fileEntry.Object)
+		for i, chunk := range fileEntry.Object.Chunks {
+			if snap.Context().Err() != nil {
+				return "", snap.Context().Err()
+			}
+
+			if sparse[i] {
+				zeroed, err := snapshotRestoreZeroes(fp, offset, int64(chunk.Length))
+				if err != nil {
+					return "", err
+				}
+				written = written || zeroed
+				offset += int64(chunk.Length)
+				continue
+			}
+
+			if cap(buffer) < int(chunk.Length) {
+				buffer = make([]byte, chunk.Length)
+			}
+			buffer = buffer[:chunk.Length]
+
+			n, err := fp.ReadAt(buffer, offset)
+			if err != nil && err != io.EOF {
+				return "", err
+			}
+			if n != len(buffer) || snap.repository.Checksum(buffer) != chunk.Checksum {
+				data, err := snap.GetBlob(packfile.TYPE_CHUNK, chunk.Checksum)
+				if err != nil {
+					return "", err
+				}
+				if _, err := fp.WriteAt(data, offset); err != nil {
+					return "", err
+				}
+				written = true
+			}
+			offset += int64(chunk.Length)
+		}
+	}
+	if !written && existing.Size() == offset {
+		return "unchanged", nil
+	}
+	return "", fp.Truncate(offset)
+}
+
+// snapshotRestoreZeroes makes sure that length bytes at offset in fp are
+// zeros, only writing over the parts that are not, and reports whether it
+// wrote.  Past the end of the file nothing is written, the final truncation
+// leaves a hole there.
+func snapshotRestoreZeroes(fp exporter.File, offset int64, length int64) (bool, error) {
+	written := false
+	buffer := make([]byte, min(length, int64(len(zeroes))))
+	for length > 0 {
+		buffer = buffer[:min(length, int64(len(buffer)))]
+		n, err := fp.ReadAt(buffer, offset)
+		if err != nil && err != io.EOF {
+			return written, err
+		}
+		if !bytes.Equal(buffer[:n], zeroes[:n]) {
+			if _, err := fp.WriteAt(zeroes[:n], offset); err != nil {
+				return written, err
+			}
+			written = true
+		}
+		if n != len(buffer) {
+			return written, nil
+		}
+		offset += int64(len(buffer))
+		length -= int64(len(buffer))
+	}
+	return written, nil
+}
+
+// snapshotRestorePrune removes the entries of dest that the snapshot does not
+// have in pathname.
+func snapshotRestorePrune(snap *Snapshot, exp exporter.Exporter, dest string, pathname string, names map[string]struct{}) error {
+	entries, err := exp.ReadDir(dest)
+	if err != nil {
+		return err
+	}
+	for _, name := range entries {
+		if _, ok := names[name]; ok {
+			continue
+		}
+		snap.Logger().Info("%s: removing %s, not in snapshot", pathname, name)
+		if err := exp.Remove(path.Join(dest, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotRestoreAttributes restores the metadata of an entry once its content
+// is in place.  The owner goes first as chown clears the setuid bits, and the
+// times go last as the other changes would update them.  POSIX ACLs are
+// stored as extended attributes and are restored along with them.
+func snapshotRestoreAttributes(snap *Snapshot, exp exporter.Exporter, dest string, pathname string, fileinfo *objects.FileInfo, xattrs []vfs.ExtendedAttribute, opts *RestoreOptions) error {
+	if !opts.NoOwner {
+		if err := exp.SetOwner(dest, fileinfo, opts.NumericOwner); err != nil {
+			return err
+		}
+	}
+	if err := exp.SetPermissions(dest, fileinfo); err != nil {
+		return err
+	}
+	if !opts.NoXattrs {
+		for _, xattr := range xattrs {
+			if err := exp.SetExtendedAttribute(dest, xattr.Name, xattr.Value); err != nil {
+				snap.Logger().Warn("%s: could not restore extended attribute %s: %s", pathname, xattr.Name, err)
+			}
+		}
+	}
+	return exp.SetTimes(dest, fileinfo)
+}
+
+func (snap *Snapshot) Restore(exp exporter.Exporter, base string, pathname string, opts *RestoreOptions) (*RestoreReport, error) {
 	snap.Event(events.StartEvent())
 	defer snap.Event(events.DoneEvent())
 
 	fs, err := snap.Filesystem()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	maxConcurrency := opts.MaxConcurrency
@@ -137,9 +698,19 @@ func (snap *Snapshot) Restore(exp exporter.Exporter, base string, pathname strin
 	}
 
 	restoreContext := &restoreContext{
-		hardlinks:      make(map[string]string),
+		hardlinks:      make(map[string]*hardlink),
 		hardlinksMutex: sync.Mutex{},
 		maxConcurrency: make(chan bool, maxConcurrency),
+		report: &RestoreReport{
+			Snapshot:   snap.Header.Identifier,
+			Restored:   make([]RestoreEntry, 0),
+			Skipped:    make([]RestoreEntry, 0),
+			Failed:     make([]RestoreEntry, 0),
+			Mismatched: make([]RestoreEntry, 0),
+		},
+	}
+	for _, source := range snap.Header.GetSources() {
+		restoreContext.sources = append(restoreContext.sources, path.Clean(source.Directory))
 	}
 	defer close(restoreContext.maxConcurrency)
 
@@ -149,7 +720,13 @@ func (snap *Snapshot) Restore(exp exporter.Exporter, base string, pathname strin
 	}
 
 	wg := sync.WaitGroup{}
-	defer wg.Wait()
-
-	return snapshotRestorePath(snap, fs, exp, base, pathname, pathname, opts, restoreContext, &wg)
+	batch := &restoreBatch{wg: &wg}
+	err = snapshotRestorePath(snap, fs, exp, base, pathname, pathname, nil, opts, restoreContext, batch)
+	snapshotRestoreBatch(snap, exp, opts, restoreContext, batch)
+	wg.Wait()
+	restoreContext.report.sort()
+	if ctxErr := snap.Context().Err(); ctxErr != nil {
+		return restoreContext.report, ctxErr
+	}
+	return restoreContext.report, err
 }
@@ -0,0 +1,231 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/pkg/xattr"
+	"golang.org/x/sys/unix"
+)
+
+func TestRestoreDevice(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("creating devices requires the superuser")
+	}
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	if err := unix.Mknod(filepath.Join(src, "null"), unix.S_IFCHR|0600, int(unix.Mkdev(1, 3))); err != nil {
+		t.Skipf("Failed to create device: %v", err)
+	}
+	snap := backupTree(t, repo, src, nil)
+
+	dst := t.TempDir()
+	report := restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true})
+	if len(report.Failed) != 0 {
+		t.Fatalf("Expected no failures, got %v", report.Failed)
+	}
+
+	fi, err := os.Lstat(filepath.Join(dst, "null"))
+	if err != nil {
+		t.Fatalf("Failed to stat restored device: %v", err)
+	}
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		t.Fatalf("Expected a character device, got %s", fi.Mode())
+	}
+	rdev := uint64(fi.Sys().(*syscall.Stat_t).Rdev)
+	if unix.Major(rdev) != 1 || unix.Minor(rdev) != 3 {
+		t.Errorf("Expected device 1,3, got %d,%d", unix.Major(rdev), unix.Minor(rdev))
+	}
+}
+
+// posixACL encodes an access ACL granting mode 0750 to the owner, read
+// access to uid 1234 and nothing to others, as stored in
+// system.posix_acl_access.
+func posixACL() []byte {
+	acl := []byte{2, 0, 0, 0}
+	for _, entry := range []struct {
+		tag, perm uint16
+		id        uint32
+	}{
+		{0x01, 7, 0xffffffff},
+		{0x02, 4, 1234},
+		{0x04, 5, 0xffffffff},
+		{0x10, 5, 0xffffffff},
+		{0x20, 0, 0xffffffff},
+	} {
+		acl = binary.LittleEndian.AppendUint16(acl, entry.tag)
+		acl = binary.LittleEndian.AppendUint16(acl, entry.perm)
+		acl = binary.LittleEndian.AppendUint32(acl, entry.id)
+	}
+	return acl
+}
+
+func TestRestoreAttributes(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("restoring owners requires the superuser")
+	}
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "hello"})
+	pathname := filepath.Join(src, "a.txt")
+	if err := os.Chmod(pathname, 0640); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+	if err := os.Lchown(pathname, 1234, 1235); err != nil {
+		t.Fatalf("Failed to chown: %v", err)
+	}
+	if err := xattr.LSet(pathname, "user.comment", []byte("greeting")); err != nil {
+		t.Skipf("Failed to set extended attribute: %v", err)
+	}
+	acl := xattr.LSet(pathname, "system.posix_acl_access", posixACL()) == nil
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(pathname, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+	srcInfo, err := os.Lstat(pathname)
+	if err != nil {
+		t.Fatalf("Failed to stat: %v", err)
+	}
+	snap := backupTree(t, repo, src, nil)
+
+	dst := t.TempDir()
+	restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true})
+	restored := filepath.Join(dst, "a.txt")
+	fi, err := os.Lstat(restored)
+	if err != nil {
+		t.Fatalf("Failed to stat restored file: %v", err)
+	}
+	if fi.Mode() != srcInfo.Mode() {
+		t.Errorf("Expected mode %s, got %s", srcInfo.Mode(), fi.Mode())
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("Expected mtime %s, got %s", mtime, fi.ModTime())
+	}
+	if stat := fi.Sys().(*syscall.Stat_t); stat.Uid != 1234 || stat.Gid != 1235 {
+		t.Errorf("Expected owner 1234:1235, got %d:%d", stat.Uid, stat.Gid)
+	}
+	if value, err := xattr.LGet(restored, "user.comment"); err != nil || string(value) != "greeting" {
+		t.Errorf("Expected user.comment to be restored, got %q: %v", value, err)
+	}
+	if acl {
+		value, err := xattr.LGet(restored, "system.posix_acl_access")
+		if err != nil || !bytes.Equal(value, posixACL()) {
+			t.Errorf("Expected the ACL to be restored, got %v: %v", value, err)
+		}
+	}
+
+	dst = t.TempDir()
+	restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true, NoOwner: true, NoXattrs: true})
+	restored = filepath.Join(dst, "a.txt")
+	fi, err = os.Lstat(restored)
+	if err != nil {
+		t.Fatalf("Failed to stat restored file: %v", err)
+	}
+	if stat := fi.Sys().(*syscall.Stat_t); stat.Uid != 0 || stat.Gid != 0 {
+		t.Errorf("Expected -no-owner to leave the file to the superuser, got %d:%d", stat.Uid, stat.Gid)
+	}
+	if _, err := xattr.LGet(restored, "user.comment"); err == nil {
+		t.Errorf("Expected -no-xattrs not to restore user.comment")
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("Expected mtime %s, got %s", mtime, fi.ModTime())
+	}
+}
+
+func TestRestoreOwnerNames(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("restoring owners requires the superuser")
+	}
+	repo := testutil.NewRepository(t, nil)
+	snap, err := New(repo)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	defer snap.closePacker()
+
+	dir := t.TempDir()
+	exp, err := exporter.NewExporter(dir)
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+	defer exp.Close()
+
+	// a file owned by root on a system where root has another uid
+	fileinfo := &objects.FileInfo{
+		Lname:      "a.txt",
+		Lmode:      0644,
+		LmodTime:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Luid:       1234,
+		Lgid:       1235,
+		Lusername:  "root",
+		Lgroupname: "root",
+	}
+	for _, test := range []struct {
+		numeric  bool
+		uid, gid uint32
+	}{
+		{false, 0, 0},
+		{true, 1234, 1235},
+	} {
+		dest := filepath.Join(dir, "a.txt")
+		if err := os.WriteFile(dest, []byte("hello"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		opts := &RestoreOptions{NumericOwner: test.numeric}
+		if err := snapshotRestoreAttributes(snap, exp, dest, "/a.txt", fileinfo, nil, opts); err != nil {
+			t.Fatalf("Failed to restore attributes: %v", err)
+		}
+		fi, err := os.Lstat(dest)
+		if err != nil {
+			t.Fatalf("Failed to stat: %v", err)
+		}
+		if stat := fi.Sys().(*syscall.Stat_t); stat.Uid != test.uid || stat.Gid != test.gid {
+			t.Errorf("Expected owner %d:%d with numeric=%v, got %d:%d", test.uid, test.gid, test.numeric, stat.Uid, stat.Gid)
+		}
+		if fi.Mode() != 0644 {
+			t.Errorf("Expected mode 0644, got %s", fi.Mode())
+		}
+	}
+}
+
+func TestBackupCacheExtendedAttributes(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "hello"})
+	pathname := filepath.Join(src, "a.txt")
+	if err := xattr.LSet(pathname, "user.comment", []byte("first")); err != nil {
+		t.Skipf("Failed to set extended attribute: %v", err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(pathname, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+	backupTree(t, repo, src, nil)
+
+	// setfattr leaves the mtime alone
+	if err := xattr.LSet(pathname, "user.comment", []byte("second")); err != nil {
+		t.Fatalf("Failed to set extended attribute: %v", err)
+	}
+	snap := backupTree(t, repo, src, nil)
+
+	dst := t.TempDir()
+	restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true})
+	value, err := xattr.LGet(filepath.Join(dst, "a.txt"), "user.comment")
+	if err != nil {
+		t.Fatalf("Failed to get extended attribute: %v", err)
+	}
+	if string(value) != "second" {
+		t.Errorf("Expected the changed extended attribute to be backed up, got %q", value)
+	}
+}
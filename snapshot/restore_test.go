@@ -0,0 +1,113 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"golang.org/x/sys/unix"
+)
+
+func TestRestoreBatches(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	// more files than a batch holds, some of them empty
+	files := make(map[string]string)
+	for i := 0; i < restoreBatchFiles+10; i++ {
+		files[fmt.Sprintf("dir/file%03d", i)] = strings.Repeat(fmt.Sprintf("content %d\n", i), i)
+	}
+	src := t.TempDir()
+	writeTree(t, src, files)
+	if err := os.Link(filepath.Join(src, "dir/file010"), filepath.Join(src, "dir/link")); err != nil {
+		t.Fatalf("Failed to create hardlink: %v", err)
+	}
+	files["dir/link"] = files["dir/file010"]
+	snap := backupTree(t, repo, src, nil)
+
+	dst := t.TempDir()
+	report := restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true, MaxConcurrency: 2})
+	if len(report.Failed) != 0 {
+		t.Fatalf("Expected no failures, got %v", report.Failed)
+	}
+	// the files, dir and the source directory
+	if len(report.Restored) != len(files)+2 {
+		t.Errorf("Expected %d restored entries, got %d", len(files)+2, len(report.Restored))
+	}
+
+	for name, expected := range files {
+		content, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatalf("Failed to read restored %s: %v", name, err)
+		}
+		if string(content) != expected {
+			t.Errorf("Expected %s to hold %d bytes, got %d", name, len(expected), len(content))
+		}
+	}
+
+	st1, err := os.Stat(filepath.Join(dst, "dir/file010"))
+	if err != nil {
+		t.Fatalf("Failed to stat restored file: %v", err)
+	}
+	st2, err := os.Stat(filepath.Join(dst, "dir/link"))
+	if err != nil {
+		t.Fatalf("Failed to stat restored link: %v", err)
+	}
+	if !os.SameFile(st1, st2) {
+		t.Errorf("Expected dir/link to be a hardlink to dir/file010")
+	}
+}
+
+func TestRestoreSpecialFiles(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"dir/file": "content"})
+	if err := os.Symlink("dir/file", filepath.Join(src, "symlink")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.Link(filepath.Join(src, "dir/file"), filepath.Join(src, "hardlink")); err != nil {
+		t.Fatalf("Failed to create hardlink: %v", err)
+	}
+	if err := unix.Mkfifo(filepath.Join(src, "fifo"), 0640); err != nil {
+		t.Fatalf("Failed to create fifo: %v", err)
+	}
+	snap := backupTree(t, repo, src, nil)
+
+	dst := t.TempDir()
+	report := restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true})
+	if len(report.Failed) != 0 {
+		t.Fatalf("Expected no failures, got %v", report.Failed)
+	}
+
+	target, err := os.Readlink(filepath.Join(dst, "symlink"))
+	if err != nil {
+		t.Fatalf("Failed to read restored symlink: %v", err)
+	}
+	if target != "dir/file" {
+		t.Errorf("Expected symlink to point to dir/file, got %s", target)
+	}
+
+	fi1, err := os.Stat(filepath.Join(dst, "dir/file"))
+	if err != nil {
+		t.Fatalf("Failed to stat restored file: %v", err)
+	}
+	fi2, err := os.Stat(filepath.Join(dst, "hardlink"))
+	if err != nil {
+		t.Fatalf("Failed to stat restored hardlink: %v", err)
+	}
+	if !os.SameFile(fi1, fi2) {
+		t.Errorf("Expected hardlink to be restored as a link to dir/file")
+	}
+
+	fi, err := os.Lstat(filepath.Join(dst, "fifo"))
+	if err != nil {
+		t.Fatalf("Failed to stat restored fifo: %v", err)
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 || fi.Mode().Perm() != 0640 {
+		t.Errorf("Expected a fifo with mode 0640, got %s", fi.Mode())
+	}
+
+}
@@ -0,0 +1,386 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/packfile"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot/header"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+	"github.com/PlakarKorp/plakar/snapshot/vfs"
+	"github.com/google/uuid"
+)
+
+type RewriteOptions struct {
+	MaxConcurrency uint64
+}
+
+// snapshotImporter exposes a source of a snapshot as an importer so that
+// it can be fed back into the backup machinery, it scans the source
+// directory, what is below it and its parents.
+type snapshotImporter struct {
+	snap   *Snapshot
+	fs     *vfs.Filesystem
+	source header.Importer
+}
+
+func newSnapshotImporter(snap *Snapshot, source header.Importer) (*snapshotImporter, error) {
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotImporter{snap: snap, fs: fs, source: source}, nil
+}
+
+func (p *snapshotImporter) Origin() string {
+	return p.source.Origin
+}
+
+func (p *snapshotImporter) Type() string {
+	return "snapshot"
+}
+
+func (p *snapshotImporter) Root() string {
+	return p.source.Directory
+}
+
+// scans reports whether pathname belongs to the source.
+func (p *snapshotImporter) scans(pathname string) bool {
+	root := p.source.Directory
+	return pathname == root || isBelow(pathname, root) || isBelow(root, pathname)
+}
+
+func (p *snapshotImporter) Scan(ctx context.Context, options *importer.ScanOptions) (<-chan importer.ScanResult, error) {
+	c := make(chan importer.ScanResult, 1000)
+
+	errorsChan, err := p.snap.Errors("/")
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer close(c)
+
+		for pathname := range p.fs.Pathnames() {
+			if ctx.Err() != nil {
+				break
+			}
+			if !p.scans(pathname) {
+				continue
+			}
+			entry, err := p.fs.Stat(pathname)
+			if err != nil {
+				c <- importer.ScanError{Pathname: pathname, Err: err}
+				continue
+			}
+
+			switch entry := entry.(type) {
+			case *vfs.DirEntry:
+				c <- importer.ScanRecord{
+					Type:               importer.RecordTypeDirectory,
+					Pathname:           pathname,
+					FileInfo:           entry.FileInfo,
+					ExtendedAttributes: extendedAttributesMap(entry.ExtendedAttributes),
+					FileAttributes:     entry.FileAttributes,
+				}
+			case *vfs.FileEntry:
+				c <- importer.ScanRecord{
+					Type:               entry.Type,
+					Pathname:           pathname,
+					Target:             entry.SymlinkTarget,
+					FileInfo:           entry.FileInfo,
+					ExtendedAttributes: extendedAttributesMap(entry.ExtendedAttributes),
+					FileAttributes:     entry.FileAttributes,
+				}
+			}
+		}
+
+		for item := range errorsChan {
+			if p.scans(item.Name) {
+				c <- importer.ScanError{Pathname: item.Name, Err: errors.New(item.Error)}
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+// IgnoreRules returns nothing as the exclusions of the snapshot are kept in
+// its header.
+func (p *snapshotImporter) IgnoreRules() []*importer.IgnoreRule {
+	return nil
+}
+
+func (p *snapshotImporter) NewReader(pathname string) (io.ReadCloser, error) {
+	return p.fs.Open(pathname)
+}
+
+func (p *snapshotImporter) Close() error {
+	return nil
+}
+
+func extendedAttributesMap(attrs []vfs.ExtendedAttribute) map[string][]byte {
+	ret := make(map[string][]byte)
+	for _, attr := range attrs {
+		ret[attr.Name] = attr.Value
+	}
+	return ret
+}
+
+func newRewriter(repo *repository.Repository, hdr *header.Header) *Snapshot {
+	snap := &Snapshot{
+		repository: repo,
+		stateDelta: repo.NewStateDelta(),
+
+		Header: hdr,
+
+		packerChan:     make(chan interface{}, runtime.NumCPU()*2+1),
+		packerChanDone: make(chan bool),
+	}
+	go packerJob(snap)
+	return snap
+}
+
+// Rewrite copies the snapshot into the dst repository, re-encoding every
+// blob with the compression and encryption settings of dst.
+//
+// If both repositories share the same hashing and chunking settings, blobs
+// are copied as-is and the header and its signature are kept intact.
+// Otherwise, the tree is rebuilt from the snapshot content which preserves
+// the identifier and metadata of the header, but only preserves the
+// signature if the current identity is the one that signed it.
+func (snap *Snapshot) Rewrite(dst *repository.Repository, options *RewriteOptions) error {
+	var err error
+	if snap.sameChunking(dst) {
+		err = snap.rewriteBlobs(dst, options)
+	} else {
+		err = snap.rewriteTree(dst, options)
+	}
+	if err != nil {
+		return err
+	}
+	// the following snapshots must see what this one copied
+	return dst.RebuildState()
+}
+
+// visitBlobs calls fn once with each blob of the snapshot except for its
+// header and signature, it stops at the first error.
+func (snap *Snapshot) visitBlobs(fn func(Type packfile.Type, checksum objects.Checksum) error) error {
+	visitors := []struct {
+		Type  packfile.Type
+		Visit func(func(objects.Checksum) error) error
+	}{
+		{packfile.TYPE_CHUNK, visitList(snap.ListChunks)},
+		{packfile.TYPE_OBJECT, visitList(snap.ListObjects)},
+		{packfile.TYPE_FILE, visitList(snap.ListFiles)},
+		{packfile.TYPE_DIRECTORY, visitList(snap.ListDirectories)},
+		{packfile.TYPE_CHILD, snap.VisitChildren},
+		{packfile.TYPE_ERROR, snap.VisitErrors},
+		{packfile.TYPE_DATA, visitList(snap.ListDatas)},
+	}
+
+	for _, visitor := range visitors {
+		seen := make(map[objects.Checksum]struct{})
+		err := visitor.Visit(func(checksum objects.Checksum) error {
+			if checksum == (objects.Checksum{}) {
+				return nil
+			}
+			if _, exists := seen[checksum]; exists {
+				return nil
+			}
+			seen[checksum] = struct{}{}
+			return fn(visitor.Type, checksum)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func visitList(list func() (<-chan objects.Checksum, error)) func(func(objects.Checksum) error) error {
+	return func(fn func(objects.Checksum) error) error {
+		c, err := list()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			for range c {
+			}
+		}()
+		for checksum := range c {
+			if err := fn(checksum); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// StoredSize returns the size of the blobs of snapshots as stored in their
+// repository, blobs shared between them are counted once.
+func StoredSize(snapshots []*Snapshot) (uint64, error) {
+	type blob struct {
+		Type     packfile.Type
+		checksum objects.Checksum
+	}
+	seen := make(map[blob]struct{})
+
+	total := uint64(0)
+	add := func(snap *Snapshot, Type packfile.Type, checksum objects.Checksum) error {
+		if _, exists := seen[blob{Type, checksum}]; exists {
+			return nil
+		}
+		seen[blob{Type, checksum}] = struct{}{}
+
+		length, err := snap.repository.GetBlobSize(Type, checksum)
+		if err != nil {
+			return err
+		}
+		total += uint64(length)
+		return nil
+	}
+
+	for _, snap := range snapshots {
+		err := snap.visitBlobs(func(Type packfile.Type, checksum objects.Checksum) error {
+			return add(snap, Type, checksum)
+		})
+		if err != nil {
+			return 0, err
+		}
+		if err := add(snap, packfile.TYPE_SNAPSHOT, snap.Header.Identifier); err != nil {
+			return 0, err
+		}
+		if snap.BlobExists(packfile.TYPE_SIGNATURE, snap.Header.Identifier) {
+			if err := add(snap, packfile.TYPE_SIGNATURE, snap.Header.Identifier); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return total, nil
+}
+
+func (snap *Snapshot) rewriteBlobs(dst *repository.Repository, options *RewriteOptions) error {
+	serializedHdr, err := snap.GetBlob(packfile.TYPE_SNAPSHOT, snap.Header.Identifier)
+	if err != nil {
+		return err
+	}
+
+	maxConcurrency := options.MaxConcurrency
+	if maxConcurrency == 0 {
+		maxConcurrency = uint64(snap.Context().GetMaxConcurrency())
+	}
+
+	writer := newRewriter(dst, snap.Header)
+
+	var firstErr error
+	var muErr sync.Mutex
+	concurrency := make(chan bool, maxConcurrency)
+	wg := sync.WaitGroup{}
+
+	err = snap.visitBlobs(func(Type packfile.Type, checksum objects.Checksum) error {
+		if dst.BlobExists(Type, checksum) {
+			return nil
+		}
+
+		concurrency <- true
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-concurrency
+				wg.Done()
+			}()
+
+			data, err := snap.GetBlob(Type, checksum)
+			if err == nil {
+				err = writer.PutBlob(Type, checksum, data)
+			}
+			if err != nil {
+				muErr.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				muErr.Unlock()
+			}
+		}()
+		return nil
+	})
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	if snap.BlobExists(packfile.TYPE_SIGNATURE, snap.Header.Identifier) {
+		signature, err := snap.GetBlob(packfile.TYPE_SIGNATURE, snap.Header.Identifier)
+		if err != nil {
+			return err
+		}
+		if err := writer.PutBlob(packfile.TYPE_SIGNATURE, snap.Header.Identifier, signature); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.PutBlob(packfile.TYPE_SNAPSHOT, snap.Header.Identifier, serializedHdr); err != nil {
+		return err
+	}
+
+	snap.Logger().Trace("snapshot", "%x: Rewrite(): blobs copied", snap.Header.GetIndexShortID())
+	return writer.commitState()
+}
+
+func (snap *Snapshot) rewriteTree(dst *repository.Repository, options *RewriteOptions) error {
+	imps := make([]importer.Importer, 0)
+	for _, source := range snap.Header.GetSources() {
+		imp, err := newSnapshotImporter(snap, source)
+		if err != nil {
+			return err
+		}
+		defer imp.Close()
+		imps = append(imps, imp)
+	}
+
+	hdr := *snap.Header
+	writer := newRewriter(dst, &hdr)
+
+	if err := writer.backup(imps, &BackupOptions{MaxConcurrency: options.MaxConcurrency}); err != nil {
+		return err
+	}
+	writer.Header.Duration = snap.Header.Duration
+
+	kp := writer.Context().GetKeypair()
+	canSign := kp != nil && bytes.Equal(kp.PublicKey, writer.Header.Identity.PublicKey)
+	if writer.Header.Identity.Identifier != uuid.Nil && !canSign {
+		snap.Logger().Warn("%x: signature can't be preserved, rewritten snapshot is unsigned", snap.Header.GetIndexShortID())
+		writer.Header.Identity = header.Identity{}
+	}
+
+	serializedHdr, err := writer.Header.Serialize()
+	if err != nil {
+		return err
+	}
+
+	if writer.Header.Identity.Identifier != uuid.Nil {
+		serializedHdrChecksum := dst.Checksum(serializedHdr)
+		signature := kp.Sign(serializedHdrChecksum[:])
+		if err := writer.PutBlob(packfile.TYPE_SIGNATURE, writer.Header.Identifier, signature); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.PutBlob(packfile.TYPE_SNAPSHOT, writer.Header.Identifier, serializedHdr); err != nil {
+		return err
+	}
+
+	snap.Logger().Trace("snapshot", "%x: Rewrite(): tree rebuilt", snap.Header.GetIndexShortID())
+	return writer.commitState()
+}
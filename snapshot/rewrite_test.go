@@ -0,0 +1,128 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/packfile"
+	"github.com/PlakarKorp/plakar/repository/state"
+	"github.com/PlakarKorp/plakar/storage"
+)
+
+func TestRewriteSharedBlobs(t *testing.T) {
+	src := testutil.NewRepository(t, nil)
+	dst := testutil.NewRepository(t, nil)
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"shared.txt": "shared content"})
+	first := backupTree(t, src, dir, nil)
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("new content"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	second := backupTree(t, src, dir, nil)
+
+	for _, snap := range []*Snapshot{first, second} {
+		if err := snap.Rewrite(dst, &RewriteOptions{MaxConcurrency: 2}); err != nil {
+			t.Fatalf("Failed to rewrite snapshot: %v", err)
+		}
+	}
+
+	rd, err := dst.GetState(second.Header.Identifier)
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	delta, err := state.DeserializeStream(rd)
+	if err != nil {
+		t.Fatalf("Failed to deserialize state: %v", err)
+	}
+	st := state.New()
+	st.Merge(second.Header.Identifier, delta)
+
+	err = first.visitBlobs(func(Type packfile.Type, checksum objects.Checksum) error {
+		if Type == packfile.TYPE_CHUNK && st.BlobExists(Type, checksum) {
+			t.Errorf("Expected chunk %x of the first snapshot not to be copied again", checksum[:4])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to visit blobs: %v", err)
+	}
+
+	copies := make([]*Snapshot, 0)
+	for _, snap := range []*Snapshot{first, second} {
+		loaded, err := Load(dst, snap.Header.Identifier)
+		if err != nil {
+			t.Fatalf("Failed to load rewritten snapshot: %v", err)
+		}
+		copies = append(copies, loaded)
+	}
+
+	srcSize, err := StoredSize([]*Snapshot{first, second})
+	if err != nil {
+		t.Fatalf("Failed to compute source size: %v", err)
+	}
+	dstSize, err := StoredSize(copies)
+	if err != nil {
+		t.Fatalf("Failed to compute destination size: %v", err)
+	}
+	if srcSize == 0 || srcSize != dstSize {
+		t.Errorf("Expected identical non-zero sizes with identical settings, got %d and %d", srcSize, dstSize)
+	}
+}
+
+func TestRewriteSources(t *testing.T) {
+	src := testutil.NewRepository(t, nil)
+	dst := testutil.NewRepository(t, func(configuration *storage.Configuration) {
+		configuration.Chunking.NormalSize = 512 * 1024
+	})
+
+	dirA := t.TempDir()
+	dirB := t.TempDir()
+	writeTree(t, dirA, map[string]string{"a.txt": "first source"})
+	writeTree(t, dirB, map[string]string{"sub/b.txt": "second source"})
+
+	snap, err := New(src)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if err := snap.BackupSources([]string{dirA, dirB}, &BackupOptions{MaxConcurrency: 2}); err != nil {
+		t.Fatalf("Failed to back up sources: %v", err)
+	}
+	if err := snap.Rewrite(dst, &RewriteOptions{MaxConcurrency: 2}); err != nil {
+		t.Fatalf("Failed to rewrite snapshot: %v", err)
+	}
+
+	copied, err := Load(dst, snap.Header.Identifier)
+	if err != nil {
+		t.Fatalf("Failed to load rewritten snapshot: %v", err)
+	}
+	sources := copied.Header.GetSources()
+	if len(sources) != 2 {
+		t.Fatalf("Expected 2 sources, got %d", len(sources))
+	}
+	for i, dir := range []string{dirA, dirB} {
+		if sources[i].Directory != filepath.ToSlash(dir) {
+			t.Errorf("Expected source %d to be %s, got %s", i, dir, sources[i].Directory)
+		}
+	}
+
+	for i, expected := range []map[string]string{
+		{"a.txt": "first source"},
+		{"sub/b.txt": "second source"},
+	} {
+		dstDir := t.TempDir()
+		restoreTree(t, copied, dstDir, sources[i].Directory, &RestoreOptions{Rebase: true})
+		for name, content := range expected {
+			data, err := os.ReadFile(filepath.Join(dstDir, name))
+			if err != nil {
+				t.Fatalf("Failed to read restored %s: %v", name, err)
+			}
+			if string(data) != content {
+				t.Errorf("Expected %s to hold %q, got %q", name, content, data)
+			}
+		}
+	}
+}
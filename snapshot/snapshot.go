@@ -3,12 +3,12 @@ package snapshot
 import (
 	"bytes"
 	"crypto/rand"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PlakarKorp/plakar/context"
@@ -37,8 +37,15 @@ type Snapshot struct {
 
 	Header *header.Header
 
-	packerChan     chan interface{}
-	packerChanDone chan bool
+	packerChan      chan interface{}
+	packerChanDone  chan bool
+	packerErrOnce   sync.Once
+	packerErr       error
+	packerCloseOnce sync.Once
+	packerDiscard   atomic.Bool
+
+	zeroChunkOnce sync.Once
+	zeroChunk     objects.Checksum
 }
 
 type PackerMsg struct {
@@ -46,43 +53,50 @@ type PackerMsg struct {
 	Type      packfile.Type
 	Checksum  objects.Checksum
 	Data      []byte
+	Flags     packfile.Flags
 }
 
+// packerJob packs data blobs (chunks and datas) and metadata blobs into
+// separate packfiles, so that browsing a snapshot only needs the smaller
+// metadata packfiles.
 func packerJob(snap *Snapshot) {
+	configuration := snap.repository.Configuration().Packfile
+
 	wg := sync.WaitGroup{}
 	for i := 0; i < runtime.NumCPU(); i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			var packer *Packer
+			var dataPacker, metadataPacker *Packer
 
 			for msg := range snap.packerChan {
-				if packer == nil {
-					packer = NewPacker()
-				}
-
 				if msg, ok := msg.(*PackerMsg); !ok {
 					panic("received data with unexpected type")
 				} else {
+					packer := &dataPacker
+					flags := msg.Flags
+					if msg.Type.IsMetadata() {
+						packer = &metadataPacker
+						flags |= packfile.FLAG_METADATA
+					}
+					if *packer == nil {
+						*packer = NewPacker()
+					}
+
 					snap.Logger().Trace("packer", "%x: PackerMsg(%d, %064x), dt=%s", snap.Header.GetIndexShortID(), msg.Type, msg.Checksum, time.Since(msg.Timestamp))
-					packer.AddBlob(msg.Type, msg.Checksum, msg.Data)
-				}
+					(*packer).AddBlob(msg.Type, msg.Checksum, msg.Data, flags)
 
-				if packer.Size() > uint32(snap.repository.Configuration().Packfile.MaxSize) {
-					err := snap.PutPackfile(packer)
-					if err != nil {
-						panic(err)
+					if (*packer).Size() > configuration.MaxSizeFor(msg.Type) {
+						snap.flushPacker(*packer)
+						*packer = nil
 					}
-					packer = nil
 				}
 			}
 
-			if packer != nil {
-				err := snap.PutPackfile(packer)
-				if err != nil {
-					panic(err)
+			for _, packer := range []*Packer{dataPacker, metadataPacker} {
+				if packer != nil && !snap.packerDiscard.Load() {
+					snap.flushPacker(packer)
 				}
-				packer = nil
 			}
 		}()
 	}
@@ -91,6 +105,34 @@ func packerJob(snap *Snapshot) {
 	close(snap.packerChanDone)
 }
 
+// flushPacker writes the packfile of packer.  On failure its blobs are not
+// recorded in the state delta, the error is kept for closePacker to return
+// so that the snapshot is not committed.
+func (snap *Snapshot) flushPacker(packer *Packer) {
+	if err := snap.PutPackfile(packer); err != nil {
+		snap.Logger().Warn("could not write packfile: %s", err)
+		snap.packerErrOnce.Do(func() { snap.packerErr = err })
+	}
+}
+
+// closePacker writes the pending packfiles and stops the packer, it returns
+// the first error writing a packfile.  Further calls only return the error.
+func (snap *Snapshot) closePacker() error {
+	snap.packerCloseOnce.Do(func() {
+		close(snap.packerChan)
+		<-snap.packerChanDone
+	})
+	return snap.packerErr
+}
+
+// discardPacker stops the packer without writing the pending packfiles, as
+// done when a backup fails: no state would reference their blobs.  It does
+// nothing once the packer is closed.
+func (snap *Snapshot) discardPacker() {
+	snap.packerDiscard.Store(true)
+	snap.closePacker()
+}
+
 func New(repo *repository.Repository) (*Snapshot, error) {
 	var identifier objects.Checksum
 
@@ -233,39 +275,11 @@ func (snap *Snapshot) PutPackfile(packer *Packer) error {
 
 	repo := snap.repository
 
-	serializedData, err := packer.Packfile.SerializeData()
+	serializedPackfile, err := repo.EncodePackfile(packer.Packfile)
 	if err != nil {
-		panic("could not serialize pack file data" + err.Error())
-	}
-	serializedIndex, err := packer.Packfile.SerializeIndex()
-	if err != nil {
-		panic("could not serialize pack file index" + err.Error())
-	}
-	serializedFooter, err := packer.Packfile.SerializeFooter()
-	if err != nil {
-		panic("could not serialize pack file footer" + err.Error())
-	}
-
-	encryptedIndex, err := repo.EncodeBuffer(serializedIndex)
-	if err != nil {
-		return err
+		return fmt.Errorf("could not serialize pack file: %w", err)
 	}
 
-	encryptedFooter, err := repo.EncodeBuffer(serializedFooter)
-	if err != nil {
-		return err
-	}
-
-	encryptedFooterLength := uint8(len(encryptedFooter))
-
-	versionBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(versionBytes, packer.Packfile.Footer.Version)
-
-	serializedPackfile := append(serializedData, encryptedIndex...)
-	serializedPackfile = append(serializedPackfile, encryptedFooter...)
-	serializedPackfile = append(serializedPackfile, versionBytes...)
-	serializedPackfile = append(serializedPackfile, byte(encryptedFooterLength))
-
 	checksum := snap.repository.Checksum(serializedPackfile)
 
 	var checksum32 objects.Checksum
@@ -274,7 +288,7 @@ func (snap *Snapshot) PutPackfile(packer *Packer) error {
 	repo.Logger().Trace("snapshot", "%x: PutPackfile(%x, ...)", snap.Header.GetIndexShortID(), checksum32)
 	err = snap.repository.PutPackfile(checksum32, bytes.NewBuffer(serializedPackfile))
 	if err != nil {
-		panic("could not write pack file")
+		return fmt.Errorf("could not write pack file: %w", err)
 	}
 
 	for _, Type := range packer.Types() {
@@ -284,11 +298,13 @@ func (snap *Snapshot) PutPackfile(packer *Packer) error {
 					snap.Repository().SetPackfileForBlob(Type, checksum32,
 						blobChecksum,
 						packer.Packfile.Index[idx].Offset,
-						packer.Packfile.Index[idx].Length)
-					snap.stateDelta.SetPackfileForBlob(Type, checksum32,
+						packer.Packfile.Index[idx].Length,
+						packer.Packfile.Index[idx].Flags)
+					snap.stateDelta.SetPackfileForBlobWithFlags(Type, checksum32,
 						blobChecksum,
 						packer.Packfile.Index[idx].Offset,
-						packer.Packfile.Index[idx].Length)
+						packer.Packfile.Index[idx].Length,
+						packer.Packfile.Index[idx].Flags)
 					break
 				}
 			}
@@ -299,9 +315,6 @@ func (snap *Snapshot) PutPackfile(packer *Packer) error {
 }
 
 func (snap *Snapshot) Commit() error {
-
-	repo := snap.repository
-
 	serializedHdr, err := snap.Header.Serialize()
 	if err != nil {
 		return err
@@ -319,11 +332,20 @@ func (snap *Snapshot) Commit() error {
 		return err
 	}
 
-	close(snap.packerChan)
-	<-snap.packerChanDone
+	return snap.commitState()
+}
+
+// commitState flushes pending packfiles and persists the state delta of
+// the snapshot, it expects the header blob to have been put already.
+func (snap *Snapshot) commitState() error {
+	repo := snap.repository
+
+	if err := snap.closePacker(); err != nil {
+		return err
+	}
 
 	var serializedRepositoryIndex bytes.Buffer
-	err = snap.stateDelta.SerializeStream(&serializedRepositoryIndex)
+	err := snap.stateDelta.SerializeStream(&serializedRepositoryIndex)
 	if err != nil {
 		snap.Logger().Warn("could not serialize repository index: %s", err)
 		return err
@@ -357,8 +379,14 @@ func (snap *Snapshot) ListChunks() (<-chan objects.Checksum, error) {
 			if err != nil {
 				break
 			}
-			for _, chunk := range fsentry.(*vfs.FileEntry).Object.Chunks {
-				c <- chunk.Checksum
+			object := fsentry.(*vfs.FileEntry).Object
+			if object == nil {
+				continue
+			}
+			for _, chunk := range object.Chunks {
+				if !chunk.IsHole() {
+					c <- chunk.Checksum
+				}
 			}
 		}
 		close(c)
@@ -378,7 +406,9 @@ func (snap *Snapshot) ListObjects() (<-chan objects.Checksum, error) {
 			if err != nil {
 				break
 			}
-			c <- fsentry.(*vfs.FileEntry).Object.Checksum
+			if object := fsentry.(*vfs.FileEntry).Object; object != nil {
+				c <- object.Checksum
+			}
 		}
 		close(c)
 	}()
@@ -416,6 +446,46 @@ func (snap *Snapshot) ListDirectories() (<-chan objects.Checksum, error) {
 	return c, nil
 }
 
+// VisitChildren calls fn with the checksum of every child blob of the tree,
+// it stops at the first error.
+func (snap *Snapshot) VisitChildren(fn func(objects.Checksum) error) error {
+	directories, err := snap.ListDirectories()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for range directories {
+		}
+	}()
+
+	for checksum := range directories {
+		data, err := snap.GetBlob(packfile.TYPE_DIRECTORY, checksum)
+		if err != nil {
+			return err
+		}
+		dirEntry, err := vfs.DirEntryFromBytes(data)
+		if err != nil {
+			return err
+		}
+		iter := dirEntry.Children
+		for iter != nil {
+			if err := fn(*iter); err != nil {
+				return err
+			}
+			data, err := snap.GetBlob(packfile.TYPE_CHILD, *iter)
+			if err != nil {
+				return err
+			}
+			child, err := vfs.ChildEntryFromBytes(data)
+			if err != nil {
+				return err
+			}
+			iter = child.Successor
+		}
+	}
+	return nil
+}
+
 func (snap *Snapshot) ListDatas() (<-chan objects.Checksum, error) {
 	c := make(chan objects.Checksum)
 
@@ -0,0 +1,105 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+
+	_ "github.com/PlakarKorp/plakar/snapshot/exporter/fs"
+	_ "github.com/PlakarKorp/plakar/snapshot/importer/fs"
+)
+
+// writeTree creates the given files, by pathname relative to dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		pathname := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(pathname), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(pathname, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+// backupTree backs up dir and returns the snapshot loaded back from the
+// repository.
+func backupTree(t *testing.T, repo *repository.Repository, dir string, options *BackupOptions) *Snapshot {
+	t.Helper()
+
+	snap, err := New(repo)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if options == nil {
+		options = &BackupOptions{}
+	}
+	if options.MaxConcurrency == 0 {
+		options.MaxConcurrency = 4
+	}
+	if err := snap.Backup(dir, options); err != nil {
+		t.Fatalf("Failed to back up %s: %v", dir, err)
+	}
+
+	loaded, err := Load(repo, snap.Header.Identifier)
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	return loaded
+}
+
+// restoreTree restores pathname from snap below dst with the fs exporter.
+func restoreTree(t *testing.T, snap *Snapshot, dst string, pathname string, opts *RestoreOptions) *RestoreReport {
+	t.Helper()
+
+	exp, err := exporter.NewExporter(dst)
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+	defer exp.Close()
+
+	if opts.MaxConcurrency == 0 {
+		opts.MaxConcurrency = 4
+	}
+	report, err := snap.Restore(exp, exp.Root(), pathname, opts)
+	if err != nil {
+		t.Fatalf("Failed to restore %s: %v", pathname, err)
+	}
+	return report
+}
+
+func TestBackupRestore(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"a.txt":     "hello",
+		"sub/b.txt": "world",
+	})
+	snap := backupTree(t, repo, src, nil)
+
+	var id objects.Checksum
+	if snap.Header.Identifier == id {
+		t.Fatalf("Expected snapshot to have an identifier")
+	}
+
+	dst := t.TempDir()
+	restoreTree(t, snap, dst, src, &RestoreOptions{Rebase: true})
+
+	for name, expected := range map[string]string{"a.txt": "hello", "sub/b.txt": "world"} {
+		content, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatalf("Failed to read restored %s: %v", name, err)
+		}
+		if string(content) != expected {
+			t.Errorf("Expected %s to hold %q, got %q", name, expected, content)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package snapshot
+
+import (
+	"io"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+)
+
+// maxHoleChunk bounds the length of a hole chunk, longer holes are split.
+const maxHoleChunk = 1 << 30
+
+var zeroes = make([]byte, 1<<16)
+
+// writeZeroes writes length zeros to w.
+func writeZeroes(w io.Writer, length int64) error {
+	for length > 0 {
+		n := min(length, int64(len(zeroes)))
+		if _, err := w.Write(zeroes[:n]); err != nil {
+			return err
+		}
+		length -= n
+	}
+	return nil
+}
+
+// ZeroReader reads as an endless run of zeros.
+type ZeroReader struct{}
+
+func (ZeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// ObjectHoles returns the runs of hole chunks of object, as a SparseReader
+// over its content would.
+func ObjectHoles(object *objects.Object) []importer.Hole {
+	var holes []importer.Hole
+	var offset int64
+	for _, chunk := range object.Chunks {
+		if chunk.IsHole() {
+			if last := len(holes) - 1; last >= 0 && holes[last].Offset+holes[last].Length == offset {
+				holes[last].Length += int64(chunk.Length)
+			} else {
+				holes = append(holes, importer.Hole{Offset: offset, Length: int64(chunk.Length)})
+			}
+		}
+		offset += int64(chunk.Length)
+	}
+	return holes
+}
+
+// splitSparseChunks is splitChunks for readers able to locate holes: the
+// data between holes is chunked as usual and hole is called for the holes,
+// which are neither read nor chunked.
+func (snap *Snapshot) splitSparseChunks(rd io.ReadCloser, size int64, fn func(data []byte) error, hole func(length uint32) error) error {
+	sparse, isSparse := rd.(importer.SparseReader)
+	if !isSparse || size <= 0 {
+		return snap.splitChunks(rd, size, fn)
+	}
+	holes, err := sparse.Holes()
+	if err != nil {
+		return err
+	}
+	if len(holes) == 0 {
+		return snap.splitChunks(rd, size, fn)
+	}
+
+	var offset int64
+	for _, h := range holes {
+		// the file may have shrunk since it was scanned
+		if h.Offset >= size {
+			break
+		}
+		h.Length = min(h.Length, size-h.Offset)
+
+		if h.Offset > offset {
+			segment := h.Offset - offset
+			if err := snap.splitChunks(io.NopCloser(io.LimitReader(sparse, segment)), segment, fn); err != nil {
+				return err
+			}
+		}
+		if _, err := sparse.Seek(h.Offset+h.Length, io.SeekStart); err != nil {
+			return err
+		}
+		for remaining := h.Length; remaining > 0; {
+			n := min(remaining, maxHoleChunk)
+			if err := hole(uint32(n)); err != nil {
+				return err
+			}
+			remaining -= n
+		}
+		offset = h.Offset + h.Length
+	}
+	if offset < size {
+		return snap.splitChunks(io.NopCloser(io.LimitReader(sparse, size-offset)), size-offset, fn)
+	}
+	return nil
+}
+
+// sparseChunks returns which chunks of object are restored as holes: its
+// holes and, for snapshots that did not record them, the chunks of zeros.
+// The chunker cuts runs of zeros into chunks of the maximum size, which
+// are told apart by their checksum without being fetched.
+func (snap *Snapshot) sparseChunks(object *objects.Object) []bool {
+	maxSize := snap.repository.Configuration().Chunking.MaxSize
+	snap.zeroChunkOnce.Do(func() {
+		snap.zeroChunk = snap.repository.Checksum(make([]byte, maxSize))
+	})
+
+	sparse := make([]bool, len(object.Chunks))
+	for i := range object.Chunks {
+		chunk := &object.Chunks[i]
+		sparse[i] = chunk.IsHole() || (chunk.Length == maxSize && chunk.Checksum == snap.zeroChunk)
+	}
+	return sparse
+}
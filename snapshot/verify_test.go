@@ -0,0 +1,76 @@
+package snapshot
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/internal/testutil"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+)
+
+// corruptExporter flips a byte of the files it stores whose name starts
+// with bad.
+type corruptExporter struct {
+	exporter.Exporter
+}
+
+func (p *corruptExporter) StoreFile(pathname string, fp io.Reader) error {
+	if !strings.HasPrefix(filepath.Base(pathname), "bad") {
+		return p.Exporter.StoreFile(pathname, fp)
+	}
+	data, err := io.ReadAll(fp)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		data = []byte{0}
+	} else {
+		data[len(data)/2] ^= 0xff
+	}
+	return p.Exporter.StoreFile(pathname, bytes.NewReader(data))
+}
+
+func TestRestoreVerify(t *testing.T) {
+	repo := testutil.NewRepository(t, nil)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"good.txt":  "intact content",
+		"bad.txt":   "content corrupted on its way",
+		"bad-empty": "",
+	})
+	snap := backupTree(t, repo, src, nil)
+
+	dst := t.TempDir()
+	fsExp, err := exporter.NewExporter(dst)
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+	defer fsExp.Close()
+	exp := &corruptExporter{Exporter: fsExp}
+
+	report, err := snap.Restore(exp, exp.Root(), src, &RestoreOptions{Rebase: true, Verify: true, MaxConcurrency: 2})
+	if err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	if _, ok := reported(report.Restored, filepath.Join(src, "good.txt")); !ok {
+		t.Errorf("Expected good.txt to pass verification")
+	}
+	for _, name := range []string{"bad.txt", "bad-empty"} {
+		reason, ok := reported(report.Mismatched, filepath.Join(src, name))
+		if !ok {
+			t.Errorf("Expected %s to fail verification", name)
+			continue
+		}
+		if !strings.HasPrefix(reason, errRestoreMismatch.Error()) {
+			t.Errorf("Expected %s to be reported as a checksum mismatch, got %q", name, reason)
+		}
+		if _, ok := reported(report.Restored, filepath.Join(src, name)); ok {
+			t.Errorf("Expected %s not to be reported as restored", name)
+		}
+	}
+}
@@ -1,13 +1,15 @@
 package vfs
 
 import (
-	"bytes"
 	"errors"
 	"io"
 	"io/fs"
 	"path"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/packfile"
 )
 
@@ -17,17 +19,25 @@ type VFilep struct {
 
 	isDir bool
 
-	obuf          *bytes.Buffer
-	chunksLengths []uint32
-	offset        int64
-	size          int64
+	// chunkOffsets holds the offset of each chunk in the file, so that
+	// the chunk holding an offset is found without reading the others
+	chunkOffsets []int64
+	offset       int64
+	size         int64
+
+	// the last chunk read is kept as reads are usually sequential
+	muCache     sync.Mutex
+	cachedChunk int
+	cachedData  []byte
 }
 
 var _ fs.File = (*VFilep)(nil)
 var _ fs.ReadDirFile = (*VFilep)(nil)
+var _ io.ReaderAt = (*VFilep)(nil)
+var _ io.Seeker = (*VFilep)(nil)
 
 func NewVFilep(fs *Filesystem, entry FSEntry) *VFilep {
-	chunksLengths := make([]uint32, 0)
+	chunkOffsets := make([]int64, 0)
 	size := int64(0)
 
 	if entry.Stat().IsDir() {
@@ -39,93 +49,117 @@ func NewVFilep(fs *Filesystem, entry FSEntry) *VFilep {
 	} else if fileEntry, ok := entry.(*FileEntry); ok {
 		if fileEntry.Object != nil {
 			for _, chunk := range fileEntry.Object.Chunks {
-				chunksLengths = append(chunksLengths, chunk.Length)
+				chunkOffsets = append(chunkOffsets, size)
 				size += int64(chunk.Length)
 			}
 		}
 		return &VFilep{
-			vfs:           fs,
-			vfsEntry:      entry,
-			chunksLengths: chunksLengths,
-			obuf:          bytes.NewBuffer([]byte{}),
-			offset:        0,
-			size:          size,
+			vfs:          fs,
+			vfsEntry:     entry,
+			chunkOffsets: chunkOffsets,
+			offset:       0,
+			size:         size,
+			cachedChunk:  -1,
 		}
 	}
 	panic("invalid entry type")
 }
 
-func (vf *VFilep) Read(p []byte) (int, error) {
+func (vf *VFilep) object() (*objects.Object, error) {
 	if vf.isDir {
-		return 0, errors.New("not a file")
+		return nil, errors.New("not a file")
 	}
 
 	fileEntry := vf.vfsEntry.(*FileEntry)
-
 	if fileEntry.Object == nil {
-		return 0, fs.ErrInvalid
+		return nil, fs.ErrInvalid
 	}
+	return fileEntry.Object, nil
+}
 
-	if vf.offset == vf.size {
-		return 0, io.EOF
+func (vf *VFilep) Read(p []byte) (int, error) {
+	n, err := vf.ReadAt(p, vf.offset)
+	vf.offset += int64(n)
+	if err == io.EOF && n != 0 {
+		err = nil
 	}
+	return n, err
+}
 
-	readSize := uint(len(p))
-	chunkStart := int64(0)
-	for chunkOffset, chunkLength := range vf.chunksLengths {
-		// reader offset is past this chunk, skip
-		if vf.offset > chunkStart+int64(chunkLength) {
-			chunkStart += int64(chunkLength)
-			continue
-		}
-
-		// we have data to read from this chunk, fetch content
-		rd, err := vf.vfs.repo.GetBlob(packfile.TYPE_CHUNK, fileEntry.Object.Chunks[chunkOffset].Checksum)
-		if err != nil {
-			return -1, err
-		}
+// ReadAt reads from the chunk holding off onwards, holes read as zeros.
+// It may be called concurrently.
+func (vf *VFilep) ReadAt(p []byte, off int64) (int, error) {
+	object, err := vf.object()
+	if err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
 
-		data, err := io.ReadAll(rd)
-		if err != nil {
-			return -1, err
-		}
+	n := 0
+	for n < len(p) && off < vf.size {
+		// the last chunk starting at or before off, which skips empty ones
+		idx := sort.Search(len(vf.chunkOffsets), func(i int) bool {
+			return vf.chunkOffsets[i] > off
+		}) - 1
+		chunk := object.Chunks[idx]
 
-		// compute how much we can read from this one
-		endOffset := chunkStart + int64(chunkLength)
-		available := endOffset - int64(vf.offset)
-		chunkStart += int64(chunkLength)
+		start := off - vf.chunkOffsets[idx]
+		length := min(int64(chunk.Length)-start, int64(len(p)-n))
 
-		// find beginning and ending offsets in current chunk
-		beg := int64(chunkLength) - available
-		end := beg + available
-		if available >= int64(readSize) {
-			end = beg + int64(readSize)
-		}
-		if end > int64(len(data)) {
-			end = int64(len(data))
+		if chunk.IsHole() {
+			clear(p[n : n+int(length)])
+		} else {
+			data, err := vf.chunk(idx, object)
+			if err != nil {
+				return n, err
+			}
+			if int64(len(data)) < start+length {
+				return n, io.ErrUnexpectedEOF
+			}
+			copy(p[n:], data[start:start+length])
 		}
+		n += int(length)
+		off += length
+	}
 
-		nbytes, err := vf.obuf.Write(data[beg:end])
-		if err != nil {
-			return -1, err
-		}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
 
-		// update offset and remaining buffer capacity, possibly exiting loop
-		vf.offset += int64(nbytes)
-		readSize -= uint(nbytes)
-		if vf.offset == vf.size || readSize == 0 {
-			break
-		}
+func (vf *VFilep) chunk(idx int, object *objects.Object) ([]byte, error) {
+	vf.muCache.Lock()
+	if vf.cachedChunk == idx {
+		data := vf.cachedData
+		vf.muCache.Unlock()
+		return data, nil
+	}
+	vf.muCache.Unlock()
+
+	rd, err := vf.vfs.repo.GetBlob(packfile.TYPE_CHUNK, object.Chunks[idx].Checksum)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
 	}
 
-	return vf.obuf.Read(p)
+	vf.muCache.Lock()
+	vf.cachedChunk, vf.cachedData = idx, data
+	vf.muCache.Unlock()
+	return data, nil
 }
 
 func (vf *VFilep) Close() error {
 	if !vf.isDir {
 		vf.offset = 0
-		vf.size = 0
-		vf.obuf.Reset()
+		vf.muCache.Lock()
+		vf.cachedChunk, vf.cachedData = -1, nil
+		vf.muCache.Unlock()
 	}
 	return nil
 }
@@ -165,34 +199,26 @@ func (vf *VFilep) ReadDir(count int) ([]fs.DirEntry, error) {
 	return ret, nil
 }
 
+// Seek only moves the offset, the chunk holding it is located when read.
+// Seeking past the end is allowed, reads return io.EOF there.
 func (vf *VFilep) Seek(offset int64, whence int) (int64, error) {
-	if vf.isDir {
-		return 0, errors.New("not a file")
-	}
-
-	fileEntry := vf.vfsEntry.(*FileEntry)
-
-	if fileEntry.Object == nil {
-		return 0, fs.ErrInvalid
+	if _, err := vf.object(); err != nil {
+		return 0, err
 	}
 
 	switch whence {
 	case io.SeekStart:
-		if offset > vf.size {
-			return 0, io.EOF
-		}
-		vf.offset = offset
 	case io.SeekCurrent:
-		if vf.offset+offset > vf.size {
-			return 0, io.EOF
-		}
-		vf.offset += offset
+		offset += vf.offset
 	case io.SeekEnd:
-		if offset > vf.size {
-			return 0, io.EOF
-		}
-		vf.offset = vf.size - offset
+		offset += vf.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
 	}
+	vf.offset = offset
 	return vf.offset, nil
 }
 
@@ -18,6 +18,7 @@ package database
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -44,6 +45,7 @@ type Repository struct {
 
 	Repository string
 	location   string
+	ctx        context.Context
 }
 
 func init() {
@@ -76,11 +78,11 @@ func (repo *Repository) connect(addr string) error {
 	repo.conn = conn
 
 	if repo.backend == "sqlite3" {
-		_, err = repo.conn.Exec("PRAGMA journal_mode=WAL;")
+		_, err = repo.conn.ExecContext(repo.ctx, "PRAGMA journal_mode=WAL;")
 		if err != nil {
 			return nil
 		}
-		_, err = repo.conn.Exec("PRAGMA busy_timeout=2000;")
+		_, err = repo.conn.ExecContext(repo.ctx, "PRAGMA busy_timeout=2000;")
 		if err != nil {
 			return nil
 		}
@@ -90,22 +92,30 @@ func (repo *Repository) connect(addr string) error {
 	return nil
 }
 
-func (repo *Repository) Create(location string, config storage.Configuration) error {
+// writeContext is the context of the statements modifying the database,
+// they are carried through once started so that an interrupted command can
+// still record what it uploaded.
+func (repo *Repository) writeContext() context.Context {
+	return context.WithoutCancel(repo.ctx)
+}
+
+func (repo *Repository) Create(ctx context.Context, location string, config storage.Configuration) error {
+	repo.ctx = ctx
 	err := repo.connect(location)
 	if err != nil {
 		return err
 	}
 
-	statement, err := repo.conn.Prepare(`CREATE TABLE IF NOT EXISTS configuration (
+	statement, err := repo.conn.PrepareContext(repo.ctx, `CREATE TABLE IF NOT EXISTS configuration (
 		value	BLOB
 	);`)
 	if err != nil {
 		return err
 	}
 	defer statement.Close()
-	statement.Exec()
+	statement.ExecContext(repo.ctx)
 
-	statement, err = repo.conn.Prepare(`CREATE TABLE IF NOT EXISTS states (
+	statement, err = repo.conn.PrepareContext(repo.ctx, `CREATE TABLE IF NOT EXISTS states (
 		checksum	VARCHAR(64) NOT NULL PRIMARY KEY,
 		data		BLOB
 	);`)
@@ -113,9 +123,9 @@ func (repo *Repository) Create(location string, config storage.Configuration) er
 		return err
 	}
 	defer statement.Close()
-	statement.Exec()
+	statement.ExecContext(repo.ctx)
 
-	statement, err = repo.conn.Prepare(`CREATE TABLE IF NOT EXISTS packfiles (
+	statement, err = repo.conn.PrepareContext(repo.ctx, `CREATE TABLE IF NOT EXISTS packfiles (
 		checksum	VARCHAR(64) NOT NULL PRIMARY KEY,
 		data		BLOB
 	);`)
@@ -123,20 +133,20 @@ func (repo *Repository) Create(location string, config storage.Configuration) er
 		return err
 	}
 	defer statement.Close()
-	statement.Exec()
+	statement.ExecContext(repo.ctx)
 
 	jsonConfig, err := json.Marshal(config)
 	if err != nil {
 		return err
 	}
 
-	statement, err = repo.conn.Prepare(`INSERT INTO configuration(value) VALUES(?)`)
+	statement, err = repo.conn.PrepareContext(repo.ctx, `INSERT INTO configuration(value) VALUES(?)`)
 	if err != nil {
 		return err
 	}
 	defer statement.Close()
 
-	_, err = statement.Exec(jsonConfig)
+	_, err = statement.ExecContext(repo.ctx, jsonConfig)
 	if err != nil {
 		return err
 	}
@@ -144,7 +154,8 @@ func (repo *Repository) Create(location string, config storage.Configuration) er
 	return nil
 }
 
-func (repo *Repository) Open(location string) error {
+func (repo *Repository) Open(ctx context.Context, location string) error {
+	repo.ctx = ctx
 	err := repo.connect(location)
 	if err != nil {
 		return err
@@ -153,7 +164,7 @@ func (repo *Repository) Open(location string) error {
 	var buffer []byte
 	var repositoryConfig storage.Configuration
 
-	err = repo.conn.QueryRow(`SELECT value FROM configuration`).Scan(&buffer)
+	err = repo.conn.QueryRowContext(repo.ctx, `SELECT value FROM configuration`).Scan(&buffer)
 	if err != nil {
 		return err
 	}
@@ -173,14 +184,14 @@ func (repo *Repository) Close() error {
 }
 
 func (repo *Repository) Commit(snapshotID objects.Checksum, data []byte) error {
-	statement, err := repo.conn.Prepare(`INSERT INTO snapshots (snapshotID, data) VALUES(?, ?)`)
+	statement, err := repo.conn.PrepareContext(repo.writeContext(), `INSERT INTO snapshots (snapshotID, data) VALUES(?, ?)`)
 	if err != nil {
 		return err
 	}
 	defer statement.Close()
 
 	repo.wrMutex.Lock()
-	_, err = statement.Exec(snapshotID, data)
+	_, err = statement.ExecContext(repo.writeContext(), snapshotID, data)
 	repo.wrMutex.Unlock()
 	if err != nil {
 		return err
@@ -195,7 +206,7 @@ func (repo *Repository) Configuration() storage.Configuration {
 
 // states
 func (repo *Repository) GetStates() ([]objects.Checksum, error) {
-	rows, err := repo.conn.Query("SELECT checksum FROM states")
+	rows, err := repo.conn.QueryContext(repo.ctx, "SELECT checksum FROM states")
 	if err != nil {
 		return nil, err
 	}
@@ -221,14 +232,14 @@ func (repo *Repository) PutState(checksum objects.Checksum, rd io.Reader) error
 		return err
 	}
 
-	statement, err := repo.conn.Prepare(`INSERT INTO states (checksum, data) VALUES(?, ?)`)
+	statement, err := repo.conn.PrepareContext(repo.writeContext(), `INSERT INTO states (checksum, data) VALUES(?, ?)`)
 	if err != nil {
 		return err
 	}
 	defer statement.Close()
 
 	repo.wrMutex.Lock()
-	_, err = statement.Exec(checksum[:], data)
+	_, err = statement.ExecContext(repo.writeContext(), checksum[:], data)
 	repo.wrMutex.Unlock()
 	if err != nil {
 		if sqliteErr, ok := err.(sqlite3.Error); !ok {
@@ -245,7 +256,7 @@ func (repo *Repository) PutState(checksum objects.Checksum, rd io.Reader) error
 
 func (repo *Repository) GetState(checksum objects.Checksum) (io.Reader, error) {
 	var data []byte
-	err := repo.conn.QueryRow(`SELECT data FROM states WHERE checksum=?`, checksum[:]).Scan(&data)
+	err := repo.conn.QueryRowContext(repo.ctx, `SELECT data FROM states WHERE checksum=?`, checksum[:]).Scan(&data)
 	if err != nil {
 		return nil, err
 	}
@@ -253,14 +264,14 @@ func (repo *Repository) GetState(checksum objects.Checksum) (io.Reader, error) {
 }
 
 func (repo *Repository) DeleteState(checksum objects.Checksum) error {
-	statement, err := repo.conn.Prepare(`DELETE FROM states WHERE checksum=?`)
+	statement, err := repo.conn.PrepareContext(repo.writeContext(), `DELETE FROM states WHERE checksum=?`)
 	if err != nil {
 		return err
 	}
 	defer statement.Close()
 
 	repo.wrMutex.Lock()
-	_, err = statement.Exec(checksum[:])
+	_, err = statement.ExecContext(repo.writeContext(), checksum[:])
 	repo.wrMutex.Unlock()
 	if err != nil {
 		// if err is that it's already present, we should discard err and assume a concurrent write
@@ -271,7 +282,7 @@ func (repo *Repository) DeleteState(checksum objects.Checksum) error {
 
 // packfiles
 func (repo *Repository) GetPackfiles() ([]objects.Checksum, error) {
-	rows, err := repo.conn.Query("SELECT checksum FROM packfiles")
+	rows, err := repo.conn.QueryContext(repo.ctx, "SELECT checksum FROM packfiles")
 	if err != nil {
 		return nil, err
 	}
@@ -297,14 +308,14 @@ func (repo *Repository) PutPackfile(checksum objects.Checksum, rd io.Reader) err
 		return err
 	}
 
-	statement, err := repo.conn.Prepare(`INSERT INTO packfiles (checksum, data) VALUES(?, ?)`)
+	statement, err := repo.conn.PrepareContext(repo.writeContext(), `INSERT INTO packfiles (checksum, data) VALUES(?, ?)`)
 	if err != nil {
 		return err
 	}
 	defer statement.Close()
 
 	repo.wrMutex.Lock()
-	_, err = statement.Exec(checksum[:], data)
+	_, err = statement.ExecContext(repo.writeContext(), checksum[:], data)
 	repo.wrMutex.Unlock()
 	if err != nil {
 		if sqliteErr, ok := err.(sqlite3.Error); !ok {
@@ -321,7 +332,7 @@ func (repo *Repository) PutPackfile(checksum objects.Checksum, rd io.Reader) err
 
 func (repo *Repository) GetPackfile(checksum objects.Checksum) (io.Reader, error) {
 	var data []byte
-	err := repo.conn.QueryRow(`SELECT data FROM packfiles WHERE checksum=?`, checksum[:]).Scan(&data)
+	err := repo.conn.QueryRowContext(repo.ctx, `SELECT data FROM packfiles WHERE checksum=?`, checksum[:]).Scan(&data)
 	if err != nil {
 		return nil, err
 	}
@@ -330,7 +341,7 @@ func (repo *Repository) GetPackfile(checksum objects.Checksum) (io.Reader, error
 
 func (repo *Repository) GetPackfileBlob(checksum objects.Checksum, offset uint32, length uint32) (io.Reader, error) {
 	var data []byte
-	err := repo.conn.QueryRow(`SELECT substr(data, ?, ?) FROM packfiles WHERE checksum=?`, offset+1, length, checksum[:]).Scan(&data)
+	err := repo.conn.QueryRowContext(repo.ctx, `SELECT substr(data, ?, ?) FROM packfiles WHERE checksum=?`, offset+1, length, checksum[:]).Scan(&data)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			err = repository.ErrBlobNotFound
@@ -341,14 +352,14 @@ func (repo *Repository) GetPackfileBlob(checksum objects.Checksum, offset uint32
 }
 
 func (repo *Repository) DeletePackfile(checksum objects.Checksum) error {
-	statement, err := repo.conn.Prepare(`DELETE FROM packfiles WHERE checksum=?`)
+	statement, err := repo.conn.PrepareContext(repo.writeContext(), `DELETE FROM packfiles WHERE checksum=?`)
 	if err != nil {
 		return err
 	}
 	defer statement.Close()
 
 	repo.wrMutex.Lock()
-	_, err = statement.Exec(checksum[:])
+	_, err = statement.ExecContext(repo.writeContext(), checksum[:])
 	repo.wrMutex.Unlock()
 	if err != nil {
 		// if err is that it's already present, we should discard err and assume a concurrent write
@@ -18,6 +18,7 @@ package fs
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -73,7 +74,7 @@ func (repo *Repository) Location() string {
 	return repo.location
 }
 
-func (repo *Repository) Create(location string, config storage.Configuration) error {
+func (repo *Repository) Create(ctx context.Context, location string, config storage.Configuration) error {
 	if strings.HasPrefix(location, "fs://") {
 		location = location[4:]
 	}
@@ -123,7 +124,7 @@ func (repo *Repository) Create(location string, config storage.Configuration) er
 	return os.Rename(tmpfile, configPath)
 }
 
-func (repo *Repository) Open(location string) error {
+func (repo *Repository) Open(ctx context.Context, location string) error {
 	if strings.HasPrefix(location, "fs://") {
 		location = location[4:]
 	}
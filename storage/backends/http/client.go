@@ -18,6 +18,7 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -32,6 +33,7 @@ type Repository struct {
 	config     storage.Configuration
 	Repository string
 	location   string
+	ctx        context.Context
 }
 
 func init() {
@@ -54,7 +56,13 @@ func (repo *Repository) sendRequest(method string, url string, requestType strin
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(method, url+requestType, bytes.NewBuffer(requestBody))
+	// requests modifying the repository are carried through once started
+	// so that an interrupted command can still record what it uploaded
+	ctx := repo.ctx
+	if method == "PUT" || method == "DELETE" {
+		ctx = context.WithoutCancel(ctx)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url+requestType, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return nil, err
 	}
@@ -63,11 +71,12 @@ func (repo *Repository) sendRequest(method string, url string, requestType strin
 	return client.Do(req)
 }
 
-func (repo *Repository) Create(location string, config storage.Configuration) error {
+func (repo *Repository) Create(ctx context.Context, location string, config storage.Configuration) error {
 	return nil
 }
 
-func (repo *Repository) Open(location string) error {
+func (repo *Repository) Open(ctx context.Context, location string) error {
+	repo.ctx = ctx
 	repo.Repository = location
 	r, err := repo.sendRequest("GET", location, "/", network.ReqOpen{
 		Repository: "",
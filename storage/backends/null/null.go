@@ -18,6 +18,7 @@ package fs
 
 import (
 	"bytes"
+	"context"
 	"io"
 
 	"github.com/PlakarKorp/plakar/objects"
@@ -44,11 +45,11 @@ func (repo *Repository) Location() string {
 	return repo.location
 }
 
-func (repository *Repository) Create(location string, config storage.Configuration) error {
+func (repository *Repository) Create(ctx context.Context, location string, config storage.Configuration) error {
 	return nil
 }
 
-func (repository *Repository) Open(location string) error {
+func (repository *Repository) Open(ctx context.Context, location string) error {
 	repositoryConfig := storage.NewConfiguration()
 	repository.config = *repositoryConfig
 
@@ -18,6 +18,7 @@ package plakard
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"fmt"
 	"io"
@@ -49,6 +50,10 @@ type Repository struct {
 
 	inflightRequests map[uuid.UUID]chan network.Request
 	notifications    chan network.Request
+	// closed once the connection is lost, pending requests fail then
+	closed chan struct{}
+
+	ctx context.Context
 }
 
 func init() {
@@ -112,15 +117,19 @@ func (repository *Repository) connectTCP(location *url.URL) error {
 
 	repository.inflightRequests = make(map[uuid.UUID]chan network.Request)
 	repository.notifications = make(chan network.Request)
+	repository.closed = make(chan struct{})
 
 	//repository.maxConcurrentRequest = make(chan bool, 1024)
 
 	go func() {
 		for m := range repository.notifications {
 			repository.mu.Lock()
-			notify := repository.inflightRequests[m.Uuid]
+			notify, exists := repository.inflightRequests[m.Uuid]
 			repository.mu.Unlock()
-			notify <- m
+			// the request may have been cancelled already
+			if exists {
+				notify <- m
+			}
 		}
 	}()
 
@@ -129,6 +138,7 @@ func (repository *Repository) connectTCP(location *url.URL) error {
 			result := network.Request{}
 			err = repository.decoder.Decode(&result)
 			if err != nil {
+				close(repository.closed)
 				conn.Close()
 				return
 			}
@@ -162,13 +172,17 @@ func (repository *Repository) connectStdio() error {
 
 	repository.inflightRequests = make(map[uuid.UUID]chan network.Request)
 	repository.notifications = make(chan network.Request)
+	repository.closed = make(chan struct{})
 
 	go func() {
 		for m := range repository.notifications {
 			repository.mu.Lock()
-			notify := repository.inflightRequests[m.Uuid]
+			notify, exists := repository.inflightRequests[m.Uuid]
 			repository.mu.Unlock()
-			notify <- m
+			// the request may have been cancelled already
+			if exists {
+				notify <- m
+			}
 		}
 	}()
 
@@ -177,6 +191,7 @@ func (repository *Repository) connectStdio() error {
 			result := network.Request{}
 			err = repository.decoder.Decode(&result)
 			if err != nil {
+				close(repository.closed)
 				stdin.Close()
 				subProcess.Wait()
 				return
@@ -221,13 +236,17 @@ func (repository *Repository) connectSSH(location *url.URL) error {
 
 	repository.inflightRequests = make(map[uuid.UUID]chan network.Request)
 	repository.notifications = make(chan network.Request)
+	repository.closed = make(chan struct{})
 
 	go func() {
 		for m := range repository.notifications {
 			repository.mu.Lock()
-			notify := repository.inflightRequests[m.Uuid]
+			notify, exists := repository.inflightRequests[m.Uuid]
 			repository.mu.Unlock()
-			notify <- m
+			// the request may have been cancelled already
+			if exists {
+				notify <- m
+			}
 		}
 	}()
 
@@ -236,6 +255,7 @@ func (repository *Repository) connectSSH(location *url.URL) error {
 			result := network.Request{}
 			err = repository.decoder.Decode(&result)
 			if err != nil {
+				close(repository.closed)
 				stdin.Close()
 				subProcess.Wait()
 				return
@@ -247,6 +267,13 @@ func (repository *Repository) connectSSH(location *url.URL) error {
 	return nil
 }
 
+var writeRequests = map[string]struct{}{
+	"ReqPutState":       {},
+	"ReqDeleteState":    {},
+	"ReqPutPackfile":    {},
+	"ReqDeletePackfile": {},
+}
+
 func (repository *Repository) sendRequest(Type string, Payload interface{}) (*network.Request, error) {
 	Uuid, err := uuid.NewRandom()
 	if err != nil {
@@ -259,27 +286,42 @@ func (repository *Repository) sendRequest(Type string, Payload interface{}) (*ne
 		Payload: Payload,
 	}
 
-	notify := make(chan network.Request)
+	// buffered so that a late response to a cancelled request doesn't
+	// block the reader
+	notify := make(chan network.Request, 1)
 	repository.mu.Lock()
 	repository.inflightRequests[request.Uuid] = notify
 	repository.mu.Unlock()
 
+	defer func() {
+		repository.mu.Lock()
+		delete(repository.inflightRequests, request.Uuid)
+		repository.mu.Unlock()
+	}()
+
 	err = repository.encoder.Encode(&request)
 	if err != nil {
 		return nil, err
 	}
 
-	result := <-notify
-
-	repository.mu.Lock()
-	delete(repository.inflightRequests, request.Uuid)
-	repository.mu.Unlock()
-	close(notify)
-
-	return &result, nil
+	// requests modifying the repository are waited for once sent so that an
+	// interrupted command can still record what it uploaded
+	ctx := repository.ctx
+	if _, isWrite := writeRequests[Type]; isWrite {
+		ctx = context.WithoutCancel(ctx)
+	}
+	select {
+	case result := <-notify:
+		return &result, nil
+	case <-repository.closed:
+		return nil, fmt.Errorf("connection to %s closed", repository.location)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
-func (repository *Repository) Create(location string, config storage.Configuration) error {
+func (repository *Repository) Create(ctx context.Context, location string, config storage.Configuration) error {
+	repository.ctx = ctx
 	isTcp := false
 	if strings.HasPrefix(location, "tcp://") {
 		isTcp = true
@@ -316,7 +358,8 @@ func (repository *Repository) Create(location string, config storage.Configurati
 	return nil
 }
 
-func (repository *Repository) Open(location string) error {
+func (repository *Repository) Open(ctx context.Context, location string) error {
+	repository.ctx = ctx
 
 	isTcp := false
 	if strings.HasPrefix(location, "tcp://") {
@@ -42,6 +42,7 @@ type Repository struct {
 	Repository  string
 	minioClient *minio.Client
 	bucketName  string
+	ctx         context.Context
 }
 
 func init() {
@@ -78,7 +79,15 @@ func (repository *Repository) connect(location *url.URL) error {
 	return nil
 }
 
-func (repository *Repository) Create(location string, config storage.Configuration) error {
+// writeContext is the context of the requests modifying the bucket, they
+// are carried through once started so that an interrupted command can still
+// record what it uploaded.
+func (repository *Repository) writeContext() context.Context {
+	return context.WithoutCancel(repository.ctx)
+}
+
+func (repository *Repository) Create(ctx context.Context, location string, config storage.Configuration) error {
+	repository.ctx = ctx
 	parsed, err := url.Parse(location)
 	if err != nil {
 		return err
@@ -90,7 +99,7 @@ func (repository *Repository) Create(location string, config storage.Configurati
 	}
 	repository.bucketName = parsed.RequestURI()[1:]
 
-	err = repository.minioClient.MakeBucket(context.Background(), repository.bucketName, minio.MakeBucketOptions{})
+	err = repository.minioClient.MakeBucket(repository.ctx, repository.bucketName, minio.MakeBucketOptions{})
 	if err != nil {
 		return err
 	}
@@ -110,7 +119,7 @@ func (repository *Repository) Create(location string, config storage.Configurati
 		return err
 	}
 
-	_, err = repository.minioClient.PutObject(context.Background(), repository.bucketName, "CONFIG", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	_, err = repository.minioClient.PutObject(repository.writeContext(), repository.bucketName, "CONFIG", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
 	if err != nil {
 		return err
 	}
@@ -119,7 +128,8 @@ func (repository *Repository) Create(location string, config storage.Configurati
 	return nil
 }
 
-func (repository *Repository) Open(location string) error {
+func (repository *Repository) Open(ctx context.Context, location string) error {
+	repository.ctx = ctx
 	parsed, err := url.Parse(location)
 	if err != nil {
 		return err
@@ -132,7 +142,7 @@ func (repository *Repository) Open(location string) error {
 
 	repository.bucketName = parsed.RequestURI()[1:]
 
-	exists, err := repository.minioClient.BucketExists(context.Background(), repository.bucketName)
+	exists, err := repository.minioClient.BucketExists(repository.ctx, repository.bucketName)
 	if err != nil {
 		return err
 	}
@@ -140,7 +150,7 @@ func (repository *Repository) Open(location string) error {
 		return fmt.Errorf("bucket does not exist")
 	}
 
-	object, err := repository.minioClient.GetObject(context.Background(), repository.bucketName, "CONFIG", minio.GetObjectOptions{})
+	object, err := repository.minioClient.GetObject(repository.ctx, repository.bucketName, "CONFIG", minio.GetObjectOptions{})
 	if err != nil {
 		return err
 	}
@@ -190,7 +200,7 @@ func (repository *Repository) Configuration() storage.Configuration {
 // snapshots
 func (repository *Repository) GetSnapshots() ([]objects.Checksum, error) {
 	ret := make([]objects.Checksum, 0)
-	for object := range repository.minioClient.ListObjects(context.Background(), repository.bucketName, minio.ListObjectsOptions{
+	for object := range repository.minioClient.ListObjects(repository.ctx, repository.bucketName, minio.ListObjectsOptions{
 		Prefix:    "snapshots/",
 		Recursive: true,
 	}) {
@@ -211,7 +221,7 @@ func (repository *Repository) GetSnapshots() ([]objects.Checksum, error) {
 }
 
 func (repository *Repository) PutSnapshot(snapshotID objects.Checksum, data []byte) error {
-	_, err := repository.minioClient.PutObject(context.Background(), repository.bucketName, fmt.Sprintf("snapshots/%x/%s", snapshotID[0], hex.EncodeToString(snapshotID[:])), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	_, err := repository.minioClient.PutObject(repository.writeContext(), repository.bucketName, fmt.Sprintf("snapshots/%x/%s", snapshotID[0], hex.EncodeToString(snapshotID[:])), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
 	if err != nil {
 		return err
 	}
@@ -219,7 +229,7 @@ func (repository *Repository) PutSnapshot(snapshotID objects.Checksum, data []by
 }
 
 func (repository *Repository) GetSnapshot(snapshotID objects.Checksum) ([]byte, error) {
-	object, err := repository.minioClient.GetObject(context.Background(), repository.bucketName, fmt.Sprintf("snapshots/%x/%s", snapshotID[0], hex.EncodeToString(snapshotID[:])), minio.GetObjectOptions{})
+	object, err := repository.minioClient.GetObject(repository.ctx, repository.bucketName, fmt.Sprintf("snapshots/%x/%s", snapshotID[0], hex.EncodeToString(snapshotID[:])), minio.GetObjectOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -241,7 +251,7 @@ func (repository *Repository) GetSnapshot(snapshotID objects.Checksum) ([]byte,
 }
 
 func (repository *Repository) DeleteSnapshot(snapshotID objects.Checksum) error {
-	err := repository.minioClient.RemoveObject(context.Background(), repository.bucketName, fmt.Sprintf("snapshots/%x/%s", snapshotID[0], hex.EncodeToString(snapshotID[:])), minio.RemoveObjectOptions{})
+	err := repository.minioClient.RemoveObject(repository.writeContext(), repository.bucketName, fmt.Sprintf("snapshots/%x/%s", snapshotID[0], hex.EncodeToString(snapshotID[:])), minio.RemoveObjectOptions{})
 	if err != nil {
 		return err
 	}
@@ -251,7 +261,7 @@ func (repository *Repository) DeleteSnapshot(snapshotID objects.Checksum) error
 // states
 func (repository *Repository) GetStates() ([]objects.Checksum, error) {
 	ret := make([]objects.Checksum, 0)
-	for object := range repository.minioClient.ListObjects(context.Background(), repository.bucketName, minio.ListObjectsOptions{
+	for object := range repository.minioClient.ListObjects(repository.ctx, repository.bucketName, minio.ListObjectsOptions{
 		Prefix:    "states/",
 		Recursive: true,
 	}) {
@@ -272,7 +282,7 @@ func (repository *Repository) GetStates() ([]objects.Checksum, error) {
 }
 
 func (repository *Repository) PutState(checksum objects.Checksum, rd io.Reader) error {
-	_, err := repository.minioClient.PutObject(context.Background(), repository.bucketName, fmt.Sprintf("states/%02x/%016x", checksum[0], checksum), rd, -1, minio.PutObjectOptions{})
+	_, err := repository.minioClient.PutObject(repository.writeContext(), repository.bucketName, fmt.Sprintf("states/%02x/%016x", checksum[0], checksum), rd, -1, minio.PutObjectOptions{})
 	if err != nil {
 		return err
 	}
@@ -280,7 +290,7 @@ func (repository *Repository) PutState(checksum objects.Checksum, rd io.Reader)
 }
 
 func (repository *Repository) GetState(checksum objects.Checksum) (io.Reader, error) {
-	object, err := repository.minioClient.GetObject(context.Background(), repository.bucketName, fmt.Sprintf("states/%02x/%016x", checksum[0], checksum), minio.GetObjectOptions{})
+	object, err := repository.minioClient.GetObject(repository.ctx, repository.bucketName, fmt.Sprintf("states/%02x/%016x", checksum[0], checksum), minio.GetObjectOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -289,7 +299,7 @@ func (repository *Repository) GetState(checksum objects.Checksum) (io.Reader, er
 }
 
 func (repository *Repository) DeleteState(checksum objects.Checksum) error {
-	err := repository.minioClient.RemoveObject(context.Background(), repository.bucketName, fmt.Sprintf("states/%02x/%016x", checksum[0], checksum), minio.RemoveObjectOptions{})
+	err := repository.minioClient.RemoveObject(repository.writeContext(), repository.bucketName, fmt.Sprintf("states/%02x/%016x", checksum[0], checksum), minio.RemoveObjectOptions{})
 	if err != nil {
 		return err
 	}
@@ -299,7 +309,7 @@ func (repository *Repository) DeleteState(checksum objects.Checksum) error {
 // packfiles
 func (repository *Repository) GetPackfiles() ([]objects.Checksum, error) {
 	ret := make([]objects.Checksum, 0)
-	for object := range repository.minioClient.ListObjects(context.Background(), repository.bucketName, minio.ListObjectsOptions{
+	for object := range repository.minioClient.ListObjects(repository.ctx, repository.bucketName, minio.ListObjectsOptions{
 		Prefix:    "packfiles/",
 		Recursive: true,
 	}) {
@@ -320,7 +330,7 @@ func (repository *Repository) GetPackfiles() ([]objects.Checksum, error) {
 }
 
 func (repository *Repository) PutPackfile(checksum objects.Checksum, rd io.Reader) error {
-	_, err := repository.minioClient.PutObject(context.Background(), repository.bucketName, fmt.Sprintf("packfiles/%02x/%016x", checksum[0], checksum), rd, -1, minio.PutObjectOptions{})
+	_, err := repository.minioClient.PutObject(repository.writeContext(), repository.bucketName, fmt.Sprintf("packfiles/%02x/%016x", checksum[0], checksum), rd, -1, minio.PutObjectOptions{})
 	if err != nil {
 		return err
 	}
@@ -328,7 +338,7 @@ func (repository *Repository) PutPackfile(checksum objects.Checksum, rd io.Reade
 }
 
 func (repository *Repository) GetPackfile(checksum objects.Checksum) (io.Reader, error) {
-	object, err := repository.minioClient.GetObject(context.Background(), repository.bucketName, fmt.Sprintf("packfiles/%02x/%016x", checksum[0], checksum), minio.GetObjectOptions{})
+	object, err := repository.minioClient.GetObject(repository.ctx, repository.bucketName, fmt.Sprintf("packfiles/%02x/%016x", checksum[0], checksum), minio.GetObjectOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -338,7 +348,7 @@ func (repository *Repository) GetPackfile(checksum objects.Checksum) (io.Reader,
 func (repository *Repository) GetPackfileBlob(checksum objects.Checksum, offset uint32, length uint32) (io.Reader, error) {
 	opts := minio.GetObjectOptions{}
 	opts.SetRange(int64(offset), int64(offset+length))
-	object, err := repository.minioClient.GetObject(context.Background(), repository.bucketName, fmt.Sprintf("packfiles/%02x/%016x", checksum[0], checksum), opts)
+	object, err := repository.minioClient.GetObject(repository.ctx, repository.bucketName, fmt.Sprintf("packfiles/%02x/%016x", checksum[0], checksum), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -366,7 +376,7 @@ func (repository *Repository) GetPackfileBlob(checksum objects.Checksum, offset
 }
 
 func (repository *Repository) DeletePackfile(checksum objects.Checksum) error {
-	err := repository.minioClient.RemoveObject(context.Background(), repository.bucketName, fmt.Sprintf("packfiles/%02x/%016x", checksum[0], checksum), minio.RemoveObjectOptions{})
+	err := repository.minioClient.RemoveObject(repository.writeContext(), repository.bucketName, fmt.Sprintf("packfiles/%02x/%016x", checksum[0], checksum), minio.RemoveObjectOptions{})
 	if err != nil {
 		return err
 	}
@@ -376,7 +386,7 @@ func (repository *Repository) DeletePackfile(checksum objects.Checksum) error {
 //////
 
 func (repository *Repository) Commit(snapshotID objects.Checksum, data []byte) error {
-	_, err := repository.minioClient.PutObject(context.Background(), repository.bucketName, fmt.Sprintf("snapshots/%x/%s", snapshotID[0], hex.EncodeToString(snapshotID[:])), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	_, err := repository.minioClient.PutObject(repository.writeContext(), repository.bucketName, fmt.Sprintf("snapshots/%x/%s", snapshotID[0], hex.EncodeToString(snapshotID[:])), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
 	if err != nil {
 		return err
 	}
@@ -17,6 +17,7 @@
 package storage
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -67,8 +68,8 @@ func NewConfiguration() *Configuration {
 }
 
 type Store interface {
-	Create(repository string, configuration Configuration) error
-	Open(repository string) error
+	Create(ctx context.Context, repository string, configuration Configuration) error
+	Open(ctx context.Context, repository string) error
 	Configuration() Configuration
 	Location() string
 
@@ -157,28 +158,32 @@ func New(location string) (Store, error) {
 	return NewStore(backendName, location)
 }
 
-func Open(location string) (Store, error) {
+// Open opens the store at location, requests reading from the store are
+// aborted once ctx is cancelled.  Requests modifying it are carried through
+// once started, so that an interrupted command can still record what it
+// uploaded.
+func Open(ctx context.Context, location string) (Store, error) {
 	store, err := New(location)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
 		return nil, err
 	}
 
-	if err = store.Open(location); err != nil {
+	if err = store.Open(ctx, location); err != nil {
 		return nil, err
 	} else {
 		return store, nil
 	}
 }
 
-func Create(location string, configuration Configuration) (Store, error) {
+func Create(ctx context.Context, location string, configuration Configuration) (Store, error) {
 	store, err := New(location)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
 		return nil, err
 	}
 
-	if err = store.Create(location, configuration); err != nil {
+	if err = store.Create(ctx, location, configuration); err != nil {
 		return nil, err
 	} else {
 		return store, nil
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"bytes"
+	stdcontext "context"
 	"io"
 	"os"
 	"runtime"
@@ -22,12 +23,12 @@ type MockBackend struct {
 	location      string
 }
 
-func (mb *MockBackend) Create(repository string, configuration Configuration) error {
+func (mb *MockBackend) Create(ctx stdcontext.Context, repository string, configuration Configuration) error {
 	mb.configuration = configuration
 	return nil
 }
 
-func (mb *MockBackend) Open(repository string) error {
+func (mb *MockBackend) Open(ctx stdcontext.Context, repository string) error {
 	return nil
 }
 
@@ -100,7 +101,7 @@ func TestCreateStore(t *testing.T) {
 	ctx.SetMaxConcurrency(runtime.NumCPU()*8 + 1)
 
 	config := NewConfiguration()
-	store, err := Create("/test/location", *config)
+	store, err := Create(ctx, "/test/location", *config)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -115,7 +116,7 @@ func TestOpenStore(t *testing.T) {
 	ctx.SetLogger(logging.NewLogger(os.Stdout, os.Stderr))
 	ctx.SetMaxConcurrency(runtime.NumCPU()*8 + 1)
 
-	store, err := Open("/test/location")
+	store, err := Open(ctx, "/test/location")
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}